@@ -67,6 +67,8 @@ func parser(config *SsmagentConfig) {
 		config.Ssm.RunCommandLogsRetentionDurationHours,
 		DefaultStateOrchestrationLogsRetentionDurationHoursMin,
 		DefaultRunCommandLogsRetentionDurationHours)
+	config.Ssm.DocumentWorkerMemoryLimitMB = getNumericValueAboveMin(config.Ssm.DocumentWorkerMemoryLimitMB, 0, 0)
+	config.Ssm.DocumentWorkerCPULimitSeconds = getNumericValueAboveMin(config.Ssm.DocumentWorkerCPULimitSeconds, 0, 0)
 
 }
 
@@ -43,6 +43,11 @@ type SsmCfg struct {
 	AssociationLogsRetentionDurationHours int
 	RunCommandLogsRetentionDurationHours  int
 	SessionLogsRetentionDurationHours     int
+	// resource limits of the out-of-proc document workers, 0 means unlimited
+	DocumentWorkerMemoryLimitMB   int
+	DocumentWorkerCPULimitSeconds int
+	// scheduling priority of the out-of-proc document workers, empty inherits the agent's, BelowNormal or Idle lowers it
+	DocumentWorkerPriority string
 }
 
 // AgentInfo represents metadata for amazon-ssm-agent
@@ -0,0 +1,78 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package outofproc
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer/outofproc/channel"
+	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer/outofproc/proc"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+//how long the worker has to acknowledge a cancel before it's killed
+var defaultCancelAckTimeout = 10 * time.Second
+
+//ErrCancelNotAcked is returned by CancelWorker when the worker is killed for not acknowledging the cancel
+var ErrCancelNotAcked = errors.New("worker did not acknowledge the cancel, killed")
+
+//controlSender is the part of the file channel delivering acknowledged control messages
+type controlSender interface {
+	SendControlWithAck(msg channel.ControlMessage, deadline time.Duration) (<-chan channel.AckResult, error)
+}
+
+//CancelWorker sends a cancel control message to the worker, the worker acknowledges it once it has cancelled the document;
+//if the cancel cannot be sent or is not acknowledged within ackTimeout, e.g. the worker is stuck, the worker process is killed
+func CancelWorker(log log.T, ipc channel.Channel, process proc.OSProcess, ackTimeout time.Duration) error {
+	sender, ok := ipc.(controlSender)
+	if !ok {
+		log.Errorf("channel does not support control messages, killing process: %v", process.Pid())
+		return killUnacked(process)
+	}
+	result, err := sender.SendControlWithAck(channel.ControlMessage{Type: channel.ControlCancel}, ackTimeout)
+	if err != nil {
+		log.Errorf("failed to send cancel to process: %v, killing it: %v", process.Pid(), err)
+		return killUnacked(process)
+	}
+	if ack := <-result; ack.Acked {
+		log.Infof("process: %v acknowledged the cancel in %v", process.Pid(), ack.Latency)
+		return nil
+	}
+	log.Errorf("process: %v did not acknowledge the cancel within %v, killing it", process.Pid(), ackTimeout)
+	return killUnacked(process)
+}
+
+func killUnacked(process proc.OSProcess) error {
+	if err := process.Kill(); err != nil {
+		return err
+	}
+	return ErrCancelNotAcked
+}
+
+//escalate a cancel of the document to the worker process, the cancel datagram only reaches a worker that's still messaging
+//the process is the launched one, or the reattached one after an agent restart
+func (e *OutOfProcExecuter) cancelOnRequest(ipc channel.Channel, process proc.OSProcess) {
+	//a channel without control messages only gets the cancel datagram
+	if _, ok := ipc.(controlSender); !ok {
+		return
+	}
+	e.cancelFlag.Wait()
+	if !e.cancelFlag.Canceled() {
+		return
+	}
+	if err := CancelWorker(e.ctx.Log(), ipc, process, defaultCancelAckTimeout); err != nil {
+		e.ctx.Log().Errorf("cancel escalated: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// +build integration
+// +build darwin freebsd linux netbsd openbsd
+
+package outofproc
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer/outofproc/channel"
+	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer/outofproc/proc"
+	"github.com/stretchr/testify/assert"
+)
+
+func startSleeper(t *testing.T) proc.OSProcess {
+	process, err := proc.StartProcess("sleep", []string{"30"})
+	assert.NoError(t, err)
+	return process
+}
+
+//waitExited returns true once the process exits
+func waitExited(process proc.OSProcess, timeout time.Duration) bool {
+	done := make(chan bool, 1)
+	go func() {
+		process.Wait()
+		done <- true
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func TestCancelWorkerAcked(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "cancel")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "channel")
+	master, err := channel.NewFileWatcherChannel(logger, channel.ModeMaster, name)
+	assert.NoError(t, err)
+	defer master.Destroy()
+	worker, err := channel.NewFileWatcherChannel(logger, channel.ModeWorker, name)
+	assert.NoError(t, err)
+	defer worker.Close()
+	process := startSleeper(t)
+	defer process.Kill()
+
+	//the worker acknowledges the cancel once it has cancelled the document
+	go func() {
+		msg := <-worker.ControlMessages()
+		assert.Equal(t, channel.ControlCancel, msg.Type)
+		assert.NoError(t, worker.Acknowledge(msg))
+	}()
+	assert.NoError(t, CancelWorker(logger, master, process, 5*time.Second))
+	//an acknowledging worker is left to tear itself down
+	assert.False(t, waitExited(process, 200*time.Millisecond))
+}
+
+func TestCancelWorkerStuckIsKilled(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "cancel")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "channel")
+	master, err := channel.NewFileWatcherChannel(logger, channel.ModeMaster, name)
+	assert.NoError(t, err)
+	defer master.Destroy()
+	worker, err := channel.NewFileWatcherChannel(logger, channel.ModeWorker, name)
+	assert.NoError(t, err)
+	defer worker.Close()
+	process := startSleeper(t)
+
+	//the channel of the worker is alive and receives the cancel, but the worker never gets to handle it
+	assert.Equal(t, ErrCancelNotAcked, CancelWorker(logger, master, process, 500*time.Millisecond))
+	assert.Equal(t, channel.ControlCancel, (<-worker.ControlMessages()).Type)
+	assert.True(t, waitExited(process, 5*time.Second))
+}
+
+func TestCancelWorkerNotAckedIsKilled(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "cancel")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	//the worker is hung and never opens its end of the channel
+	master, err := channel.NewFileWatcherChannel(logger, channel.ModeMaster, path.Join(dir, "channel"))
+	assert.NoError(t, err)
+	defer master.Destroy()
+	process := startSleeper(t)
+
+	assert.Equal(t, ErrCancelNotAcked, CancelWorker(logger, master, process, 200*time.Millisecond))
+	assert.True(t, waitExited(process, 5*time.Second))
+}
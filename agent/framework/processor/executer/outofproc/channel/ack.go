@@ -0,0 +1,120 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"fmt"
+	"time"
+)
+
+//the control message acknowledging a message sent with an ack deadline, its content is the correlation id of the message
+const controlAck ControlType = "ack"
+
+//AckResult resolves a message sent with an ack deadline
+type AckResult struct {
+	//false if the peer did not acknowledge the message within the deadline
+	Acked bool
+	//time between the send and the acknowledgment, the deadline if not acked
+	Latency time.Duration
+}
+
+//pendingAck is a sent message waiting for its acknowledgment
+type pendingAck struct {
+	sentAt time.Time
+	result chan AckResult
+	timer  *time.Timer
+}
+
+//SendWithAck sends a payload the peer acknowledges once it's delivered to its consumer, the returned go channel receives
+//a single AckResult once acked or once the deadline passes; the peer must unwrap the envelope and send the acks,
+//a legacy peer never acks
+func (ch *fileWatcherChannel) SendWithAck(rawJson string, deadline time.Duration) (<-chan AckResult, error) {
+	return ch.sendWithAck(envelope{Payload: rawJson}, deadline)
+}
+
+//SendControlWithAck is SendWithAck for a control message, e.g. to escalate when a cancel goes unacked; unlike a payload,
+//the control message is acknowledged by the consumer of the peer once it's handled, see Acknowledge()
+func (ch *fileWatcherChannel) SendControlWithAck(msg ControlMessage, deadline time.Duration) (<-chan AckResult, error) {
+	return ch.sendWithAck(envelope{Control: &msg}, deadline)
+}
+
+func (ch *fileWatcherChannel) sendWithAck(env envelope, deadline time.Duration) (<-chan AckResult, error) {
+	pending := &pendingAck{
+		sentAt: time.Now(),
+		result: make(chan AckResult, 1),
+	}
+	//the correlation id is registered before the send, so that an ack never arrives ahead of it
+	ch.acksMu.Lock()
+	ch.ackCounter++
+	env.AckID = fmt.Sprintf("%v-%v-%v", ch.mode, ch.startTime, ch.ackCounter)
+	if ch.acks == nil {
+		ch.acks = make(map[string]*pendingAck)
+	}
+	ch.acks[env.AckID] = pending
+	pending.timer = time.AfterFunc(deadline, func() {
+		if ch.takeAck(env.AckID) != nil {
+			ch.logger.Errorf("message %v not acknowledged within %v", env.AckID, deadline)
+			pending.result <- AckResult{Latency: deadline}
+		}
+	})
+	ch.acksMu.Unlock()
+	if err := ch.send(env); err != nil {
+		if ch.takeAck(env.AckID) != nil {
+			pending.timer.Stop()
+		}
+		return nil, err
+	}
+	return pending.result, nil
+}
+
+//remove the pending ack of the given correlation id, return nil if it's already resolved
+func (ch *fileWatcherChannel) takeAck(id string) *pendingAck {
+	ch.acksMu.Lock()
+	defer ch.acksMu.Unlock()
+	pending, ok := ch.acks[id]
+	if !ok {
+		return nil
+	}
+	delete(ch.acks, id)
+	return pending
+}
+
+//resolve the pending ack of a received acknowledgment, a late ack is dropped
+func (ch *fileWatcherChannel) resolveAck(id string) {
+	pending := ch.takeAck(id)
+	if pending == nil {
+		ch.logger.Debugf("dropping late or unknown ack %v", id)
+		return
+	}
+	pending.timer.Stop()
+	pending.result <- AckResult{Acked: true, Latency: time.Since(pending.sentAt)}
+}
+
+//Acknowledge tells the peer that a control message received from ControlMessages() is handled, it does nothing if the
+//peer did not ask for an acknowledgment
+func (ch *fileWatcherChannel) Acknowledge(msg ControlMessage) error {
+	if msg.AckID == "" {
+		return nil
+	}
+	return ch.SendControl(ControlMessage{Type: controlAck, Content: msg.AckID})
+}
+
+//acknowledge a delivered message to the peer, off the consuming go-routine since Send() must not be called under consumeMu
+func (ch *fileWatcherChannel) acknowledge(id string) {
+	ch.spawn(func() {
+		if err := ch.SendControl(ControlMessage{Type: controlAck, Content: id}); err != nil {
+			ch.logger.Errorf("failed to acknowledge message %v: %v", id, err)
+		}
+	})
+}
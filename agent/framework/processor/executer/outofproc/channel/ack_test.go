@@ -0,0 +1,97 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func waitForAck(t *testing.T, result <-chan AckResult) AckResult {
+	select {
+	case ack := <-result:
+		return ack
+	case <-time.After(10 * time.Second):
+		assert.Fail(t, "ack never resolved")
+		return AckResult{}
+	}
+}
+
+func TestSendWithAck(t *testing.T) {
+	//the binary envelope has no room for the correlation id, the message falls back to json
+	pair := newChannelPair(t, harnessVariant{master: Options{Encoding: EncodingBinary}})
+	defer pair.cleanup()
+	master, worker := pair.master, pair.worker
+
+	result, err := master.SendWithAck("payload", 5*time.Second)
+	assert.NoError(t, err)
+	msg, err := worker.WaitForMessage(5 * time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "payload", msg)
+	ack := waitForAck(t, result)
+	assert.True(t, ack.Acked)
+	assert.True(t, ack.Latency > 0)
+
+	result, err = master.SendControlWithAck(ControlMessage{Type: ControlCancel}, 5*time.Second)
+	assert.NoError(t, err)
+	control := <-worker.ControlMessages()
+	assert.Equal(t, ControlCancel, control.Type)
+	//a control message is acknowledged once the consumer handled it, not on its delivery
+	time.Sleep(200 * time.Millisecond)
+	assert.Empty(t, result)
+	assert.NoError(t, worker.Acknowledge(control))
+	assert.True(t, waitForAck(t, result).Acked)
+	//a control message sent without an ack has nothing to acknowledge
+	assert.NoError(t, worker.Acknowledge(ControlMessage{Type: ControlCancel}))
+	//the acks are consumed by the channel, the consumer only sees the messages
+	_, err = master.WaitForMessage(100 * time.Millisecond)
+	assert.Equal(t, ErrMessageTimeout, err)
+	assert.Empty(t, master.ControlMessages())
+}
+
+func TestSendWithAckTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "ack")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	//the worker never starts
+	master, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, path.Join(dir, "channel"))
+	assert.NoError(t, err)
+	defer master.Destroy()
+
+	deadline := 100 * time.Millisecond
+	result, err := master.SendControlWithAck(ControlMessage{Type: ControlCancel}, deadline)
+	assert.NoError(t, err)
+	ack := waitForAck(t, result)
+	assert.False(t, ack.Acked)
+	assert.Equal(t, deadline, ack.Latency)
+	//an ack arriving after the deadline is dropped
+	master.resolveAck("master-20170101000000-1")
+	assert.Empty(t, result)
+	master.acksMu.Lock()
+	assert.Empty(t, master.acks)
+	master.acksMu.Unlock()
+
+	master.Close()
+	_, err = master.SendWithAck("closed", deadline)
+	assert.Equal(t, ErrChannelClosed, err)
+	master.acksMu.Lock()
+	assert.Empty(t, master.acks)
+	master.acksMu.Unlock()
+}
@@ -0,0 +1,73 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"path"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
+)
+
+type AuditOutcome string
+
+const (
+	//the payload or the control message was handed over to the consumer
+	AuditDelivered AuditOutcome = "delivered"
+	//the message was moved aside for a replay, see ReplayDeadLetter()
+	AuditDeadLettered AuditOutcome = "deadlettered"
+	//the message was past its expiry, see SendWithExpiry()
+	AuditExpired AuditOutcome = "expired"
+	//the message was removed without being delivered, e.g. it failed to decode or came from an unexpected peer
+	AuditDropped AuditOutcome = "dropped"
+)
+
+//AuditRecord is the receipt of a message of the peer, see Options.OnAudit
+type AuditRecord struct {
+	//Options.Tag of the channel
+	Tag string `json:"tag,omitempty"`
+	//the sequence id of the message, i.e. the name of its file
+	ID string `json:"id"`
+	//when the peer sent the message, zero unless the peer has TrackLatency enabled
+	SentAt time.Time `json:"sentAt"`
+	//when the message was consumed by this end
+	ConsumedAt time.Time    `json:"consumedAt"`
+	Outcome    AuditOutcome `json:"outcome"`
+}
+
+//String formats the record as a single line of json, e.g. to ship it to an audit sink
+func (r AuditRecord) String() string {
+	line, err := jsonutil.Marshal(r)
+	if err != nil {
+		return ""
+	}
+	return line
+}
+
+//pass the receipt of a message to Options.OnAudit, sentAt is 0 if unknown; the caller must hold consumeMu
+func (ch *fileWatcherChannel) audit(filepath string, sentAt int64, outcome AuditOutcome) {
+	if ch.options.OnAudit == nil {
+		return
+	}
+	record := AuditRecord{
+		Tag:        ch.options.Tag,
+		ID:         path.Base(filepath),
+		ConsumedAt: time.Unix(0, ch.now()),
+		Outcome:    outcome,
+	}
+	if sentAt > 0 {
+		record.SentAt = time.Unix(0, sentAt)
+	}
+	ch.options.OnAudit(record)
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditRoundTrip(t *testing.T) {
+	records := make(chan AuditRecord, 10)
+	pair := newChannelPair(t, harnessVariant{
+		master: Options{OnAudit: func(record AuditRecord) { records <- record }},
+		worker: Options{TrackLatency: true},
+	})
+	defer pair.cleanup()
+	master, worker := pair.master, pair.worker
+
+	before := time.Now()
+	assert.NoError(t, worker.Send("result"))
+	msg, err := master.WaitForMessage(5 * time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "result", msg)
+	record := <-records
+	assert.True(t, strings.HasPrefix(record.ID, string(ModeWorker)))
+	assert.Equal(t, AuditDelivered, record.Outcome)
+	assert.False(t, record.SentAt.Before(before.Add(-time.Second)))
+	assert.False(t, record.ConsumedAt.Before(record.SentAt))
+	assert.Contains(t, record.String(), `"outcome":"delivered"`)
+
+	//an expired message is audited but not delivered
+	assert.NoError(t, worker.SendWithExpiry("stale", -time.Second))
+	record = <-records
+	assert.Equal(t, AuditExpired, record.Outcome)
+	assert.Empty(t, master.onMessageChan)
+}
+
+func TestAuditDeadLetter(t *testing.T) {
+	var records []AuditRecord
+	ch := newTestChannel(t, ModeMaster, Options{OnAudit: func(record AuditRecord) { records = append(records, record) }})
+	defer os.RemoveAll(ch.path)
+	dropMessage(t, ch.path, "worker-20170101000000-001x", "m1")
+	ch.consumeAll()
+	assert.Len(t, records, 1)
+	assert.Equal(t, AuditRecord{ID: "worker-20170101000000-001x", ConsumedAt: records[0].ConsumedAt, Outcome: AuditDeadLettered}, records[0])
+	assert.Equal(t, []string{"worker-20170101000000-001x"}, ch.DeadLetters())
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"os"
+	"path"
+	"time"
+)
+
+//how often the backlog age is checked against Options.BacklogAgeThreshold, injected by the tests
+var backlogCheckInterval = time.Second
+
+//backlogAge returns the age of the oldest message of the peer still waiting in the channel directory, 0 if there is none
+//the age is measured from the file modification time, since the counter id scheme only carries the channel start time
+//a message failed to be removed after delivery keeps counting, it is already reported as ErrNotWritable
+func (ch *fileWatcherChannel) backlogAge() time.Duration {
+	_, age := ch.pending()
+	return age
+}
+
+//pending returns the number of messages of the peer waiting in the channel directory and the age of the oldest one
+//only the message files are stat'ed, so that it stays cheap enough for a diagnostics loop
+func (ch *fileWatcherChannel) pending() (count int, age time.Duration) {
+	ch.mu.RLock()
+	dir := ch.path
+	ch.mu.RUnlock()
+	f, err := os.Open(dir)
+	if err != nil {
+		return 0, 0
+	}
+	names, _ := f.Readdirnames(-1)
+	f.Close()
+	var oldest time.Time
+	for _, name := range names {
+		if !ch.isReadable(name) {
+			continue
+		}
+		info, err := os.Lstat(path.Join(dir, name))
+		if err != nil {
+			//consumed in the meantime
+			continue
+		}
+		count++
+		if oldest.IsZero() || info.ModTime().Before(oldest) {
+			oldest = info.ModTime()
+		}
+	}
+	if oldest.IsZero() {
+		return count, 0
+	}
+	return count, time.Since(oldest)
+}
+
+//monitorBacklog calls Options.OnBacklogAge once the backlog grows older than the threshold, and once more
+//every time the backlog gets stuck again after draining below the threshold, it stops when the channel is closed
+func (ch *fileWatcherChannel) monitorBacklog() {
+	defer ch.recoverPanic()
+	ticker := time.NewTicker(backlogCheckInterval)
+	defer ticker.Stop()
+	fired := false
+	for range ticker.C {
+		if ch.isClosed() {
+			return
+		}
+		age := ch.backlogAge()
+		if age < ch.options.BacklogAgeThreshold {
+			fired = false
+			continue
+		}
+		if !fired {
+			fired = true
+			ch.logger.Errorf("oldest message of channel %v has been waiting for %v", ch.path, age)
+			ch.options.OnBacklogAge(age)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBacklogAge(t *testing.T) {
+	defer func(interval time.Duration) { backlogCheckInterval = interval }(backlogCheckInterval)
+	backlogCheckInterval = 10 * time.Millisecond
+	const threshold = 100 * time.Millisecond
+	dir, err := ioutil.TempDir(".", "backlog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ages := make(chan time.Duration, 10)
+	//nobody consumes the channel directory
+	ch := newTestChannelAt(t, ModeMaster, dir, Options{
+		BacklogAgeThreshold: threshold,
+		OnBacklogAge:        func(age time.Duration) { ages <- age },
+	})
+	go ch.monitorBacklog()
+	defer func() {
+		ch.mu.Lock()
+		ch.closed = true
+		ch.mu.Unlock()
+	}()
+
+	//the messages sent by this end do not count
+	dropMessage(t, dir, "master-20170101000000-000", "sent")
+	assert.Equal(t, time.Duration(0), ch.Stats().BacklogAge)
+
+	dropMessage(t, dir, "worker-20170101000000-000", "waiting")
+	select {
+	case age := <-ages:
+		assert.True(t, age >= threshold, "age %v below the threshold", age)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "backlog age never crossed the threshold")
+	}
+	assert.True(t, ch.Stats().BacklogAge >= threshold)
+	//the callback fires once per stuck backlog
+	time.Sleep(5 * backlogCheckInterval)
+	assert.Empty(t, ages)
+
+	ch.consumeAll()
+	assert.Equal(t, "waiting", <-ch.onMessageChan)
+	assert.Equal(t, time.Duration(0), ch.Stats().BacklogAge)
+}
@@ -0,0 +1,70 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"errors"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//a failing hook keeps the message on disk undelivered, the retry delivers it once the hook succeeds
+func TestBeforeDeleteDefersDeletion(t *testing.T) {
+	defer func(interval time.Duration) { deleteRetryInterval = interval }(deleteRetryInterval)
+	deleteRetryInterval = 10 * time.Millisecond
+	var mu sync.Mutex
+	var audited []string
+	failing := true
+	hook := func(sequenceID string, content string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing {
+			failing = false
+			return errors.New("audit sink unavailable")
+		}
+		audited = append(audited, sequenceID+":"+content)
+		return nil
+	}
+	ch := newTestChannel(t, ModeMaster, Options{BeforeDelete: hook})
+	defer os.RemoveAll(ch.path)
+	dropMessage(t, ch.path, sequenceName(0), "m0")
+	dropMessage(t, ch.path, sequenceName(1), "m1")
+
+	ch.consumeAll()
+	//m0 stays on disk and m1 waits behind it
+	ch.consumeMu.Lock()
+	assert.Equal(t, 0, ch.recvCounter)
+	ch.consumeMu.Unlock()
+	_, err := os.Stat(path.Join(ch.path, sequenceName(0)))
+	assert.NoError(t, err)
+
+	for _, expected := range []string{"m0", "m1"} {
+		msg, err := ch.WaitForMessage(5 * time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, msg)
+	}
+	_, err = os.Stat(path.Join(ch.path, sequenceName(0)))
+	assert.True(t, os.IsNotExist(err))
+	mu.Lock()
+	assert.Equal(t, []string{sequenceName(0) + ":m0", sequenceName(1) + ":m1"}, audited)
+	mu.Unlock()
+	ch.mu.Lock()
+	ch.closed = true
+	ch.mu.Unlock()
+}
@@ -1,7 +1,9 @@
 package channel
 
 import (
+	"errors"
 	"path"
+	"time"
 
 	"github.com/aws/amazon-ssm-agent/agent/appconfig"
 	"github.com/aws/amazon-ssm-agent/agent/fileutil"
@@ -20,41 +22,115 @@ const (
 
 type Mode string
 
+var (
+	//ErrChannelClosed is returned when the channel is closed while being used
+	ErrChannelClosed = errors.New("channel already closed")
+	//ErrMessageTimeout is returned when no message arrives within the requested duration
+	ErrMessageTimeout = errors.New("timed out waiting for message")
+	//ErrNotWritable is returned when the channel directory cannot be written, e.g. the file system turned read-only
+	ErrNotWritable = errors.New("channel directory is not writable")
+	//ErrCrossFilesystem is returned when a channel is moved to another file system, which cannot be done atomically
+	ErrCrossFilesystem = errors.New("cannot move channel across file systems")
+	//ErrInvalidJSON is returned by Send() when the payload is not valid json and Options.ValidateJSON is set
+	ErrInvalidJSON = errors.New("payload is not valid json")
+	//ErrMultipleConsumers is the panic value of a second GetMessage() call, or of one mixed with WaitForMessage(), delivery is
+	//pinned to a single consumer
+	ErrMultipleConsumers = errors.New("channel messages already have a consumer")
+	//ErrPathEscaped is returned when the channel directory no longer is the one resolved at construction, e.g. a link was swapped in
+	ErrPathEscaped = errors.New("channel directory was replaced after it was opened")
+	//ErrStreamAborted is returned by a writer of SendStream() once it timed out or the channel was destroyed
+	ErrStreamAborted = errors.New("stream aborted before it was closed")
+	//ErrQuiesceTimeout is returned by Quiesce() when the pending messages are not taken by the consumers in time
+	ErrQuiesceTimeout = errors.New("timed out waiting for the pending messages to be delivered")
+	//ErrTransformNotPermitted is returned when a message is to be compressed or encrypted without the peer agreeing to it
+	ErrTransformNotPermitted = errors.New("message transform is not agreed with the peer")
+	//ErrIdleTimeout is returned by WaitIdle() when the channel does not become idle in time
+	ErrIdleTimeout = errors.New("timed out waiting for the channel to become idle")
+	//ErrOwnershipLocked is returned by ClaimOwnership() when another channel holds the ownership lock for too long
+	ErrOwnershipLocked = errors.New("timed out waiting for the ownership lock of the channel directory")
+	//ErrEndOfStream is returned by WaitForMessage() once the peer ended the stream and the payloads before it are taken
+	ErrEndOfStream = errors.New("peer ended the stream")
+	//ErrPathTooLong is returned when the files of the channel would exceed the name or path length limits of the platform
+	ErrPathTooLong = errors.New("channel path exceeds the file name limits of the platform")
+)
+
 //Channel is defined as a persistent interface for raw json datagram transmission, it is designed to adopt both file ad named pipe
 type Channel interface {
 	//send a raw json datagram to the channel, return when send is "complete" -- message is dropped to the persistent layer
 	Send(string) error
 	//receive a dategram, the go channel on the other end is closed when channel is closed
+	//the messages are delivered in order to a single consumer go-routine, obtain the go channel once and keep reading it from there
 	GetMessage() <-chan string
+	//block until the next datagram is received, return ErrMessageTimeout if it does not arrive within the timeout
+	//the caller pulling the datagrams is their single consumer too, do not mix it with GetMessage()
+	WaitForMessage(timeout time.Duration) (string, error)
 	//safely release all in memory resources -- drain the sending/receiving/queue and GetMessage() go channel, channel is reusable after close
 	Close()
 	//destroy the persistent channel transport, channel is no longer reusable after destroy
 	Destroy()
 }
 
+//DefaultRoot returns the default root dir of the file channels
+func DefaultRoot() (string, error) {
+	instanceID, err := platform.InstanceID()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(appconfig.DefaultDataStorePath, instanceID, defaultFileChannelPath), nil
+}
+
+//ChannelPath returns the directory of the named file channel under the default root dir
+func ChannelPath(filename string) (string, error) {
+	root, err := DefaultRoot()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(root, filename), nil
+}
+
 //find the folder named as "documentID" under the default root dir
 //if not found, create a new filechannel under the default root dir
 //return the channel and the found flag
 func CreateFileChannel(log log.T, mode Mode, filename string) (Channel, error, bool) {
-	instanceID, err := platform.InstanceID()
+	root, err := DefaultRoot()
 	if err != nil {
 		log.Errorf("failed to load instance ID: %v", err)
 		return nil, err, false
 	}
-	list, err := fileutil.ReadDir(path.Join(appconfig.DefaultDataStorePath, instanceID, defaultFileChannelPath))
+	return createFileChannel(log, root, mode, filename, Options{})
+}
+
+//CreateFileChannelUnderRoot is CreateFileChannel with the channel directories placed under the given root instead of the default one,
+//e.g. a tmpfs mount for speed or a per-user directory for RunAs isolation; the root must already exist, see ValidateRoot()
+//the file system of the root is probed once, see InspectRoot(), Options.CooperativeLock is enabled if its rename is not atomic
+func CreateFileChannelUnderRoot(log log.T, root string, mode Mode, filename string) (Channel, error, bool) {
+	report, err := InspectRoot(root)
+	if err != nil {
+		log.Errorf("invalid channel root: %v", err)
+		return nil, err, false
+	}
+	if !report.AtomicRename {
+		log.Infof("rename is not atomic under channel root %v, locking the messages while they are written", root)
+	}
+	return createFileChannel(log, root, mode, filename, Options{CooperativeLock: !report.AtomicRename})
+}
+
+func createFileChannel(log log.T, root string, mode Mode, filename string, options Options) (Channel, error, bool) {
+	channelPath := path.Join(root, filename)
+	list, err := fileutil.ReadDir(root)
 	if err != nil {
-		log.Infof("failed to read the default channel root directory: %v, creating a new Channel", err)
-		f, err := NewFileWatcherChannel(log, mode, path.Join(appconfig.DefaultDataStorePath, instanceID, defaultFileChannelPath, filename))
+		log.Infof("failed to read the channel root directory: %v, creating a new Channel", err)
+		f, err := NewFileWatcherChannelWithOptions(log, mode, channelPath, options)
 		return f, err, false
 	}
 	for _, val := range list {
 		if val.Name() == filename {
 			log.Infof("channel: %v found", filename)
-			f, err := NewFileWatcherChannel(log, mode, path.Join(appconfig.DefaultDataStorePath, instanceID, defaultFileChannelPath, filename))
+			f, err := reopenFileWatcherChannel(log, mode, channelPath, options)
 			return f, err, true
 		}
 	}
 	log.Infof("channel: %v not found, creating a new file channel...", filename)
-	f, err := NewFileWatcherChannel(log, mode, path.Join(appconfig.DefaultDataStorePath, instanceID, defaultFileChannelPath, filename))
+	f, err := NewFileWatcherChannelWithOptions(log, mode, channelPath, options)
 	return f, err, false
 }
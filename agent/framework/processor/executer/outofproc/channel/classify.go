@@ -0,0 +1,96 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+//FileClass is the role of a file of the channel directory from the perspective of a channel, see ListFiles()
+type FileClass string
+
+const (
+	//a message of the peer, this side consumes it
+	FileReadable FileClass = "readable"
+	//a message sent by this side and not consumed by the peer yet
+	FileOwn FileClass = "own"
+	//a message name of another mode that this side does not consume either, e.g. its mode collides with this side's,
+	//nobody reads it
+	FileFiltered FileClass = "filtered"
+	//a file under tmp: a message being written, or one taken aside by a lazy read, a stream or a compaction
+	FileTmp FileClass = "tmp"
+	//a message moved aside after it failed to be delivered, see DeadLetters()
+	FileDeadLetter FileClass = "deadletter"
+	//anything else, e.g. the pid files or the owner of the directory
+	FileOther FileClass = "other"
+)
+
+//ClassifiedFile is a file of the channel directory along with its class, the files under tmp are named tmp/<name>
+type ClassifiedFile struct {
+	Name  string
+	Class FileClass
+	//the mode parsed from a message name, empty for the other files
+	Mode Mode
+}
+
+//ListFiles lists the files of the channel directory classified the way this side sees them, to diagnose a message that is
+//not delivered: a message of the peer showing up as filtered rather than readable is dropped by the name filter
+func (ch *fileWatcherChannel) ListFiles() ([]ClassifiedFile, error) {
+	ch.mu.RLock()
+	dir, tmpDir := ch.path, ch.tmpPath
+	ch.mu.RUnlock()
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]ClassifiedFile, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() && path.Join(dir, info.Name()) == tmpDir {
+			continue
+		}
+		files = append(files, ch.classify(info.Name()))
+	}
+	//tmp is missing once the channel is destroyed
+	infos, _ = ioutil.ReadDir(tmpDir)
+	for _, info := range infos {
+		file := ClassifiedFile{Name: path.Join(path.Base(tmpDir), info.Name()), Class: FileTmp}
+		if strings.HasPrefix(info.Name(), deadLetterPrefix) {
+			file.Class = FileDeadLetter
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+//classify a file of the channel directory by the same filter consume() applies
+func (ch *fileWatcherChannel) classify(name string) ClassifiedFile {
+	file := ClassifiedFile{Name: name, Class: FileOther}
+	if !messageNamePattern.MatchString(name) {
+		return file
+	}
+	if id, err := ParseSequenceID(name); err == nil {
+		file.Mode = id.Mode
+	}
+	switch {
+	case ch.isReadable(name):
+		file.Class = FileReadable
+	case file.Mode == ch.mode:
+		file.Class = FileOwn
+	default:
+		file.Class = FileFiltered
+	}
+	return file
+}
@@ -0,0 +1,68 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListFiles(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(ch.path)
+	dropMessage(t, ch.path, sequenceName(0), "m0")
+	dropMessage(t, ch.path, sequenceName(1), "m1")
+	dropMessage(t, ch.path, "master-20170101000000-000", "own")
+	//a peer whose mode contains this side's is filtered by the name
+	dropMessage(t, ch.path, "submaster-20170101000000-000", "lost")
+	for _, name := range []string{"owner", path.Join("tmp", "sending-master-1-1"), path.Join("tmp", deadLetterPrefix+sequenceName(2))} {
+		assert.NoError(t, ioutil.WriteFile(path.Join(ch.path, name), []byte("x"), defaultFileWriteMode))
+	}
+
+	files, err := ch.ListFiles()
+	assert.NoError(t, err)
+	counts := make(map[FileClass]int)
+	for _, file := range files {
+		counts[file.Class]++
+		if file.Name == "submaster-20170101000000-000" {
+			assert.Equal(t, FileFiltered, file.Class)
+			assert.Equal(t, Mode("submaster"), file.Mode)
+		}
+	}
+	assert.Equal(t, map[FileClass]int{
+		FileReadable:   2,
+		FileOwn:        1,
+		FileFiltered:   1,
+		FileTmp:        1,
+		FileDeadLetter: 1,
+		FileOther:      1,
+	}, counts)
+
+	//the worker sees the same directory the other way around
+	worker := newTestChannel(t, ModeWorker, Options{})
+	os.RemoveAll(worker.path)
+	worker.path, worker.tmpPath = ch.path, ch.tmpPath
+	files, err = worker.ListFiles()
+	assert.NoError(t, err)
+	counts = make(map[FileClass]int)
+	for _, file := range files {
+		counts[file.Class]++
+	}
+	assert.Equal(t, 2, counts[FileOwn])
+	assert.Equal(t, 2, counts[FileReadable])
+}
@@ -0,0 +1,92 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	//the wall clock, in unix nano, only used for the timestamps shared with the peer, i.e. the latency and the expiry
+	wallClock = func() int64 {
+		return time.Now().UnixNano()
+	}
+	//the elapsed time on the monotonic clock, which an adjustment of the wall clock does not affect
+	monotonicClock = func() time.Duration {
+		return time.Since(processStart)
+	}
+	processStart = time.Now()
+)
+
+//the wall clock falling behind the monotonic clock by more than this is a step rather than a slew of the time sync
+const clockStepTolerance = 100 * time.Millisecond
+
+//clockGuard stamps the messages of a channel on the monotonic clock and detects the wall clock stepping backwards
+type clockGuard struct {
+	mu sync.Mutex
+	//the readings of both clocks at the last check
+	wall int64
+	mono time.Duration
+	//the readings at construction, the stamps advance from the wall clock of then on the monotonic clock
+	baseWall int64
+	baseMono time.Duration
+	steps    uint64
+}
+
+func newClockGuard() *clockGuard {
+	wall, mono := wallClock(), monotonicClock()
+	return &clockGuard{
+		wall:     wall,
+		mono:     mono,
+		baseWall: wall,
+		baseMono: mono,
+	}
+}
+
+//check reads the wall clock along with how far it stepped back since the last check, 0 if it did not
+func (g *clockGuard) check() (int64, time.Duration) {
+	wall, mono := wallClock(), monotonicClock()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	step := (mono - g.mono) - time.Duration(wall-g.wall)
+	g.wall, g.mono = wall, mono
+	if step <= clockStepTolerance {
+		return wall, 0
+	}
+	g.steps++
+	return wall, step
+}
+
+//stamp returns the current unix nano time on the monotonic clock, so that the ids of a channel stay ordered by send time
+//whatever the wall clock does in between
+func (g *clockGuard) stamp() int64 {
+	return g.baseWall + int64(monotonicClock()-g.baseMono)
+}
+
+func (g *clockGuard) backwardSteps() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.steps
+}
+
+//now reads the wall clock for a timestamp shared with the peer, logging a backward step since the last reading since it
+//skews the latency and the expiry of the messages in flight
+func (ch *fileWatcherChannel) now() int64 {
+	wall, step := ch.clock.check()
+	if step > 0 {
+		ch.logger.Errorf("wall clock of channel %v stepped back by %v, the latency and expiry of the messages in flight are skewed", ch.path, step)
+	}
+	return wall
+}
@@ -0,0 +1,89 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//fake both clocks, advance steps the wall clock by the given offset while the monotonic clock moves on by a millisecond
+func fakeClocks() (advance func(step time.Duration), restore func()) {
+	wall, mono := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano(), time.Second
+	originalWall, originalMono := wallClock, monotonicClock
+	wallClock = func() int64 { return wall }
+	monotonicClock = func() time.Duration { return mono }
+	advance = func(step time.Duration) {
+		wall += int64(step + time.Millisecond)
+		mono += time.Millisecond
+	}
+	restore = func() {
+		wallClock, monotonicClock = originalWall, originalMono
+	}
+	return
+}
+
+func TestClockSteppingBackwards(t *testing.T) {
+	advance, restore := fakeClocks()
+	defer restore()
+	ch := newTestChannel(t, ModeMaster, Options{IDScheme: IDSchemeTimestamp})
+	defer os.RemoveAll(ch.path)
+
+	before := ch.nextSequenceID()
+	content, err := encodeEnvelope(envelope{Payload: "sent before the step", SentAt: ch.now()})
+	assert.NoError(t, err)
+	advance(-time.Hour)
+	//the ids keep their order, stamped on the monotonic clock
+	after := ch.nextSequenceID()
+	assert.True(t, after > before, "%v is not after %v", after, before)
+	beforeID, _ := ParseSequenceID(before)
+	afterID, _ := ParseSequenceID(after)
+	assert.Equal(t, 0, afterID.Counter)
+	assert.NotEqual(t, beforeID.Stamp, afterID.Stamp)
+
+	//the message sent before the step is delivered, its negative latency is not a sample
+	dropMessage(t, ch.path, sequenceName(0), content)
+	ch.onCreate(path.Join(ch.path, sequenceName(0)))
+	assert.Equal(t, "sent before the step", <-ch.onMessageChan)
+	stats := ch.Stats()
+	assert.Equal(t, uint64(1), stats.ClockSteps)
+	assert.Equal(t, uint64(0), stats.SendLatency.Count)
+
+	//a steady clock or one slewed within the tolerance is not a step
+	advance(0)
+	ch.now()
+	advance(-clockStepTolerance / 2)
+	ch.now()
+	assert.Equal(t, uint64(1), ch.Stats().ClockSteps)
+}
+
+func TestClockGuardStamp(t *testing.T) {
+	advance, restore := fakeClocks()
+	defer restore()
+	guard := newClockGuard()
+	start := guard.stamp()
+	advance(-time.Hour)
+	assert.Equal(t, int64(time.Millisecond), guard.stamp()-start)
+	wall, step := guard.check()
+	assert.Equal(t, time.Hour, step)
+	assert.Equal(t, wallClock(), wall)
+	advance(time.Hour)
+	_, step = guard.check()
+	assert.Equal(t, time.Duration(0), step)
+	assert.Equal(t, uint64(1), guard.backwardSteps())
+}
@@ -0,0 +1,241 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+/*
+	compacted file, replacing a run of contiguous messages under the sequence id of the first one, all integers are big endian:
+	magic(1) | frame | frame | ...
+	frame: name length(2) | name | content length(4) | content
+	the name is the original sequence id of the message and the content the original file content, raw or in an envelope
+	like the binary envelope, the magic byte never starts a json document nor a valid utf-8 sequence
+*/
+const (
+	compactedMagic       = 0xc0
+	compactingFilePrefix = "compacting-"
+)
+
+//the size a compacted file grows up to, it's read into memory as a whole; a larger backlog is compacted into several files
+var compactMaxBytes = 4 << 20
+
+var errCorruptCompacted = errors.New("corrupt compacted file")
+
+//a message read from a compacted file
+type frame struct {
+	name    string
+	content string
+}
+
+func isCompacted(content string) bool {
+	return len(content) > 0 && content[0] == compactedMagic
+}
+
+func decodeCompacted(content string) ([]frame, error) {
+	var frames []frame
+	rest := content[1:]
+	for len(rest) > 0 {
+		if len(rest) < 2 {
+			return nil, errCorruptCompacted
+		}
+		nameLength := int(binary.BigEndian.Uint16([]byte(rest[:2])))
+		rest = rest[2:]
+		if len(rest) < nameLength+4 {
+			return nil, errCorruptCompacted
+		}
+		name := rest[:nameLength]
+		length := int(binary.BigEndian.Uint32([]byte(rest[nameLength : nameLength+4])))
+		rest = rest[nameLength+4:]
+		if len(rest) < length {
+			return nil, errCorruptCompacted
+		}
+		frames = append(frames, frame{name: name, content: rest[:length]})
+		rest = rest[length:]
+	}
+	return frames, nil
+}
+
+func writeFrame(w io.Writer, name, content string) error {
+	header := make([]byte, 2+len(name)+4)
+	binary.BigEndian.PutUint16(header, uint16(len(name)))
+	copy(header[2:], name)
+	binary.BigEndian.PutUint32(header[2+len(name):], uint32(len(content)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, content)
+	return err
+}
+
+//Compact concatenates the contiguous messages of the peer waiting on disk, from the next expected one on, into fewer files,
+//so that a channel backed up with thousands of small messages is faster to scan and consume; the messages are delivered in
+//order as if they were never compacted. Consuming is paused meanwhile, the peer may keep sending. It returns the number of
+//messages compacted, the backlog is left as is with Options.BeforeDelete, since the hook receives the content of each file.
+//A compacted file is written aside then swapped in place of the first message before the others are removed, the messages
+//left over by an interruption are recognized as delivered with the compacted file
+func (ch *fileWatcherChannel) Compact() (int, error) {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+	if ch.closed {
+		return 0, ErrChannelClosed
+	}
+	if ch.options.BeforeDelete != nil {
+		return 0, nil
+	}
+	ch.consumeMu.Lock()
+	defer ch.consumeMu.Unlock()
+	ch.removeStaleCompactions()
+	fileInfos, err := ch.fs().ReadDir(ch.path)
+	if err != nil {
+		return 0, err
+	}
+	var names []string
+	for _, info := range fileInfos {
+		if name := info.Name(); ch.isReadable(name) {
+			names = append(names, name)
+		}
+	}
+	total := 0
+	next := ch.recvCounter
+	for len(names) > 0 {
+		count, err := ch.compactRun(names, next)
+		if err != nil {
+			return total, err
+		}
+		//a run of a single message is left as is
+		if count < 2 {
+			break
+		}
+		total += count
+		next += count
+		names = names[count:]
+	}
+	if total > 0 {
+		ch.logger.Infof("compacted %v messages", total)
+	}
+	return total, nil
+}
+
+//compact the leading messages of names numbered contiguously from the given counter, up to compactMaxBytes
+//it returns the number of messages compacted, nothing is changed if it's less than 2
+func (ch *fileWatcherChannel) compactRun(names []string, counter int) (int, error) {
+	tmpPath := path.Join(ch.tmpPath, compactingFilePrefix+names[0])
+	f, err := ch.fs().Create(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = f.Write([]byte{compactedMagic}); err != nil {
+		f.Close()
+		ch.fs().Remove(tmpPath)
+		return 0, err
+	}
+	size := 1
+	count := 0
+	for _, name := range names {
+		filepath := path.Join(ch.path, name)
+		if c, err := parseSequenceCounter(filepath); err != nil || c != counter+count || ch.undeletable[name] {
+			break
+		}
+		if ch.options.CooperativeLock && ch.isLocked(filepath) {
+			break
+		}
+		content, err := ch.fs().ReadFile(filepath)
+		if err != nil {
+			break
+		}
+		//a compacted file is not compacted again, and the run does not grow beyond the limit unless it's the first message
+		if isCompacted(string(content)) || (count > 0 && size+len(content)+len(name)+6 > compactMaxBytes) {
+			break
+		}
+		if err = writeFrame(f, name, string(content)); err != nil {
+			f.Close()
+			ch.fs().Remove(tmpPath)
+			return 0, err
+		}
+		size += len(content) + len(name) + 6
+		count++
+	}
+	if syncer, ok := f.(interface {
+		Sync() error
+	}); ok && count > 1 {
+		err = syncer.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || count < 2 {
+		ch.fs().Remove(tmpPath)
+		return 0, err
+	}
+	//the swap is atomic, the messages after the first one are now delivered with the compacted file
+	if err = ch.fs().Rename(tmpPath, path.Join(ch.path, names[0])); err != nil {
+		ch.fs().Remove(tmpPath)
+		return 0, err
+	}
+	for _, name := range names[1:count] {
+		ch.removeConsumed(path.Join(ch.path, name))
+	}
+	return count, nil
+}
+
+//remove the files of the compactions interrupted before their swap, the messages are still in place
+func (ch *fileWatcherChannel) removeStaleCompactions() {
+	fileInfos, _ := ioutil.ReadDir(ch.tmpPath)
+	for _, info := range fileInfos {
+		if strings.HasPrefix(info.Name(), compactingFilePrefix) {
+			os.Remove(path.Join(ch.tmpPath, info.Name()))
+		}
+	}
+}
+
+//deliver the messages of a compacted file in order, the file is removed before any of them is delivered like a single message
+func (ch *fileWatcherChannel) consumeCompacted(filepath string, counter int, content string) {
+	frames, err := decodeCompacted(content)
+	if err != nil {
+		ch.deadLetter(filepath, err)
+		ch.recvCounter = counter + 1
+		return
+	}
+	ch.removeConsumed(filepath)
+	for _, frame := range frames {
+		framePath := path.Join(ch.path, frame.name)
+		frameCounter, err := parseSequenceCounter(framePath)
+		if err != nil {
+			ch.logger.Errorf("dropping message %v of compacted file %v: %v", frame.name, filepath, err)
+			continue
+		}
+		//a frame has no metadata of its own
+		ch.deliverContent(framePath, frameCounter, frame.content, nil, true)
+	}
+}
+
+//keep a message of a compacted file for a replay, see deadLetter()
+func (ch *fileWatcherChannel) deadLetterFrame(filepath string, content string, reason error) {
+	deadPath := path.Join(ch.tmpPath, deadLetterPrefix+path.Base(filepath))
+	ch.logger.Errorf("moving message %v to %v: %v", filepath, deadPath, reason)
+	if err := ioutil.WriteFile(deadPath, []byte(content), defaultFileWriteMode); err != nil {
+		ch.logger.Errorf("failed to move message %v, skipping it: %v", filepath, err)
+	}
+	ch.audit(filepath, 0, AuditDeadLettered)
+	//the file is only left over by an interrupted compaction
+	ch.removeConsumed(filepath)
+}
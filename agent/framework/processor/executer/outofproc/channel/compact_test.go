@@ -0,0 +1,140 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//read the given number of messages off the channel in a separate go-routine, since the go channel buffers only a few of them
+func collectMessages(ch *fileWatcherChannel, count int) <-chan []string {
+	result := make(chan []string, 1)
+	go func() {
+		var messages []string
+		for len(messages) < count {
+			select {
+			case msg := <-ch.onMessageChan:
+				messages = append(messages, msg)
+			case <-time.After(5 * time.Second):
+				result <- messages
+				return
+			}
+		}
+		result <- messages
+	}()
+	return result
+}
+
+func TestCompactBacklog(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(ch.path)
+	const backlog = 10000
+	var expected []string
+	for i := 0; i < backlog; i++ {
+		msg := fmt.Sprintf("message %v", i)
+		content := msg
+		//a few messages are wrapped in an envelope, the rest are raw
+		if i%1000 == 0 {
+			var err error
+			content, err = encodeEnvelope(envelope{SentAt: time.Now().UnixNano(), Payload: msg})
+			assert.NoError(t, err)
+		}
+		dropMessage(t, ch.path, sequenceName(i), content)
+		expected = append(expected, msg)
+	}
+	compacted, err := ch.Compact()
+	assert.NoError(t, err)
+	assert.Equal(t, backlog, compacted)
+	files, err := ioutil.ReadDir(ch.path)
+	assert.NoError(t, err)
+	//the compacted file and the tmp directory
+	assert.Len(t, files, 2)
+	//a message arriving after the compaction is delivered after the compacted ones
+	dropMessage(t, ch.path, sequenceName(backlog), "late")
+	expected = append(expected, "late")
+
+	result := collectMessages(ch, len(expected))
+	ch.consumeAll()
+	assert.Equal(t, expected, <-result)
+	assert.Equal(t, backlog+1, ch.recvCounter)
+	assert.Equal(t, uint64(backlog+1), ch.recvSizes.total())
+	files, err = ioutil.ReadDir(ch.path)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestCompactStopsAtGap(t *testing.T) {
+	defer func(max int) { compactMaxBytes = max }(compactMaxBytes)
+	//room for two messages per file
+	compactMaxBytes = 100
+	ch := newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(ch.path)
+	for _, i := range []int{0, 1, 2, 3, 4, 6, 7} {
+		dropMessage(t, ch.path, sequenceName(i), fmt.Sprintf("message %v", i))
+	}
+	compacted, err := ch.Compact()
+	assert.NoError(t, err)
+	//the limit splits the run, the last message of it is left alone, and the messages after the gap are not touched
+	assert.Equal(t, 4, compacted)
+	files, err := ioutil.ReadDir(ch.path)
+	assert.NoError(t, err)
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	assert.Equal(t, []string{"tmp", sequenceName(0), sequenceName(2), sequenceName(4), sequenceName(6), sequenceName(7)}, names)
+}
+
+//a compaction interrupted right after the swap leaves the original messages behind, they are not delivered twice
+func TestCompactInterrupted(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(ch.path)
+	for i := 0; i < 3; i++ {
+		dropMessage(t, ch.path, sequenceName(i), fmt.Sprintf("message %v", i))
+	}
+	//a compaction interrupted before the swap is discarded by the next one
+	stale := path.Join(ch.tmpPath, compactingFilePrefix+sequenceName(0))
+	assert.NoError(t, ioutil.WriteFile(stale, []byte{compactedMagic, 0}, defaultFileWriteMode))
+	compacted, err := ch.Compact()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, compacted)
+	_, err = os.Stat(stale)
+	assert.True(t, os.IsNotExist(err))
+	for i := 1; i < 3; i++ {
+		dropMessage(t, ch.path, sequenceName(i), fmt.Sprintf("message %v", i))
+	}
+
+	result := collectMessages(ch, 3)
+	ch.consumeAll()
+	assert.Equal(t, []string{"message 0", "message 1", "message 2"}, <-result)
+	assert.Empty(t, ch.onMessageChan)
+	files, err := ioutil.ReadDir(ch.path)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestDecodeCompactedCorrupt(t *testing.T) {
+	_, err := decodeCompacted(string([]byte{compactedMagic, 0, 5, 'a'}))
+	assert.Equal(t, errCorruptCompacted, err)
+	frames, err := decodeCompacted(string([]byte{compactedMagic}))
+	assert.NoError(t, err)
+	assert.Empty(t, frames)
+}
@@ -0,0 +1,125 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+var errEmptyDeadLetter = errors.New("dead-lettered message is empty")
+
+//DeadLetters lists the ids of the messages moved aside by the channel, in the order they were received
+func (ch *fileWatcherChannel) DeadLetters() []string {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+	return ch.listDeadLetters()
+}
+
+//ReplayDeadLetter moves a dead-lettered message back into the channel under a fresh sequence id of the peer, so that
+//it's consumed as the next message; the message is checked to be readable and intact before it's re-injected
+//the id carries the counter of the next expected message, which takes the fast path and stays within the consume window
+func (ch *fileWatcherChannel) ReplayDeadLetter(id string) error {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+	if ch.closed {
+		return ErrChannelClosed
+	}
+	//the message is consumed once the lock is released, after the receiving counter it's based on
+	ch.consumeMu.Lock()
+	defer ch.consumeMu.Unlock()
+	return ch.replayLocked(id, ch.recvCounter)
+}
+
+//ReplayAllDeadLetter replays the dead-lettered messages accepted by the filter in the order they were received, all of them
+//if the filter is nil; it stops at the first message failing to replay and returns the number of messages replayed
+func (ch *fileWatcherChannel) ReplayAllDeadLetter(filter func(id string) bool) (int, error) {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+	if ch.closed {
+		return 0, ErrChannelClosed
+	}
+	ch.consumeMu.Lock()
+	defer ch.consumeMu.Unlock()
+	replayed := 0
+	for _, id := range ch.listDeadLetters() {
+		if filter != nil && !filter(id) {
+			continue
+		}
+		if err := ch.replayLocked(id, ch.recvCounter+replayed); err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+	return replayed, nil
+}
+
+//the caller must hold the read lock, so that the channel is not moved meanwhile
+func (ch *fileWatcherChannel) listDeadLetters() []string {
+	fileInfos, _ := ioutil.ReadDir(ch.tmpPath)
+	var ids []string
+	for _, info := range fileInfos {
+		if strings.HasPrefix(info.Name(), deadLetterPrefix) {
+			ids = append(ids, strings.TrimPrefix(info.Name(), deadLetterPrefix))
+		}
+	}
+	return ids
+}
+
+//re-inject the message with the given counter, the caller must hold the read lock and consumeMu
+func (ch *fileWatcherChannel) replayLocked(id string, counter int) error {
+	log := ch.logger
+	deadPath := path.Join(ch.tmpPath, deadLetterPrefix+id)
+	if err := validateDeadLetter(deadPath); err != nil {
+		log.Errorf("refusing to replay message %v: %v", id, err)
+		return err
+	}
+	replayID := SequenceID{Mode: ch.peerMode(), Stamp: time.Now().Format("20060102150405"), Counter: counter}
+	replayPath := path.Join(ch.path, replayID.String())
+	if _, err := os.Stat(replayPath); err == nil {
+		return fmt.Errorf("cannot replay message %v: %v already exists", id, replayPath)
+	}
+	if err := rename(deadPath, replayPath); err != nil {
+		log.Errorf("failed to replay message %v: %v", id, err)
+		return err
+	}
+	log.Infof("replayed message %v as %v", id, replayID)
+	return nil
+}
+
+//check that the dead-lettered message can be read and, if it's wrapped in a binary envelope, that its checksum matches
+func validateDeadLetter(deadPath string) error {
+	content, err := ioutil.ReadFile(deadPath)
+	if err != nil {
+		return err
+	}
+	if len(content) == 0 {
+		return errEmptyDeadLetter
+	}
+	_, err = decodeEnvelope(string(content))
+	return err
+}
+
+//the mode of the other end, whose messages this end consumes
+func (ch *fileWatcherChannel) peerMode() Mode {
+	if ch.mode == ModeMaster {
+		return ModeWorker
+	}
+	return ModeMaster
+}
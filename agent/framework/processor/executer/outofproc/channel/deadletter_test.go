@@ -0,0 +1,122 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+//open a master channel and dead-letter the given messages, by their malformed ids
+func newDeadLetterChannel(t *testing.T, dir string, messages map[string]string) *fileWatcherChannel {
+	name := path.Join(dir, "channel")
+	ch, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, name)
+	assert.NoError(t, err)
+	for id, content := range messages {
+		dropMessage(t, name, id, content)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(ch.DeadLetters()) < len(messages) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Len(t, ch.DeadLetters(), len(messages))
+	return ch
+}
+
+func TestReplayDeadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "replay")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ch := newDeadLetterChannel(t, dir, map[string]string{
+		"worker-20170101000000-001x": "m1",
+		"worker-20170101000000-002x": "m2",
+	})
+	defer ch.Destroy()
+
+	assert.NoError(t, ch.ReplayDeadLetter("worker-20170101000000-002x"))
+	msg, err := ch.WaitForMessage(5 * time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "m2", msg)
+	assert.Equal(t, []string{"worker-20170101000000-001x"}, ch.DeadLetters())
+	assert.Error(t, ch.ReplayDeadLetter("worker-20170101000000-002x"))
+
+	assert.NoError(t, ch.ReplayDeadLetter("worker-20170101000000-001x"))
+	msg, err = ch.WaitForMessage(5 * time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "m1", msg)
+	assert.Empty(t, ch.DeadLetters())
+}
+
+func TestReplayAllDeadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "replay")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ch := newDeadLetterChannel(t, dir, map[string]string{
+		"worker-20170101000000-001x": "m1",
+		"worker-20170101000000-002x": "m2",
+		"worker-20170101000000-003y": "m3",
+	})
+	defer ch.Destroy()
+
+	replayed, err := ch.ReplayAllDeadLetter(func(id string) bool { return strings.HasSuffix(id, "x") })
+	assert.NoError(t, err)
+	assert.Equal(t, 2, replayed)
+	for _, expected := range []string{"m1", "m2"} {
+		msg, err := ch.WaitForMessage(5 * time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, msg)
+	}
+	assert.Equal(t, []string{"worker-20170101000000-003y"}, ch.DeadLetters())
+
+	replayed, err = ch.ReplayAllDeadLetter(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, replayed)
+	msg, err := ch.WaitForMessage(5 * time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "m3", msg)
+}
+
+func TestReplayDeadLetterValidation(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "replay")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	corrupt := []byte(encodeBinaryEnvelope(envelope{Payload: "m1"}))
+	corrupt[len(corrupt)-1] ^= 0xff
+	ch := newDeadLetterChannel(t, dir, map[string]string{
+		"worker-20170101000000-001x": string(corrupt),
+		"worker-20170101000000-002x": "",
+		"worker-20170101000000-003x": "m3",
+	})
+	defer ch.Destroy()
+
+	assert.Equal(t, ErrCorruptEnvelope, ch.ReplayDeadLetter("worker-20170101000000-001x"))
+	assert.Equal(t, errEmptyDeadLetter, ch.ReplayDeadLetter("worker-20170101000000-002x"))
+	//the bulk replay stops at the first invalid message, which stays dead-lettered
+	replayed, err := ch.ReplayAllDeadLetter(nil)
+	assert.Equal(t, ErrCorruptEnvelope, err)
+	assert.Equal(t, 0, replayed)
+	assert.Len(t, ch.DeadLetters(), 3)
+	_, err = ch.WaitForMessage(100 * time.Millisecond)
+	assert.Equal(t, ErrMessageTimeout, err)
+
+	ch.Close()
+	assert.Equal(t, ErrChannelClosed, ch.ReplayDeadLetter("worker-20170101000000-003x"))
+}
@@ -0,0 +1,55 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"time"
+)
+
+const (
+	//the default of Options.DebounceInterval
+	defaultDebounceInterval = 50 * time.Millisecond
+	//the expired entries are pruned once the recorded events reach this number
+	maxDebounceEntries = 64
+)
+
+//debounced reports whether an event of the file was already handled within the debounce interval, some file systems
+//emit several create events for a single file; otherwise the event is recorded
+func (ch *fileWatcherChannel) debounced(filepath string) bool {
+	interval := ch.options.DebounceInterval
+	if interval == 0 {
+		interval = defaultDebounceInterval
+	}
+	if interval < 0 {
+		return false
+	}
+	ch.debounceMu.Lock()
+	defer ch.debounceMu.Unlock()
+	now := time.Now()
+	if last, ok := ch.recentEvents[filepath]; ok && now.Sub(last) < interval {
+		return true
+	}
+	if ch.recentEvents == nil {
+		ch.recentEvents = make(map[string]time.Time)
+	}
+	if len(ch.recentEvents) >= maxDebounceEntries {
+		for name, last := range ch.recentEvents {
+			if now.Sub(last) >= interval {
+				delete(ch.recentEvents, name)
+			}
+		}
+	}
+	ch.recentEvents[filepath] = now
+	return false
+}
@@ -0,0 +1,81 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+)
+
+//feeds the file events of a test
+type fakeEventSource struct {
+	events chan fsnotify.Event
+	errors chan error
+}
+
+func newFakeEventSource() *fakeEventSource {
+	return &fakeEventSource{
+		events: make(chan fsnotify.Event),
+		errors: make(chan error),
+	}
+}
+
+func (f *fakeEventSource) Events() <-chan fsnotify.Event { return f.events }
+func (f *fakeEventSource) Errors() <-chan error          { return f.errors }
+func (f *fakeEventSource) Remove(name string) error      { return nil }
+func (f *fakeEventSource) Close() error {
+	close(f.events)
+	return nil
+}
+
+func TestDebounced(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{DebounceInterval: 50 * time.Millisecond})
+	defer os.RemoveAll(ch.path)
+	assert.False(t, ch.debounced("a"))
+	assert.True(t, ch.debounced("a"))
+	assert.False(t, ch.debounced("b"))
+	time.Sleep(60 * time.Millisecond)
+	assert.False(t, ch.debounced("a"))
+
+	ch.options.DebounceInterval = -1
+	assert.False(t, ch.debounced("b"))
+	assert.False(t, ch.debounced("b"))
+}
+
+//a repeated create event of a consumed message does not trigger a poll, which would consume the message after it early
+func TestDuplicateCreateEvents(t *testing.T) {
+	for _, interval := range []time.Duration{0, -1} {
+		ch := newTestChannel(t, ModeMaster, Options{DebounceInterval: interval})
+		source := newFakeEventSource()
+		ch.startWatch(source)
+		first := path.Join(ch.path, sequenceName(0))
+		dropMessage(t, ch.path, sequenceName(0), "m0")
+		for i := 0; i < 3; i++ {
+			source.events <- fsnotify.Event{Name: first, Op: fsnotify.Create}
+		}
+		assert.Equal(t, "m0", <-ch.onMessageChan)
+		//the event of this message is still to come, only a poll would consume it
+		dropMessage(t, ch.path, sequenceName(2), "m2")
+		source.events <- fsnotify.Event{Name: first, Op: fsnotify.Create}
+		source.Close()
+		time.Sleep(50 * time.Millisecond)
+		assert.Empty(t, ch.onMessageChan, "debounce interval %v", interval)
+		os.RemoveAll(ch.path)
+	}
+}
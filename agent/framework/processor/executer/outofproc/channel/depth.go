@@ -0,0 +1,71 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"os"
+	"path"
+)
+
+//SendWithDepth sends a payload like Send() and returns the number of the messages of this side the peer has not consumed
+//yet, the new one included, so that a producer can slow down before the peer falls too far behind
+//the depth is tracked from the messages sent since the first call rather than by listing the directory on every send; it
+//runs ahead of the peer while it consumes out of order, e.g. with Options.OrderControlFirst
+func (ch *fileWatcherChannel) SendWithDepth(rawJson string) (int, error) {
+	if err := ch.trackDepth(); err != nil {
+		return 0, err
+	}
+	err := ch.Send(rawJson)
+	return ch.depth(), err
+}
+
+//start tracking the messages sent, seeded with the ones of this side already waiting for the peer, e.g. sent by Send()
+func (ch *fileWatcherChannel) trackDepth() error {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+	ch.sendMu.Lock()
+	defer ch.sendMu.Unlock()
+	if ch.depthTracked {
+		return nil
+	}
+	infos, err := ch.fs().ReadDir(ch.path)
+	if err != nil {
+		ch.logger.Errorf("failed to list the pending messages of channel %v: %v", ch.path, err)
+		return err
+	}
+	for _, info := range infos {
+		if ch.classify(info.Name()).Class == FileOwn {
+			ch.sentNames = append(ch.sentNames, info.Name())
+		}
+	}
+	ch.sortSequenceNames(ch.sentNames)
+	ch.depthTracked = true
+	return nil
+}
+
+//depth drops the messages the peer consumed from the front of the tracked ones, the peer consumes them in order so that
+//it's a single Stat() per call as long as the peer keeps up
+func (ch *fileWatcherChannel) depth() int {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+	ch.sendMu.Lock()
+	defer ch.sendMu.Unlock()
+	for len(ch.sentNames) > 0 {
+		if _, err := ch.fs().Stat(path.Join(ch.path, ch.sentNames[0])); !os.IsNotExist(err) {
+			break
+		}
+		ch.sentNames = ch.sentNames[1:]
+	}
+	return len(ch.sentNames)
+}
@@ -0,0 +1,61 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendWithDepth(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(ch.path)
+	//sent before the depth is tracked
+	assert.NoError(t, ch.Send("m0"))
+	//the messages of the peer are not part of the depth
+	dropMessage(t, ch.path, sequenceName(0), "peer")
+
+	for i := 1; i <= 3; i++ {
+		depth, err := ch.SendWithDepth("m")
+		assert.NoError(t, err)
+		assert.Equal(t, i+1, depth)
+	}
+	//the peer consumes the two oldest
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, os.Remove(path.Join(ch.path, SequenceID{Mode: ModeMaster, Stamp: ch.startTime, Counter: i}.String())))
+	}
+	depth, err := ch.SendWithDepth("m")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, depth)
+
+	files, err := ch.ListFiles()
+	assert.NoError(t, err)
+	own := 0
+	for _, file := range files {
+		if file.Class == FileOwn {
+			own++
+		}
+	}
+	assert.Equal(t, own, depth)
+
+	//the peer catches up
+	for i := 2; i < 5; i++ {
+		assert.NoError(t, os.Remove(path.Join(ch.path, SequenceID{Mode: ModeMaster, Stamp: ch.startTime, Counter: i}.String())))
+	}
+	assert.Equal(t, 0, ch.depth())
+	assert.Empty(t, ch.sentNames)
+}
@@ -0,0 +1,162 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	//prefix of the receiving counter left in the tmp directory by Detach() for the next process consuming the channel
+	detachedCounterPrefix = "detached-"
+	//the stamp of the messages flushed back by Detach(), it orders them ahead of any message of the peer in both id schemes
+	detachedStamp = "00000000000000"
+)
+
+//Detach hands the consumer role of the channel over to another process, e.g. a new agent process during a hot restart:
+//the consumption stops, the messages buffered in memory and not taken by the consumers yet are written back to disk ahead
+//of the messages of the peer, and the receiving counter is left for the process reopening the channel in the same mode.
+//The channel is closed afterwards, the directory is left in place. Stop taking messages before calling it, a message
+//taken concurrently may be taken out of order; the streamed messages and the files handed over are not flushed back.
+//The flushed messages carry no sender identity, see ExpectPeer()
+func (ch *fileWatcherChannel) Detach() error {
+	if ch.isClosed() || !atomic.CompareAndSwapInt32(&ch.detached, 0, 1) {
+		return ErrChannelClosed
+	}
+	ch.logger.Infof("detaching channel %v", ch.path)
+	var controls []ControlMessage
+	var messages []pendingMessage
+	buffer := ch.onMessageChan
+	if ch.options.LazyRead {
+		//stop the reader first, so that the deferred messages are flushed in order, it closes the go channel once it's done
+		ch.abandonPending()
+		for msg := range ch.onMessageChan {
+			messages = append(messages, pendingMessage{payload: msg})
+		}
+		if ch.pendingLeft != nil {
+			messages = append(messages, *ch.pendingLeft)
+		}
+		buffer = nil
+	}
+	take := func(locked <-chan struct{}) bool {
+		select {
+		case msg := <-ch.controlChan:
+			controls = append(controls, msg)
+		case msg := <-ch.messageChan:
+			messages = append(messages, pendingMessage{payload: msg.Payload})
+		case msg := <-buffer:
+			messages = append(messages, pendingMessage{payload: msg})
+		case msg := <-ch.pendingChan:
+			messages = append(messages, msg)
+		case <-locked:
+			return false
+		}
+		return true
+	}
+	//the consuming go-routine may be blocked on a full go channel while holding consumeMu, keep taking until it lets go
+	locked := make(chan struct{})
+	go func() {
+		ch.consumeMu.Lock()
+		close(locked)
+	}()
+	for take(locked) {
+	}
+	//the consumption is stopped from then on, take what is left; the closed go channel only keeps take() from blocking
+	ready := make(chan struct{})
+	close(ready)
+	for take(ready) || len(ch.controlChan)+len(ch.messageChan)+len(buffer)+len(ch.pendingChan) > 0 {
+	}
+	err := ch.flushLocked(controls, messages)
+	ch.consumeMu.Unlock()
+	ch.Close()
+	return err
+}
+
+//write the taken messages back as messages of the peer and leave the receiving counter they start from, the caller must
+//hold consumeMu
+func (ch *fileWatcherChannel) flushLocked(controls []ControlMessage, messages []pendingMessage) error {
+	log := ch.logger
+	start := ch.recvCounter - len(controls) - len(messages)
+	if start < 0 {
+		start = 0
+	}
+	counter := start
+	var failed error
+	flush := func(msg pendingMessage, env envelope) {
+		id := SequenceID{Mode: ch.peerMode(), Stamp: detachedStamp, Counter: counter}
+		counter++
+		target := path.Join(ch.path, id.String())
+		if _, err := os.Stat(target); err == nil {
+			err = fmt.Errorf("%v already exists", target)
+			log.Errorf("failed to flush a buffered message back: %v", err)
+			failed = err
+			return
+		}
+		var err error
+		if msg.path != "" {
+			err = rename(msg.path, target)
+		} else {
+			var content, tmpPath string
+			if content, err = encodeEnvelope(env); err == nil {
+				if tmpPath, err = ch.writeTmpFile(content); err == nil {
+					if err = ch.fs().Rename(tmpPath, target); err != nil {
+						ch.fs().Remove(tmpPath)
+					}
+				}
+			}
+		}
+		if err != nil {
+			log.Errorf("failed to flush a buffered message back as %v, it's lost: %v", id, err)
+			failed = err
+		}
+	}
+	//the control messages are taken by their own consumer, they go first
+	for i := range controls {
+		flush(pendingMessage{}, envelope{Control: &controls[i]})
+	}
+	for _, msg := range messages {
+		flush(msg, envelope{Payload: msg.payload})
+	}
+	counterPath := path.Join(ch.tmpPath, detachedCounterPrefix+string(ch.mode))
+	if err := ioutil.WriteFile(counterPath, []byte(strconv.Itoa(start)), defaultFileWriteMode); err != nil {
+		log.Errorf("failed to leave the receiving counter %v for the next process: %v", start, err)
+		failed = err
+	}
+	log.Infof("flushed %v buffered messages back to channel %v, the next process receives from %v", counter-start, ch.path, start)
+	return failed
+}
+
+//resume from the receiving counter left by the process the channel was detached from, if any
+func (ch *fileWatcherChannel) resumeDetached() {
+	counterPath := path.Join(ch.tmpPath, detachedCounterPrefix+string(ch.mode))
+	content, err := ioutil.ReadFile(counterPath)
+	if err != nil {
+		return
+	}
+	//consumed once, a later process goes by the messages on disk
+	os.Remove(counterPath)
+	counter, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || counter < 0 {
+		ch.logger.Errorf("ignoring the malformed receiving counter %q left by the detached process", content)
+		return
+	}
+	ch.logger.Infof("resuming channel %v detached by the previous process from %v", ch.path, counter)
+	ch.recvCounter = counter
+}
@@ -0,0 +1,74 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+//wait until the given number of messages are buffered in memory
+func waitBuffered(t *testing.T, ch *fileWatcherChannel, count int) {
+	for start := time.Now(); ch.buffered() != count; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			assert.FailNow(t, "messages not buffered", "%v buffered instead of %v", ch.buffered(), count)
+		}
+	}
+}
+
+func TestDetachFlushesBufferedMessages(t *testing.T) {
+	for _, options := range []Options{{ConsumeWindow: 2}, {ConsumeWindow: 2, LazyRead: true}} {
+		dir, err := ioutil.TempDir(".", "detach")
+		assert.NoError(t, err)
+		name := path.Join(dir, "channel")
+		worker, err := NewFileWatcherChannel(log.NewMockLog(), ModeWorker, name)
+		assert.NoError(t, err)
+		previous, err := NewFileWatcherChannelWithOptions(log.NewMockLog(), ModeMaster, name, options)
+		assert.NoError(t, err)
+		assert.NoError(t, worker.SendControl(ControlMessage{Type: ControlHeartbeat}))
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, worker.Send(fmt.Sprintf("m%v", i)))
+		}
+		//the first message is taken, the others are held in memory
+		assert.Equal(t, "m0", <-previous.GetMessage())
+		waitBuffered(t, previous, 5)
+
+		assert.NoError(t, previous.Detach())
+		assert.Equal(t, ErrChannelClosed, previous.Detach())
+		for i := 5; i < 7; i++ {
+			assert.NoError(t, worker.Send(fmt.Sprintf("m%v", i)))
+		}
+		reattached, err := reopenFileWatcherChannel(log.NewMockLog(), ModeMaster, name, options)
+		assert.NoError(t, err)
+		assert.Equal(t, ControlMessage{Type: ControlHeartbeat}, <-reattached.ControlMessages())
+		messages := reattached.GetMessage()
+		for i := 1; i < 7; i++ {
+			assert.Equal(t, fmt.Sprintf("m%v", i), <-messages)
+		}
+		//the receiving counter is taken over only once
+		_, err = os.Stat(path.Join(reattached.tmpPath, detachedCounterPrefix+string(ModeMaster)))
+		assert.True(t, os.IsNotExist(err))
+		reattached.Destroy()
+		previous.Destroy()
+		worker.Destroy()
+		os.RemoveAll(dir)
+	}
+}
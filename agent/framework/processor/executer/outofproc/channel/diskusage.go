@@ -0,0 +1,54 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"os"
+	"path"
+)
+
+//DiskUsage returns the bytes occupied by the channel directory: the pending messages of both ends, the messages being written
+//in tmp, the dead letters and the streamed messages not closed yet; sum it across the channels to gauge the IPC disk pressure
+func (ch *fileWatcherChannel) DiskUsage() (int64, error) {
+	ch.mu.RLock()
+	dir := ch.path
+	ch.mu.RUnlock()
+	return diskUsage(dir)
+}
+
+//sum the size of the regular files under dir, a file removed during the walk is not counted
+func diskUsage(dir string) (int64, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return 0, err
+	}
+	infos, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, info := range infos {
+		if info.IsDir() {
+			size, err := diskUsage(path.Join(dir, info.Name()))
+			if err != nil && !os.IsNotExist(err) {
+				return 0, err
+			}
+			total += size
+		} else if info.Mode().IsRegular() {
+			total += info.Size()
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskUsage(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(ch.path)
+	usage, err := ch.DiskUsage()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), usage)
+
+	//the sent messages, a pending message of the peer, a fragment being written and a dead letter
+	assert.NoError(t, ch.Send(strings.Repeat("a", 100)))
+	assert.NoError(t, ch.Send(strings.Repeat("b", 200)))
+	dropMessage(t, ch.path, "worker-20170101000000-000", strings.Repeat("c", 300))
+	assert.NoError(t, ioutil.WriteFile(path.Join(ch.tmpPath, "worker-20170101000000-001"), []byte(strings.Repeat("d", 400)), defaultFileCreateMode))
+	assert.NoError(t, ioutil.WriteFile(path.Join(ch.tmpPath, deadLetterPrefix+"worker-bad"), []byte(strings.Repeat("e", 500)), defaultFileCreateMode))
+	usage, err = ch.DiskUsage()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1500), usage)
+	assert.Contains(t, ch.StatusLine(), " disk=1500 ")
+
+	//consumed messages are no longer counted
+	ch.consumeAll()
+	<-ch.onMessageChan
+	usage, err = ch.DiskUsage()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1200), usage)
+
+	os.RemoveAll(ch.path)
+	_, err = ch.DiskUsage()
+	assert.Error(t, err)
+}
@@ -0,0 +1,146 @@
+package channel
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
+)
+
+//the current version of the on-disk envelope, 0 means a legacy raw datagram
+//a new major version is not readable by an older peer, which rejects it; a new minor version only adds optional fields,
+//which an older peer ignores, so that a master and a worker of different agent versions keep talking during an upgrade
+const (
+	envelopeVersion      = 1
+	envelopeMinorVersion = 4
+)
+
+//ErrIncompatibleVersion is returned for a message sent by a peer of a newer major envelope version
+var ErrIncompatibleVersion = errors.New("incompatible envelope version")
+
+type ControlType string
+
+const (
+	ControlCancel    ControlType = "cancel"
+	ControlHeartbeat ControlType = "heartbeat"
+	//ends the payloads of the sender, see SendEndOfStream()
+	ControlEndOfStream ControlType = "eos"
+)
+
+type ControlValidation string
+
+const (
+	//deliver every control message as is
+	ControlValidationNone ControlValidation = ""
+	//drop the malformed control messages, deliver the unknown types, e.g. sent by a newer peer
+	ControlValidationIgnoreUnknown ControlValidation = "ignore"
+	//drop the malformed control messages as well as the unknown types
+	ControlValidationReject ControlValidation = "reject"
+)
+
+var (
+	errMalformedControl   = errors.New("malformed control message")
+	errUnknownControlType = errors.New("unknown control message type")
+)
+
+//the control types understood by this version, mapped to the validation of their required fields
+var knownControlTypes = map[ControlType]func(ControlMessage) error{
+	ControlCancel:      func(ControlMessage) error { return nil },
+	ControlHeartbeat:   func(ControlMessage) error { return nil },
+	ControlEndOfStream: func(ControlMessage) error { return nil },
+	controlHello:       func(ControlMessage) error { return nil },
+	controlAck: func(msg ControlMessage) error {
+		if msg.Content == "" {
+			return errMalformedControl
+		}
+		return nil
+	},
+}
+
+//validate a received control message against the given strictness
+func validateControl(msg ControlMessage, validation ControlValidation) error {
+	if validation == ControlValidationNone {
+		return nil
+	}
+	if msg.Type == "" {
+		return errMalformedControl
+	}
+	validate, known := knownControlTypes[msg.Type]
+	if !known {
+		if validation == ControlValidationReject {
+			return errUnknownControlType
+		}
+		return nil
+	}
+	return validate(msg)
+}
+
+//ControlMessage is a transport level signal, delivered apart from the payloads
+type ControlMessage struct {
+	Type    ControlType `json:"type"`
+	Content string      `json:"content,omitempty"`
+	//set on a received control message the peer sent with SendControlWithAck(), see Acknowledge(); carried by the envelope
+	AckID string `json:"-"`
+}
+
+//envelope wraps a datagram on disk with the metadata of the channel transport
+//it is opt-in on the sending side since a legacy peer delivers the envelope as is, the receiving side always unwraps it
+type envelope struct {
+	Version int `json:"ipcVersion"`
+	//the minor version, 0 for a peer predating it
+	Minor int `json:"ipcMinor,omitempty"`
+	//unix nano timestamp of Send(), compared to the local clock at consume time
+	SentAt int64 `json:"sentAt,omitempty"`
+	//set if the envelope carries a control message instead of a payload
+	Control *ControlMessage `json:"control,omitempty"`
+	Payload string          `json:"payload"`
+	//set if the sender expects an ack once the message is delivered, see SendWithAck()
+	AckID string `json:"ackId,omitempty"`
+	//unix nano timestamp after which the message is dropped instead of delivered, see SendWithExpiry()
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+	//the identity of the sender, see Options.SendIdentity; since minor version 2
+	SenderPid   int    `json:"senderPid,omitempty"`
+	SenderStart string `json:"senderStart,omitempty"`
+	//the transforms applied to the payload, which is then base64 encoded, see SendWithTransforms(); since minor version 3
+	Compressed bool `json:"compressed,omitempty"`
+	Sealed     bool `json:"sealed,omitempty"`
+	//set if the payload is to be consumed ahead of the pending ones, see SendPriority(); since minor version 4
+	Priority bool `json:"prioritized,omitempty"`
+}
+
+func encodeEnvelope(env envelope) (string, error) {
+	env.Version = envelopeVersion
+	env.Minor = envelopeMinorVersion
+	return jsonutil.Marshal(env)
+}
+
+//decode the file content, content not wrapped in an envelope is returned as the payload of a legacy envelope
+//only a binary envelope failing its integrity check or an envelope of a newer major version returns an error
+func decodeEnvelope(content string) (envelope, error) {
+	if isBinaryEnvelope(content) {
+		return decodeBinaryEnvelope(content)
+	}
+	//cheap pre-check to avoid parsing every legacy datagram twice
+	if !strings.Contains(content, `"ipcVersion"`) {
+		return envelope{Payload: content}, nil
+	}
+	var env envelope
+	if err := jsonutil.Unmarshal(content, &env); err != nil {
+		//the fields of a newer major version may not even fit the ones of this version
+		var header struct {
+			Version int `json:"ipcVersion"`
+		}
+		if jsonutil.Unmarshal(content, &header) == nil && header.Version > envelopeVersion {
+			return envelope{}, ErrIncompatibleVersion
+		}
+		return envelope{Payload: content}, nil
+	}
+	if env.Version < envelopeVersion {
+		return envelope{Payload: content}, nil
+	}
+	//the fields of a newer major version may not mean the same, do not guess
+	if env.Version > envelopeVersion {
+		return envelope{}, ErrIncompatibleVersion
+	}
+	return env, nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"strings"
+)
+
+/*
+	compact binary envelope, all integers are big endian:
+	magic(1) | version(1) | kind(1) | sentAt(8) | body length(4) | body crc32(4) | body
+	for a control message the body is {type}\x00{content}
+	the magic byte never starts a json document nor a valid utf-8 sequence, so the receiver tells the formats apart by the first byte
+*/
+const (
+	binaryEnvelopeMagic      = 0xb1
+	binaryEnvelopeVersion    = 1
+	binaryEnvelopeHeaderSize = 19
+
+	binaryKindPayload = 0
+	binaryKindControl = 1
+)
+
+var ErrCorruptEnvelope = errors.New("corrupt binary envelope")
+
+func isBinaryEnvelope(content string) bool {
+	return len(content) > 0 && content[0] == binaryEnvelopeMagic
+}
+
+func encodeBinaryEnvelope(env envelope) string {
+	kind := byte(binaryKindPayload)
+	body := env.Payload
+	if env.Control != nil {
+		kind = binaryKindControl
+		body = string(env.Control.Type) + "\x00" + env.Control.Content
+	}
+	buf := make([]byte, binaryEnvelopeHeaderSize+len(body))
+	buf[0] = binaryEnvelopeMagic
+	buf[1] = binaryEnvelopeVersion
+	buf[2] = kind
+	binary.BigEndian.PutUint64(buf[3:11], uint64(env.SentAt))
+	binary.BigEndian.PutUint32(buf[11:15], uint32(len(body)))
+	copy(buf[binaryEnvelopeHeaderSize:], body)
+	binary.BigEndian.PutUint32(buf[15:19], crc32.ChecksumIEEE(buf[binaryEnvelopeHeaderSize:]))
+	return string(buf)
+}
+
+func decodeBinaryEnvelope(content string) (env envelope, err error) {
+	if len(content) < binaryEnvelopeHeaderSize || content[0] != binaryEnvelopeMagic {
+		return env, ErrCorruptEnvelope
+	}
+	if content[1] > binaryEnvelopeVersion {
+		return env, ErrIncompatibleVersion
+	}
+	if content[1] != binaryEnvelopeVersion {
+		return env, ErrCorruptEnvelope
+	}
+	header := []byte(content[:binaryEnvelopeHeaderSize])
+	length := binary.BigEndian.Uint32(header[11:15])
+	body := content[binaryEnvelopeHeaderSize:]
+	if uint32(len(body)) != length || crc32.ChecksumIEEE([]byte(body)) != binary.BigEndian.Uint32(header[15:19]) {
+		return env, ErrCorruptEnvelope
+	}
+	env.Version = envelopeVersion
+	env.SentAt = int64(binary.BigEndian.Uint64(header[3:11]))
+	switch header[2] {
+	case binaryKindPayload:
+		env.Payload = body
+	case binaryKindControl:
+		parts := strings.SplitN(body, "\x00", 2)
+		if len(parts) != 2 {
+			return env, ErrCorruptEnvelope
+		}
+		env.Control = &ControlMessage{Type: ControlType(parts[0]), Content: parts[1]}
+	default:
+		return env, ErrCorruptEnvelope
+	}
+	return env, nil
+}
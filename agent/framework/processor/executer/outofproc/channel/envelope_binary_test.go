@@ -0,0 +1,102 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBinaryEnvelopeRoundTrip(t *testing.T) {
+	for _, env := range []envelope{
+		{Version: envelopeVersion, Payload: `{"version":"1.0","type":"reply","content":""}`},
+		{Version: envelopeVersion, SentAt: 10, Payload: ""},
+		{Version: envelopeVersion, SentAt: 10, Control: &ControlMessage{Type: ControlCancel, Content: "a\x00b"}},
+	} {
+		content := encodeBinaryEnvelope(env)
+		assert.True(t, isBinaryEnvelope(content))
+		decoded, err := decodeEnvelope(content)
+		assert.NoError(t, err)
+		assert.Equal(t, env, decoded)
+	}
+}
+
+func TestBinaryEnvelopeCorrupt(t *testing.T) {
+	content := encodeBinaryEnvelope(envelope{Payload: "payload"})
+	//flipped body byte fails the checksum
+	corrupt := content[:len(content)-1] + "X"
+	_, err := decodeEnvelope(corrupt)
+	assert.Equal(t, ErrCorruptEnvelope, err)
+	//truncated file fails the length check
+	_, err = decodeEnvelope(content[:len(content)-2])
+	assert.Equal(t, ErrCorruptEnvelope, err)
+	_, err = decodeEnvelope(content[:5])
+	assert.Equal(t, ErrCorruptEnvelope, err)
+}
+
+func TestSendBinaryEncoding(t *testing.T) {
+	ch := newTestChannel(t, ModeWorker, Options{Encoding: EncodingBinary})
+	defer os.RemoveAll(ch.path)
+	assert.NoError(t, ch.Send("payload"))
+	assert.NoError(t, ch.SendControl(ControlMessage{Type: ControlHeartbeat}))
+	//read the messages back as the peer would, the peer does not need to enable the binary encoding
+	peer := newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(peer.path)
+	content, err := ioutil.ReadFile(path.Join(ch.path, "worker-20170101000000-000"))
+	assert.NoError(t, err)
+	assert.True(t, isBinaryEnvelope(string(content)))
+	peer.consume(path.Join(ch.path, "worker-20170101000000-000"))
+	peer.consume(path.Join(ch.path, "worker-20170101000000-001"))
+	assert.Equal(t, "payload", <-peer.onMessageChan)
+	assert.Equal(t, ControlMessage{Type: ControlHeartbeat}, <-peer.controlChan)
+}
+
+var benchmarkPayload = `{"version":"1.0","type":"reply","content":"` + strings.Repeat("r", 64) + `"}`
+
+func BenchmarkEncodeJSONEnvelope(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		encodeEnvelope(envelope{SentAt: 10, Payload: benchmarkPayload})
+	}
+}
+
+func BenchmarkEncodeBinaryEnvelope(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		encodeBinaryEnvelope(envelope{SentAt: 10, Payload: benchmarkPayload})
+	}
+}
+
+func BenchmarkDecodeJSONEnvelope(b *testing.B) {
+	content, _ := encodeEnvelope(envelope{SentAt: 10, Payload: benchmarkPayload})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		decodeEnvelope(content)
+	}
+}
+
+func BenchmarkDecodeBinaryEnvelope(b *testing.B) {
+	content := encodeBinaryEnvelope(envelope{SentAt: 10, Payload: benchmarkPayload})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		decodeEnvelope(content)
+	}
+}
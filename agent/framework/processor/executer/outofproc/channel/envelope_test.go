@@ -0,0 +1,69 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//an older peer sends envelopes without a minor version, they are read as is
+func TestDecodeEnvelopeOlderMinor(t *testing.T) {
+	env, err := decodeEnvelope(`{"ipcVersion":1,"payload":"p","ackId":"id"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, envelope{Version: 1, Payload: "p", AckID: "id"}, env)
+}
+
+//a newer peer of the same major version may add fields, they are ignored
+func TestDecodeEnvelopeNewerMinor(t *testing.T) {
+	env, err := decodeEnvelope(`{"ipcVersion":1,"ipcMinor":7,"payload":"p","priority":"high","route":{"to":"x"}}`)
+	assert.NoError(t, err)
+	assert.Equal(t, envelope{Version: 1, Minor: 7, Payload: "p"}, env)
+}
+
+//a newer major version may change the meaning of the fields, it's rejected instead of mis-parsed
+func TestDecodeEnvelopeNewerMajor(t *testing.T) {
+	_, err := decodeEnvelope(`{"ipcVersion":2,"payload":{"parts":["p"]}}`)
+	assert.Equal(t, ErrIncompatibleVersion, err)
+	binary := []byte(encodeBinaryEnvelope(envelope{Payload: "p"}))
+	binary[1] = binaryEnvelopeVersion + 1
+	_, err = decodeEnvelope(string(binary))
+	assert.Equal(t, ErrIncompatibleVersion, err)
+}
+
+func TestEncodeEnvelopeVersion(t *testing.T) {
+	content, err := encodeEnvelope(envelope{Payload: "p"})
+	assert.NoError(t, err)
+	env, err := decodeEnvelope(content)
+	assert.NoError(t, err)
+	assert.Equal(t, envelope{Version: envelopeVersion, Minor: envelopeMinorVersion, Payload: "p"}, env)
+}
+
+//a message of a newer major version is dead-lettered for a replay after the upgrade, the messages after it keep flowing
+func TestConsumeIncompatibleVersion(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(ch.path)
+	dropMessage(t, ch.path, "worker-20170101000000-000", `{"ipcVersion":2,"payload":"future"}`)
+	dropMessage(t, ch.path, "worker-20170101000000-001", `{"ipcVersion":1,"ipcMinor":9,"payload":"compatible","extra":true}`)
+	ch.consumeAll()
+	assert.Equal(t, "compatible", <-ch.onMessageChan)
+	assert.Empty(t, ch.onMessageChan)
+	assert.Equal(t, []string{"worker-20170101000000-000"}, ch.DeadLetters())
+	_, err := os.Stat(path.Join(ch.path, "worker-20170101000000-000"))
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, 2, ch.recvCounter)
+}
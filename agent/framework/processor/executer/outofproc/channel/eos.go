@@ -0,0 +1,55 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"sync/atomic"
+)
+
+//SendEndOfStream tells the peer that no payload follows, e.g. once the worker sent the result of the document; the peer closes
+//its GetMessage() go channel once the payloads sent before are delivered, so that a consumer ranging over it terminates
+//the control messages keep flowing both ways until the channel is closed
+func (ch *fileWatcherChannel) SendEndOfStream() error {
+	return ch.SendControl(ControlMessage{Type: ControlEndOfStream})
+}
+
+//StreamEnded returns whether the peer ended the stream, i.e. the GetMessage() go channel was closed by the end of stream
+//rather than by Close()
+func (ch *fileWatcherChannel) StreamEnded() bool {
+	return atomic.LoadInt32(&ch.ended) == 1
+}
+
+//close the payload go channels on receiving the end of stream, the caller must hold consumeMu
+func (ch *fileWatcherChannel) endStream(filepath string) {
+	if ch.StreamEnded() {
+		ch.logger.Debugf("dropping repeated end of stream %v", filepath)
+		return
+	}
+	ch.logger.Infof("peer ended the stream of channel %v", ch.path)
+	atomic.StoreInt32(&ch.ended, 1)
+	ch.closeMessages()
+}
+
+//close the payload go channels, either on the end of stream or once the channel is closed
+func (ch *fileWatcherChannel) closeMessages() {
+	ch.closeMessagesOnce.Do(func() {
+		if ch.options.LazyRead {
+			//readPending() closes it once the messages left are drained
+			close(ch.pendingChan)
+		} else {
+			close(ch.onMessageChan)
+		}
+		close(ch.messageChan)
+	})
+}
@@ -0,0 +1,103 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndOfStreamTerminatesRange(t *testing.T) {
+	pair := newChannelPair(t, harnessVariant{})
+	defer pair.cleanup()
+	master, worker := pair.master, pair.worker
+
+	for _, msg := range []string{"first", "second", "third"} {
+		assert.NoError(t, worker.Send(msg))
+	}
+	assert.NoError(t, worker.SendControl(ControlMessage{Type: ControlHeartbeat}))
+	assert.NoError(t, worker.SendEndOfStream())
+	done := make(chan []string)
+	go func() {
+		var received []string
+		for msg := range master.GetMessage() {
+			received = append(received, msg)
+		}
+		done <- received
+	}()
+	select {
+	case received := <-done:
+		assert.Equal(t, []string{"first", "second", "third"}, received)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the range over the messages did not terminate")
+	}
+	assert.True(t, master.StreamEnded())
+	//the end of stream is not delivered as a control message, the others still are
+	assert.Equal(t, ControlHeartbeat, (<-master.ControlMessages()).Type)
+	assert.Empty(t, master.ControlMessages())
+
+	//a late payload is dropped, the channel is still closed cleanly
+	assert.NoError(t, worker.Send("late"))
+	deadline := time.Now().Add(5 * time.Second)
+	files, _ := ioutil.ReadDir(pair.name)
+	for len(files) > 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		files, _ = ioutil.ReadDir(pair.name)
+	}
+	//only the tmp directory is left
+	assert.Len(t, files, 1)
+	master.Close()
+	assert.False(t, worker.StreamEnded())
+}
+
+//the consumer pulling the messages learns the end of stream once it has pulled the payloads sent before it
+func TestEndOfStreamWaitForMessage(t *testing.T) {
+	pair := newChannelPair(t, harnessVariant{})
+	defer pair.cleanup()
+	master, worker := pair.master, pair.worker
+
+	assert.NoError(t, worker.Send("first"))
+	assert.NoError(t, worker.SendEndOfStream())
+	msg, err := master.WaitForMessage(5 * time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", msg)
+	_, err = master.WaitForMessage(5 * time.Second)
+	assert.Equal(t, ErrEndOfStream, err)
+}
+
+func TestEndOfStreamNotOvertakingPayloads(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{Order: OrderControlFirst})
+	defer os.RemoveAll(ch.path)
+	dropMessage(t, ch.path, sequenceName(0), "first")
+	dropMessage(t, ch.path, sequenceName(1), "second")
+	eos, err := encodeEnvelope(envelope{Control: &ControlMessage{Type: ControlEndOfStream}})
+	assert.NoError(t, err)
+	dropMessage(t, ch.path, sequenceName(2), eos)
+	cancel, err := encodeEnvelope(envelope{Control: &ControlMessage{Type: ControlCancel}})
+	assert.NoError(t, err)
+	dropMessage(t, ch.path, sequenceName(3), cancel)
+	ch.consumeAll()
+
+	var received []string
+	for msg := range ch.onMessageChan {
+		received = append(received, msg)
+	}
+	assert.Equal(t, []string{"first", "second"}, received)
+	//the cancel still overtakes the payloads
+	assert.Equal(t, ControlCancel, (<-ch.controlChan).Type)
+}
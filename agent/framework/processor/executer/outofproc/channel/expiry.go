@@ -0,0 +1,38 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"time"
+)
+
+//SendWithExpiry sends a payload the peer drops instead of delivering if it's consumed after ttl, e.g. after a consumer stall
+//the expiry is checked against the peer's clock, both ends share the host clock; a legacy peer delivers the envelope as is
+func (ch *fileWatcherChannel) SendWithExpiry(rawJson string, ttl time.Duration) error {
+	return ch.send(envelope{Payload: rawJson, ExpiresAt: ch.now() + int64(ttl)})
+}
+
+//SendControlWithExpiry is SendWithExpiry for a control message, e.g. a cancel only meaningful for a short window
+func (ch *fileWatcherChannel) SendControlWithExpiry(msg ControlMessage, ttl time.Duration) error {
+	return ch.send(envelope{Control: &msg, ExpiresAt: ch.now() + int64(ttl)})
+}
+
+//whether the received message is past the expiry set by the sender
+func (ch *fileWatcherChannel) expired(env envelope) bool {
+	return ch.expiredAt(env, ch.now())
+}
+
+func (ch *fileWatcherChannel) expiredAt(env envelope, now int64) bool {
+	return env.ExpiresAt > 0 && now > env.ExpiresAt
+}
@@ -0,0 +1,77 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//a cancel consumed after a stall longer than its expiry is dropped, the message after it is still delivered
+func TestExpiredControlMessageDropped(t *testing.T) {
+	sender := newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(sender.path)
+	receiver := newTestChannel(t, ModeWorker, Options{})
+	defer os.RemoveAll(receiver.path)
+	assert.NoError(t, os.MkdirAll(sender.tmpPath, defaultFileCreateMode))
+	sender.path = receiver.path
+	sender.tmpPath = path.Join(receiver.path, "tmp")
+	assert.NoError(t, os.MkdirAll(sender.tmpPath, defaultFileCreateMode))
+
+	assert.NoError(t, sender.SendControlWithExpiry(ControlMessage{Type: ControlCancel}, 50*time.Millisecond))
+	assert.NoError(t, sender.SendControlWithExpiry(ControlMessage{Type: ControlHeartbeat}, time.Hour))
+	//the consumer stalls past the expiry of the cancel
+	time.Sleep(100 * time.Millisecond)
+	receiver.consumeAll()
+	assert.Len(t, receiver.controlChan, 1)
+	if len(receiver.controlChan) > 0 {
+		assert.Equal(t, ControlHeartbeat, (<-receiver.controlChan).Type)
+	}
+	assert.Equal(t, 2, receiver.recvCounter)
+	_, err := os.Stat(path.Join(receiver.path, "master-20170101000000-000"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestExpiredPayloadDropped(t *testing.T) {
+	ch := newTestChannel(t, ModeWorker, Options{})
+	defer os.RemoveAll(ch.path)
+	expired, err := encodeEnvelope(envelope{Payload: "stale", ExpiresAt: time.Now().Add(-time.Second).UnixNano()})
+	assert.NoError(t, err)
+	fresh, err := encodeEnvelope(envelope{Payload: "fresh", ExpiresAt: time.Now().Add(time.Hour).UnixNano()})
+	assert.NoError(t, err)
+	dropMessage(t, ch.path, "master-20170101000000-000", expired)
+	dropMessage(t, ch.path, "master-20170101000000-001", fresh)
+	ch.consumeAll()
+	assert.Len(t, ch.onMessageChan, 1)
+	if len(ch.onMessageChan) > 0 {
+		assert.Equal(t, "fresh", <-ch.onMessageChan)
+	}
+}
+
+//the binary envelope has no room for the expiry, the message falls back to json
+func TestSendWithExpiryBinaryEncoding(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{Encoding: EncodingBinary})
+	defer os.RemoveAll(ch.path)
+	content, err := ch.encode(envelope{Payload: "payload", ExpiresAt: time.Now().Add(time.Hour).UnixNano()})
+	assert.NoError(t, err)
+	assert.False(t, isBinaryEnvelope(content))
+	env, err := decodeEnvelope(content)
+	assert.NoError(t, err)
+	assert.Equal(t, "payload", env.Payload)
+	assert.False(t, ch.expired(env))
+}
@@ -0,0 +1,194 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// +build linux,amd64 linux,arm64
+
+package channel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+//see linux/fanotify.h
+const (
+	fanCloexec      = 0x1
+	fanNonblock     = 0x2
+	fanReportDirFid = 0x400
+	fanReportName   = 0x800
+	fanMarkAdd      = 0x1
+	fanMarkRemove   = 0x2
+	fanMovedTo      = 0x80
+	fanCreate       = 0x100
+	fanQOverflow    = 0x4000
+
+	fanEventInfoTypeDfidName = 2
+	fanEventMetadataLen      = 24
+	//info header(4) + fsid(8) + file_handle.handle_bytes(4) + file_handle.handle_type(4)
+	fanInfoFidHeaderLen = 20
+
+	atFdcwd = -100
+)
+
+var errFanotifyOverflow = errors.New("fanotify event queue overflow")
+
+//fanotifySource reports the files created or renamed into a single directory
+type fanotifySource struct {
+	dir       string
+	file      *os.File
+	events    chan fsnotify.Event
+	errors    chan error
+	done      chan bool
+	closeOnce sync.Once
+}
+
+func openFanotify(name string) (eventSource, error) {
+	flags := fanCloexec | fanNonblock | fanReportDirFid | fanReportName
+	fd, _, errno := syscall.Syscall(syscall.SYS_FANOTIFY_INIT, uintptr(flags), uintptr(syscall.O_RDONLY|syscall.O_CLOEXEC), 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	if err := fanotifyMark(int(fd), fanMarkAdd, name); err != nil {
+		syscall.Close(int(fd))
+		return nil, err
+	}
+	s := &fanotifySource{
+		dir: name,
+		//the descriptor is non-blocking, so that the reads go through the runtime poller and Close() unblocks them
+		file:   os.NewFile(fd, "fanotify"),
+		events: make(chan fsnotify.Event),
+		errors: make(chan error),
+		done:   make(chan bool),
+	}
+	go s.readEvents()
+	return s, nil
+}
+
+func fanotifyMark(fd int, flags int, name string) error {
+	p, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+	dirfd := atFdcwd
+	_, _, errno := syscall.Syscall6(syscall.SYS_FANOTIFY_MARK, uintptr(fd), uintptr(flags), fanCreate|fanMovedTo, uintptr(dirfd), uintptr(unsafe.Pointer(p)), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (s *fanotifySource) Events() <-chan fsnotify.Event {
+	return s.events
+}
+
+func (s *fanotifySource) Errors() <-chan error {
+	return s.errors
+}
+
+func (s *fanotifySource) Remove(name string) error {
+	return fanotifyMark(int(s.file.Fd()), fanMarkRemove, name)
+}
+
+func (s *fanotifySource) descriptors() int {
+	return 1
+}
+
+func (s *fanotifySource) Close() (err error) {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		err = s.file.Close()
+	})
+	return
+}
+
+//both go channels are closed once the source is closed, the same as fsnotify.Watcher
+func (s *fanotifySource) readEvents() {
+	defer close(s.errors)
+	defer close(s.events)
+	buf := make([]byte, 4096)
+	for {
+		n, err := s.file.Read(buf)
+		if err != nil {
+			//Close() marks the source done before closing the file, the resulting read error is not reported
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			select {
+			case <-s.done:
+			case s.errors <- err:
+			}
+			return
+		}
+		events, overflow := parseFanotifyEvents(buf[:n], s.dir)
+		if overflow {
+			select {
+			case <-s.done:
+				return
+			case s.errors <- errFanotifyOverflow:
+			}
+		}
+		for _, event := range events {
+			select {
+			case <-s.done:
+				return
+			case s.events <- event:
+			}
+		}
+	}
+}
+
+//parse the fanotify_event_metadata records, each followed by a directory file handle and the name of the entry
+func parseFanotifyEvents(buf []byte, dir string) (events []fsnotify.Event, overflow bool) {
+	for len(buf) >= fanEventMetadataLen {
+		eventLen := int(binary.LittleEndian.Uint32(buf[0:4]))
+		metadataLen := int(binary.LittleEndian.Uint16(buf[6:8]))
+		mask := binary.LittleEndian.Uint64(buf[8:16])
+		if metadataLen < fanEventMetadataLen || eventLen < metadataLen || eventLen > len(buf) {
+			return
+		}
+		if mask&fanQOverflow != 0 {
+			overflow = true
+		}
+		info := buf[metadataLen:eventLen]
+		for len(info) >= 4 {
+			infoType := info[0]
+			infoLen := int(binary.LittleEndian.Uint16(info[2:4]))
+			if infoLen < 4 || infoLen > len(info) {
+				break
+			}
+			if infoType == fanEventInfoTypeDfidName && infoLen > fanInfoFidHeaderLen {
+				nameStart := fanInfoFidHeaderLen + int(binary.LittleEndian.Uint32(info[12:16]))
+				if nameStart < infoLen {
+					name := info[nameStart:infoLen]
+					if end := bytes.IndexByte(name, 0); end >= 0 {
+						name = name[:end]
+					}
+					events = append(events, fsnotify.Event{Name: path.Join(dir, string(name)), Op: fsnotify.Create})
+				}
+			}
+			info = info[infoLen:]
+		}
+		buf = buf[eventLen:]
+	}
+	return
+}
@@ -0,0 +1,87 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// +build linux,amd64 linux,arm64
+
+package channel
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+)
+
+//build a fanotify event record carrying a directory handle of the given size and the entry name
+func fanotifyRecord(mask uint64, handleBytes int, name string) []byte {
+	infoLen := fanInfoFidHeaderLen + handleBytes + len(name) + 1
+	infoLen += (8 - infoLen%8) % 8
+	info := make([]byte, infoLen)
+	info[0] = fanEventInfoTypeDfidName
+	binary.LittleEndian.PutUint16(info[2:4], uint16(infoLen))
+	binary.LittleEndian.PutUint32(info[12:16], uint32(handleBytes))
+	copy(info[fanInfoFidHeaderLen+handleBytes:], name)
+	metadata := make([]byte, fanEventMetadataLen)
+	binary.LittleEndian.PutUint32(metadata[0:4], uint32(fanEventMetadataLen+infoLen))
+	binary.LittleEndian.PutUint16(metadata[6:8], fanEventMetadataLen)
+	binary.LittleEndian.PutUint64(metadata[8:16], mask)
+	return append(metadata, info...)
+}
+
+func TestParseFanotifyEvents(t *testing.T) {
+	buf := append(fanotifyRecord(fanCreate, 8, "worker-20170101000000-000"), fanotifyRecord(fanMovedTo, 12, "worker-20170101000000-001")...)
+	events, overflow := parseFanotifyEvents(buf, "channel")
+	assert.False(t, overflow)
+	assert.Equal(t, []fsnotify.Event{
+		{Name: "channel/worker-20170101000000-000", Op: fsnotify.Create},
+		{Name: "channel/worker-20170101000000-001", Op: fsnotify.Create},
+	}, events)
+
+	overflowRecord := make([]byte, fanEventMetadataLen)
+	binary.LittleEndian.PutUint32(overflowRecord[0:4], fanEventMetadataLen)
+	binary.LittleEndian.PutUint16(overflowRecord[6:8], fanEventMetadataLen)
+	binary.LittleEndian.PutUint64(overflowRecord[8:16], fanQOverflow)
+	events, overflow = parseFanotifyEvents(overflowRecord, "channel")
+	assert.True(t, overflow)
+	assert.Empty(t, events)
+
+	//a truncated record is ignored
+	events, _ = parseFanotifyEvents(buf[:len(buf)-1], "channel")
+	assert.Len(t, events, 1)
+}
+
+func TestFanotifySource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fanotify")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	source, err := openFanotify(dir)
+	if err != nil {
+		t.Skipf("fanotify not available: %v", err)
+	}
+	assert.NoError(t, ioutil.WriteFile(path.Join(dir, "created"), []byte("m"), defaultFileWriteMode))
+	select {
+	case event := <-source.Events():
+		assert.Equal(t, path.Join(dir, "created"), event.Name)
+		assert.Equal(t, fsnotify.Create, event.Op)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "no event received")
+	}
+	assert.NoError(t, source.Close())
+	_, more := <-source.Events()
+	assert.False(t, more)
+}
@@ -2,20 +2,22 @@ package channel
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"time"
 
-	"strconv"
-
-	"errors"
-
 	"sync"
+	"sync/atomic"
+	"syscall"
 
 	"regexp"
+	"runtime/debug"
 
+	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
 	"github.com/aws/amazon-ssm-agent/agent/log"
 	"github.com/fsnotify/fsnotify"
 )
@@ -27,22 +29,314 @@ const (
 
 	consumeAttemptCount                = 3
 	consumeRetryIntervalInMilliseconds = 10
+
+	defaultCloseTimeout = 5 * time.Second
+
+	defaultRenameBackoff = 10 * time.Millisecond
+
+	//prefix of a message with a malformed sequence id moved out of the channel directory
+	deadLetterPrefix = "deadletter-"
+)
+
+// injected by the tests to simulate transient rename failures
+var rename = os.Rename
+
+// injected by the tests to simulate a read-only file system
+var removeFile = os.Remove
+
+// release the file watcher resources, it could block on a stuck file system
+var closeWatcher = func(watcher eventSource, path string) {
+	//make sure the file watcher closed as well as the watch list is removed, otherwise can cause leak in ubuntu kernel
+	watcher.Remove(path)
+	watcher.Close()
+}
+
+type IDScheme string
+
+const (
+	//{mode}-{channel start time in seconds}-{counter}, the counter restarts from 0 when the channel is reopened
+	IDSchemeCounter IDScheme = "counter"
+	//{mode}-{unix nano timestamp}-{tiebreak counter}, the timestamp advances on the monotonic clock within a channel and is
+	//ascending across reopens of the same mode as long as the wall clock does not go backwards
+	IDSchemeTimestamp IDScheme = "timestamp"
+)
+
+type Encoding string
+
+const (
+	//the payload as is, or a json envelope if any of the envelope features is enabled
+	EncodingJSON Encoding = "json"
+	//compact length-prefixed envelope with a checksum, cheaper to encode and decode for small high frequency messages
+	EncodingBinary Encoding = "binary"
+)
+
+// Options tunes the behavior of a file channel, zero values fall back to the defaults
+type Options struct {
+	//FileSystem is the store the messages go through, the os if nil; e.g. to instrument the store or to simulate its failures
+	FileSystem FileSystem
+	//Tag is an opaque correlation tag, e.g. the id of the document owning the channel; it's added to every log line of the
+	//channel and reported by Stats() and StatusLine(), see FindByTag()
+	Tag string
+	//ReadBufferSize is the size hint of the pooled buffer used to read messages, 0 disables pooling
+	//messages larger than the hint fall back to a fresh allocation
+	ReadBufferSize int
+	//SizeBuckets are the upper bounds of the message size histogram in bytes, DefaultSizeBuckets if empty
+	SizeBuckets []int
+	//CloseTimeout bounds the file watcher teardown in Close(), defaultCloseTimeout if 0
+	CloseTimeout time.Duration
+	//TrackLatency stamps each sent message with the send time, so that the peer can measure the send-to-consume latency
+	//it wraps the messages in an envelope, enable it only when the peer is able to unwrap it
+	TrackLatency bool
+	//IDScheme determines the format of the sequence id, IDSchemeCounter if empty
+	//both schemes can be read by any receiver, since the files are consumed in lexical order of their names
+	IDScheme IDScheme
+	//Encoding is the on-disk format of the envelope, EncodingJSON if empty
+	//EncodingBinary wraps every message, enable it only when the peer is able to unwrap it
+	Encoding Encoding
+	//ControlValidation determines which received control messages are dropped instead of delivered, ControlValidationNone if empty
+	ControlValidation ControlValidation
+	//WatchTimeout bounds the wait for a file watch once the global limit is reached, defaultWatchTimeout if 0, see SetMaxWatches()
+	WatchTimeout time.Duration
+	//StreamThreshold delivers the messages of at least this size to GetStream() backed by their files instead of reading them
+	//into a string, 0 disables streaming; the messages wrapped in an envelope are always read into a string
+	StreamThreshold int64
+	//SendIdentity stamps each sent message with the pid and the start time of this channel, so that the peer is able to drop
+	//the messages of another sender, see ExpectPeer(); it wraps the messages in an envelope
+	SendIdentity bool
+	//HandoffDir hands the messages of at least HandoffThreshold bytes over to GetFile() as files linked into this directory
+	//instead of reading them, the directory must be on the file system of the channel, empty disables the handoff;
+	//the messages wrapped in an envelope are always read into a string and StreamThreshold takes precedence
+	HandoffDir       string
+	HandoffThreshold int64
+	//SendStreamTimeout aborts a writer returned by SendStream() that is not closed in time, discarding what was written,
+	//defaultSendStreamTimeout if 0
+	SendStreamTimeout time.Duration
+	//Order determines the order the pending messages are consumed in, OrderFIFO if nil
+	Order OrderPolicy
+	//SequenceOrder determines the sending order of the pending messages of the peer, which Order starts from, SendOrder if nil
+	SequenceOrder SequenceLess
+	//DeliverMetadata delivers the payloads with the metadata of their files to GetMessageWithMetadata() instead of GetMessage()
+	DeliverMetadata bool
+	//RenameRetries is the number of retries of a failed rename in Send(), the platform default if 0, negative disables the retry
+	RenameRetries int
+	//RenameBackoff is the wait before the first rename retry, doubled on each retry, defaultRenameBackoff if 0
+	RenameBackoff time.Duration
+	//OnBacklogAge is called when the oldest message waiting in the channel directory gets older than BacklogAgeThreshold,
+	//e.g. to declare the consumer stuck; both must be set to enable the check, see Stats().BacklogAge
+	OnBacklogAge        func(age time.Duration)
+	BacklogAgeThreshold time.Duration
+	//ConsumeWindow bounds the directory poll triggered by an out-of-order message to the messages less than ConsumeWindow ahead
+	//of the next expected one, 0 polls the whole directory; see consumeWindowLocked()
+	ConsumeWindow int
+	//MaxLifetime closes the channel, and destroys it on the master side, once it has been open for that long, e.g. when the
+	//document owning it never completes; 0 disables it, see ResetLifetime()
+	MaxLifetime time.Duration
+	//GapGracePeriod is how long the next expected message may be missing while later ones are left on disk by the ConsumeWindow,
+	//e.g. after the file is deleted out-of-band, before it's skipped; defaultGapGracePeriod if 0, negative never skips it
+	GapGracePeriod time.Duration
+	//OnGap is called with the ids of the messages skipped once the grace period is over, e.g. for the executer to fail the
+	//document over the data loss; nil only logs the skip
+	OnGap func(skipped []SequenceID)
+	//WatchBackend selects the source of the file events, WatchBackendAuto if empty
+	WatchBackend WatchBackend
+	//CooperativeLock holds a sidecar lock while writing each message and defers consuming the peer's messages until their
+	//lock is released, so that a partially written file is never read even if the rename is not atomic, e.g. on windows
+	//both ends must enable it to be effective
+	CooperativeLock bool
+	//BeforeDelete is called with the sequence id and the content as read of every consumed message before it's removed,
+	//e.g. to mirror it to an audit sink; an error leaves the message on disk undelivered and retries it later, holding back
+	//the messages after it. The hook runs on the consuming go-routine: a slow hook slows down the whole channel and a
+	//failing one stalls it. The messages delivered through GetStream() are not passed to it
+	BeforeDelete func(sequenceID string, content string) error
+	//DebounceInterval collapses the repeated create events of a file within the interval into one consume attempt,
+	//defaultDebounceInterval if 0, negative disables it
+	DebounceInterval time.Duration
+	//ValidateJSON rejects a payload that is not valid json in Send() with ErrInvalidJSON, instead of failing to decode on the peer
+	//the payload is parsed once more, enable it while troubleshooting or where the cost does not matter
+	ValidateJSON bool
+	//LazyRead leaves the received messages on disk until the consumer of GetMessage() pulls them, reading them one at a time,
+	//so that a burst of large messages is not held in memory; the messages wrapped in an envelope are still read on arrival.
+	//The messages not pulled yet are lost once the channel is destroyed. It has no effect with DeliverMetadata or BeforeDelete
+	LazyRead bool
+	//ReadWorkers is the number of go-routines reading the pending messages of a directory scan ahead of their delivery, so that
+	//a slow read does not hold back the ones after it; the messages are still delivered in order. 0 or 1 reads them one by one,
+	//it has no effect with StreamThreshold, HandoffDir, LazyRead or CooperativeLock
+	ReadWorkers int
+	//PeerRestart determines how the messages of a restarted peer counting from 0 again are consumed, PeerRestartIgnore if empty
+	//it only detects the restart of a peer using IDSchemeCounter
+	PeerRestart PeerRestartPolicy
+	//CompressThreshold compresses the payloads of at least this size once the peer agreed CapabilityCompression, 0 disables it
+	//see SendWithTransforms() to pick the transforms per message
+	CompressThreshold int
+	//EncryptionKey is the AES key sealing the payloads sent with TransformEncrypt and opening the ones received, 16, 24 or 32 bytes
+	EncryptionKey []byte
+	//DirMode is the permission of the channel directory and its tmp directory, applied once they are created and again by
+	//Refresh(), e.g. to grant a RunAs user access to it; defaultFileCreateMode under the umask if 0
+	DirMode os.FileMode
+	//OnUnreadable determines what happens to a message failing to read in UnreadableAttempts consume rounds in a row,
+	//UnreadableRetry if empty; defaultUnreadableAttempts if UnreadableAttempts is 0
+	OnUnreadable       UnreadablePolicy
+	UnreadableAttempts int
+	//OnAudit is called with the receipt of every message of the peer once it's delivered, dead-lettered, expired or dropped,
+	//e.g. to ship the receipts to an audit sink apart from the debug log, nil disables it. It runs on the consuming go-routine
+	//and must not block; the messages delivered through GetStream(), GetFile() or with LazyRead are not passed to it
+	OnAudit func(record AuditRecord)
+	//TmpfsRoot places a new channel on the tmpfs mounted there, leaving a link at its name for the peer, e.g. to cut the latency
+	//of frequent control messages; the channel falls back to its name on disk if no tmpfs is mounted there or it has less than
+	//TmpfsMinFree bytes left, defaultTmpfsMinFree if 0. The last element of the names must be unique across the channels under
+	//TmpfsRoot, and the messages on tmpfs do not survive a reboot. See Stats().Backing
+	TmpfsRoot    string
+	TmpfsMinFree int64
+	//Throttle leaves the messages on disk and polls the directory less often while the consumer of GetMessage() is behind,
+	//instead of holding the consuming go-routine until the consumer takes the next payload, and resumes once it caught up;
+	//no message is dropped and at most the go channel buffer is held in memory. See Stats().Throttled
+	Throttle bool
+}
+
+// consumerMode is how the payloads of a channel are consumed, see GetMessage(), GetMessageShared() and WaitForMessage()
+type consumerMode int
+
+const (
+	consumerNone consumerMode = iota
+	consumerSingle
+	consumerShared
+	//the single consumer pulling the payloads one by one with WaitForMessage()
+	consumerPull
 )
 
-//TODO add unittest
+// Message is a received payload along with the metadata of the file it was read from
+type Message struct {
+	Payload string
+	//the last modification time of the file, i.e. when the sender wrote it
+	ModTime time.Time
+	//the size of the file on disk, including the envelope if any
+	Size int64
+}
+
+// TODO add unittest
 type fileWatcherChannel struct {
 	logger        log.T
 	path          string
 	tmpPath       string
 	onMessageChan chan string
+	controlChan   chan ControlMessage
+	messageChan   chan Message
 	mode          Mode
 	counter       int
 	//the next expected message
 	recvCounter int
 	startTime   string
-	watcher     *fsnotify.Watcher
+	watcher     eventSource
 	mu          sync.RWMutex
-	closed      bool
+	//the start time of the peer the next expected message is from, tracked with Options.PeerRestart, guarded by consumeMu
+	recvStamp string
+	//serializes reading the directory, so that a file is never consumed twice by concurrent watch go-routines
+	consumeMu sync.Mutex
+	closed    bool
+	//whether Destroy() was called, guarded by mu
+	destroyed bool
+	//the step the teardown reached, set atomically, and the signal of the watch go-routines exiting, created by Close() under mu
+	teardown     int32
+	watchStopped chan struct{}
+	//the store the directory is on, see Options.TmpfsRoot
+	backing BackingStore
+	//identifies this channel in the ownership token of the directory, see ClaimOwnership()
+	ownerToken string
+	//the number of SendPriority() calls since the last payload sent in order, set atomically
+	priorityBurst int32
+	//whether Quiesce() was called, the sends are refused from then on, guarded by mu
+	quiescing bool
+	//fires once the channel outlived Options.MaxLifetime, nil if it's disabled; stopped by Close() under mu
+	lifetime  *time.Timer
+	options   Options
+	readPool  *sync.Pool
+	sentSizes *sizeHistogram
+	recvSizes *sizeHistogram
+	latencies *latencyWindow
+	scans     scanStats
+	clock     *clockGuard
+	//names of the files delivered but failed to be removed, guarded by consumeMu
+	undeletable map[string]bool
+	//names of the messages consumed last, guarded by consumeMu
+	recentConsumed *recentSet
+	//the number of consume rounds in a row each message failed to read in, see Options.OnUnreadable, guarded by consumeMu
+	readFailures map[string]int
+	//the handshake of the peer, routed apart from the other control messages
+	helloChan chan ControlMessage
+	//the streamed messages and the readers not closed yet
+	streamChan chan io.ReadCloser
+	streamsMu  sync.Mutex
+	streams    map[*streamReader]bool
+	//the writers returned by SendStream() not closed yet, guarded by streamsMu
+	sendStreams map[*streamWriter]bool
+	//the paths of the messages handed over as files
+	fileChan chan string
+	//last timestamp issued by IDSchemeTimestamp and the tiebreak among the ids sharing it
+	lastStamp int64
+	tiebreak  int
+	//whether the last window scan left stragglers on disk and the number of scans in a row consuming nothing, guarded by consumeMu
+	windowSkipped bool
+	windowMisses  int
+	//the missing message the stragglers are waiting for and since when, guarded by consumeMu
+	gapCounter int
+	gapSince   time.Time
+	//the path the channel was moved from, a link to the current path is left there for the peer
+	movedFrom string
+	//the real directory resolved at construction, see checkPinned(), and the link the channel was opened through if any
+	pinned   os.FileInfo
+	linkPath string
+	//the messages sent with an ack deadline and not resolved yet, by correlation id
+	ackCounter int
+	acksMu     sync.Mutex
+	acks       map[string]*pendingAck
+	//serializes the senders sharing the read lock, e.g. the acks sent off the consuming go-routine, guards the sending counters
+	sendMu sync.Mutex
+	//the messages sent and maybe not consumed by the peer yet, oldest first, tracked once SendWithDepth() is called
+	depthTracked bool
+	sentNames    []string
+	//whether the consumer is behind and the number of times it fell behind, set atomically, see Options.Throttle
+	throttled     int32
+	throttleCount uint32
+	//the interval the directory is polled at while the consumer is behind, guarded by consumeMu
+	throttleInterval time.Duration
+	//whether a poll is scheduled for a message locked by the peer or deferred by the BeforeDelete hook, guarded by consumeMu
+	retryPending bool
+	//the messages of the directory scan in progress read by Options.ReadWorkers, nil if none, guarded by consumeMu
+	readAhead *readAhead
+	//whether any message of the peer was received, the handshake included, i.e. the peer came up; set atomically
+	peerSeen int32
+	//number of messages being consumed, from reading the file until the payload is buffered in the go channels; set atomically
+	consuming int32
+	//number of watch go-routines running for this channel, more than one while a replaced watcher is torn down
+	watching int32
+	//number of times the watch go-routine was restarted after dying, guarded by mu
+	watchRestarts int
+	//the capabilities agreed by Handshake(), which gate the transforms of the payloads, guarded by mu
+	agreed []Capability
+	//the peer the messages must be sent by, nil if any, see ExpectPeer()
+	identityMu   sync.Mutex
+	expectedPeer *PeerIdentity
+	//the go-routines started by spawn() still running, and the file watchers not torn down yet along with their descriptors
+	routines   int32
+	watchers   int32
+	watcherFDs int32
+	//how the payloads are consumed, guarded by mu
+	consumer consumerMode
+	//whether the peer ended the stream, set atomically, and the close of the payload go channels either by it or by Close()
+	ended             int32
+	closeMessagesOnce sync.Once
+	//whether the consumer role was handed over to another process by Detach(), set atomically
+	detached int32
+	//when the last event of each recent file was handled, guarded by debounceMu
+	debounceMu   sync.Mutex
+	recentEvents map[string]time.Time
+	//the messages waiting for the consumer with Options.LazyRead, the one read and not taken yet, and the stop signal of the reader
+	pendingChan     chan pendingMessage
+	pendingHeld     int32
+	pendingStop     chan struct{}
+	pendingStopOnce sync.Once
+	//the message the reader held when stopped, set before it closes the GetMessage() go channel
+	pendingLeft *pendingMessage
 }
 
 //TODO make this constructor private
@@ -52,53 +346,148 @@ type fileWatcherChannel struct {
  	Only Master channel has the privilege to remove the dir at close time
 */
 func NewFileWatcherChannel(logger log.T, mode Mode, name string) (*fileWatcherChannel, error) {
+	return NewFileWatcherChannelWithOptions(logger, mode, name, Options{})
+}
 
-	tmpPath := path.Join(name, "tmp")
-	curTime := time.Now()
+// NewFileWatcherChannelWithOptions creates a file channel tuned by the given options
+func NewFileWatcherChannelWithOptions(logger log.T, mode Mode, name string, options Options) (*fileWatcherChannel, error) {
+	if options.Tag != "" {
+		logger = logger.WithContext("[tag=" + options.Tag + "]")
+	}
+
+	//reserve the watch before touching the directory, so that a channel with pending messages is never removed on failure
+	watchTimeout := options.WatchTimeout
+	if watchTimeout <= 0 {
+		watchTimeout = defaultWatchTimeout
+	}
+	if err := checkResourceLimits(); err != nil {
+		logger.Errorf("failed to create channel %v: %v", name, err)
+		return nil, err
+	}
+	if err := checkPathLength(logger, mode, name); err != nil {
+		logger.Errorf("failed to create channel %v: %v", name, err)
+		return nil, err
+	}
+	if err := watches.acquire(watchTimeout); err != nil {
+		logger.Errorf("failed to create channel %v: %v", name, err)
+		return nil, err
+	}
+	placeOnTmpfs(logger, name, options)
 	//TODO if client is RunAs, server needs to grant client user R/W access respectively
 	if err := createIfNotExist(name); err != nil {
 		logger.Errorf("failed to create directory: %v", err)
 		os.RemoveAll(name)
+		watches.release()
 		//if err occurs, the channel is not healthy anymore, should return false
 		return nil, err
 	}
-	if err := createIfNotExist(tmpPath); err != nil {
+	//a relocated channel root is commonly a link, pin the real directory it points to
+	linkPath := name
+	name, pinned, err := resolveChannelPath(name)
+	if err != nil {
+		logger.Errorf("failed to resolve channel path %v: %v", linkPath, err)
+		watches.release()
+		return nil, err
+	}
+	if name == linkPath {
+		linkPath = ""
+	}
+	ch := newChannel(logger, mode, name, options)
+	if err := createIfNotExist(ch.tmpPath); err != nil {
 		logger.Errorf("failed to create directory: %v", err)
 		os.RemoveAll(name)
+		watches.release()
 		//if err occurs, the channel is not healthy anymore, should return false
 		return nil, err
 	}
 
-	//buffered channel in order not to block listener
-	onMessageChan := make(chan string, defaultChannelBufferSize)
-
 	//start file watcher and monitor the directory
-	watcher, err := fsnotify.NewWatcher()
+	watcher, err := newWatcher(logger, name, options.WatchBackend)
 	if err != nil {
-		logger.Errorf("filewatcher listener encountered error when start watcher: %v", err)
 		os.RemoveAll(name)
+		watches.release()
 		return nil, err
 	}
-
-	if err = watcher.Add(name); err != nil {
-		logger.Errorf("filewatcher listener encountered error when add watch: %v", err)
-		os.RemoveAll(name)
-		return nil, err
+	ch.watcher = watcher
+	ch.pinned = pinned
+	ch.linkPath = linkPath
+	ch.backing = backingStore(name)
+	//a master reattaching to the channel takes over the cleanup from the previous one
+	if mode == ModeMaster {
+		if err := ch.ClaimOwnership(); err != nil {
+			logger.Errorf("failed to claim the ownership of channel %v, leaving it to the previous owner: %v", name, err)
+		}
+	}
+	if err := ch.applyDirMode(); err != nil {
+		logger.Errorf("failed to apply the permissions of channel %v: %v", name, err)
 	}
+	ch.resumeDetached()
+	ch.holdWatcher(watcher)
+	if ch.options.LazyRead {
+		ch.spawn(ch.readPending)
+	}
+	register(ch)
+	ch.startWatch(watcher)
+	if options.OnBacklogAge != nil && options.BacklogAgeThreshold > 0 {
+		ch.spawn(ch.monitorBacklog)
+	}
+	ch.armLifetime()
+	return ch, nil
+}
 
+// newChannel builds the channel of the given directory, without touching the directory nor starting any go-routine
+func newChannel(logger log.T, mode Mode, name string, options Options) *fileWatcherChannel {
+	if options.DeliverMetadata || options.BeforeDelete != nil {
+		options.LazyRead = false
+	}
+	//buffered channel in order not to block listener
+	onMessageChan := make(chan string, defaultChannelBufferSize)
+	if options.LazyRead {
+		//the messages are buffered on disk instead, only the one handed over is in memory
+		onMessageChan = make(chan string)
+	}
+	curTime := time.Now()
 	ch := &fileWatcherChannel{
 		path:          name,
-		tmpPath:       tmpPath,
-		watcher:       watcher,
+		tmpPath:       path.Join(name, "tmp"),
 		onMessageChan: onMessageChan,
+		controlChan:   make(chan ControlMessage, defaultChannelBufferSize),
+		helloChan:     make(chan ControlMessage, 1),
+		streamChan:    make(chan io.ReadCloser, defaultChannelBufferSize),
+		fileChan:      make(chan string, defaultChannelBufferSize),
+		messageChan:   make(chan Message, defaultChannelBufferSize),
 		logger:        logger,
 		mode:          mode,
 		counter:       0,
 		recvCounter:   0,
 		startTime:     fmt.Sprintf("%04d%02d%02d%02d%02d%02d", curTime.Year(), curTime.Month(), curTime.Day(), curTime.Hour(), curTime.Minute(), curTime.Second()),
+		options:       options,
+		readPool:      newReadPool(options.ReadBufferSize),
+		sentSizes:     newSizeHistogram(options.SizeBuckets),
+		recvSizes:     newSizeHistogram(options.SizeBuckets),
+		latencies:     newLatencyWindow(),
+		clock:         newClockGuard(),
+		ownerToken:    newOwnerToken(mode),
 	}
-	go ch.watch()
-	return ch, nil
+	if options.LazyRead {
+		ch.pendingChan = make(chan pendingMessage, defaultChannelBufferSize)
+		ch.pendingStop = make(chan struct{})
+	}
+	return ch
+}
+
+// ReopenFileWatcherChannel reattaches to an existing channel, e.g. after the agent restarts while the worker keeps running
+// unlike NewFileWatcherChannel, it fails if the channel directory no longer exists
+func ReopenFileWatcherChannel(logger log.T, mode Mode, name string) (*fileWatcherChannel, error) {
+	return reopenFileWatcherChannel(logger, mode, name, Options{})
+}
+
+func reopenFileWatcherChannel(logger log.T, mode Mode, name string, options Options) (*fileWatcherChannel, error) {
+	if _, err := os.Stat(name); err != nil {
+		logger.Errorf("failed to reopen channel %v: %v", name, err)
+		return nil, err
+	}
+	return NewFileWatcherChannelWithOptions(logger, mode, name, options)
 }
 
 func createIfNotExist(dir string) (err error) {
@@ -110,129 +499,644 @@ func createIfNotExist(dir string) (err error) {
 }
 
 /*
-	drop a file in the destination path with the file name as sequence id
-	the file is first named as tmp, then quickly renamed to guarantee atomicity
-	sequence id format: {mode}-{command start time}-{counter} , squence id is guaranteed to be ascending order
-
+drop a file in the destination path with the file name as sequence id
+the file is first named as tmp, then quickly renamed to guarantee atomicity
+sequence id format: {mode}-{command start time}-{counter} , squence id is guaranteed to be ascending order
+with IDSchemeTimestamp the format is {mode}-{unix nano}-{tiebreak}, see nextSequenceID()
 */
 func (ch *fileWatcherChannel) Send(rawJson string) error {
-	if ch.closed {
-		return errors.New("channel already closed")
-	}
+	return ch.send(envelope{Payload: rawJson})
+}
+
+// SendControl sends a control message, it is delivered to the ControlMessages() go channel of the peer instead of GetMessage()
+// control messages are always wrapped in an envelope, the peer must be able to unwrap it
+func (ch *fileWatcherChannel) SendControl(msg ControlMessage) error {
+	return ch.send(envelope{Control: &msg})
+}
+
+func (ch *fileWatcherChannel) send(env envelope) error {
 	log := ch.logger
+	if ch.options.ValidateJSON && env.Control == nil {
+		var parsed interface{}
+		if err := jsonutil.Unmarshal(env.Payload, &parsed); err != nil {
+			log.Errorf("refusing to send malformed json: %v", err)
+			return ErrInvalidJSON
+		}
+	}
+	//the read lock only keeps Close() from tearing down the channel under the senders, the concurrent senders are not
+	//serialized while encoding and writing, only while picking the sequence id and renaming the file in place
 	ch.mu.RLock()
 	defer ch.mu.RUnlock()
-	sequenceID := fmt.Sprintf("%v-%s-%03d", ch.mode, ch.startTime, ch.counter)
-	filepath := path.Join(ch.path, sequenceID)
-	tmp_filepath := path.Join(ch.tmpPath, sequenceID)
-	//ensure sync exclusive write
-	if err := ioutil.WriteFile(tmp_filepath, []byte(rawJson), defaultFileWriteMode); err != nil {
-		log.Errorf("write file %v encountered error: %v \n", tmp_filepath, err)
+	if ch.closed || ch.quiescing {
+		return ErrChannelClosed
+	}
+	content, err := ch.encode(env)
+	if err != nil {
+		log.Errorf("failed to encode message: %v", err)
 		return err
 	}
-	if err := os.Rename(tmp_filepath, filepath); err != nil {
-		log.Errorf("send renaming file encountered error: %v", err)
+	tmpPath, err := ch.writeTmpFile(content)
+	if err != nil {
+		log.Errorf("write file %v encountered error: %v \n", tmpPath, err)
+		return notWritableOr(err)
+	}
+	if err = ch.commitLocked(tmpPath); err != nil {
+		ch.fs().Remove(tmpPath)
 		return err
 	}
+	if env.Control == nil {
+		ch.sentSizes.record(len(env.Payload))
+		if !env.Priority {
+			atomic.StoreInt32(&ch.priorityBurst, 0)
+		}
+	}
+	return nil
+}
+
+// write the content of a message under a unique name in the tmp directory, it gets its sequence id once complete
+func (ch *fileWatcherChannel) writeTmpFile(content string) (string, error) {
+	tmpPath := path.Join(ch.tmpPath, ch.tmpFileName())
+	f, err := ch.fs().Create(tmpPath)
+	if err != nil {
+		return tmpPath, err
+	}
+	_, err = io.WriteString(f, content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		ch.fs().Remove(tmpPath)
+	}
+	return tmpPath, err
+}
+
+// move a complete message file into the channel directory under the next sequence id, the caller must hold the read lock
+// sendMu makes picking the id, renaming the file and advancing the counter atomic, so that the ids are never reused and the
+// messages land in the order of their ids
+func (ch *fileWatcherChannel) commitLocked(tmpPath string) error {
+	log := ch.logger
+	ch.sendMu.Lock()
+	defer ch.sendMu.Unlock()
+	sequenceID := ch.nextSequenceID()
+	if ch.options.CooperativeLock {
+		lockPath, err := ch.lockMessage(sequenceID)
+		if err != nil {
+			log.Errorf("failed to lock message %v: %v", sequenceID, err)
+			return notWritableOr(err)
+		}
+		//released once the message is renamed in place, or failed to
+		defer removeFile(lockPath)
+	}
+	if err := ch.renameWithRetry(tmpPath, path.Join(ch.path, sequenceID)); err != nil {
+		log.Errorf("send renaming file encountered error: %v", err)
+		return notWritableOr(err)
+	}
 	//file successfully sent, increment counter
 	ch.counter++
+	if ch.depthTracked {
+		ch.sentNames = append(ch.sentNames, sequenceID)
+	}
 	return nil
 }
 
+// translate the error of a read-only file system or a permission denial into ErrNotWritable
+func notWritableOr(err error) error {
+	cause := err
+	switch e := err.(type) {
+	case *os.PathError:
+		cause = e.Err
+	case *os.LinkError:
+		cause = e.Err
+	}
+	if os.IsPermission(cause) || cause == syscall.EROFS {
+		return ErrNotWritable
+	}
+	return err
+}
+
+// rename the tmp file to its destination, retrying with backoff since the rename can transiently fail on windows
+func (ch *fileWatcherChannel) renameWithRetry(from, to string) (err error) {
+	log := ch.logger
+	retries := ch.options.RenameRetries
+	if retries == 0 {
+		retries = defaultRenameRetries
+	}
+	backoff := ch.options.RenameBackoff
+	if backoff <= 0 {
+		backoff = defaultRenameBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		if err = ch.fs().Rename(from, to); err == nil || attempt >= retries {
+			return
+		}
+		log.Debugf("renaming %v failed (attempt %v), retrying in %v: %v", from, attempt+1, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// wrap the datagram in an envelope if any of the envelope features is enabled
+func (ch *fileWatcherChannel) encode(env envelope) (string, error) {
+	env, err := ch.transform(env)
+	if err != nil {
+		return "", err
+	}
+	//the binary envelope has no room for the correlation id, the expiry, the sender identity, the transforms nor the priority
+	tagged := env.Compressed || env.Sealed || env.Priority
+	binaryEncoding := ch.options.Encoding == EncodingBinary && env.AckID == "" && env.ExpiresAt == 0 && !ch.options.SendIdentity && !tagged
+	if !ch.options.TrackLatency && env.Control == nil && env.AckID == "" && env.ExpiresAt == 0 && !binaryEncoding && !ch.options.SendIdentity && !tagged {
+		return env.Payload, nil
+	}
+	if ch.options.SendIdentity {
+		identity := ch.Identity()
+		env.SenderPid, env.SenderStart = identity.Pid, identity.StartTime
+	}
+	if ch.options.TrackLatency {
+		env.SentAt = ch.now()
+	}
+	if binaryEncoding {
+		return encodeBinaryEnvelope(env), nil
+	}
+	return encodeEnvelope(env)
+}
+
+// Stats returns a snapshot of the channel metrics
+func (ch *fileWatcherChannel) Stats() Stats {
+	return Stats{
+		Tag:           ch.options.Tag,
+		SentSizes:     ch.sentSizes.snapshot(),
+		ReceivedSizes: ch.recvSizes.snapshot(),
+		SendLatency:   ch.latencies.snapshot(),
+		BacklogAge:    ch.backlogAge(),
+		ClockSteps:    ch.clock.backwardSteps(),
+		Backing:       ch.backing,
+		Scans:         ch.scans.snapshot(),
+		Throttled:     atomic.LoadInt32(&ch.throttled) == 1,
+		Throttles:     uint64(atomic.LoadUint32(&ch.throttleCount)),
+	}
+}
+
+// GetMessage hands the payloads over to a single consumer, a second call panics with ErrMultipleConsumers so that an accidental
+// fan-out processing the messages out of order is caught early; see GetMessageShared() for several consumers
 func (ch *fileWatcherChannel) GetMessage() <-chan string {
+	ch.claimConsumer(consumerSingle)
 	return ch.onMessageChan
 }
 
+// GetMessageShared returns the payload go channel to any number of consumer go-routines, the messages are still delivered
+// in order but may be processed in any order; it panics with ErrMultipleConsumers if GetMessage() has been called
+func (ch *fileWatcherChannel) GetMessageShared() <-chan string {
+	ch.claimConsumer(consumerShared)
+	return ch.onMessageChan
+}
+
+// record how the payloads are consumed, a single consumer excludes any other; the shared consumers, and the pulling one,
+// can claim the payloads repeatedly
+func (ch *fileWatcherChannel) claimConsumer(mode consumerMode) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.consumer != consumerNone && (ch.consumer != mode || mode == consumerSingle) {
+		panic(ErrMultipleConsumers)
+	}
+	ch.consumer = mode
+}
+
+// GetMessageWithMetadata receives the payloads with their file metadata, it is only fed if Options.DeliverMetadata is set
+// the go channel is closed together with the GetMessage() one
+func (ch *fileWatcherChannel) GetMessageWithMetadata() <-chan Message {
+	return ch.messageChan
+}
+
+// ControlMessages receives the control messages only, the payloads keep flowing to GetMessage()
+// the go channel is closed together with the GetMessage() one
+func (ch *fileWatcherChannel) ControlMessages() <-chan ControlMessage {
+	return ch.controlChan
+}
+
+// WaitForMessage returns the next message, or ErrChannelClosed if the channel is closed while waiting, ErrEndOfStream once
+// the peer ended the stream
+// the caller pulling the messages is their single consumer, it panics with ErrMultipleConsumers if GetMessage() or
+// GetMessageShared() has been called, and so do they once WaitForMessage() has been
+func (ch *fileWatcherChannel) WaitForMessage(timeout time.Duration) (string, error) {
+	ch.claimConsumer(consumerPull)
+	select {
+	case msg, more := <-ch.onMessageChan:
+		if !more {
+			if ch.StreamEnded() {
+				return "", ErrEndOfStream
+			}
+			return "", ErrChannelClosed
+		}
+		return msg, nil
+	case <-time.After(timeout):
+		return "", ErrMessageTimeout
+	}
+}
+
+// Destroy closes the channel and, on the master side, removes its directory; it's safe to call any number of times, after
+// Close() or not, only the first call removes the directory
+// it blocks until the watch go-routines exited, up to Options.CloseTimeout, see TeardownState(); so it must not be called
+// from a hook running on the consuming go-routine
 func (ch *fileWatcherChannel) Destroy() {
 	ch.Close()
-	//only master can remove the dir at close
-	if ch.mode == ModeMaster {
-		ch.logger.Debug("master removing directory...")
-		if err := os.RemoveAll(ch.path); err != nil {
+	ch.mu.Lock()
+	destroyed := ch.destroyed
+	ch.destroyed = true
+	watchStopped := ch.watchStopped
+	ch.mu.Unlock()
+	if destroyed {
+		return
+	}
+	<-watchStopped
+	ch.closeStreams()
+	ch.abandonPending()
+	//a timer polling the directory, e.g. a retry, waits for the removal to complete and finds nothing left
+	ch.consumeMu.Lock()
+	defer ch.consumeMu.Unlock()
+	defer ch.advanceTeardown(TeardownRemoved)
+	//only the owner can remove the dir at close, the master unless the ownership was transferred
+	if !ch.IsOwner() {
+		ch.logger.Debugf("channel %v is not owned by this end, leaving the directory in place", ch.path)
+	} else {
+		ch.logger.Debug("owner removing directory...")
+		if err := ch.checkPinned(); err != nil {
+			ch.logger.Errorf("refusing to remove directory %v : %v", ch.path, err)
+		} else if err := removeChannelDir(ch.path); err != nil {
 			ch.logger.Errorf("failed to remove directory %v : %v", ch.path, err)
 		}
+		if ch.linkPath != "" {
+			if info, err := os.Lstat(ch.linkPath); err == nil && info.Mode()&os.ModeSymlink != 0 {
+				os.Remove(ch.linkPath)
+			}
+		}
+		if ch.movedFrom != "" {
+			os.Remove(ch.movedFrom)
+		}
 	}
 }
 
 // Close a filechannel
 // non-blocking call, drain the buffered messages and clear file watcher resources
 func (ch *fileWatcherChannel) Close() {
+	//block other threads to call Send()
+	ch.mu.Lock()
 	if ch.closed {
+		ch.mu.Unlock()
 		return
 	}
+	ch.closed = true
+	ch.watchStopped = make(chan struct{})
+	ch.advanceTeardown(TeardownDraining)
+	if ch.lifetime != nil {
+		ch.lifetime.Stop()
+	}
+	watchStopped := ch.watchStopped
+	ch.mu.Unlock()
+	unregister(ch)
 	log := ch.logger
 	log.Infof("channel %v requested close", ch.path)
-	//block other threads to call Send()
-	ch.closed = true
 	//read all the left over messages
-	ch.consumeAll()
+	ch.drain()
+	closeTimeout := ch.options.CloseTimeout
+	if closeTimeout <= 0 {
+		closeTimeout = defaultCloseTimeout
+	}
+	teardown := closeWatcher
+	limiter := watches
+	ch.mu.RLock()
+	watcher := ch.watcher
+	ch.mu.RUnlock()
 	// fsnotify.watch.close() could be a blocking call, we should offload them to a different go-routine
-	go func() {
+	ch.spawn(func() {
 		defer func() {
-			if msg := recover(); msg != nil {
-				log.Errorf("closing file watcher panics: %v", msg)
-			}
-			close(ch.onMessageChan)
+			ch.closeMessages()
+			close(ch.controlChan)
+			close(ch.streamChan)
+			close(ch.fileChan)
 			log.Infof("channel %v closed", ch.path)
 		}()
-		//make sure the file watcher closed as well as the watch list is removed, otherwise can cause leak in ubuntu kernel
-		ch.watcher.Remove(ch.path)
-		ch.watcher.Close()
-	}()
+		watcherClosed := make(chan bool)
+		ch.spawn(func() {
+			defer func() {
+				if msg := recover(); msg != nil {
+					log.Errorf("closing file watcher panics: %v", msg)
+				}
+				close(watcherClosed)
+			}()
+			teardown(watcher, ch.path)
+			//a watch stuck in the teardown stays accounted for, since the kernel resource is not released either
+			limiter.release()
+			ch.releaseWatcher(watcher)
+		})
+		//if the teardown hangs, do not block the consumers forever, the watcher and its go-routines are leaked in that case
+		deadline := time.Now().Add(closeTimeout)
+		select {
+		case <-watcherClosed:
+			//the watch go-routine exits once it sees its events go channel closed
+			if !ch.waitWatchStopped(deadline) {
+				log.Errorf("watch go-routine of %v did not exit in %v", ch.path, closeTimeout)
+			}
+		case <-time.After(closeTimeout):
+			log.Errorf("closing file watcher of %v did not complete in %v, the watcher resource may leak", ch.path, closeTimeout)
+		}
+		ch.advanceTeardown(TeardownWatcherStopped)
+		close(watchStopped)
+	})
 
 	return
 }
 
-//parse the counter out of the sequence id, return -1 if parsing fails
-//counter is defined as the padding last element of - separated integer
-//On windows, path.Base() does not work
-func parseSequenceCounter(filepath string) int {
-	_, name := path.Split(filepath)
-	parts := strings.Split(name, "-")
-	counter, err := strconv.ParseInt(parts[len(parts)-1], 10, 64)
+// Reset recovers a wedged channel without losing messages: it replaces the file watcher, re-derives the receiving counter
+// from the files left on disk and resumes consuming them, the GetMessage() go channel is kept as is
+func (ch *fileWatcherChannel) Reset() error {
+	log := ch.logger
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.closed {
+		return ErrChannelClosed
+	}
+	log.Infof("resetting channel %v", ch.path)
+	watcher, err := newWatcher(log, ch.path, ch.options.WatchBackend)
+	if err != nil {
+		return err
+	}
+	ch.replaceWatcherLocked(watcher)
+
+	ch.consumeMu.Lock()
+	if next, found := ch.lowestPending(); found {
+		log.Infof("re-deriving the receiving counter from %v to %v", ch.recvCounter, next.Counter)
+		ch.recvCounter = next.Counter
+	}
+	ch.consumeMu.Unlock()
+	ch.startWatch(watcher)
+	return nil
+}
+
+// MoveChannel moves the channel directory along with the pending messages to newPath, e.g. when relocating the IPC root
+// the directory is renamed as a whole, so newPath must be on the same file system, ErrCrossFilesystem otherwise
+// a link to newPath is left at the old path, so that the peer keeps sending until it reopens the channel at newPath
+func (ch *fileWatcherChannel) MoveChannel(newPath string) error {
+	log := ch.logger
+	//block Send() and the consumption until the new watcher is in place
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.closed {
+		return ErrChannelClosed
+	}
+	if _, err := os.Stat(newPath); err == nil {
+		return fmt.Errorf("cannot move channel %v to %v: %v", ch.path, newPath, os.ErrExist)
+	}
+	if err := checkPathLength(log, ch.mode, newPath); err != nil {
+		return err
+	}
+	same, err := sameFilesystem(ch.path, path.Dir(newPath))
+	if err != nil {
+		return err
+	}
+	if !same {
+		log.Errorf("cannot move channel %v to %v: %v", ch.path, newPath, ErrCrossFilesystem)
+		return ErrCrossFilesystem
+	}
+	ch.consumeMu.Lock()
+	defer ch.consumeMu.Unlock()
+	log.Infof("moving channel %v to %v", ch.path, newPath)
+	if err = rename(ch.path, newPath); err != nil {
+		return err
+	}
+	watcher, err := newWatcher(log, newPath, ch.options.WatchBackend)
 	if err != nil {
-		return -1
+		//the old watcher is still in place once moved back
+		if restoreErr := rename(newPath, ch.path); restoreErr != nil {
+			log.Errorf("failed to move channel %v back to %v: %v", newPath, ch.path, restoreErr)
+		}
+		return err
+	}
+	oldPath, oldWatcher := ch.path, ch.watcher
+	if real, pinned, err := resolveChannelPath(newPath); err == nil {
+		newPath, ch.pinned = real, pinned
 	}
-	return int(counter)
+	ch.path = newPath
+	ch.tmpPath = path.Join(newPath, "tmp")
+	ch.watcher = watcher
+	ch.holdWatcher(watcher)
+	//the old watch go-routine may still consume a pending event through the link, then exits once its watcher is closed
+	ch.spawn(func() {
+		closeWatcher(oldWatcher, oldPath)
+		ch.releaseWatcher(oldWatcher)
+	})
+	if target, err := filepath.Abs(newPath); err != nil {
+		log.Errorf("failed to link %v to %v: %v", oldPath, newPath, err)
+	} else if err = os.Symlink(target, oldPath); err != nil {
+		log.Errorf("failed to link %v to %v, the peer must reopen the channel: %v", oldPath, newPath, err)
+	} else {
+		ch.movedFrom = oldPath
+	}
+	//the new watch go-routine polls the messages dropped while the watcher was replaced
+	ch.startWatch(watcher)
+	return nil
 }
 
-//read all messages in the consuming dir, with order guarantees -- ioutil.ReadDir() sort by name, and name is the lexicographical ascending sequence id.
-//filter out its own sent messages and tmp messages
+// find the sequence id of the first unconsumed file in the sequence order
+func (ch *fileWatcherChannel) lowestPending() (SequenceID, bool) {
+	fileInfos, _ := ch.fs().ReadDir(ch.path)
+	less := ch.sequenceLess()
+	var lowest SequenceID
+	found := false
+	for _, info := range fileInfos {
+		if !ch.isReadable(info.Name()) {
+			continue
+		}
+		if id, err := ParseSequenceID(info.Name()); err == nil && (!found || less(id, lowest)) {
+			lowest, found = id, true
+		}
+	}
+	return lowest, found
+}
+
+func (ch *fileWatcherChannel) isClosed() bool {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+	return ch.closed
+}
+
+// parse the counter out of the sequence id, see ParseSequenceID()
+func parseSequenceCounter(filepath string) (int, error) {
+	id, err := ParseSequenceID(filepath)
+	return id.Counter, err
+}
+
+// move a file that cannot be consumed out of the channel directory, so that it's neither retried nor blocks the ones after it
+// the receiving counter is left as is
+func (ch *fileWatcherChannel) deadLetter(filepath string, reason error) {
+	ch.moveAside(filepath, deadLetterPrefix, reason)
+	ch.audit(filepath, 0, AuditDeadLettered)
+}
+
+// move a file under the tmp directory with the given prefix, if it fails the file is skipped instead
+func (ch *fileWatcherChannel) moveAside(filepath string, prefix string, reason error) {
+	log := ch.logger
+	asidePath := path.Join(ch.tmpPath, prefix+path.Base(filepath))
+	log.Errorf("moving message %v to %v: %v", filepath, asidePath, reason)
+	if err := ch.fs().Rename(filepath, asidePath); err != nil {
+		log.Errorf("failed to move message %v, skipping it: %v", filepath, err)
+		if ch.undeletable == nil {
+			ch.undeletable = make(map[string]bool)
+		}
+		ch.undeletable[path.Base(filepath)] = true
+	}
+}
+
+// read all messages in the consuming dir, with order guarantees -- the names are sorted by their decoded sequence ids, see SendOrder()
+// filter out its own sent messages and tmp messages
 func (ch *fileWatcherChannel) consumeAll() {
+	ch.consumeMu.Lock()
+	defer ch.consumeMu.Unlock()
+	ch.consumeAllLocked()
+}
+
+// same as consumeAll, the caller must hold consumeMu
+func (ch *fileWatcherChannel) consumeAllLocked() {
 	ch.logger.Debug("consuming all the messages under: ", ch.path)
-	fileInfos, _ := ioutil.ReadDir(ch.path)
-	if len(fileInfos) > 0 {
-		for _, info := range fileInfos {
-			name := info.Name()
-			if ch.isReadable(name) {
-				ch.consume(path.Join(ch.path, name))
-			}
+	start := time.Now()
+	fileInfos, _ := ch.fs().ReadDir(ch.path)
+	defer ch.recordScan(start, len(fileInfos))
+	var names []string
+	for _, info := range fileInfos {
+		if name := info.Name(); ch.isReadable(name) {
+			names = append(names, name)
 		}
 	}
+	ch.sortSequenceNames(names)
+	if ch.options.Order != nil && len(names) > 1 {
+		names = ch.options.Order(names, ch.isControlFile)
+	}
+	ch.consumeNamesLocked(names)
 }
 
-//TODO add unittest
+// record the cost of a directory poll started at the given time, the caller must hold consumeMu
+func (ch *fileWatcherChannel) recordScan(start time.Time, scanned int) {
+	duration := time.Since(start)
+	ch.scans.record(duration, scanned)
+	if duration > slowScanThreshold {
+		ch.logger.Infof("polling channel %v took %v for %v entries", ch.path, duration, scanned)
+	}
+}
+
+// the name of a message file, compiled once since every file event and directory poll matches against it
+var messageNamePattern = regexp.MustCompile("[a-zA-Z]+-[0-9]+-[0-9]+")
+
+// TODO add unittest
 func (ch *fileWatcherChannel) isReadable(filename string) bool {
-	matched, err := regexp.MatchString("[a-zA-Z]+-[0-9]+-[0-9]+", filename)
-	if !matched || err != nil {
+	if !messageNamePattern.MatchString(filename) {
 		return false
 	}
 	return !strings.Contains(filename, string(ch.mode)) && !strings.Contains(filename, "tmp")
 }
 
-//read and remove a given file
-func (ch *fileWatcherChannel) consume(filepath string) {
+// create a pool of read buffers of the given size, return nil if pooling is disabled
+func newReadPool(size int) *sync.Pool {
+	if size <= 0 {
+		return nil
+	}
+	return &sync.Pool{
+		New: func() interface{} {
+			buf := make([]byte, size)
+			return &buf
+		},
+	}
+}
+
+// read the whole file as a string, reusing a pooled buffer when the file fits in it
+// the content is copied only once, when converted to the delivered string; the pool is bypassed by Options.FileSystem
+func (ch *fileWatcherChannel) readFile(filepath string) (string, error) {
+	if ch.readPool == nil || ch.options.FileSystem != nil {
+		buf, err := ch.fs().ReadFile(filepath)
+		return string(buf), err
+	}
+	f, err := os.Open(filepath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	bufPtr := ch.readPool.Get().(*[]byte)
+	defer ch.readPool.Put(bufPtr)
+	buf := *bufPtr
+	if info.Size() >= int64(len(buf)) {
+		//message exceeds the pooled buffer, fall back to a fresh allocation
+		content, err := ioutil.ReadAll(f)
+		return string(content), err
+	}
+	//the buffer is larger than the file, a short read is expected; a full buffer means the file grew after Stat()
+	n, err := io.ReadFull(f, buf)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return string(buf[:n]), nil
+	} else if err != nil {
+		return "", err
+	}
+	rest, err := ioutil.ReadAll(f)
+	return string(buf) + string(rest), err
+}
+
+// read and remove a given file
+func (ch *fileWatcherChannel) consume(filepath string) bool {
 	log := ch.logger
 	log.Debugf("consuming message under path: %v", filepath)
+	if ch.undeletable[path.Base(filepath)] {
+		log.Debugf("message %v is already delivered, skipping it", filepath)
+		return true
+	}
+	if ch.consumedLately(filepath) {
+		//the same name showed up again, e.g. a send retried after the rename went through
+		log.Debugf("message %v was consumed lately, dropping the duplicate", filepath)
+		ch.fs().Remove(filepath)
+		return true
+	}
+	id, err := ParseSequenceID(filepath)
+	if err != nil {
+		ch.deadLetter(filepath, err)
+		return true
+	}
+	counter := id.Counter
+	//whatever happens to the message next, the peer was up to write it
+	atomic.StoreInt32(&ch.peerSeen, 1)
+	if ch.checkPeerRestart(id) {
+		//the leftover is delivered as any other message, the receiving counter is the current peer's
+		recvCounter := ch.recvCounter
+		defer func() { ch.recvCounter = recvCounter }()
+	}
+	//the messages of an expected peer are read to check the identity in their envelope
+	unchecked := ch.peerExpected() == nil
+	if ch.options.StreamThreshold > 0 && unchecked && ch.tryStream(filepath, counter) {
+		return true
+	}
+	if ch.options.HandoffDir != "" && unchecked && ch.tryHandoff(filepath, counter) {
+		return true
+	}
+	if ch.options.LazyRead && unchecked && ch.tryDefer(filepath, counter) {
+		return true
+	}
 
-	var buf []byte
-	var err error
+	var content string
 
 	for attempt := 0; attempt < consumeAttemptCount; attempt++ {
 		//On windows rename does not guarantee atomic access: https://github.com/golang/go/issues/8914
 		//In exclusive mode we have, this read will for sure fail when it's locked by the other end
-		buf, err = ioutil.ReadFile(filepath)
-		if err != nil {
+		var readAhead bool
+		if attempt == 0 {
+			content, err, readAhead = ch.readAhead.take(filepath)
+		}
+		if !readAhead {
+			content, err = ch.readFile(filepath)
+		}
+		if os.IsNotExist(err) {
+			//removed meanwhile, e.g. delivered as part of a compacted file
+			log.Debugf("message %v no longer exists, skipping it", filepath)
+			return true
+		} else if err != nil {
 			log.Debugf("message %v failed to read (attempt %v): %v \n", filepath, attempt+1, err)
 			time.Sleep(time.Duration(consumeRetryIntervalInMilliseconds) * time.Millisecond)
 		} else {
@@ -242,45 +1146,270 @@ func (ch *fileWatcherChannel) consume(filepath string) {
 
 	if err != nil {
 		log.Errorf("message %v failed to read: %v \n", filepath, err)
-		return
+		ch.unreadable(filepath, counter, err)
+		return true
 
 	}
+	delete(ch.readFailures, path.Base(filepath))
+	if isCompacted(content) {
+		ch.consumeCompacted(filepath, counter, content)
+		return true
+	}
+	if ch.options.BeforeDelete != nil {
+		if err = ch.options.BeforeDelete(path.Base(filepath), content); err != nil {
+			log.Errorf("deferring message %v, retrying in %v: %v", filepath, deleteRetryInterval, err)
+			return false
+		}
+	}
 
-	//remove the consumed file
-	os.Remove(filepath)
+	var info os.FileInfo
+	if ch.options.DeliverMetadata {
+		//stat before the file is removed below
+		if info, err = ch.fs().Stat(filepath); err != nil {
+			log.Errorf("message %v failed to stat: %v", filepath, err)
+		}
+	}
+	ch.deliverContent(filepath, counter, content, info, false)
+	return true
+}
+
+// decode the content of a message and route it, then remove its file; framed is whether the message was read from a compacted
+// file, in which case its own file is only left over by an interrupted compaction
+func (ch *fileWatcherChannel) deliverContent(filepath string, counter int, content string, info os.FileInfo, framed bool) {
+	log := ch.logger
+	env, err := decodeEnvelope(content)
+	if err == ErrIncompatibleVersion {
+		//keep the message for a replay once this end is upgraded too, see ReplayDeadLetter()
+		reason := fmt.Errorf("%v, the peer runs a newer agent version", err)
+		if framed {
+			ch.deadLetterFrame(filepath, content, reason)
+		} else {
+			ch.deadLetter(filepath, reason)
+		}
+		ch.recvCounter = counter + 1
+		return
+	}
+	if err != nil {
+		//the message can never be read, drop it so that it does not block the ones after it
+		log.Errorf("message %v failed to decode, dropping it: %v", filepath, err)
+		ch.removeConsumed(filepath)
+		ch.audit(filepath, 0, AuditDropped)
+		ch.recvCounter = counter + 1
+		return
+	}
+	if env, err = ch.untransform(env); err != nil {
+		//e.g. sealed under another key, keep it for a replay once the key is fixed
+		reason := fmt.Errorf("failed to restore the transformed payload: %v", err)
+		if framed {
+			ch.deadLetterFrame(filepath, content, reason)
+		} else {
+			ch.deadLetter(filepath, reason)
+		}
+		ch.recvCounter = counter + 1
+		return
+	}
+	if !ch.fromExpectedPeer(env) {
+		log.Errorf("message %v is sent by pid %v of channel started at %q instead of the expected peer, dropping it", filepath, env.SenderPid, env.SenderStart)
+		ch.removeConsumed(filepath)
+		ch.audit(filepath, env.SentAt, AuditDropped)
+		ch.recvCounter = counter + 1
+		return
+	}
+	now := ch.now()
+	if ch.expiredAt(env, now) {
+		log.Errorf("message %v expired %v ago, dropping it", filepath, time.Duration(now-env.ExpiresAt))
+		ch.removeConsumed(filepath)
+		ch.audit(filepath, env.SentAt, AuditExpired)
+		ch.recvCounter = counter + 1
+		return
+	}
+	if env.Minor > envelopeMinorVersion {
+		log.Debugf("message %v is of a newer minor version %v.%v, ignoring the fields unknown to this version", filepath, env.Version, env.Minor)
+	}
+	msg := env.Payload
+	//a negative latency is the wall clock stepping back in between rather than a sample
+	if latency := time.Duration(now - env.SentAt); env.SentAt > 0 && latency >= 0 {
+		ch.latencies.record(latency)
+	}
+	//remove the consumed file, the removal claims the message against another process consuming the same directory
+	//whereas the file of a framed message is an optional leftover
+	if !ch.removeConsumed(filepath) && !framed {
+		log.Debugf("message %v was taken by another consumer meanwhile, skipping it", filepath)
+		ch.recvCounter = counter + 1
+		return
+	}
 	//update the recvcounter
-	ch.recvCounter = parseSequenceCounter(filepath) + 1
-	//TODO handle buffered channel queue overflow
-	ch.onMessageChan <- string(buf)
+	ch.recvCounter = counter + 1
+	//route the control messages here, so that the consumers do not need to filter them out
+	if env.Control != nil {
+		if err = validateControl(*env.Control, ch.options.ControlValidation); err != nil {
+			log.Errorf("dropping control message %v of type %q: %v", filepath, env.Control.Type, err)
+			ch.audit(filepath, env.SentAt, AuditDropped)
+			return
+		}
+		ch.audit(filepath, env.SentAt, AuditDelivered)
+		if env.Control.Type == controlAck {
+			ch.resolveAck(env.Control.Content)
+			return
+		}
+		if env.Control.Type == ControlEndOfStream {
+			ch.endStream(filepath)
+			if env.AckID != "" {
+				ch.acknowledge(env.AckID)
+			}
+			return
+		}
+		if env.Control.Type == controlHello {
+			select {
+			case ch.helloChan <- *env.Control:
+			default:
+				log.Errorf("dropping repeated handshake %v", filepath)
+			}
+			return
+		}
+		//the consumer acknowledges the control message once it's handled, see Acknowledge()
+		control := *env.Control
+		control.AckID = env.AckID
+		//TODO handle buffered channel queue overflow
+		ch.controlChan <- control
+		return
+	}
+	if ch.StreamEnded() {
+		log.Errorf("dropping message %v received after the end of stream", filepath)
+		ch.audit(filepath, env.SentAt, AuditDropped)
+		return
+	}
+	ch.recvSizes.record(len(msg))
+	if ch.options.DeliverMetadata {
+		message := Message{Payload: msg}
+		if info != nil {
+			message.ModTime = info.ModTime()
+			message.Size = info.Size()
+		}
+		//TODO handle buffered channel queue overflow
+		ch.messageChan <- message
+	} else {
+		ch.deliver(msg)
+	}
+	ch.audit(filepath, env.SentAt, AuditDelivered)
+	if env.AckID != "" {
+		ch.acknowledge(env.AckID)
+	}
+}
+
+// remove a consumed file, if the removal fails (e.g. the file system turned read-only) remember the file
+// so that it is not delivered again, since the receiving counter moves on regardless
+// return false if the file is already gone, i.e. taken by another consumer of the directory, e.g. during a reattach
+func (ch *fileWatcherChannel) removeConsumed(filepath string) bool {
+	err := ch.fs().Remove(filepath)
+	if err == nil {
+		return true
+	}
+	if os.IsNotExist(err) {
+		return false
+	}
+	ch.logger.Errorf("failed to remove consumed message %v, it will not be delivered again: %v: %v", filepath, ErrNotWritable, err)
+	if ch.undeletable == nil {
+		ch.undeletable = make(map[string]bool)
+	}
+	ch.undeletable[path.Base(filepath)] = true
+	return true
+}
+
+// if the receiving counter is as expected, consume the created message
+// otherwise, read the directory in sorted order, sender assures sending order
+func (ch *fileWatcherChannel) onCreate(filepath string) {
+	ch.consumeMu.Lock()
+	defer ch.consumeMu.Unlock()
+	//a malformed sequence id is dead-lettered by the poll
+	if ch.consumedLately(filepath) {
+		ch.logger.Debugf("message %v was consumed lately, ignoring the event", filepath)
+		return
+	}
+	counter, err := parseSequenceCounter(filepath)
+	if err == nil && counter == ch.recvCounter {
+		if !ch.tryConsume(filepath) {
+			return
+		}
+		//the message may fill the gap in front of the stragglers left by the last window scan
+		if ch.windowSkipped {
+			ch.consumeWindowLocked()
+		}
+		return
+	}
+	//a late repeated event of a message consumed already does not need a poll, unlike a late message still on disk
+	if err == nil && counter < ch.recvCounter {
+		if _, statErr := ch.fs().Stat(filepath); os.IsNotExist(statErr) {
+			ch.logger.Debugf("message %v is already consumed, ignoring the event", filepath)
+			return
+		}
+	}
+	ch.logger.Debug("received out-of-order file update, polling the dir to reorder")
+	ch.consumeWindowLocked()
+}
+
+// recover a panic of a go-routine of the channel and close the channel, so that the failure does not take down the process
+// along with the other channels; it must be deferred before any lock, so that the locks are released before closing
+func (ch *fileWatcherChannel) recoverPanic() {
+	if msg := recover(); msg != nil {
+		ch.logger.Errorf("channel %v panics, closing it: %v: %s", ch.path, msg, debug.Stack())
+		ch.Close()
+	}
+}
+
+// read the left over messages at close time, a message failing again does not prevent the channel from closing
+func (ch *fileWatcherChannel) drain() {
+	defer func() {
+		if msg := recover(); msg != nil {
+			ch.logger.Errorf("draining channel %v panics, the left over messages are not delivered: %v", ch.path, msg)
+		}
+	}()
+	ch.consumeAll()
+}
+
+// start the watch go-routine of the given watcher, it's accounted for before it runs so that the teardown waits for it
+func (ch *fileWatcherChannel) startWatch(watcher eventSource) {
+	atomic.AddInt32(&ch.watching, 1)
+	ch.spawn(func() { ch.watch(watcher) })
 }
 
 // we need to launch watcher receiver in another go routine, putting watcher.Close() and the receiver in same go routine can
 // end up dead lock
 // make sure this go routine not leaking
-func (ch *fileWatcherChannel) watch() {
+func (ch *fileWatcherChannel) watch(watcher eventSource) {
+	atomic.AddInt32(&activeWatchRoutines, 1)
+	defer atomic.AddInt32(&activeWatchRoutines, -1)
+	//accounted for by startWatch()
+	defer atomic.AddInt32(&ch.watching, -1)
+	//a panic closes the channel before the supervisor looks into it
+	defer ch.superviseWatch(watcher)
+	defer ch.recoverPanic()
 	log := ch.logger
 	log.Debugf("%v listener started on path: %v", ch.mode, ch.path)
 	//drain all the current messages in the dir
 	ch.consumeAll()
 	for {
 		select {
-		case event, ok := <-ch.watcher.Events:
+		case event, ok := <-watcher.Events():
 			if !ok {
 				log.Debug("fileWatcher already closed")
 				return
 			}
 			log.Debug("received event: ", event.String())
-			if event.Op&fsnotify.Create == fsnotify.Create && ch.isReadable(event.Name) {
-				//if the receiving counter is as expected, consume that message
-				//otherwise, read the entire directory in sorted order, sender assures sending order
-				if parseSequenceCounter(event.Name) == ch.recvCounter {
-					ch.consume(event.Name)
-				} else {
-					log.Debug("received out-of-order file update, polling the dir to reorder")
-					ch.consumeAll()
+			//channel is closed and drained, the onMessageChan may already be closed if the watcher teardown timed out
+			if ch.isClosed() {
+				log.Debug("channel already closed, stop watching")
+				return
+			}
+			//match the name only, the directory may well be under a tmp or tmpfs mount, see Options.TmpfsRoot
+			if event.Op&fsnotify.Create == fsnotify.Create && ch.isReadable(path.Base(event.Name)) {
+				if ch.debounced(event.Name) {
+					log.Debugf("collapsing repeated event of %v", event.Name)
+					continue
 				}
+				ch.onCreate(event.Name)
 			}
-		case err := <-ch.watcher.Errors:
+		case err := <-watcher.Errors():
 			if err != nil {
 				log.Errorf("file watcher error: %v", err)
 			}
@@ -98,8 +98,9 @@ func TestChannelReopen(t *testing.T) {
 func verifyReceive(t *testing.T, ch Channel, messages []string, name string, done chan bool) {
 
 	//timer := time.After(5 * time.Second)
+	received := ch.GetMessage()
 	for _, testMsg := range messages {
-		msg := <-ch.GetMessage()
+		msg := <-received
 		logger.Infof("%v received message: %v", name, msg)
 		assert.Equal(t, testMsg, msg)
 
@@ -0,0 +1,767 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+//Package channel defines and implements the communication interface between agent and command runner process
+package channel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+//create a channel object without the file watcher, so consume() can be driven directly
+func newTestChannel(t testing.TB, mode Mode, options Options) *fileWatcherChannel {
+	dir, err := ioutil.TempDir("", "filechannel")
+	assert.NoError(t, err)
+	return newTestChannelAt(t, mode, dir, options)
+}
+
+//create a channel object on the given directory, without the file watcher
+func newTestChannelAt(t testing.TB, mode Mode, name string, options Options) *fileWatcherChannel {
+	ch := newChannel(log.NewMockLog(), mode, name, options)
+	assert.NoError(t, os.MkdirAll(ch.tmpPath, defaultFileCreateMode))
+	ch.startTime = "20170101000000"
+	return ch
+}
+
+func TestConsumeWithReadBuffer(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{ReadBufferSize: 16})
+	defer os.RemoveAll(ch.path)
+	messages := []string{"small", strings.Repeat("x", 16), strings.Repeat("y", 100), ""}
+	for i, msg := range messages {
+		filepath := path.Join(ch.path, fmt.Sprintf("worker-20170101000000-%03d", i))
+		assert.NoError(t, ioutil.WriteFile(filepath, []byte(msg), defaultFileWriteMode))
+		ch.consume(filepath)
+		assert.Equal(t, msg, <-ch.onMessageChan)
+		_, err := os.Stat(filepath)
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+func benchmarkConsume(b *testing.B, options Options) {
+	ch := newTestChannel(b, ModeMaster, options)
+	defer os.RemoveAll(ch.path)
+	content := []byte(strings.Repeat("m", 512))
+	filepath := path.Join(ch.path, "worker-20170101000000-000")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		ioutil.WriteFile(filepath, content, defaultFileWriteMode)
+		b.StartTimer()
+		ch.consume(filepath)
+		<-ch.onMessageChan
+	}
+}
+
+func BenchmarkConsumeNoReadBuffer(b *testing.B) {
+	benchmarkConsume(b, Options{})
+}
+
+func BenchmarkConsumeWithReadBuffer(b *testing.B) {
+	benchmarkConsume(b, Options{ReadBufferSize: 4096})
+}
+
+func TestWaitForMessage(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(ch.path)
+	_, err := ch.WaitForMessage(10 * time.Millisecond)
+	assert.Equal(t, ErrMessageTimeout, err)
+	ch.onMessageChan <- "hello"
+	msg, err := ch.WaitForMessage(time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", msg)
+	close(ch.onMessageChan)
+	_, err = ch.WaitForMessage(time.Second)
+	assert.Equal(t, ErrChannelClosed, err)
+}
+
+func TestStatsSizeHistogram(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{SizeBuckets: []int{100, 10}})
+	defer os.RemoveAll(ch.path)
+	for _, size := range []int{0, 10, 11, 100, 101, 5000} {
+		assert.NoError(t, ch.Send(strings.Repeat("s", size)))
+	}
+	filepath := path.Join(ch.path, "worker-20170101000000-000")
+	assert.NoError(t, ioutil.WriteFile(filepath, []byte("received"), defaultFileWriteMode))
+	ch.consume(filepath)
+	<-ch.onMessageChan
+
+	stats := ch.Stats()
+	assert.Equal(t, []int{10, 100}, stats.SentSizes.Bounds)
+	assert.Equal(t, []uint64{2, 2, 2}, stats.SentSizes.Counts)
+	assert.Equal(t, []uint64{1, 0, 0}, stats.ReceivedSizes.Counts)
+}
+
+func TestCloseWatcherTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "close")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	blocked := make(chan bool)
+	defer close(blocked)
+	original := closeWatcher
+	defer func() { closeWatcher = original }()
+	//the teardown completes once the test ends, so that the watcher is not leaked
+	closeWatcher = func(watcher eventSource, path string) {
+		<-blocked
+		original(watcher, path)
+	}
+	ch, err := NewFileWatcherChannelWithOptions(log.NewMockLog(), ModeMaster, path.Join(dir, "channel"), Options{CloseTimeout: 50 * time.Millisecond})
+	assert.NoError(t, err)
+	ch.Close()
+	select {
+	case _, more := <-ch.GetMessage():
+		assert.False(t, more)
+	case <-time.After(time.Second):
+		t.Fatal("onMessageChan is not closed after the close timeout")
+	}
+}
+
+//the teardown may fire from several paths of the executer
+func TestDestroyRepeated(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "destroy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, options := range []Options{{}, {LazyRead: true}} {
+		name := path.Join(dir, "channel")
+		ch, err := NewFileWatcherChannelWithOptions(log.NewMockLog(), ModeMaster, name, options)
+		assert.NoError(t, err)
+		messages := ch.GetMessage()
+		ch.Close()
+		ch.Destroy()
+		_, err = os.Stat(name)
+		assert.True(t, os.IsNotExist(err))
+		//a directory of the same name created since is not the channel's to remove anymore
+		assert.NoError(t, os.MkdirAll(name, defaultFileCreateMode))
+		ch.Destroy()
+		ch.Close()
+		_, err = os.Stat(name)
+		assert.NoError(t, err)
+		assert.NoError(t, os.RemoveAll(name))
+		select {
+		case _, more := <-messages:
+			assert.False(t, more)
+		case <-time.After(5 * time.Second):
+			t.Fatal("onMessageChan is not closed")
+		}
+		assert.Equal(t, ErrChannelClosed, ch.Send("late"))
+	}
+}
+
+func TestSendLatencyRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "latency")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "channel")
+	options := Options{TrackLatency: true}
+	master, err := NewFileWatcherChannelWithOptions(log.NewMockLog(), ModeMaster, name, options)
+	assert.NoError(t, err)
+	worker, err := NewFileWatcherChannelWithOptions(log.NewMockLog(), ModeWorker, name, options)
+	assert.NoError(t, err)
+
+	assert.NoError(t, master.Send("request"))
+	msg, err := worker.WaitForMessage(5 * time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "request", msg)
+	assert.NoError(t, worker.Send("reply"))
+	msg, err = master.WaitForMessage(5 * time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "reply", msg)
+
+	for _, ch := range []*fileWatcherChannel{master, worker} {
+		latency := ch.Stats().SendLatency
+		assert.Equal(t, uint64(1), latency.Count)
+		assert.True(t, latency.P50 > 0)
+		assert.True(t, latency.P95 >= latency.P50)
+	}
+	worker.Close()
+	master.Destroy()
+}
+
+func TestDecodeLegacyEnvelope(t *testing.T) {
+	legacy := `{"version":"1.0","type":"reply","content":""}`
+	env, err := decodeEnvelope(legacy)
+	assert.NoError(t, err)
+	assert.Equal(t, envelope{Payload: legacy}, env)
+	content, err := encodeEnvelope(envelope{SentAt: 10, Payload: legacy})
+	assert.NoError(t, err)
+	env, err = decodeEnvelope(content)
+	assert.NoError(t, err)
+	assert.Equal(t, envelope{Version: envelopeVersion, Minor: envelopeMinorVersion, SentAt: 10, Payload: legacy}, env)
+}
+
+//drop a message the way Send() does, so that the watcher never sees a partially written file
+func dropMessage(t testing.TB, dir string, name string, content string) {
+	tmpFile := path.Join(dir, "tmp", name)
+	assert.NoError(t, ioutil.WriteFile(tmpFile, []byte(content), defaultFileWriteMode))
+	assert.NoError(t, os.Rename(tmpFile, path.Join(dir, name)))
+}
+
+func TestReset(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "reset")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "channel")
+	ch, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, name)
+	assert.NoError(t, err)
+	//simulate a wedged channel, the receiving counter drifted so the new messages are not consumed on arrival
+	ch.consumeMu.Lock()
+	ch.recvCounter = 57
+	ch.consumeMu.Unlock()
+	assert.NoError(t, ch.Reset())
+	for i, msg := range []string{"m0", "m1", "m2"} {
+		dropMessage(t, name, fmt.Sprintf("worker-20170101000000-%03d", i), msg)
+	}
+	for _, expected := range []string{"m0", "m1", "m2"} {
+		msg, err := ch.WaitForMessage(5 * time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, msg)
+	}
+	ch.Close()
+	assert.Equal(t, ErrChannelClosed, ch.Reset())
+}
+
+func TestResetPreservesPendingMessages(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "reset")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "channel")
+	//stuck watcher: the watcher exists but nobody listens on it, so the messages land on disk but are never picked up
+	ch := newTestChannelAt(t, ModeMaster, name, Options{})
+	ch.watcher, err = newWatcher(ch.logger, name, WatchBackendAuto)
+	assert.NoError(t, err)
+	defer ch.Destroy()
+	for i, msg := range []string{"m5", "m6"} {
+		dropMessage(t, name, fmt.Sprintf("worker-20170101000000-%03d", i+5), msg)
+	}
+	_, err = ch.WaitForMessage(100 * time.Millisecond)
+	assert.Equal(t, ErrMessageTimeout, err)
+	assert.NoError(t, ch.Reset())
+	for _, expected := range []string{"m5", "m6"} {
+		msg, err := ch.WaitForMessage(5 * time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, msg)
+	}
+	ch.consumeMu.Lock()
+	assert.Equal(t, 7, ch.recvCounter)
+	ch.consumeMu.Unlock()
+}
+
+func TestMoveChannel(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "move")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "channel")
+	newName := path.Join(dir, "moved")
+	//the messages are pending on disk, nobody listens on the watcher
+	ch := newTestChannelAt(t, ModeMaster, name, Options{})
+	ch.watcher, err = newWatcher(ch.logger, name, WatchBackendAuto)
+	assert.NoError(t, err)
+	defer ch.Destroy()
+	for i, msg := range []string{"m0", "m1"} {
+		dropMessage(t, name, fmt.Sprintf("worker-20170101000000-%03d", i), msg)
+	}
+
+	assert.NoError(t, ch.MoveChannel(newName))
+	for _, expected := range []string{"m0", "m1"} {
+		msg, err := ch.WaitForMessage(5 * time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, msg)
+	}
+	//the peer still sending to the old path is received through the link
+	dropMessage(t, name, "worker-20170101000000-002", "m2")
+	msg, err := ch.WaitForMessage(5 * time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "m2", msg)
+	assert.NoError(t, ch.Send("sent"))
+	files, err := ioutil.ReadDir(newName)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+	assert.Equal(t, "master-20170101000000-000", files[0].Name())
+
+	//the destination must not exist and must be on the same file system
+	assert.Error(t, ch.MoveChannel(newName))
+	assert.Equal(t, ErrCrossFilesystem, ch.MoveChannel("/dev/channel"))
+	_, err = os.Stat(newName)
+	assert.NoError(t, err)
+
+	ch.Destroy()
+	_, err = os.Lstat(name)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, ErrChannelClosed, ch.MoveChannel(path.Join(dir, "closed")))
+}
+
+func TestTimestampIDSchemeAcrossRestart(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "idscheme")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "channel")
+	options := Options{IDScheme: IDSchemeTimestamp}
+	worker, err := NewFileWatcherChannelWithOptions(log.NewMockLog(), ModeWorker, name, options)
+	assert.NoError(t, err)
+	assert.NoError(t, worker.Send("m0"))
+	assert.NoError(t, worker.Send("m1"))
+	//the worker restarts within the same second, before the master picks up the messages
+	worker.Close()
+	worker, err = NewFileWatcherChannelWithOptions(log.NewMockLog(), ModeWorker, name, options)
+	assert.NoError(t, err)
+	assert.NoError(t, worker.Send("m2"))
+	assert.NoError(t, worker.Send("m3"))
+
+	files, err := ioutil.ReadDir(name)
+	assert.NoError(t, err)
+	var ids []string
+	for _, file := range files {
+		if !file.IsDir() {
+			ids = append(ids, file.Name())
+		}
+	}
+	assert.Equal(t, 4, len(ids))
+
+	master, err := NewFileWatcherChannelWithOptions(log.NewMockLog(), ModeMaster, name, options)
+	assert.NoError(t, err)
+	for _, expected := range []string{"m0", "m1", "m2", "m3"} {
+		msg, err := master.WaitForMessage(5 * time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, msg)
+	}
+	worker.Close()
+	master.Destroy()
+}
+
+func TestTimestampIDSchemeTiebreak(t *testing.T) {
+	ch := newTestChannel(t, ModeWorker, Options{IDScheme: IDSchemeTimestamp})
+	defer os.RemoveAll(ch.path)
+	//a stamp in the future simulates the clock stepping back, the ids keep ascending through the tiebreak
+	ch.lastStamp = time.Now().Add(time.Hour).UnixNano()
+	first := ch.nextSequenceID()
+	second := ch.nextSequenceID()
+	assert.True(t, first < second)
+	assert.Equal(t, fmt.Sprintf("worker-%019d-001", ch.lastStamp), first)
+	assert.Equal(t, fmt.Sprintf("worker-%019d-002", ch.lastStamp), second)
+	assert.Equal(t, -1, strings.Index(first, ch.startTime))
+}
+
+func TestControlMessagesRouting(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "control")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "channel")
+	master, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, name)
+	assert.NoError(t, err)
+	worker, err := NewFileWatcherChannel(log.NewMockLog(), ModeWorker, name)
+	assert.NoError(t, err)
+
+	assert.NoError(t, master.SendControl(ControlMessage{Type: ControlCancel}))
+	assert.NoError(t, master.Send("payload"))
+	select {
+	case control := <-worker.ControlMessages():
+		assert.Equal(t, ControlMessage{Type: ControlCancel}, control)
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancel is not delivered to the control stream")
+	}
+	msg, err := worker.WaitForMessage(5 * time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "payload", msg)
+	//neither stream receives the message of the other
+	select {
+	case control := <-worker.ControlMessages():
+		t.Fatalf("unexpected control message: %v", control)
+	default:
+	}
+	_, err = worker.WaitForMessage(100 * time.Millisecond)
+	assert.Equal(t, ErrMessageTimeout, err)
+	assert.Equal(t, uint64(1), sum(worker.Stats().ReceivedSizes.Counts))
+
+	worker.Close()
+	_, more := <-worker.ControlMessages()
+	assert.False(t, more)
+	master.Destroy()
+}
+
+func sum(counts []uint64) (total uint64) {
+	for _, count := range counts {
+		total += count
+	}
+	return
+}
+
+func TestSendRenameRetry(t *testing.T) {
+	defer func(original func(string, string) error) { rename = original }(rename)
+	failures := 0
+	rename = func(from, to string) error {
+		if failures > 0 {
+			failures--
+			return fmt.Errorf("sharing violation")
+		}
+		return os.Rename(from, to)
+	}
+	ch := newTestChannel(t, ModeMaster, Options{RenameRetries: 2, RenameBackoff: time.Millisecond})
+	defer os.RemoveAll(ch.path)
+
+	//transient contention is absorbed by the retry
+	failures = 2
+	assert.NoError(t, ch.Send("retried"))
+	content, err := ioutil.ReadFile(path.Join(ch.path, "master-20170101000000-000"))
+	assert.NoError(t, err)
+	assert.Equal(t, "retried", string(content))
+
+	//the tmp file is cleaned up once the retries are exhausted
+	failures = 3
+	assert.Error(t, ch.Send("failed"))
+	files, err := ioutil.ReadDir(ch.tmpPath)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+
+	//negative disables the retry
+	ch.options.RenameRetries = -1
+	failures = 1
+	assert.Error(t, ch.Send("failed"))
+}
+
+func TestGetMessageWithMetadata(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{DeliverMetadata: true})
+	defer os.RemoveAll(ch.path)
+	filepath := path.Join(ch.path, "worker-20170101000000-000")
+	assert.NoError(t, ioutil.WriteFile(filepath, []byte("payload"), defaultFileWriteMode))
+	modTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+	assert.NoError(t, os.Chtimes(filepath, modTime, modTime))
+	ch.consume(filepath)
+	msg := <-ch.GetMessageWithMetadata()
+	assert.Equal(t, "payload", msg.Payload)
+	assert.Equal(t, int64(len("payload")), msg.Size)
+	assert.True(t, modTime.Equal(msg.ModTime))
+	//the plain go channel is not fed
+	_, err := ch.WaitForMessage(10 * time.Millisecond)
+	assert.Equal(t, ErrMessageTimeout, err)
+}
+
+func TestConsumeRemoveFailure(t *testing.T) {
+	defer func(original func(string) error) { removeFile = original }(removeFile)
+	removeFile = func(name string) error {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.EROFS}
+	}
+	dir, err := ioutil.TempDir(".", "readonly")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ch := newTestChannelAt(t, ModeMaster, dir, Options{})
+	dropMessage(t, dir, "worker-20170101000000-000", "m0")
+	//the file stays on disk, but is delivered only once no matter how many times the directory is polled
+	for i := 0; i < 3; i++ {
+		ch.consumeAll()
+	}
+	msg, err := ch.WaitForMessage(time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "m0", msg)
+	_, err = ch.WaitForMessage(10 * time.Millisecond)
+	assert.Equal(t, ErrMessageTimeout, err)
+	_, err = os.Stat(path.Join(dir, "worker-20170101000000-000"))
+	assert.NoError(t, err)
+}
+
+//another process consuming the same directory during a handover takes the message between the read and the removal
+func TestConsumeFileTakenByAnotherConsumer(t *testing.T) {
+	defer func(original func(string) error) { removeFile = original }(removeFile)
+	removeFile = func(name string) error {
+		os.Remove(name)
+		return os.Remove(name)
+	}
+	ch := newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(ch.path)
+	dropMessage(t, ch.path, "worker-20170101000000-000", "m0")
+	ch.consumeAll()
+	//the other process delivered it, the message is neither delivered again nor remembered as undeletable
+	_, err := ch.WaitForMessage(10 * time.Millisecond)
+	assert.Equal(t, ErrMessageTimeout, err)
+	assert.Empty(t, ch.undeletable)
+	assert.Equal(t, 1, ch.recvCounter)
+
+	//the next message is delivered as usual
+	removeFile = os.Remove
+	dropMessage(t, ch.path, "worker-20170101000000-001", "m1")
+	ch.onCreate(path.Join(ch.path, "worker-20170101000000-001"))
+	msg, err := ch.WaitForMessage(time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "m1", msg)
+}
+
+func TestSendNotWritable(t *testing.T) {
+	defer func(original func(string, string) error) { rename = original }(rename)
+	rename = func(from, to string) error {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: syscall.EROFS}
+	}
+	ch := newTestChannel(t, ModeMaster, Options{RenameRetries: -1})
+	defer os.RemoveAll(ch.path)
+	assert.Equal(t, ErrNotWritable, ch.Send("message"))
+}
+
+func TestControlValidation(t *testing.T) {
+	unknown := ControlMessage{Type: "reboot"}
+	malformed := ControlMessage{Content: "no type"}
+	testCases := []struct {
+		validation ControlValidation
+		delivered  []ControlMessage
+	}{
+		{ControlValidationNone, []ControlMessage{unknown, malformed, {Type: ControlCancel}}},
+		{ControlValidationIgnoreUnknown, []ControlMessage{unknown, {Type: ControlCancel}}},
+		{ControlValidationReject, []ControlMessage{{Type: ControlCancel}}},
+	}
+	for _, testCase := range testCases {
+		sender := newTestChannel(t, ModeWorker, Options{})
+		assert.NoError(t, os.MkdirAll(sender.tmpPath, defaultFileCreateMode))
+		receiver := newTestChannel(t, ModeMaster, Options{ControlValidation: testCase.validation})
+		for i, msg := range []ControlMessage{unknown, malformed, {Type: ControlCancel}} {
+			assert.NoError(t, sender.SendControl(msg))
+			receiver.consume(path.Join(sender.path, fmt.Sprintf("worker-20170101000000-%03d", i)))
+		}
+		close(receiver.controlChan)
+		var delivered []ControlMessage
+		for msg := range receiver.controlChan {
+			delivered = append(delivered, msg)
+		}
+		assert.Equal(t, testCase.delivered, delivered, "validation %q", testCase.validation)
+		os.RemoveAll(sender.path)
+		os.RemoveAll(receiver.path)
+	}
+}
+
+func TestMalformedSequenceID(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "malformed")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "channel")
+	ch, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, name)
+	assert.NoError(t, err)
+	defer ch.Destroy()
+	malformed := []string{"worker-20170101000000-001x", "worker-20170101000000-99999999999999999999"}
+	for _, id := range malformed {
+		dropMessage(t, name, id, "malformed")
+	}
+	dropMessage(t, name, "worker-20170101000000-000", "m0")
+	dropMessage(t, name, "worker-20170101000000-001", "m1")
+	for _, expected := range []string{"m0", "m1"} {
+		msg, err := ch.WaitForMessage(5 * time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, msg)
+	}
+	_, err = ch.WaitForMessage(100 * time.Millisecond)
+	assert.Equal(t, ErrMessageTimeout, err)
+	ch.consumeMu.Lock()
+	assert.Equal(t, 2, ch.recvCounter)
+	ch.consumeMu.Unlock()
+	//the malformed messages are moved aside instead of being retried
+	for _, id := range malformed {
+		_, err = os.Stat(path.Join(name, id))
+		assert.True(t, os.IsNotExist(err))
+		_, err = os.Stat(path.Join(name, "tmp", deadLetterPrefix+id))
+		assert.NoError(t, err)
+	}
+}
+
+func TestParseSequenceCounter(t *testing.T) {
+	counter, err := parseSequenceCounter("channel/worker-20170101000000-012")
+	assert.NoError(t, err)
+	assert.Equal(t, 12, counter)
+	for _, name := range []string{"worker-20170101000000-", "worker-20170101000000-1x", "worker-20170101000000-99999999999999999999"} {
+		_, err = parseSequenceCounter(name)
+		assert.Error(t, err)
+	}
+}
+
+func TestSendValidateJSON(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{ValidateJSON: true})
+	defer os.RemoveAll(ch.path)
+	for _, malformed := range []string{"", "{", `{"key": }`, "not json", `{"key": "value"} trailing`} {
+		assert.Equal(t, ErrInvalidJSON, ch.Send(malformed), malformed)
+	}
+	//nothing is written and no sequence id is consumed
+	files, err := ioutil.ReadDir(ch.path)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.Equal(t, 0, ch.counter)
+	for _, valid := range []string{`{"key": "value"}`, "[1, 2]", `"string"`, "null"} {
+		assert.NoError(t, ch.Send(valid))
+	}
+	assert.Equal(t, 4, ch.counter)
+	//the control messages are not payloads
+	assert.NoError(t, ch.SendControl(ControlMessage{Type: ControlCancel}))
+
+	//the validation is opt-in
+	unchecked := newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(unchecked.path)
+	assert.NoError(t, os.MkdirAll(unchecked.tmpPath, defaultFileCreateMode))
+	assert.NoError(t, unchecked.Send("not json"))
+}
+
+func TestPanicIsolatedToChannel(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "isolation")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(original func(string) error) { removeFile = original }(removeFile)
+	removeFile = func(name string) error {
+		if strings.Contains(name, "faulty") {
+			panic("injected failure")
+		}
+		return os.Remove(name)
+	}
+	open := func(name string) (master, worker *fileWatcherChannel) {
+		master, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, path.Join(dir, name))
+		assert.NoError(t, err)
+		worker, err = NewFileWatcherChannel(log.NewMockLog(), ModeWorker, path.Join(dir, name))
+		assert.NoError(t, err)
+		return master, worker
+	}
+	faultyMaster, faultyWorker := open("faulty")
+	defer faultyMaster.Destroy()
+	defer faultyWorker.Close()
+	healthyMaster, healthyWorker := open("healthy")
+	defer healthyMaster.Destroy()
+	defer healthyWorker.Close()
+
+	//the consume panics, only the faulty channel is closed
+	assert.NoError(t, faultyWorker.Send("m0"))
+	_, err = faultyMaster.WaitForMessage(5 * time.Second)
+	assert.Equal(t, ErrChannelClosed, err)
+	assert.Equal(t, ErrChannelClosed, faultyMaster.Send("closed"))
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, healthyWorker.Send(fmt.Sprintf("m%v", i)))
+		msg, err := healthyMaster.WaitForMessage(5 * time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("m%v", i), msg)
+	}
+}
+
+func TestGetMessageSingleConsumer(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(ch.path)
+	messages := ch.GetMessage()
+	assert.NotNil(t, messages)
+	assert.Panics(t, func() { ch.GetMessage() })
+	assert.Panics(t, func() { ch.GetMessageShared() })
+	defer func() {
+		assert.Equal(t, ErrMultipleConsumers, recover())
+	}()
+	ch.GetMessage()
+}
+
+func TestGetMessageShared(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(ch.path)
+	first := ch.GetMessageShared()
+	assert.Equal(t, first, ch.GetMessageShared())
+	//the single consumer contract cannot be claimed once the messages are shared
+	assert.Panics(t, func() { ch.GetMessage() })
+}
+
+//the messages pulled with WaitForMessage() would be split with the consumer of GetMessage()
+func TestWaitForMessageSingleConsumer(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(ch.path)
+	ch.onMessageChan <- "first"
+	ch.onMessageChan <- "second"
+	msg, err := ch.WaitForMessage(time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", msg)
+	//the pulling consumer keeps pulling
+	msg, err = ch.WaitForMessage(time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", msg)
+	assert.Panics(t, func() { ch.GetMessage() })
+	assert.Panics(t, func() { ch.GetMessageShared() })
+
+	ch = newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(ch.path)
+	ch.GetMessage()
+	defer func() {
+		assert.Equal(t, ErrMultipleConsumers, recover())
+	}()
+	ch.WaitForMessage(time.Second)
+}
+
+//the messages sent before the consumer attaches wait in the buffer and on disk, none is dropped however late it attaches
+func TestLateConsumerLosesNothing(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "late")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "channel")
+	master, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, name)
+	assert.NoError(t, err)
+	defer master.Destroy()
+	worker, err := NewFileWatcherChannel(log.NewMockLog(), ModeWorker, name)
+	assert.NoError(t, err)
+	defer worker.Close()
+	//more messages than the go channel buffers
+	count := defaultChannelBufferSize + 20
+	for i := 0; i < count; i++ {
+		assert.NoError(t, worker.Send(fmt.Sprintf("m%v", i)))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	messages := master.GetMessage()
+	for i := 0; i < count; i++ {
+		select {
+		case msg := <-messages:
+			assert.Equal(t, fmt.Sprintf("m%v", i), msg)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message m%v is lost", i)
+		}
+	}
+}
+
+//the concurrent senders write in parallel but never share a sequence id nor leave a hole, run it with -race
+func TestConcurrentSend(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(ch.path)
+	const senders, messages = 8, 25
+	done := make(chan bool)
+	for i := 0; i < senders; i++ {
+		go func(i int) {
+			defer func() { done <- true }()
+			for j := 0; j < messages; j++ {
+				assert.NoError(t, ch.Send(fmt.Sprintf("s%v-m%v", i, j)))
+			}
+		}(i)
+	}
+	for i := 0; i < senders; i++ {
+		<-done
+	}
+
+	ch.sendMu.Lock()
+	assert.Equal(t, senders*messages, ch.counter)
+	ch.sendMu.Unlock()
+	files, err := ioutil.ReadDir(ch.path)
+	assert.NoError(t, err)
+	var names []string
+	for _, file := range files {
+		if !file.IsDir() {
+			names = append(names, file.Name())
+		}
+	}
+	assert.Len(t, names, senders*messages)
+	for i, name := range names {
+		assert.Equal(t, fmt.Sprintf("master-20170101000000-%03d", i), name)
+	}
+	tmpFiles, err := ioutil.ReadDir(ch.tmpPath)
+	assert.NoError(t, err)
+	assert.Empty(t, tmpFiles)
+}
@@ -0,0 +1,77 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync/atomic"
+)
+
+//FileSystem is the store the messages are written, renamed, read and removed through, see Options.FileSystem
+//the channel directories, the locks, the dead letters and the streams handed to GetStream() always use the os directly
+type FileSystem interface {
+	//Create creates or truncates the named file for writing
+	Create(name string) (io.WriteCloser, error)
+	Rename(from, to string) error
+	ReadFile(name string) ([]byte, error)
+	Remove(name string) error
+	//ReadDir lists the directory sorted by name
+	ReadDir(dir string) ([]os.FileInfo, error)
+	Stat(name string) (os.FileInfo, error)
+}
+
+//the default FileSystem, it goes through the rename and removeFile hooks of the tests
+type osFileSystem struct{}
+
+func (osFileSystem) Create(name string) (io.WriteCloser, error) {
+	return os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, defaultFileWriteMode)
+}
+
+func (osFileSystem) Rename(from, to string) error {
+	return rename(from, to)
+}
+
+func (osFileSystem) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(name)
+}
+
+func (osFileSystem) Remove(name string) error {
+	return removeFile(name)
+}
+
+func (osFileSystem) ReadDir(dir string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(dir)
+}
+
+func (osFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (ch *fileWatcherChannel) fs() FileSystem {
+	if ch.options.FileSystem != nil {
+		return ch.options.FileSystem
+	}
+	return osFileSystem{}
+}
+
+//distinguishes the messages being written concurrently by this process
+var tmpFileCounter int64
+
+//the name of a message being written in the tmp directory, unique among the processes sharing the channel
+func (ch *fileWatcherChannel) tmpFileName() string {
+	return sendingFilePrefix + string(ch.mode) + "-" + strconv.Itoa(os.Getpid()) + "-" + strconv.FormatInt(atomic.AddInt64(&tmpFileCounter, 1), 10)
+}
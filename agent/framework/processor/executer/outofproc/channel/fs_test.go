@@ -0,0 +1,246 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//fakeFileSystem keeps the files in memory, the next calls of an operation fail with the errors queued for it
+type fakeFileSystem struct {
+	mu       sync.Mutex
+	files    map[string][]byte
+	failures map[string][]error
+}
+
+func newFakeFileSystem() *fakeFileSystem {
+	return &fakeFileSystem{files: make(map[string][]byte), failures: make(map[string][]error)}
+}
+
+func (f *fakeFileSystem) failNext(op string, errs ...error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[op] = append(f.failures[op], errs...)
+}
+
+//the caller must hold mu
+func (f *fakeFileSystem) failure(op string) error {
+	if len(f.failures[op]) == 0 {
+		return nil
+	}
+	err := f.failures[op][0]
+	f.failures[op] = f.failures[op][1:]
+	return err
+}
+
+func (f *fakeFileSystem) names() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	for name := range f.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type fakeFile struct {
+	fs   *fakeFileSystem
+	name string
+	buf  bytes.Buffer
+}
+
+func (w *fakeFile) Write(p []byte) (int, error) {
+	w.fs.mu.Lock()
+	defer w.fs.mu.Unlock()
+	if err := w.fs.failure("Write"); err != nil {
+		return 0, &os.PathError{Op: "write", Path: w.name, Err: err}
+	}
+	return w.buf.Write(p)
+}
+
+func (w *fakeFile) Close() error {
+	w.fs.mu.Lock()
+	defer w.fs.mu.Unlock()
+	w.fs.files[w.name] = w.buf.Bytes()
+	return nil
+}
+
+func (f *fakeFileSystem) Create(name string) (io.WriteCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failure("Create"); err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	f.files[name] = nil
+	return &fakeFile{fs: f, name: name}, nil
+}
+
+func (f *fakeFileSystem) Rename(from, to string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	content, ok := f.files[from]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: syscall.ENOENT}
+	}
+	if err := f.failure("Rename"); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+	delete(f.files, from)
+	f.files[to] = content
+	return nil
+}
+
+func (f *fakeFileSystem) ReadFile(name string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failure("ReadFile"); err != nil {
+		return nil, &os.PathError{Op: "read", Path: name, Err: err}
+	}
+	content, ok := f.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return content, nil
+}
+
+func (f *fakeFileSystem) Remove(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failure("Remove"); err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	if _, ok := f.files[name]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	delete(f.files, name)
+	return nil
+}
+
+func (f *fakeFileSystem) ReadDir(dir string) ([]os.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var infos []os.FileInfo
+	for name, content := range f.files {
+		if path.Dir(name) == dir {
+			infos = append(infos, fakeFileInfo{name: path.Base(name), size: int64(len(content))})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+func (f *fakeFileSystem) Stat(name string) (os.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	content, ok := f.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return fakeFileInfo{name: path.Base(name), size: int64(len(content))}, nil
+}
+
+type fakeFileInfo struct {
+	name string
+	size int64
+}
+
+func (i fakeFileInfo) Name() string       { return i.name }
+func (i fakeFileInfo) Size() int64        { return i.size }
+func (i fakeFileInfo) Mode() os.FileMode  { return defaultFileWriteMode }
+func (i fakeFileInfo) ModTime() time.Time { return time.Time{} }
+func (i fakeFileInfo) IsDir() bool        { return false }
+func (i fakeFileInfo) Sys() interface{}   { return nil }
+
+//create a channel backed by a fake file system, nothing is written to the disk
+func newFakeChannel(t *testing.T, mode Mode) (*fileWatcherChannel, *fakeFileSystem) {
+	fs := newFakeFileSystem()
+	ch := newTestChannel(t, mode, Options{FileSystem: fs, RenameRetries: -1})
+	os.RemoveAll(ch.path)
+	return ch, fs
+}
+
+func TestSendDiskFull(t *testing.T) {
+	ch, fs := newFakeChannel(t, ModeMaster)
+	fs.failNext("Write", syscall.ENOSPC)
+	err := ch.Send("m0")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), syscall.ENOSPC.Error())
+	assert.Equal(t, 0, ch.counter)
+	//the partial tmp file is removed
+	assert.Empty(t, fs.names())
+
+	assert.NoError(t, ch.Send("m0"))
+	assert.Equal(t, []string{path.Join(ch.path, "master-20170101000000-000")}, fs.names())
+}
+
+func TestSendRenameFailure(t *testing.T) {
+	ch, fs := newFakeChannel(t, ModeMaster)
+	fs.failNext("Rename", syscall.EROFS)
+	assert.Equal(t, ErrNotWritable, ch.Send("m0"))
+	assert.Equal(t, 0, ch.counter)
+	assert.Empty(t, fs.names())
+
+	//the sequence id of the failed message is not burnt
+	assert.NoError(t, ch.Send("m0"))
+	assert.Equal(t, []string{path.Join(ch.path, "master-20170101000000-000")}, fs.names())
+}
+
+func TestConsumeReadFailure(t *testing.T) {
+	ch, fs := newFakeChannel(t, ModeMaster)
+	filepath := path.Join(ch.path, "worker-20170101000000-000")
+	fs.files[filepath] = []byte("m0")
+	//a transient failure is retried right away
+	fs.failNext("ReadFile", syscall.EBUSY)
+	ch.consumeAll()
+	assert.Equal(t, "m0", <-ch.onMessageChan)
+	assert.Empty(t, fs.names())
+
+	//a persistent one leaves the message on disk for the next poll
+	fs.files[path.Join(ch.path, "worker-20170101000000-001")] = []byte("m1")
+	for i := 0; i < consumeAttemptCount; i++ {
+		fs.failNext("ReadFile", syscall.EIO)
+	}
+	ch.consumeAll()
+	assert.Empty(t, ch.onMessageChan)
+	assert.Len(t, fs.names(), 1)
+	ch.consumeAll()
+	assert.Equal(t, "m1", <-ch.onMessageChan)
+	assert.Equal(t, 2, ch.recvCounter)
+}
+
+func TestConsumeRemoveFailureFake(t *testing.T) {
+	ch, fs := newFakeChannel(t, ModeMaster)
+	fs.files[path.Join(ch.path, "worker-20170101000000-000")] = []byte("m0")
+	fs.failNext("Remove", syscall.EROFS)
+	ch.consumeAll()
+	assert.Equal(t, "m0", <-ch.onMessageChan)
+	assert.Len(t, fs.names(), 1)
+
+	//the message left on disk is never delivered twice
+	ch.consumeAll()
+	assert.Empty(t, ch.onMessageChan)
+	assert.True(t, strings.HasSuffix(fs.names()[0], "worker-20170101000000-000"))
+}
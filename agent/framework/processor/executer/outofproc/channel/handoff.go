@@ -0,0 +1,58 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"os"
+	"path"
+)
+
+//injected by the tests to simulate a file system without hard links
+var link = os.Link
+
+//GetFile receives the paths of the messages handed over as files, see Options.HandoffDir
+//the consumer owns the files and removes them once done, unlike the readers of GetStream() they outlive the channel
+func (ch *fileWatcherChannel) GetFile() <-chan string {
+	return ch.fileChan
+}
+
+//hand the message over as a file if it's large enough and not wrapped in an envelope, return false to read it as a string
+//the file is hard linked into Options.HandoffDir so that its content is never copied, a file system without hard links or
+//a HandoffDir on another file system falls back to reading the content
+func (ch *fileWatcherChannel) tryHandoff(filepath string, counter int) bool {
+	log := ch.logger
+	info, err := os.Stat(filepath)
+	if err != nil || info.Size() < ch.options.HandoffThreshold {
+		return false
+	}
+	if enveloped, err := isEnveloped(filepath); err != nil || enveloped {
+		return false
+	}
+	//the channel name tells apart the messages of the channels sharing the directory, their counters overlap
+	handoffPath := path.Join(ch.options.HandoffDir, path.Base(ch.path)+"-"+path.Base(filepath))
+	if err = link(filepath, handoffPath); err != nil {
+		log.Debugf("failed to link message %v to %v, reading it as a string: %v", filepath, handoffPath, err)
+		return false
+	}
+	ch.recvCounter = counter + 1
+	if !ch.removeConsumed(filepath) {
+		log.Debugf("message %v was taken by another consumer meanwhile, skipping it", filepath)
+		removeFile(handoffPath)
+		return true
+	}
+	ch.recvSizes.record(int(info.Size()))
+	//TODO handle buffered channel queue overflow
+	ch.fileChan <- handoffPath
+	return true
+}
@@ -0,0 +1,82 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newHandoffTestChannel(t *testing.T) *fileWatcherChannel {
+	handoffDir, err := ioutil.TempDir("", "handoff")
+	assert.NoError(t, err)
+	return newTestChannel(t, ModeMaster, Options{HandoffDir: handoffDir, HandoffThreshold: 16})
+}
+
+func TestHandoffFile(t *testing.T) {
+	ch := newHandoffTestChannel(t)
+	defer os.RemoveAll(ch.path)
+	defer os.RemoveAll(ch.options.HandoffDir)
+	large := strings.Repeat("x", 32)
+	dropMessage(t, ch.path, sequenceName(0), "small")
+	dropMessage(t, ch.path, sequenceName(1), large)
+	enveloped, err := encodeEnvelope(envelope{Payload: large})
+	assert.NoError(t, err)
+	dropMessage(t, ch.path, sequenceName(2), enveloped)
+	ch.consumeAll()
+
+	assert.Equal(t, "small", <-ch.onMessageChan)
+	handoffPath := <-ch.GetFile()
+	assert.Equal(t, ch.options.HandoffDir, path.Dir(handoffPath))
+	content, err := ioutil.ReadFile(handoffPath)
+	assert.NoError(t, err)
+	assert.Equal(t, large, string(content))
+	//the envelope is decoded, whatever its size
+	assert.Equal(t, large, <-ch.onMessageChan)
+	assert.Equal(t, 3, ch.recvCounter)
+
+	//the consumer owns the handed over file, the channel directory holds no message anymore
+	files, err := ch.ListFiles()
+	assert.NoError(t, err)
+	for _, file := range files {
+		assert.NotEqual(t, FileReadable, file.Class, file.Name)
+	}
+	ch.Close()
+	_, err = os.Stat(handoffPath)
+	assert.NoError(t, err)
+}
+
+func TestHandoffFallsBackToRead(t *testing.T) {
+	defer func(original func(string, string) error) { link = original }(link)
+	link = func(from, to string) error {
+		return &os.LinkError{Op: "link", Old: from, New: to, Err: syscall.EXDEV}
+	}
+	ch := newHandoffTestChannel(t)
+	defer os.RemoveAll(ch.path)
+	defer os.RemoveAll(ch.options.HandoffDir)
+	large := strings.Repeat("x", 32)
+	dropMessage(t, ch.path, sequenceName(0), large)
+	ch.onCreate(path.Join(ch.path, sequenceName(0)))
+	assert.Equal(t, large, <-ch.onMessageChan)
+	assert.Empty(t, ch.GetFile())
+	infos, err := ioutil.ReadDir(ch.options.HandoffDir)
+	assert.NoError(t, err)
+	assert.Empty(t, infos)
+}
@@ -0,0 +1,103 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type Capability string
+
+const (
+	//the peer unwraps the json envelope: latency tracking and control messages
+	CapabilityEnvelope Capability = "envelope"
+	//the peer decodes the compact binary envelope
+	CapabilityBinaryEnvelope Capability = "binary"
+	//the peer inflates the compressed payloads, see TransformCompress
+	CapabilityCompression Capability = Capability(TransformCompress)
+	//the peer opens the sealed payloads, offer it only once both ends are configured with the same Options.EncryptionKey
+	CapabilityEncryption Capability = Capability(TransformEncrypt)
+
+	//the control message exchanging the capability sets, consumed by Handshake() only
+	controlHello ControlType = "hello"
+)
+
+//SupportedCapabilities is the set this version is able to receive, offer it to Handshake() unless a subset is desired
+var SupportedCapabilities = []Capability{CapabilityEnvelope, CapabilityBinaryEnvelope, CapabilityCompression}
+
+//Handshake exchanges the offered capabilities with the peer and disables the features of the channel the peer cannot receive
+//a peer that does not answer within the timeout, e.g. a legacy binary, is treated as supporting none of them
+//it must be called before any message is sent, a legacy peer receives the hello as a regular datagram and drops it as unsupported
+func (ch *fileWatcherChannel) Handshake(offered []Capability, timeout time.Duration) []Capability {
+	log := ch.logger
+	names := make([]string, len(offered))
+	for i, capability := range offered {
+		names[i] = string(capability)
+	}
+	var agreed []Capability
+	if err := ch.SendControl(ControlMessage{Type: controlHello, Content: strings.Join(names, ",")}); err != nil {
+		log.Errorf("failed to send the handshake, falling back to the baseline: %v", err)
+	} else {
+		select {
+		case hello, more := <-ch.helloChan:
+			if more {
+				agreed = intersect(offered, strings.Split(hello.Content, ","))
+			}
+		case <-time.After(timeout):
+			log.Infof("peer did not answer the handshake within %v, falling back to the baseline", timeout)
+		}
+	}
+	ch.applyCapabilities(agreed)
+	log.Infof("negotiated capabilities: %v", agreed)
+	return agreed
+}
+
+//PeerSeen returns whether the peer came up, i.e. any message of it was received since the channel was opened, the handshake
+//included; a worker that never answers was either not started or failed during its initialization
+func (ch *fileWatcherChannel) PeerSeen() bool {
+	return atomic.LoadInt32(&ch.peerSeen) == 1
+}
+
+//disable the sending features the peer is not able to receive
+func (ch *fileWatcherChannel) applyCapabilities(agreed []Capability) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if !hasCapability(agreed, CapabilityEnvelope) {
+		ch.options.TrackLatency = false
+	}
+	if !hasCapability(agreed, CapabilityBinaryEnvelope) {
+		ch.options.Encoding = EncodingJSON
+	}
+	ch.agreed = agreed
+}
+
+func intersect(offered []Capability, peer []string) (agreed []Capability) {
+	for _, name := range peer {
+		if hasCapability(offered, Capability(name)) {
+			agreed = append(agreed, Capability(name))
+		}
+	}
+	return
+}
+
+func hasCapability(set []Capability, capability Capability) bool {
+	for _, c := range set {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandshakeNegotiatesDown(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "handshake")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "channel")
+	//the newer master would like to use the binary envelope, the older worker only knows the json one
+	master, err := NewFileWatcherChannelWithOptions(log.NewMockLog(), ModeMaster, name, Options{Encoding: EncodingBinary, TrackLatency: true})
+	assert.NoError(t, err)
+	worker, err := NewFileWatcherChannelWithOptions(log.NewMockLog(), ModeWorker, name, Options{TrackLatency: true})
+	assert.NoError(t, err)
+
+	agreed := make(chan []Capability)
+	go func() {
+		agreed <- worker.Handshake([]Capability{CapabilityEnvelope}, 5*time.Second)
+	}()
+	assert.Equal(t, []Capability{CapabilityEnvelope}, master.Handshake(SupportedCapabilities, 5*time.Second))
+	assert.Equal(t, []Capability{CapabilityEnvelope}, <-agreed)
+	assert.True(t, master.PeerSeen())
+	assert.True(t, worker.PeerSeen())
+	assert.Equal(t, EncodingJSON, master.options.Encoding)
+	assert.True(t, master.options.TrackLatency)
+
+	//the handshake is not delivered as a control message, and the messages keep flowing in the agreed format
+	assert.NoError(t, master.Send("request"))
+	msg, err := worker.WaitForMessage(5 * time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "request", msg)
+	select {
+	case control := <-worker.ControlMessages():
+		t.Fatalf("unexpected control message: %v", control)
+	default:
+	}
+	worker.Close()
+	master.Destroy()
+}
+
+func TestHandshakeNoCommonCapability(t *testing.T) {
+	assert.Empty(t, intersect([]Capability{CapabilityBinaryEnvelope}, []string{string(CapabilityEnvelope)}))
+	assert.Empty(t, intersect(SupportedCapabilities, []string{""}))
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	//a legacy peer never answers
+	ch := newTestChannel(t, ModeMaster, Options{Encoding: EncodingBinary, TrackLatency: true})
+	defer os.RemoveAll(ch.path)
+	assert.Empty(t, ch.Handshake(SupportedCapabilities, 10*time.Millisecond))
+	assert.False(t, ch.PeerSeen())
+	assert.Equal(t, EncodingJSON, ch.options.Encoding)
+	assert.False(t, ch.options.TrackLatency)
+}
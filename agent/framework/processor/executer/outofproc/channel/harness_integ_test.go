@@ -0,0 +1,63 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// +build integration
+
+package channel
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHarnessDuplexOrdering(t *testing.T) {
+	forEachVariant(t, func(pair *channelPair) {
+		requests, replies := sequence("request", 50), sequence("reply", 50)
+		pair.send(pair.master, requests...)
+		pair.send(pair.worker, replies...)
+		pair.expect(pair.worker, requests...)
+		pair.expect(pair.master, replies...)
+		pair.expectNone(pair.worker)
+		pair.expectNone(pair.master)
+	})
+}
+
+func TestHarnessMasterRestart(t *testing.T) {
+	forEachVariant(t, func(pair *channelPair) {
+		pair.send(pair.worker, "before")
+		pair.expect(pair.master, "before")
+		pair.master.Close()
+		//the worker keeps sending while the master is down
+		pair.send(pair.worker, sequence("during", 10)...)
+		pair.master = pair.open(ModeMaster)
+		pair.expect(pair.master, sequence("during", 10)...)
+		pair.send(pair.master, "after")
+		pair.expect(pair.worker, "after")
+	})
+}
+
+func TestHarnessCloseAndDestroy(t *testing.T) {
+	forEachVariant(t, func(pair *channelPair) {
+		pair.send(pair.master, "last")
+		pair.expect(pair.worker, "last")
+		pair.worker.Close()
+		_, err := pair.worker.WaitForMessage(harnessTimeout)
+		assert.Equal(t, ErrChannelClosed, err)
+		assert.Equal(t, ErrChannelClosed, pair.worker.Send("closed"))
+		pair.master.Destroy()
+		_, err = os.Stat(pair.name)
+		assert.True(t, os.IsNotExist(err))
+	})
+}
@@ -0,0 +1,162 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+const harnessTimeout = 10 * time.Second
+
+//harnessVariant is a way of delivering the messages a scenario is run against
+type harnessVariant struct {
+	name string
+	//the options the master and the worker channels are opened with
+	master Options
+	worker Options
+	//receive through a periodic directory poll instead of the fsnotify events
+	polling bool
+}
+
+var harnessVariants = []harnessVariant{
+	{name: "fsnotify"},
+	{name: "polling", polling: true},
+}
+
+//channelPair is a master and a worker channel talking over a real directory
+type channelPair struct {
+	t       *testing.T
+	variant harnessVariant
+	dir     string
+	name    string
+	master  *fileWatcherChannel
+	worker  *fileWatcherChannel
+	stop    chan bool
+}
+
+//forEachVariant runs the scenario once per variant, on a fresh pair whose directory is removed even if the scenario fails
+func forEachVariant(t *testing.T, scenario func(pair *channelPair)) {
+	for _, variant := range harnessVariants {
+		t.Run(variant.name, func(t *testing.T) {
+			pair := newChannelPair(t, variant)
+			defer pair.cleanup()
+			scenario(pair)
+		})
+	}
+}
+
+func newChannelPair(t *testing.T, variant harnessVariant) *channelPair {
+	dir, err := ioutil.TempDir(".", "harness")
+	if err != nil {
+		t.Fatalf("failed to create the test directory: %v", err)
+	}
+	pair := &channelPair{
+		t:       t,
+		variant: variant,
+		dir:     dir,
+		name:    path.Join(dir, "channel"),
+		stop:    make(chan bool),
+	}
+	pair.master = pair.open(ModeMaster)
+	pair.worker = pair.open(ModeWorker)
+	return pair
+}
+
+//open a channel of the given mode on the pair's directory, e.g. to simulate a restart
+func (p *channelPair) open(mode Mode) *fileWatcherChannel {
+	options := p.variant.master
+	if mode == ModeWorker {
+		options = p.variant.worker
+	}
+	ch, err := NewFileWatcherChannelWithOptions(log.NewMockLogWithContext(string(mode)), mode, p.name, options)
+	if err != nil {
+		os.RemoveAll(p.dir)
+		p.t.Fatalf("failed to open %v channel: %v", mode, err)
+	}
+	if p.variant.polling {
+		//stop the fsnotify events, only the poll delivers the messages
+		closeWatcher(ch.watcher, p.name)
+		go p.poll(ch)
+	}
+	return ch
+}
+
+func (p *channelPair) poll(ch *fileWatcherChannel) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			//check under the consume lock, Close() marks the channel closed before draining it under the same lock
+			ch.consumeMu.Lock()
+			if ch.isClosed() {
+				ch.consumeMu.Unlock()
+				return
+			}
+			ch.consumeAllLocked()
+			ch.consumeMu.Unlock()
+		}
+	}
+}
+
+func (p *channelPair) cleanup() {
+	close(p.stop)
+	p.worker.Close()
+	p.master.Destroy()
+	os.RemoveAll(p.dir)
+}
+
+//send the messages in order, fail the scenario on the first error
+func (p *channelPair) send(ch Channel, messages ...string) {
+	for _, msg := range messages {
+		if err := ch.Send(msg); err != nil {
+			p.t.Fatalf("failed to send %v: %v", msg, err)
+		}
+	}
+}
+
+//expect the messages to be received in order
+func (p *channelPair) expect(ch Channel, messages ...string) {
+	for _, expected := range messages {
+		msg, err := ch.WaitForMessage(harnessTimeout)
+		if err != nil {
+			p.t.Fatalf("expected message %v, got error: %v", expected, err)
+		}
+		assert.Equal(p.t, expected, msg)
+	}
+}
+
+//expect nothing else to be received for a while
+func (p *channelPair) expectNone(ch Channel) {
+	msg, err := ch.WaitForMessage(100 * time.Millisecond)
+	assert.Equal(p.t, ErrMessageTimeout, err, "unexpected message: %v", msg)
+}
+
+func sequence(prefix string, count int) []string {
+	messages := make([]string, count)
+	for i := range messages {
+		messages[i] = fmt.Sprintf("%v%03d", prefix, i)
+	}
+	return messages
+}
@@ -0,0 +1,57 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"os"
+)
+
+//PeerIdentity identifies the process and the channel instance a message was sent by, see Options.SendIdentity
+type PeerIdentity struct {
+	Pid int
+	//the start time of the sending channel, it tells apart the incarnations of a worker reusing a pid
+	StartTime string
+}
+
+//Identity returns the identity this channel stamps its messages with, to be passed to the peer's ExpectPeer()
+func (ch *fileWatcherChannel) Identity() PeerIdentity {
+	return PeerIdentity{Pid: os.Getpid(), StartTime: ch.startTime}
+}
+
+//ExpectPeer drops the messages not sent by the given peer from then on, e.g. a rogue writer or a message left over by a
+//previous worker; a zero field matches any. A message not carrying the identity is dropped as well, so the peer must send it
+//with Options.SendIdentity. The expected messages are always read, neither streamed nor handed over as files
+func (ch *fileWatcherChannel) ExpectPeer(identity PeerIdentity) {
+	ch.identityMu.Lock()
+	defer ch.identityMu.Unlock()
+	ch.expectedPeer = &identity
+}
+
+func (ch *fileWatcherChannel) peerExpected() *PeerIdentity {
+	ch.identityMu.Lock()
+	defer ch.identityMu.Unlock()
+	return ch.expectedPeer
+}
+
+//whether the sender identity carried by the envelope matches the expected peer, if any
+func (ch *fileWatcherChannel) fromExpectedPeer(env envelope) bool {
+	expected := ch.peerExpected()
+	if expected == nil {
+		return true
+	}
+	if env.SenderPid == 0 {
+		return false
+	}
+	return (expected.Pid == 0 || expected.Pid == env.SenderPid) && (expected.StartTime == "" || expected.StartTime == env.SenderStart)
+}
@@ -0,0 +1,76 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendIdentity(t *testing.T) {
+	ch := newTestChannel(t, ModeWorker, Options{SendIdentity: true})
+	defer os.RemoveAll(ch.path)
+	content, err := ch.encode(envelope{Payload: "p"})
+	assert.NoError(t, err)
+	env, err := decodeEnvelope(content)
+	assert.NoError(t, err)
+	assert.Equal(t, os.Getpid(), env.SenderPid)
+	assert.Equal(t, ch.startTime, env.SenderStart)
+	assert.Equal(t, "p", env.Payload)
+}
+
+func TestExpectPeerDropsOtherSenders(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{StreamThreshold: 1, LazyRead: true})
+	defer os.RemoveAll(ch.path)
+	ch.ExpectPeer(PeerIdentity{Pid: 42, StartTime: "20170101000000"})
+	defer ch.abandonPending()
+	go ch.readPending()
+
+	messages := []string{
+		encodeTestEnvelope(t, envelope{Payload: "expected", SenderPid: 42, SenderStart: "20170101000000"}),
+		//a previous incarnation of the worker reusing the pid
+		encodeTestEnvelope(t, envelope{Payload: "stale", SenderPid: 42, SenderStart: "20161231000000"}),
+		encodeTestEnvelope(t, envelope{Payload: "rogue", SenderPid: 7, SenderStart: "20170101000000"}),
+		//no identity, neither streamed nor deferred unchecked
+		"raw",
+		encodeTestEnvelope(t, envelope{Payload: "expected again", SenderPid: 42, SenderStart: "20170101000000"}),
+	}
+	for i, content := range messages {
+		dropMessage(t, ch.path, sequenceName(i), content)
+	}
+	ch.consumeAll()
+	assert.Equal(t, "expected", <-ch.onMessageChan)
+	assert.Equal(t, "expected again", <-ch.onMessageChan)
+	assert.Empty(t, ch.GetStream())
+	assert.Equal(t, len(messages), ch.recvCounter)
+	for i := range messages {
+		_, err := os.Stat(path.Join(ch.path, sequenceName(i)))
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	//a zero field matches any
+	ch.ExpectPeer(PeerIdentity{Pid: 7})
+	dropMessage(t, ch.path, sequenceName(len(messages)), encodeTestEnvelope(t, envelope{Payload: "any start", SenderPid: 7, SenderStart: "x"}))
+	ch.consumeAll()
+	assert.Equal(t, "any start", <-ch.onMessageChan)
+}
+
+func encodeTestEnvelope(t *testing.T, env envelope) string {
+	content, err := encodeEnvelope(env)
+	assert.NoError(t, err)
+	return content
+}
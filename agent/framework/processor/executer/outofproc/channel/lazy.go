@@ -0,0 +1,111 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"os"
+	"path"
+	"sync/atomic"
+)
+
+//prefix of the messages moved out of the channel directory until the consumer pulls them, see Options.LazyRead
+const lazyFilePrefix = "pending-"
+
+//a message waiting for the consumer of GetMessage() with Options.LazyRead, either left on disk or read already,
+//e.g. to unwrap its envelope
+type pendingMessage struct {
+	path    string
+	payload string
+}
+
+//hand the payload over to GetMessage(), behind the messages deferred before it
+func (ch *fileWatcherChannel) deliver(msg string) {
+	if ch.options.LazyRead {
+		//TODO handle buffered channel queue overflow
+		ch.pendingChan <- pendingMessage{payload: msg}
+		return
+	}
+	//TODO handle buffered channel queue overflow
+	ch.onMessageChan <- msg
+}
+
+//leave the message on disk until the consumer pulls it if it's not wrapped in an envelope, return false to read it now
+func (ch *fileWatcherChannel) tryDefer(filepath string, counter int) bool {
+	if ch.StreamEnded() {
+		//read now, to be dropped
+		return false
+	}
+	info, err := os.Stat(filepath)
+	if err != nil {
+		return false
+	}
+	if enveloped, err := isEnveloped(filepath); err != nil || enveloped {
+		return false
+	}
+	//move the file out of the channel directory, so that it's not consumed again while it's waiting
+	pendingPath := path.Join(ch.tmpPath, lazyFilePrefix+path.Base(filepath))
+	if err = rename(filepath, pendingPath); err != nil {
+		ch.logger.Debugf("failed to move message %v aside, reading it now: %v", filepath, err)
+		return false
+	}
+	ch.recvCounter = counter + 1
+	ch.recvSizes.record(int(info.Size()))
+	//TODO handle buffered channel queue overflow
+	ch.pendingChan <- pendingMessage{path: pendingPath}
+	return true
+}
+
+//feed GetMessage() one message at a time, reading each deferred message only once the previous one is taken by the consumer
+//until the channel is closed and the messages are drained, or the channel is destroyed
+func (ch *fileWatcherChannel) readPending() {
+	defer close(ch.onMessageChan)
+	for {
+		var pending pendingMessage
+		var more bool
+		select {
+		case pending, more = <-ch.pendingChan:
+			if !more {
+				return
+			}
+		case <-ch.pendingStop:
+			return
+		}
+		msg := pending.payload
+		if pending.path != "" {
+			content, err := ch.readFile(pending.path)
+			removeFile(pending.path)
+			if err != nil {
+				ch.logger.Errorf("message %v failed to read, dropping it: %v", pending.path, err)
+				continue
+			}
+			msg = content
+		}
+		atomic.StoreInt32(&ch.pendingHeld, 1)
+		select {
+		case ch.onMessageChan <- msg:
+			atomic.StoreInt32(&ch.pendingHeld, 0)
+		case <-ch.pendingStop:
+			//flushed back by Detach(), dropped otherwise
+			ch.pendingLeft = &pendingMessage{payload: msg}
+			return
+		}
+	}
+}
+
+//stop feeding GetMessage(), the messages still on disk are removed along with the channel directory
+func (ch *fileWatcherChannel) abandonPending() {
+	if ch.options.LazyRead {
+		ch.pendingStopOnce.Do(func() { close(ch.pendingStop) })
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLazyReadDefersMessages(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{LazyRead: true})
+	defer os.RemoveAll(ch.path)
+	wrapped, err := encodeEnvelope(envelope{SentAt: 1, Payload: "wrapped"})
+	assert.NoError(t, err)
+	messages := []string{"first", wrapped, "third"}
+	for i, msg := range messages {
+		dropMessage(t, ch.path, sequenceName(i), msg)
+		assert.True(t, ch.consume(path.Join(ch.path, sequenceName(i))))
+	}
+	assert.Equal(t, len(messages), ch.recvCounter)
+	//the raw messages are left on disk out of the channel directory, the enveloped one is read on arrival
+	files, err := ioutil.ReadDir(ch.tmpPath)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+	for _, file := range files {
+		assert.True(t, strings.HasPrefix(file.Name(), lazyFilePrefix))
+	}
+	assert.Equal(t, len(messages), ch.buffered())
+
+	go ch.readPending()
+	for _, expected := range []string{"first", "wrapped", "third"} {
+		msg, err := ch.WaitForMessage(5 * time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, msg)
+	}
+	files, err = ioutil.ReadDir(ch.tmpPath)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+	close(ch.pendingChan)
+	_, err = ch.WaitForMessage(5 * time.Second)
+	assert.Equal(t, ErrChannelClosed, err)
+}
+
+func TestLazyReadAbandoned(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{LazyRead: true})
+	defer os.RemoveAll(ch.path)
+	dropMessage(t, ch.path, sequenceName(0), "never pulled")
+	dropMessage(t, ch.path, sequenceName(1), "never read")
+	ch.consumeAll()
+	go ch.readPending()
+	//the first message is read and held for the consumer, the second one stays on disk
+	deadline := time.Now().Add(5 * time.Second)
+	for ch.buffered() != 2 || len(ch.pendingChan) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("the first message is not read")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	ch.abandonPending()
+	ch.abandonPending()
+	_, err := ch.WaitForMessage(5 * time.Second)
+	assert.Equal(t, ErrChannelClosed, err)
+}
+
+func TestLazyReadRoundTrip(t *testing.T) {
+	pair := newChannelPair(t, harnessVariant{master: Options{LazyRead: true}})
+	defer pair.cleanup()
+	master, worker := pair.master, pair.worker
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, worker.Send(sequenceName(i)))
+	}
+	for i := 0; i < 10; i++ {
+		msg, err := master.WaitForMessage(5 * time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, sequenceName(i), msg)
+	}
+	worker.Close()
+	master.Destroy()
+	_, err := master.WaitForMessage(5 * time.Second)
+	assert.Equal(t, ErrChannelClosed, err)
+}
+
+//the memory held by a burst of large messages the consumer did not pull yet
+func benchmarkLargeMessageBurst(b *testing.B, lazy bool) {
+	const burst = 32
+	content := strings.Repeat("l", 1<<20)
+	var held uint64
+	for i := 0; i < b.N; i++ {
+		var ch *fileWatcherChannel
+		if lazy {
+			ch = newTestChannel(b, ModeMaster, Options{LazyRead: true})
+		} else {
+			ch = newTestChannel(b, ModeMaster, Options{})
+		}
+		for j := 0; j < burst; j++ {
+			dropMessage(b, ch.path, sequenceName(j), content)
+		}
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		ch.consumeAll()
+		if lazy {
+			go ch.readPending()
+			//let the reader pick up the first message
+			for atomic.LoadInt32(&ch.pendingHeld) == 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		if after.HeapAlloc > before.HeapAlloc {
+			held += after.HeapAlloc - before.HeapAlloc
+		}
+		for j := 0; j < burst; j++ {
+			<-ch.onMessageChan
+		}
+		os.RemoveAll(ch.path)
+	}
+	b.ReportMetric(float64(held)/float64(b.N)/(1<<20), "MB-held/burst")
+}
+
+func BenchmarkLargeMessageBurstEager(b *testing.B) {
+	benchmarkLargeMessageBurst(b, false)
+}
+
+func BenchmarkLargeMessageBurstLazy(b *testing.B) {
+	benchmarkLargeMessageBurst(b, true)
+}
@@ -0,0 +1,52 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"time"
+)
+
+//start the timer of Options.MaxLifetime, the caller must not have shared the channel yet
+func (ch *fileWatcherChannel) armLifetime() {
+	if ch.options.MaxLifetime <= 0 {
+		return
+	}
+	ch.lifetime = time.AfterFunc(ch.options.MaxLifetime, ch.expire)
+}
+
+//ResetLifetime restarts the count of Options.MaxLifetime, e.g. when the document owning the channel is known to progress
+//it returns false if the lifetime is disabled or the channel is closed already
+func (ch *fileWatcherChannel) ResetLifetime() bool {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+	if ch.closed || ch.lifetime == nil {
+		return false
+	}
+	ch.lifetime.Reset(ch.options.MaxLifetime)
+	return true
+}
+
+//the channel outlived Options.MaxLifetime, it's presumably leaked by a document that never completes
+func (ch *fileWatcherChannel) expire() {
+	defer ch.recoverPanic()
+	if ch.isClosed() {
+		return
+	}
+	ch.logger.Errorf("channel %v exceeded its lifetime of %v, closing it: %v", ch.path, ch.options.MaxLifetime, ch.StatusLine())
+	if ch.mode == ModeMaster {
+		ch.Destroy()
+	} else {
+		ch.Close()
+	}
+}
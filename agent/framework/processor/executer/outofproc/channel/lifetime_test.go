@@ -0,0 +1,69 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelPastLifetimeAutoCloses(t *testing.T) {
+	pair := newChannelPair(t, harnessVariant{master: Options{MaxLifetime: 200 * time.Millisecond}})
+	defer pair.cleanup()
+	master, worker := pair.master, pair.worker
+
+	//a reset postpones the expiry
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, master.ResetLifetime())
+	time.Sleep(150 * time.Millisecond)
+	assert.False(t, master.isClosed())
+
+	select {
+	case _, ok := <-master.GetMessage():
+		assert.False(t, ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel past its lifetime is not closed")
+	}
+	assert.True(t, master.isClosed())
+	assert.False(t, master.ResetLifetime())
+	//the master destroys the directory once closed
+	deadline := time.Now().Add(5 * time.Second)
+	_, err := os.Stat(pair.name)
+	for ; err == nil && time.Now().Before(deadline); _, err = os.Stat(pair.name) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, os.IsNotExist(err))
+	assert.False(t, worker.ResetLifetime())
+}
+
+func TestCloseStopsLifetime(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "lifetime")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "channel")
+	ch, err := NewFileWatcherChannelWithOptions(log.NewMockLog(), ModeMaster, name, Options{MaxLifetime: 50 * time.Millisecond})
+	assert.NoError(t, err)
+	ch.Close()
+	time.Sleep(100 * time.Millisecond)
+	//closed before its lifetime, the master keeps the directory for a reopen
+	_, err = os.Stat(name)
+	assert.NoError(t, err)
+	ch.Destroy()
+}
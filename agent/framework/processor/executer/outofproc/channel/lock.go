@@ -0,0 +1,111 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"os"
+	"path"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	//suffix of the sidecar lock held by the sender while writing a message, kept under tmp/ so that it's never mistaken for a message
+	lockFileSuffix = ".lock"
+	//a lock older than this is left over by a sender that crashed mid-write, the message is consumed regardless
+	lockStaleTimeout = 10 * time.Second
+)
+
+//how long the consumer waits before polling again for a locked message, injected by the tests
+var lockRetryInterval = 50 * time.Millisecond
+
+//how long the consumer waits before retrying a message whose deletion was deferred by Options.BeforeDelete
+var deleteRetryInterval = time.Second
+
+func (ch *fileWatcherChannel) lockPath(sequenceID string) string {
+	return path.Join(ch.tmpPath, sequenceID+lockFileSuffix)
+}
+
+//create the lock of a message about to be written, the caller removes it once the message is renamed in place
+func (ch *fileWatcherChannel) lockMessage(sequenceID string) (string, error) {
+	lockPath := ch.lockPath(sequenceID)
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, defaultFileWriteMode)
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	return lockPath, nil
+}
+
+//check whether the peer is still writing the message
+func (ch *fileWatcherChannel) isLocked(filepath string) bool {
+	info, err := os.Stat(ch.lockPath(path.Base(filepath)))
+	if err != nil {
+		return false
+	}
+	if time.Since(info.ModTime()) > lockStaleTimeout {
+		ch.logger.Errorf("ignoring stale lock of message %v", filepath)
+		return false
+	}
+	return true
+}
+
+//consume the message unless the peer still holds its lock or the BeforeDelete hook defers it,
+//return false if the messages after it must wait as well
+func (ch *fileWatcherChannel) tryConsume(filepath string) bool {
+	//the messages are left to the process taking over, see Detach()
+	if atomic.LoadInt32(&ch.detached) == 1 {
+		return false
+	}
+	//the messages are left on disk until the consumer catches up, see Options.Throttle
+	if ch.throttleLocked() {
+		return false
+	}
+	atomic.AddInt32(&ch.consuming, 1)
+	defer atomic.AddInt32(&ch.consuming, -1)
+	if ch.options.CooperativeLock && ch.isLocked(filepath) {
+		ch.logger.Debugf("message %v is still being written, retrying in %v", filepath, lockRetryInterval)
+		ch.retryLater(lockRetryInterval)
+		return false
+	}
+	recvCounter := ch.recvCounter
+	if !ch.consume(filepath) {
+		ch.retryLater(deleteRetryInterval)
+		return false
+	}
+	//a message failing to read is retried, it's consumed once the counter moves past it
+	if ch.recvCounter != recvCounter {
+		ch.markConsumed(filepath)
+	}
+	return true
+}
+
+//poll the directory again later, neither the removal of a lock nor a deferred deletion triggers any event in the channel directory
+//the caller must hold consumeMu
+func (ch *fileWatcherChannel) retryLater(interval time.Duration) {
+	if ch.retryPending {
+		return
+	}
+	ch.retryPending = true
+	time.AfterFunc(interval, func() {
+		defer ch.recoverPanic()
+		ch.consumeMu.Lock()
+		defer ch.consumeMu.Unlock()
+		ch.retryPending = false
+		//Close() drains the directory under consumeMu before closing the go channels, checking under the lock is enough
+		if !ch.isClosed() {
+			ch.consumeWindowLocked()
+		}
+	})
+}
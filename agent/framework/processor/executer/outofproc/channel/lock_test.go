@@ -0,0 +1,86 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendCooperativeLock(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{CooperativeLock: true})
+	defer os.RemoveAll(ch.path)
+	defer func(original func(string, string) error) { rename = original }(rename)
+	var locked bool
+	rename = func(from, to string) error {
+		_, err := os.Stat(ch.lockPath(path.Base(to)))
+		locked = err == nil
+		return os.Rename(from, to)
+	}
+	assert.NoError(t, ch.Send("m0"))
+	//the lock is held across the rename and released afterwards
+	assert.True(t, locked)
+	files, err := ioutil.ReadDir(ch.tmpPath)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestConsumeSkipsLockedMessage(t *testing.T) {
+	defer func(interval time.Duration) { lockRetryInterval = interval }(lockRetryInterval)
+	lockRetryInterval = 10 * time.Millisecond
+	ch := newTestChannel(t, ModeMaster, Options{CooperativeLock: true})
+	defer os.RemoveAll(ch.path)
+	//the worker is still writing m0 while m1 is complete
+	lockPath, err := ch.lockMessage("worker-20170101000000-000")
+	assert.NoError(t, err)
+	dropMessage(t, ch.path, "worker-20170101000000-000", "partial")
+	dropMessage(t, ch.path, "worker-20170101000000-001", "m1")
+
+	ch.consumeAll()
+	//m1 waits for m0, so that the order is kept
+	_, err = ch.WaitForMessage(5 * lockRetryInterval)
+	assert.Equal(t, ErrMessageTimeout, err)
+	ch.consumeMu.Lock()
+	assert.Equal(t, 0, ch.recvCounter)
+	ch.consumeMu.Unlock()
+
+	//the retry picks up both once the lock is released
+	assert.NoError(t, ioutil.WriteFile(path.Join(ch.path, "worker-20170101000000-000"), []byte("m0"), defaultFileWriteMode))
+	assert.NoError(t, os.Remove(lockPath))
+	for _, expected := range []string{"m0", "m1"} {
+		msg, err := ch.WaitForMessage(5 * time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, msg)
+	}
+	ch.mu.Lock()
+	ch.closed = true
+	ch.mu.Unlock()
+}
+
+func TestConsumeIgnoresStaleLock(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{CooperativeLock: true})
+	defer os.RemoveAll(ch.path)
+	lockPath, err := ch.lockMessage("worker-20170101000000-000")
+	assert.NoError(t, err)
+	stale := time.Now().Add(-2 * lockStaleTimeout)
+	assert.NoError(t, os.Chtimes(lockPath, stale, stale))
+	dropMessage(t, ch.path, "worker-20170101000000-000", "m0")
+	ch.consumeAll()
+	assert.Equal(t, "m0", <-ch.onMessageChan)
+}
@@ -1,6 +1,10 @@
 package channelmock
 
-import "github.com/stretchr/testify/mock"
+import (
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
 
 type MockedChannel struct {
 	mock.Mock
@@ -16,6 +20,11 @@ func (m *MockedChannel) GetMessage() <-chan string {
 	return args.Get(0).(chan string)
 }
 
+func (m *MockedChannel) WaitForMessage(timeout time.Duration) (string, error) {
+	args := m.Called(timeout)
+	return args.String(0), args.Error(1)
+}
+
 func (m *MockedChannel) Close() {
 	m.Called()
 	return
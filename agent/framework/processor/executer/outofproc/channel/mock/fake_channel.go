@@ -106,6 +106,18 @@ func (f *FakeChannel) GetMessage() <-chan string {
 	return f.recvChan
 }
 
+func (f *FakeChannel) WaitForMessage(timeout time.Duration) (string, error) {
+	select {
+	case msg, more := <-f.recvChan:
+		if !more {
+			return "", channel.ErrChannelClosed
+		}
+		return msg, nil
+	case <-time.After(timeout):
+		return "", channel.ErrMessageTimeout
+	}
+}
+
 //close stops the receiving channel
 func (f *FakeChannel) Close() {
 	if f.closed {
@@ -0,0 +1,221 @@
+package channel
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+//streamEnvelope wraps a logical stream's datagram with the stream id, so that several streams can share one physical channel
+type streamEnvelope struct {
+	ID      string `json:"id"`
+	Payload string `json:"payload"`
+}
+
+//Multiplexer carries multiple logical channels over one physical Channel, saving a directory and a file watch per stream
+//Both ends must use a Multiplexer over the same physical channel and agree on the stream ids
+type Multiplexer struct {
+	logger   log.T
+	physical Channel
+	streams  map[string]*logicalChannel
+	mu       sync.Mutex
+	closed   bool
+}
+
+//logicalChannel is a single stream of a Multiplexer, it implements Channel
+//every stream queues its payloads on its own, so that a consumer falling behind on one stream does not hold up the others
+type logicalChannel struct {
+	id            string
+	mux           *Multiplexer
+	onMessageChan chan string
+	//queue holds the payloads demultiplexed but not yet taken by the consumer, queued wakes up the pump
+	queue  []string
+	queued chan bool
+	//ended is set once the physical channel is closed, the queued payloads are still delivered
+	ended bool
+	//done stops the pump when the stream is closed
+	done      chan bool
+	closeOnce sync.Once
+	mu        sync.Mutex
+	closed    bool
+}
+
+//NewMultiplexer takes the ownership of the physical channel and starts demultiplexing its messages
+func NewMultiplexer(logger log.T, physical Channel) *Multiplexer {
+	m := &Multiplexer{
+		logger:   logger,
+		physical: physical,
+		streams:  make(map[string]*logicalChannel),
+	}
+	go m.demux()
+	return m
+}
+
+//Open returns the logical channel identified by id, messages received for the id before Open() are buffered
+func (m *Multiplexer) Open(id string) Channel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.getStream(id)
+}
+
+//getStream must be called with the lock held
+func (m *Multiplexer) getStream(id string) *logicalChannel {
+	if stream, ok := m.streams[id]; ok {
+		return stream
+	}
+	stream := &logicalChannel{
+		id:            id,
+		mux:           m,
+		onMessageChan: make(chan string, defaultChannelBufferSize),
+		queued:        make(chan bool, 1),
+		done:          make(chan bool),
+	}
+	go stream.pump()
+	if m.closed {
+		stream.Close()
+	}
+	m.streams[id] = stream
+	return stream
+}
+
+//Close closes the physical channel, all the logical channels are closed once the physical one drains
+func (m *Multiplexer) Close() {
+	m.physical.Close()
+}
+
+//Destroy closes and removes the physical channel
+func (m *Multiplexer) Destroy() {
+	m.physical.Destroy()
+}
+
+//dispatch every physical message to its logical stream, close all streams when the physical channel closes
+func (m *Multiplexer) demux() {
+	log := m.logger
+	for datagram := range m.physical.GetMessage() {
+		var env streamEnvelope
+		if err := jsonutil.Unmarshal(datagram, &env); err != nil {
+			log.Errorf("failed to parse multiplexed message, dropping it: %v", err)
+			continue
+		}
+		m.mu.Lock()
+		stream := m.getStream(env.ID)
+		m.mu.Unlock()
+		if !stream.deliver(env.Payload) {
+			log.Debugf("logical channel %v already closed, dropping message", env.ID)
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	for _, stream := range m.streams {
+		stream.end()
+	}
+	log.Debug("multiplexer stopped")
+}
+
+//deliver queues a payload on the stream without waiting for the consumer, return false if the stream is closed
+func (l *logicalChannel) deliver(payload string) bool {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return false
+	}
+	l.queue = append(l.queue, payload)
+	l.mu.Unlock()
+	l.wake()
+	return true
+}
+
+//end closes the stream once the consumer has taken the queued payloads
+func (l *logicalChannel) end() {
+	l.mu.Lock()
+	l.ended = true
+	l.mu.Unlock()
+	l.wake()
+}
+
+func (l *logicalChannel) wake() {
+	select {
+	case l.queued <- true:
+	default:
+	}
+}
+
+//pump hands the queued payloads over to the consumer in order, it's the only writer of onMessageChan and closes it
+func (l *logicalChannel) pump() {
+	defer close(l.onMessageChan)
+	for {
+		l.mu.Lock()
+		if len(l.queue) == 0 {
+			ended := l.ended
+			l.mu.Unlock()
+			if ended {
+				l.Close()
+				return
+			}
+			select {
+			case <-l.queued:
+				continue
+			case <-l.done:
+				return
+			}
+		}
+		payload := l.queue[0]
+		l.queue = l.queue[1:]
+		l.mu.Unlock()
+		select {
+		case l.onMessageChan <- payload:
+		case <-l.done:
+			return
+		}
+	}
+}
+
+//Send does not take the stream lock, done is closed first by Close
+func (l *logicalChannel) Send(rawJson string) error {
+	select {
+	case <-l.done:
+		return ErrChannelClosed
+	default:
+	}
+	datagram, err := jsonutil.Marshal(streamEnvelope{ID: l.id, Payload: rawJson})
+	if err != nil {
+		return err
+	}
+	return l.mux.physical.Send(datagram)
+}
+
+func (l *logicalChannel) GetMessage() <-chan string {
+	return l.onMessageChan
+}
+
+func (l *logicalChannel) WaitForMessage(timeout time.Duration) (string, error) {
+	select {
+	case msg, more := <-l.onMessageChan:
+		if !more {
+			return "", ErrChannelClosed
+		}
+		return msg, nil
+	case <-time.After(timeout):
+		return "", ErrMessageTimeout
+	}
+}
+
+//Close stops the logical stream only, the physical channel and the other streams stay open
+//the payloads not yet taken by the consumer are dropped
+func (l *logicalChannel) Close() {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.closed = true
+		l.queue = nil
+	})
+}
+
+//Destroy is identical to Close, the physical channel is owned by the Multiplexer
+func (l *logicalChannel) Destroy() {
+	l.Close()
+}
@@ -0,0 +1,135 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+//Package channel defines and implements the communication interface between agent and command runner process
+package channel
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiplexerThreeStreams(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "multiplexer")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "mux")
+	master, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, name)
+	assert.NoError(t, err)
+	worker, err := NewFileWatcherChannel(log.NewMockLog(), ModeWorker, name)
+	assert.NoError(t, err)
+	masterMux := NewMultiplexer(log.NewMockLog(), master)
+	workerMux := NewMultiplexer(log.NewMockLog(), worker)
+
+	streams := []string{"control", "stdout", "results"}
+	for _, id := range streams {
+		sender := masterMux.Open(id)
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, sender.Send(id+string(rune('0'+i))))
+		}
+	}
+	//each logical stream receives its own messages in order
+	for _, id := range streams {
+		receiver := workerMux.Open(id)
+		for i := 0; i < 3; i++ {
+			msg, err := receiver.WaitForMessage(5 * time.Second)
+			assert.NoError(t, err)
+			assert.Equal(t, id+string(rune('0'+i)), msg)
+		}
+	}
+	//reply on a single stream
+	assert.NoError(t, workerMux.Open("results").Send("done"))
+	msg, err := masterMux.Open("results").WaitForMessage(5 * time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "done", msg)
+
+	//closing the physical channel closes all logical streams
+	workerMux.Close()
+	for _, id := range streams {
+		_, err := workerMux.Open(id).WaitForMessage(5 * time.Second)
+		assert.Equal(t, ErrChannelClosed, err)
+	}
+	masterMux.Destroy()
+}
+
+//a logical stream closed while it's sending, run with -race
+func TestLogicalChannelSendWhileClosing(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "multiplexer")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	master, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, path.Join(dir, "mux"))
+	assert.NoError(t, err)
+	masterMux := NewMultiplexer(log.NewMockLog(), master)
+	defer masterMux.Destroy()
+
+	stream := masterMux.Open("control")
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if err := stream.Send("cancel"); err != nil {
+				assert.Equal(t, ErrChannelClosed, err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		stream.Close()
+	}()
+	wg.Wait()
+	assert.Equal(t, ErrChannelClosed, stream.Send("cancel"))
+	//the other streams are still open
+	assert.NoError(t, masterMux.Open("stdout").Send("output"))
+}
+
+//the consumer of a stream falls behind, the other streams keep receiving their messages
+func TestMultiplexerSlowStream(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "multiplexer")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "mux")
+	master, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, name)
+	assert.NoError(t, err)
+	worker, err := NewFileWatcherChannel(log.NewMockLog(), ModeWorker, name)
+	assert.NoError(t, err)
+	masterMux := NewMultiplexer(log.NewMockLog(), master)
+	defer masterMux.Destroy()
+	workerMux := NewMultiplexer(log.NewMockLog(), worker)
+	defer workerMux.Close()
+
+	//stdout is never read, its messages exceed the buffer of the stream
+	stdout := masterMux.Open("stdout")
+	count := 2 * defaultChannelBufferSize
+	for i := 0; i < count; i++ {
+		assert.NoError(t, stdout.Send("output"))
+	}
+	assert.NoError(t, masterMux.Open("control").Send("cancel"))
+	msg, err := workerMux.Open("control").WaitForMessage(10 * time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "cancel", msg)
+	//the slow stream still gets all of its messages once its consumer catches up
+	receiver := workerMux.Open("stdout")
+	for i := 0; i < count; i++ {
+		msg, err := receiver.WaitForMessage(10 * time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, "output", msg)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"path"
+)
+
+//OrderPolicy reorders the pending message files before they are consumed by a directory poll
+//names are in the sending order of the peer, see Options.SequenceOrder; isControl reads the file to tell a control message apart
+//the policy applies to the backlog found on disk only, a message arriving when nothing is pending is consumed right away
+type OrderPolicy func(names []string, isControl func(name string) bool) []string
+
+//OrderFIFO delivers the messages in the sending order, it's the default policy
+//both the payloads and the control messages keep the order they were sent in
+func OrderFIFO(names []string, isControl func(name string) bool) []string {
+	return names
+}
+
+//OrderControlFirst delivers the pending control messages ahead of the pending payloads, e.g. a cancel overtakes queued output
+//the priority payloads are ordered as control messages, see SendPriority()
+//the payloads keep their sending order among themselves, so do the control messages; it costs an extra read of every pending file
+func OrderControlFirst(names []string, isControl func(name string) bool) []string {
+	ordered := make([]string, 0, len(names))
+	var payloads []string
+	for _, name := range names {
+		if isControl(name) {
+			ordered = append(ordered, name)
+		} else {
+			payloads = append(payloads, name)
+		}
+	}
+	return append(ordered, payloads...)
+}
+
+//check whether the message file under the channel directory carries a control message or a priority payload, see SendPriority()
+//the end of stream is ordered as a payload, so that it never overtakes the payloads sent before it
+func (ch *fileWatcherChannel) isControlFile(name string) bool {
+	content, err := ioutil.ReadFile(path.Join(ch.path, name))
+	if err != nil {
+		return false
+	}
+	env, err := decodeEnvelope(string(content))
+	if err != nil {
+		return false
+	}
+	return env.Priority || (env.Control != nil && env.Control.Type != ControlEndOfStream)
+}
@@ -0,0 +1,64 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testOrderPolicy(t *testing.T, order OrderPolicy) (cancelled bool) {
+	dir, err := ioutil.TempDir(".", "order")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ch := newTestChannelAt(t, ModeMaster, dir, Options{Order: order})
+	//the consumer is slow, only one payload fits in the go channel
+	ch.onMessageChan = make(chan string, 1)
+	for i := 0; i < 3; i++ {
+		dropMessage(t, dir, fmt.Sprintf("worker-20170101000000-%03d", i), fmt.Sprintf("output%v", i))
+	}
+	cancel, err := encodeEnvelope(envelope{Control: &ControlMessage{Type: ControlCancel}})
+	assert.NoError(t, err)
+	dropMessage(t, dir, "worker-20170101000000-003", cancel)
+
+	go ch.consumeAll()
+	select {
+	case <-ch.controlChan:
+		cancelled = true
+	case <-time.After(200 * time.Millisecond):
+	}
+	//drain the backlog, the payloads keep their order under any policy
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, fmt.Sprintf("output%v", i), <-ch.onMessageChan)
+	}
+	if !cancelled {
+		<-ch.controlChan
+	}
+	return
+}
+
+func TestOrderControlFirst(t *testing.T) {
+	assert.True(t, testOrderPolicy(t, OrderControlFirst))
+}
+
+func TestOrderFIFO(t *testing.T) {
+	//the cancel waits behind the payloads blocked on the slow consumer
+	assert.False(t, testOrderPolicy(t, nil))
+	assert.False(t, testOrderPolicy(t, OrderFIFO))
+}
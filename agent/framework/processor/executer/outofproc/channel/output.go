@@ -0,0 +1,119 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
+)
+
+const (
+	OutputStdout = "stdout"
+	OutputStderr = "stderr"
+)
+
+//a line longer than this is forwarded in several chunks, so that a single message stays small whatever the output looks like
+const maxOutputChunk = 32 << 10
+
+//OutputLine is a line of process output forwarded by the output bridge, the line is base64 encoded in json so that
+//binary output survives the transport
+type OutputLine struct {
+	Stream string `json:"stream"`
+	//the line without its trailing newline
+	Line []byte `json:"line"`
+	//set on every chunk of a long line but the last one, the chunks are to be concatenated
+	Partial bool `json:"partial,omitempty"`
+}
+
+//outputBridge frames what is written to it into lines and sends each of them as an OutputLine payload
+type outputBridge struct {
+	ch     Channel
+	stream string
+	mu     sync.Mutex
+	buf    []byte
+}
+
+//NewOutputBridge returns a writer forwarding the lines written to it over the channel, e.g. as the Stdout of a worker process
+//launched with proc.SpawnOptions; close it once the process exits to flush the last line if it's not newline terminated
+func NewOutputBridge(ch Channel, stream string) io.WriteCloser {
+	return &outputBridge{
+		ch:     ch,
+		stream: stream,
+	}
+}
+
+func (b *outputBridge) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	for {
+		if i := bytes.IndexByte(b.buf, '\n'); i >= 0 && i <= maxOutputChunk {
+			if err := b.send(b.buf[:i], false); err != nil {
+				return 0, err
+			}
+			b.buf = b.buf[i+1:]
+			continue
+		}
+		if len(b.buf) <= maxOutputChunk {
+			break
+		}
+		if err := b.send(b.buf[:maxOutputChunk], true); err != nil {
+			return 0, err
+		}
+		b.buf = b.buf[maxOutputChunk:]
+	}
+	//do not keep growing the backing array of a long running output
+	b.buf = append([]byte(nil), b.buf...)
+	return len(p), nil
+}
+
+//Close flushes the pending unterminated line, the channel is left open
+func (b *outputBridge) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.buf) == 0 {
+		return nil
+	}
+	err := b.send(b.buf, false)
+	b.buf = nil
+	return err
+}
+
+func (b *outputBridge) send(line []byte, partial bool) error {
+	datagram, err := jsonutil.Marshal(OutputLine{Stream: b.stream, Line: line, Partial: partial})
+	if err != nil {
+		return err
+	}
+	return b.ch.Send(datagram)
+}
+
+//ParseOutputLine decodes a payload sent by the output bridge
+func ParseOutputLine(datagram string) (line OutputLine, err error) {
+	err = jsonutil.Unmarshal(datagram, &line)
+	return
+}
+
+//ReadOutputLine decodes a payload sent by the output bridge and received through GetStream(), it closes the reader
+func ReadOutputLine(r io.ReadCloser) (OutputLine, error) {
+	defer r.Close()
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return OutputLine{}, err
+	}
+	return ParseOutputLine(string(content))
+}
@@ -0,0 +1,107 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//records the sent payloads, only Send() is used by the output bridge
+type sendRecorder struct {
+	Channel
+	sent []string
+}
+
+func (r *sendRecorder) Send(rawJson string) error {
+	r.sent = append(r.sent, rawJson)
+	return nil
+}
+
+func (r *sendRecorder) lines(t *testing.T) []OutputLine {
+	var lines []OutputLine
+	for _, datagram := range r.sent {
+		line, err := ParseOutputLine(datagram)
+		assert.NoError(t, err)
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestOutputBridgeFramesLines(t *testing.T) {
+	recorder := &sendRecorder{}
+	bridge := NewOutputBridge(recorder, OutputStdout)
+	//lines split across writes are reassembled
+	for _, write := range []string{"first\nsec", "ond\n", "\nthird"} {
+		n, err := bridge.Write([]byte(write))
+		assert.NoError(t, err)
+		assert.Equal(t, len(write), n)
+	}
+	assert.Len(t, recorder.sent, 3)
+	//the unterminated line is flushed at close
+	assert.NoError(t, bridge.Close())
+	lines := recorder.lines(t)
+	assert.Len(t, lines, 4)
+	for i, expected := range []string{"first", "second", "", "third"} {
+		assert.Equal(t, OutputLine{Stream: OutputStdout, Line: []byte(expected)}, lines[i])
+	}
+}
+
+func TestOutputBridgeChunksLongBinaryLine(t *testing.T) {
+	recorder := &sendRecorder{}
+	bridge := NewOutputBridge(recorder, OutputStderr)
+	long := bytes.Repeat([]byte{0xff, 0x00, 'x'}, maxOutputChunk)
+	_, err := bridge.Write(append(long, '\n'))
+	assert.NoError(t, err)
+	assert.NoError(t, bridge.Close())
+
+	var joined []byte
+	lines := recorder.lines(t)
+	assert.Len(t, lines, 3)
+	for i, line := range lines {
+		assert.Equal(t, OutputStderr, line.Stream)
+		assert.Equal(t, i < len(lines)-1, line.Partial)
+		assert.True(t, len(line.Line) <= maxOutputChunk)
+		joined = append(joined, line.Line...)
+	}
+	assert.Equal(t, long, joined)
+}
+
+//a chunk above the stream threshold of the receiver is read through GetStream()
+func TestOutputBridgeStreamedReceive(t *testing.T) {
+	pair := newChannelPair(t, harnessVariant{master: Options{StreamThreshold: 1024}})
+	defer pair.cleanup()
+	master, worker := pair.master, pair.worker
+
+	bridge := NewOutputBridge(worker, OutputStdout)
+	_, err := bridge.Write([]byte(strings.Repeat("x", 2048) + "\nshort\n"))
+	assert.NoError(t, err)
+	select {
+	case r := <-master.GetStream():
+		line, err := ReadOutputLine(r)
+		assert.NoError(t, err)
+		assert.Equal(t, strings.Repeat("x", 2048), string(line.Line))
+	case <-time.After(5 * time.Second):
+		t.Fatal("long line is not streamed")
+	}
+	msg, err := master.WaitForMessage(5 * time.Second)
+	assert.NoError(t, err)
+	line, err := ParseOutputLine(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, "short", string(line.Line))
+}
@@ -0,0 +1,110 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	//the token of the channel responsible for removing the directory, kept under tmp/ so that it's never mistaken for a message
+	ownerFileName = "owner"
+)
+
+//how long ClaimOwnership() waits for the ownership lock held by another channel, injected by the tests
+var ownerLockTimeout = 2 * time.Second
+
+//tells apart the channels opened by the same process on the same directory
+var ownerTokenCounter int32
+
+//the token identifying this channel as the owner of the directory, unique across the processes and the reopens
+func newOwnerToken(mode Mode) string {
+	return fmt.Sprintf("%v-%v-%v-%v", mode, os.Getpid(), time.Now().UnixNano(), atomic.AddInt32(&ownerTokenCounter, 1))
+}
+
+func (ch *fileWatcherChannel) ownerPath() string {
+	return path.Join(ch.tmpPath, ownerFileName)
+}
+
+//ClaimOwnership makes this channel responsible for removing the directory in Destroy(), the previous owner then leaves the
+//directory in place when destroyed; a master claims it when opening the channel, e.g. when reattaching after a restart
+func (ch *fileWatcherChannel) ClaimOwnership() error {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+	if ch.closed {
+		return ErrChannelClosed
+	}
+	return ch.withOwnerLock(func() error {
+		return ch.writeOwner()
+	})
+}
+
+//IsOwner returns whether Destroy() removes the directory, i.e. this channel holds the ownership token, or no channel does
+//and this is the master end
+func (ch *fileWatcherChannel) IsOwner() bool {
+	content, err := ioutil.ReadFile(ch.ownerPath())
+	if os.IsNotExist(err) {
+		return ch.mode == ModeMaster
+	} else if err != nil {
+		ch.logger.Errorf("failed to read the owner of channel %v, falling back to the master: %v", ch.path, err)
+		return ch.mode == ModeMaster
+	}
+	return string(content) == ch.ownerToken
+}
+
+//write the token of this channel in place of the current owner's, the caller must hold the ownership lock
+func (ch *fileWatcherChannel) writeOwner() error {
+	tmpPath := ch.ownerPath() + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, []byte(ch.ownerToken), defaultFileWriteMode); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, ch.ownerPath()); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	ch.logger.Debugf("channel %v is owned by %v", ch.path, ch.ownerToken)
+	return nil
+}
+
+//run fn holding the ownership lock, so that the concurrent claims do not interleave; a lock left over by a crashed process
+//is taken over once stale
+func (ch *fileWatcherChannel) withOwnerLock(fn func() error) error {
+	lockPath := ch.ownerPath() + lockFileSuffix
+	deadline := time.Now().Add(ownerLockTimeout)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, defaultFileWriteMode)
+		if err == nil {
+			f.Close()
+			defer os.Remove(lockPath)
+			return fn()
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > lockStaleTimeout {
+			ch.logger.Errorf("ignoring stale ownership lock of channel %v", ch.path)
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return ErrOwnershipLocked
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOwnershipTransfer(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "ownership")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "channel")
+	previous, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, name)
+	assert.NoError(t, err)
+	assert.True(t, previous.IsOwner())
+	worker, err := NewFileWatcherChannel(log.NewMockLog(), ModeWorker, name)
+	assert.NoError(t, err)
+	assert.False(t, worker.IsOwner())
+
+	//the master restarts and reattaches to the channel while the previous one is still around
+	reattached, err := ReopenFileWatcherChannel(log.NewMockLog(), ModeMaster, name)
+	assert.NoError(t, err)
+	assert.True(t, reattached.IsOwner())
+	assert.False(t, previous.IsOwner())
+	//the ownership can be handed back and forth explicitly
+	assert.NoError(t, previous.ClaimOwnership())
+	assert.True(t, previous.IsOwner())
+	assert.False(t, reattached.IsOwner())
+	assert.NoError(t, reattached.ClaimOwnership())
+	assert.False(t, previous.IsOwner())
+
+	//the demoted master leaves the directory to the new owner
+	previous.Destroy()
+	_, err = os.Stat(name)
+	assert.NoError(t, err)
+	worker.Destroy()
+	_, err = os.Stat(name)
+	assert.NoError(t, err)
+	reattached.Destroy()
+	_, err = os.Stat(name)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestOwnershipWithoutToken(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "ownership")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "channel")
+	ch, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, name)
+	assert.NoError(t, err)
+	//a directory created by an older agent has no token, the master owns it
+	assert.NoError(t, os.Remove(ch.ownerPath()))
+	assert.True(t, ch.IsOwner())
+	ch.Destroy()
+	_, err = os.Stat(name)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestClaimOwnershipLocked(t *testing.T) {
+	defer func(timeout time.Duration) { ownerLockTimeout = timeout }(ownerLockTimeout)
+	ownerLockTimeout = 100 * time.Millisecond
+	//unlike the master, the worker does not own a directory without a token
+	ch := newTestChannel(t, ModeWorker, Options{})
+	defer os.RemoveAll(ch.path)
+	ch.ownerToken = newOwnerToken(ModeWorker)
+	lockPath := ch.ownerPath() + lockFileSuffix
+	assert.NoError(t, ioutil.WriteFile(lockPath, nil, defaultFileWriteMode))
+	assert.Equal(t, ErrOwnershipLocked, ch.ClaimOwnership())
+	assert.False(t, ch.IsOwner())
+
+	//the lock of a crashed process is taken over once stale
+	stale := time.Now().Add(-2 * lockStaleTimeout)
+	assert.NoError(t, os.Chtimes(lockPath, stale, stale))
+	assert.NoError(t, ch.ClaimOwnership())
+	assert.True(t, ch.IsOwner())
+	_, err := os.Stat(lockPath)
+	assert.True(t, os.IsNotExist(err))
+}
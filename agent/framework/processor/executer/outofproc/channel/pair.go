@@ -0,0 +1,92 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"bytes"
+	"crypto/aes"
+	"errors"
+	"fmt"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+//ErrMispaired is returned when the two ends of a channel would not talk to each other, e.g. both opened in the same mode
+var ErrMispaired = errors.New("channel ends are not paired")
+
+//PairConfig is what the worker needs to open its end of a channel opened by NewMasterChannel(), pass it to OpenWorkerChannel()
+//the encryption key must reach the worker over a private pipe rather than its command line
+type PairConfig struct {
+	//the directory of the channel, the same for both ends
+	Name string
+	//the mode of the worker end, the opposite of the master one
+	Mode Mode
+	//the capabilities the master offers to Handshake(), the worker offers the same
+	Capabilities []Capability
+	//Options.EncryptionKey of both ends, required if CapabilityEncryption is offered
+	EncryptionKey []byte
+}
+
+//Validate checks the config describes the worker end of a channel the master is able to talk to
+func (c PairConfig) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("%v: the channel name is empty", ErrMispaired)
+	}
+	if c.Mode != ModeWorker {
+		return fmt.Errorf("%v: the worker end is in mode %q instead of %q", ErrMispaired, c.Mode, ModeWorker)
+	}
+	for _, capability := range c.Capabilities {
+		if capability != CapabilityEncryption && !hasCapability(SupportedCapabilities, capability) {
+			return fmt.Errorf("%v: unknown capability %q", ErrMispaired, capability)
+		}
+	}
+	if hasCapability(c.Capabilities, CapabilityEncryption) {
+		if _, err := aes.NewCipher(c.EncryptionKey); err != nil {
+			return fmt.Errorf("%v: encryption is offered with an invalid key: %v", ErrMispaired, err)
+		}
+	}
+	return nil
+}
+
+//NewMasterChannel opens the master end of the named channel and returns the config the worker opens its end with, so that
+//the name, the modes and the shared key of both ends cannot drift apart; options.EncryptionKey is the shared key
+func NewMasterChannel(logger log.T, name string, options Options, capabilities []Capability) (*fileWatcherChannel, PairConfig, error) {
+	config := PairConfig{
+		Name:          name,
+		Mode:          ModeWorker,
+		Capabilities:  append([]Capability(nil), capabilities...),
+		EncryptionKey: options.EncryptionKey,
+	}
+	if err := config.Validate(); err != nil {
+		logger.Errorf("refusing to open channel %v: %v", name, err)
+		return nil, config, err
+	}
+	ch, err := NewFileWatcherChannelWithOptions(logger, ModeMaster, name, options)
+	return ch, config, err
+}
+
+//OpenWorkerChannel opens the worker end described by the config, it fails rather than creating another directory if the
+//master end does not exist under the name; options.EncryptionKey, if set, must be the key of the config
+func OpenWorkerChannel(logger log.T, config PairConfig, options Options) (*fileWatcherChannel, error) {
+	err := config.Validate()
+	if err == nil && len(options.EncryptionKey) > 0 && !bytes.Equal(options.EncryptionKey, config.EncryptionKey) {
+		err = fmt.Errorf("%v: the encryption key differs from the master one", ErrMispaired)
+	}
+	if err != nil {
+		logger.Errorf("refusing to open channel %v: %v", config.Name, err)
+		return nil, err
+	}
+	options.EncryptionKey = config.EncryptionKey
+	return reopenFileWatcherChannel(logger, ModeWorker, config.Name, options)
+}
@@ -0,0 +1,79 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPairRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "pair")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	key := []byte("0123456789abcdef")
+	master, config, err := NewMasterChannel(log.NewMockLog(), path.Join(dir, "channel"), Options{EncryptionKey: key}, SupportedCapabilities)
+	assert.NoError(t, err)
+	defer master.Destroy()
+	assert.Equal(t, ModeWorker, config.Mode)
+	worker, err := OpenWorkerChannel(log.NewMockLog(), config, Options{})
+	assert.NoError(t, err)
+	defer worker.Close()
+	assert.Equal(t, key, worker.options.EncryptionKey)
+
+	assert.NoError(t, master.Send("request"))
+	msg, err := worker.WaitForMessage(5 * time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "request", msg)
+	assert.NoError(t, worker.Send("response"))
+	msg, err = master.WaitForMessage(5 * time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "response", msg)
+}
+
+func TestPairMispaired(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "pair")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "channel")
+	master, config, err := NewMasterChannel(log.NewMockLog(), name, Options{}, []Capability{CapabilityEnvelope})
+	assert.NoError(t, err)
+	defer master.Destroy()
+
+	sameMode := config
+	sameMode.Mode = ModeMaster
+	_, err = OpenWorkerChannel(log.NewMockLog(), sameMode, Options{})
+	assert.Contains(t, err.Error(), ErrMispaired.Error())
+
+	//the worker must not create a directory of its own no master is watching
+	wrongName := config
+	wrongName.Name = path.Join(dir, "other")
+	_, err = OpenWorkerChannel(log.NewMockLog(), wrongName, Options{})
+	assert.Error(t, err)
+	_, err = os.Stat(wrongName.Name)
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = OpenWorkerChannel(log.NewMockLog(), config, Options{EncryptionKey: []byte("0123456789abcdef")})
+	assert.Contains(t, err.Error(), ErrMispaired.Error())
+
+	//encryption is offered without a usable key
+	_, _, err = NewMasterChannel(log.NewMockLog(), path.Join(dir, "sealed"), Options{}, []Capability{CapabilityEncryption})
+	assert.Contains(t, err.Error(), ErrMispaired.Error())
+}
@@ -0,0 +1,74 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+const (
+	//a name component is at most 255 bytes on the common file systems of every platform
+	maxNameLength = 255
+	//the digits of the longest stamp, i.e. a unix nano timestamp, and of the longest counter of a sequence id
+	maxStampDigits   = 20
+	maxCounterDigits = 10
+	//the digits of a pid and of the tmp file counter, see tmpFileName()
+	maxPidDigits        = 10
+	maxTmpCounterDigits = 19
+)
+
+//longestFileName returns the length of the longest file name the channel of the given mode creates, the names are bounded
+//since they are made of the mode, a stamp and counters, so that a channel path too long for them is rejected up front
+//rather than failing a Send() of a random message
+func longestFileName(mode Mode) int {
+	id := len(mode) + len("-") + maxStampDigits + len("-") + maxCounterDigits
+	longest := id + len(lockFileSuffix)
+	for _, prefix := range []string{deadLetterPrefix, quarantinePrefix, compactingFilePrefix, streamFilePrefix, lazyFilePrefix} {
+		if n := len(prefix) + id; n > longest {
+			longest = n
+		}
+	}
+	if n := len(sendingFilePrefix) + len(mode) + len("-") + maxPidDigits + len("-") + maxTmpCounterDigits; n > longest {
+		longest = n
+	}
+	return longest
+}
+
+//checkPathLength checks that every file the channel of the given mode creates under name fits the platform limits
+func checkPathLength(log log.T, mode Mode, name string) error {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return err
+	}
+	for _, component := range strings.Split(filepath.ToSlash(abs), "/") {
+		if len(component) > maxNameLength {
+			log.Errorf("name %.32v... of channel path %v is %v bytes long, the limit is %v", component, abs, len(component), maxNameLength)
+			return ErrPathTooLong
+		}
+	}
+	longest := longestFileName(mode)
+	if longest > maxNameLength {
+		log.Errorf("mode %v makes file names of up to %v bytes, the limit is %v", mode, longest, maxNameLength)
+		return ErrPathTooLong
+	}
+	//the tmp files are the deepest ones
+	if n := len(filepath.Join(abs, "tmp")) + len("/") + longest; n > maxPathLength {
+		log.Errorf("channel path %v makes paths of up to %v bytes, the limit is %v", abs, n, maxPathLength)
+		return ErrPathTooLong
+	}
+	return nil
+}
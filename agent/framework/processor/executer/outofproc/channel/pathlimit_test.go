@@ -0,0 +1,90 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+//nest directories under dir up to a path of exactly the given length
+func longChannelPath(t *testing.T, dir string, length int) string {
+	name, err := filepath.Abs(dir)
+	assert.NoError(t, err)
+	for len(name) < length {
+		//keep room for the separator and a name of at least one byte in the last step
+		n := length - len(name) - 1
+		if n > 200 {
+			n = 200
+			if length-len(name)-1-n < 2 {
+				n -= 2
+			}
+		}
+		name = filepath.Join(name, strings.Repeat("d", n))
+	}
+	assert.Equal(t, length, len(name))
+	return name
+}
+
+func TestLongChannelPathAtLimit(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "pathlimit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := longChannelPath(t, dir, maxPathLength-len("/tmp/")-longestFileName(ModeMaster))
+	master, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, name)
+	assert.NoError(t, err)
+	defer master.Destroy()
+	worker, err := NewFileWatcherChannel(log.NewMockLog(), ModeWorker, name)
+	assert.NoError(t, err)
+	defer worker.Close()
+
+	assert.NoError(t, master.Send("hello"))
+	select {
+	case msg := <-worker.GetMessage():
+		assert.Equal(t, "hello", msg)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message is not delivered")
+	}
+}
+
+func TestLongChannelPathRejected(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "pathlimit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := longChannelPath(t, dir, maxPathLength-len("/tmp/")-longestFileName(ModeMaster)+1)
+	_, err = NewFileWatcherChannel(log.NewMockLog(), ModeMaster, name)
+	assert.Equal(t, ErrPathTooLong, err)
+	//nothing is created on the way
+	_, err = os.Stat(filepath.Dir(name))
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = NewFileWatcherChannel(log.NewMockLog(), ModeMaster, filepath.Join(dir, strings.Repeat("d", maxNameLength+1)))
+	assert.Equal(t, ErrPathTooLong, err)
+	_, err = NewFileWatcherChannel(log.NewMockLog(), Mode(strings.Repeat("m", maxNameLength)), filepath.Join(dir, "channel"))
+	assert.Equal(t, ErrPathTooLong, err)
+
+	//the channel stays where it is when moved to a path too long
+	ch, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, filepath.Join(dir, "channel"))
+	assert.NoError(t, err)
+	defer ch.Destroy()
+	assert.Equal(t, ErrPathTooLong, ch.MoveChannel(name))
+	assert.NoError(t, ch.Send("hello"))
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"os"
+	"path"
+)
+
+//PendingMessage is a message of the peer waiting in the channel directory, as reported by PeekPending()
+type PendingMessage struct {
+	//the sequence id of the message, i.e. its file name
+	ID string
+	//the type of a control message, empty for a payload
+	Control ControlType
+	//the payload, or the content of a control message, unwrapped from its envelope
+	Content string
+	//the size of the content, also reported when the content is left out
+	Size int
+	//whether the content is left out since the byte cap was reached
+	Truncated bool
+}
+
+//PeekPending lists the messages of the peer waiting in the channel directory in sequence order, without consuming
+//them, e.g. to capture the state of a hung document; the files and the receiving counter are left untouched.
+//The content is returned up to maxBytes in total, the messages after the cap are listed with their id and size only.
+//The messages of a compacted file are listed one by one, the ones already handed over to the consumer are not listed
+func (ch *fileWatcherChannel) PeekPending(maxBytes int) ([]PendingMessage, error) {
+	ch.mu.RLock()
+	dir := ch.path
+	ch.mu.RUnlock()
+	fileInfos, err := ch.fs().ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var messages []PendingMessage
+	budget := maxBytes
+	//the messages left over by an interrupted compaction are listed once
+	compacted := make(map[string]bool)
+	add := func(id, content string) {
+		message := PendingMessage{ID: id, Content: content}
+		if env, err := decodeEnvelope(content); err == nil {
+			message.Content = env.Payload
+			if env.Control != nil {
+				message.Control = env.Control.Type
+				message.Content = env.Control.Content
+			}
+		}
+		message.Size = len(message.Content)
+		if message.Size > budget {
+			message.Content = ""
+			message.Truncated = true
+		} else {
+			budget -= message.Size
+		}
+		messages = append(messages, message)
+	}
+	var names []string
+	for _, info := range fileInfos {
+		if ch.isReadable(info.Name()) {
+			names = append(names, info.Name())
+		}
+	}
+	ch.sortSequenceNames(names)
+	for _, name := range names {
+		if compacted[name] {
+			continue
+		}
+		content, err := ch.fs().ReadFile(path.Join(dir, name))
+		if os.IsNotExist(err) {
+			//consumed meanwhile
+			continue
+		} else if err != nil {
+			return messages, err
+		}
+		if !isCompacted(string(content)) {
+			add(name, string(content))
+			continue
+		}
+		frames, err := decodeCompacted(string(content))
+		if err != nil {
+			return messages, err
+		}
+		for _, frame := range frames {
+			compacted[frame.name] = true
+			add(frame.name, frame.content)
+		}
+	}
+	return messages, nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeekPending(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(ch.path)
+	dropMessage(t, ch.path, sequenceName(0), "first")
+	wrapped, err := encodeEnvelope(envelope{SentAt: 1, Payload: "second"})
+	assert.NoError(t, err)
+	dropMessage(t, ch.path, sequenceName(1), wrapped)
+	control, err := encodeEnvelope(envelope{Control: &ControlMessage{Type: ControlCancel, Content: "now"}})
+	assert.NoError(t, err)
+	dropMessage(t, ch.path, sequenceName(2), control)
+	dropMessage(t, ch.path, sequenceName(3), strings.Repeat("l", 100))
+	dropMessage(t, ch.path, sequenceName(4), "last")
+	//the own messages are not pending for this end
+	assert.NoError(t, ch.Send("sent"))
+
+	messages, err := ch.PeekPending(20)
+	assert.NoError(t, err)
+	assert.Equal(t, []PendingMessage{
+		{ID: sequenceName(0), Content: "first", Size: 5},
+		{ID: sequenceName(1), Content: "second", Size: 6},
+		{ID: sequenceName(2), Control: ControlCancel, Content: "now", Size: 3},
+		{ID: sequenceName(3), Size: 100, Truncated: true},
+		{ID: sequenceName(4), Content: "last", Size: 4},
+	}, messages)
+	//nothing is consumed
+	assert.Equal(t, 0, ch.recvCounter)
+	files, err := ioutil.ReadDir(ch.path)
+	assert.NoError(t, err)
+	//the five messages, the sent one and the tmp directory
+	assert.Len(t, files, 7)
+	assert.Empty(t, ch.onMessageChan)
+	assert.Empty(t, ch.controlChan)
+
+	//the messages of a compacted file are listed one by one
+	compacted, err := ch.Compact()
+	assert.NoError(t, err)
+	assert.Equal(t, 5, compacted)
+	messages, err = ch.PeekPending(0)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 5)
+	assert.Equal(t, sequenceName(4), messages[4].ID)
+	assert.True(t, messages[4].Truncated)
+	assert.Equal(t, 0, ch.recvCounter)
+}
@@ -0,0 +1,37 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"sync/atomic"
+)
+
+//the number of SendPriority() calls in a row sent ahead of the pending payloads, the next one is sent in the sending order
+//so that a flood of priority messages cannot hold back the payloads queued behind it forever
+const maxPriorityBurst = 8
+
+//SendPriority sends a payload the peer consumes ahead of the messages pending on disk, e.g. an urgent instruction queued
+//behind a large output; the peer must consume with Options.Order set to OrderControlFirst, which orders a priority payload as
+//a control message, i.e. behind the control and priority messages sent before it. It does not overtake the payloads already
+//buffered in the GetMessage() go channel of the peer. A peer with the default OrderFIFO consumes it in the sending order,
+//so does a legacy peer, which unwraps the envelope all the same.
+//Past maxPriorityBurst priority sends in a row, the payload is sent in the sending order, until a regular Send() resets the burst
+func (ch *fileWatcherChannel) SendPriority(rawJson string) error {
+	env := envelope{Payload: rawJson, Priority: true}
+	if atomic.AddInt32(&ch.priorityBurst, 1) > maxPriorityBurst {
+		ch.logger.Infof("%v priority messages sent in a row, sending the next one in order", maxPriorityBurst)
+		env.Priority = false
+	}
+	return ch.send(env)
+}
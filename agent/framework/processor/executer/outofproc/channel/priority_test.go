@@ -0,0 +1,71 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriorityConsumedFirst(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "priority")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "channel")
+	sender := newTestChannel(t, ModeWorker, Options{})
+	os.RemoveAll(sender.path)
+	sender.path, sender.tmpPath = name, path.Join(name, "tmp")
+	assert.NoError(t, os.MkdirAll(sender.tmpPath, defaultFileCreateMode))
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, sender.Send(fmt.Sprintf("output%v", i)))
+	}
+	assert.NoError(t, sender.SendPriority("urgent"))
+
+	receiver := newTestChannel(t, ModeMaster, Options{Order: OrderControlFirst})
+	os.RemoveAll(receiver.path)
+	receiver.path, receiver.tmpPath = name, sender.tmpPath
+	receiver.consumeAll()
+	assert.Equal(t, "urgent", <-receiver.onMessageChan)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, fmt.Sprintf("output%v", i), <-receiver.onMessageChan)
+	}
+	//the messages after it are consumed as usual
+	assert.NoError(t, sender.Send("output3"))
+	receiver.onCreate(path.Join(name, sentName(4)))
+	assert.Equal(t, "output3", <-receiver.onMessageChan)
+}
+
+//the name of the file of the given message sent by a test channel
+func sentName(counter int) string {
+	return SequenceID{Mode: ModeWorker, Stamp: "20170101000000", Counter: counter}.String()
+}
+
+func TestPriorityBurstFallsBackToOrder(t *testing.T) {
+	ch := newTestChannel(t, ModeWorker, Options{})
+	defer os.RemoveAll(ch.path)
+	for i := 0; i <= maxPriorityBurst; i++ {
+		assert.NoError(t, ch.SendPriority("urgent"))
+	}
+	assert.True(t, ch.isControlFile(sentName(maxPriorityBurst-1)))
+	//a flood of priority messages does not starve the ones sent in order
+	assert.False(t, ch.isControlFile(sentName(maxPriorityBurst)))
+	assert.NoError(t, ch.Send("output"))
+	assert.NoError(t, ch.SendPriority("urgent"))
+	assert.True(t, ch.isControlFile(sentName(maxPriorityBurst+2)))
+}
@@ -0,0 +1,110 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//how often Quiesce() checks whether the pending messages are delivered, injected by the tests
+var quiescePollInterval = 50 * time.Millisecond
+
+//Quiesce prepares the channel for a graceful shutdown: the sends are refused with ErrChannelClosed from then on, and the
+//messages left on disk or buffered in the go channels keep being delivered until the consumers took all of them.
+//It returns ErrQuiesceTimeout if they are not taken within the timeout, the channel is left open either way, call Close()
+//or Destroy() afterwards
+func (ch *fileWatcherChannel) Quiesce(timeout time.Duration) error {
+	log := ch.logger
+	ch.mu.Lock()
+	if ch.closed {
+		ch.mu.Unlock()
+		return ErrChannelClosed
+	}
+	ch.quiescing = true
+	ch.mu.Unlock()
+	log.Infof("quiescing channel %v", ch.path)
+	deadline := time.Now().Add(timeout)
+	for {
+		//the watcher may have missed the messages dropped right before, poll the directory as well
+		ch.consumeAll()
+		count, _ := ch.pending()
+		buffered := ch.buffered()
+		if count == 0 && buffered == 0 {
+			log.Infof("channel %v quiesced", ch.path)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			log.Errorf("channel %v failed to quiesce within %v, %v messages pending and %v buffered", ch.path, timeout, count, buffered)
+			return ErrQuiesceTimeout
+		}
+		time.Sleep(quiescePollInterval)
+	}
+}
+
+//the number of messages delivered to the go channels and not taken by the consumers yet
+func (ch *fileWatcherChannel) buffered() int {
+	return len(ch.onMessageChan) + len(ch.pendingChan) + int(atomic.LoadInt32(&ch.pendingHeld)) + len(ch.controlChan) +
+		len(ch.messageChan) + len(ch.streamChan) + len(ch.fileChan)
+}
+
+//WaitIdle blocks until the channel is idle: no message file of either end is waiting in the channel directory, none is being
+//written by a sender of either end, none is being consumed and none is buffered in the go channels. Unlike Quiesce() the sends
+//are not refused, a send starting afterwards makes the channel busy again.
+//It returns ErrIdleTimeout if the channel is not idle within the timeout; it does not consume the messages itself, a message
+//missed by the watcher keeps the channel busy
+func (ch *fileWatcherChannel) WaitIdle(timeout time.Duration) error {
+	if ch.isClosed() {
+		return ErrChannelClosed
+	}
+	deadline := time.Now().Add(timeout)
+	for !ch.idle() {
+		if time.Now().After(deadline) {
+			ch.logger.Errorf("channel %v is still busy after %v", ch.path, timeout)
+			return ErrIdleTimeout
+		}
+		time.Sleep(quiescePollInterval)
+	}
+	return nil
+}
+
+//whether the channel is idle, see WaitIdle(); a message moves from the tmp directory to the channel directory, then to the
+//consuming go-routine and the go channels, so that each place is looked at after the one the message comes from
+func (ch *fileWatcherChannel) idle() bool {
+	ch.mu.RLock()
+	dir, tmpDir := ch.path, ch.tmpPath
+	ch.mu.RUnlock()
+	//the leftover of a sender that crashed mid-write is not a send in flight
+	infos, _ := ioutil.ReadDir(tmpDir)
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), sendingFilePrefix) && time.Since(info.ModTime()) <= lockStaleTimeout {
+			return false
+		}
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return false
+	}
+	names, _ := f.Readdirnames(-1)
+	f.Close()
+	for _, name := range names {
+		if messageNamePattern.MatchString(name) && !strings.Contains(name, "tmp") {
+			return false
+		}
+	}
+	return atomic.LoadInt32(&ch.consuming) == 0 && ch.buffered() == 0
+}
@@ -0,0 +1,137 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//the messages in flight are delivered while quiescing, the new sends are refused
+func TestQuiesceDeliversInFlightMessages(t *testing.T) {
+	defer func(interval time.Duration) { quiescePollInterval = interval }(quiescePollInterval)
+	quiescePollInterval = 10 * time.Millisecond
+	ch := newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(ch.path)
+	expected := []string{"m0", "m1", "m2"}
+	for i, msg := range expected {
+		dropMessage(t, ch.path, sequenceName(i), msg)
+	}
+	//a slow consumer takes the messages one by one
+	received := make(chan []string)
+	messages := ch.GetMessage()
+	go func() {
+		var taken []string
+		for len(taken) < len(expected) {
+			time.Sleep(20 * time.Millisecond)
+			taken = append(taken, <-messages)
+		}
+		received <- taken
+	}()
+
+	assert.NoError(t, ch.Quiesce(5*time.Second))
+	assert.Equal(t, expected, <-received)
+	count, _ := ch.pending()
+	assert.Equal(t, 0, count)
+	assert.Equal(t, ErrChannelClosed, ch.Send("refused"))
+	_, err := ch.SendStream()
+	assert.Equal(t, ErrChannelClosed, err)
+	//the channel is still open until closed
+	assert.False(t, ch.isClosed())
+	ch.mu.Lock()
+	ch.closed = true
+	ch.mu.Unlock()
+	assert.Equal(t, ErrChannelClosed, ch.Quiesce(time.Second))
+}
+
+func TestQuiesceTimeout(t *testing.T) {
+	defer func(interval time.Duration) { quiescePollInterval = interval }(quiescePollInterval)
+	quiescePollInterval = 10 * time.Millisecond
+	ch := newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(ch.path)
+	dropMessage(t, ch.path, sequenceName(0), "never taken")
+	//nobody consumes the buffered message
+	assert.Equal(t, ErrQuiesceTimeout, ch.Quiesce(50*time.Millisecond))
+	assert.Equal(t, 1, ch.buffered())
+}
+
+//the consumer has taken every message sent by the producer once the channel is idle
+func TestWaitIdleSynchronizesProducerConsumer(t *testing.T) {
+	defer func(interval time.Duration) { quiescePollInterval = interval }(quiescePollInterval)
+	quiescePollInterval = 10 * time.Millisecond
+	pair := newChannelPair(t, harnessVariant{})
+	defer pair.cleanup()
+	master, worker := pair.master, pair.worker
+
+	const count = 20
+	var mu sync.Mutex
+	var taken []string
+	messages := master.GetMessage()
+	go func() {
+		for msg := range messages {
+			mu.Lock()
+			taken = append(taken, msg)
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	var producer sync.WaitGroup
+	producer.Add(1)
+	go func() {
+		defer producer.Done()
+		for i := 0; i < count; i++ {
+			assert.NoError(t, worker.Send(fmt.Sprintf("m%v", i)))
+		}
+	}()
+	producer.Wait()
+	assert.NoError(t, master.WaitIdle(5*time.Second))
+	assert.NoError(t, worker.WaitIdle(time.Second))
+	//the last message may be taken and not appended yet
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	assert.Len(t, taken, count)
+	mu.Unlock()
+}
+
+func TestWaitIdle(t *testing.T) {
+	defer func(interval time.Duration) { quiescePollInterval = interval }(quiescePollInterval)
+	quiescePollInterval = 10 * time.Millisecond
+	ch := newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(ch.path)
+	assert.NoError(t, ch.WaitIdle(time.Second))
+
+	//a message being written by the peer
+	sending := path.Join(ch.tmpPath, sendingFilePrefix+"worker-1-1")
+	assert.NoError(t, ioutil.WriteFile(sending, []byte("m0"), defaultFileWriteMode))
+	assert.Equal(t, ErrIdleTimeout, ch.WaitIdle(30*time.Millisecond))
+	//unless the sender is long gone
+	stale := time.Now().Add(-2 * lockStaleTimeout)
+	assert.NoError(t, os.Chtimes(sending, stale, stale))
+	assert.NoError(t, ch.WaitIdle(time.Second))
+
+	//a message waiting on disk, then buffered and not taken
+	dropMessage(t, ch.path, sequenceName(0), "m0")
+	assert.Equal(t, ErrIdleTimeout, ch.WaitIdle(30*time.Millisecond))
+	ch.consumeAll()
+	assert.Equal(t, ErrIdleTimeout, ch.WaitIdle(30*time.Millisecond))
+	assert.Equal(t, "m0", <-ch.onMessageChan)
+	assert.NoError(t, ch.WaitIdle(time.Second))
+}
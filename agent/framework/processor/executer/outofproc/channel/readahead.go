@@ -0,0 +1,167 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"path"
+	"sync"
+)
+
+//a pending message read ahead of its delivery
+type readResult struct {
+	done    chan struct{}
+	content string
+	err     error
+	//whether the result holds a slot of the read-ahead window
+	slot bool
+}
+
+//readAhead reads the messages of a directory scan concurrently by Options.ReadWorkers go-routines, while consume() takes them
+//in order; the results are keyed by file name until they're taken, at most twice as many as the workers are held
+type readAhead struct {
+	ch      *fileWatcherChannel
+	mu      sync.Mutex
+	results map[string]*readResult
+	slots   chan struct{}
+	jobs    chan string
+	stop    chan struct{}
+}
+
+//start reading the given files ahead, it returns nil if the read-ahead is disabled or not worth it
+//it's disabled along with the options deciding how to read a file from its first bytes or a sidecar lock
+func (ch *fileWatcherChannel) startReadAhead(names []string) *readAhead {
+	workers := ch.options.ReadWorkers
+	if workers <= 1 || len(names) < 2 || ch.options.StreamThreshold > 0 || ch.options.HandoffDir != "" || ch.options.LazyRead || ch.options.CooperativeLock {
+		return nil
+	}
+	r := &readAhead{
+		ch:      ch,
+		results: make(map[string]*readResult),
+		slots:   make(chan struct{}, 2*workers),
+		jobs:    make(chan string),
+		stop:    make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		ch.spawn(r.work)
+	}
+	ch.spawn(func() { r.dispatch(names) })
+	return r
+}
+
+//hand the files over to the workers in order, as long as there is room in the window
+func (r *readAhead) dispatch(names []string) {
+	defer close(r.jobs)
+	for _, name := range names {
+		select {
+		case r.slots <- struct{}{}:
+		case <-r.stop:
+			return
+		}
+		r.mu.Lock()
+		if _, taken := r.results[name]; taken {
+			//consume() got there first and read it itself
+			r.mu.Unlock()
+			<-r.slots
+			continue
+		}
+		r.results[name] = &readResult{done: make(chan struct{}), slot: true}
+		r.mu.Unlock()
+		select {
+		case r.jobs <- name:
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+func (r *readAhead) work() {
+	for name := range r.jobs {
+		content, err := r.ch.readFile(path.Join(r.ch.path, name))
+		r.mu.Lock()
+		result := r.results[name]
+		r.mu.Unlock()
+		if result == nil {
+			//released meanwhile
+			continue
+		}
+		result.content, result.err = content, err
+		close(result.done)
+	}
+}
+
+//take returns the content of the file if it's read ahead, waiting for its read to complete, ok is false if the caller
+//should read it itself
+func (r *readAhead) take(filepath string) (content string, err error, ok bool) {
+	if r == nil {
+		return "", nil, false
+	}
+	name := path.Base(filepath)
+	r.mu.Lock()
+	result := r.results[name]
+	if result == nil {
+		//not dispatched yet, keep the dispatcher from reading it
+		r.results[name] = &readResult{}
+		r.mu.Unlock()
+		return "", nil, false
+	}
+	r.mu.Unlock()
+	if !result.slot {
+		return "", nil, false
+	}
+	select {
+	case <-result.done:
+		return result.content, result.err, true
+	case <-r.stop:
+		return "", nil, false
+	}
+}
+
+//drop the result of a file once consume() is done with it, whether it took it or not, freeing its slot
+func (r *readAhead) release(name string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	//the placeholder of a file read by consume() itself is kept, so that the dispatcher never reads it
+	if result := r.results[name]; result != nil && result.slot {
+		delete(r.results, name)
+		<-r.slots
+	}
+}
+
+//stop reading ahead, the reads in progress complete in the background and their results are dropped
+func (r *readAhead) close() {
+	if r == nil {
+		return
+	}
+	close(r.stop)
+}
+
+//consume the given files in order until one is deferred, reading them ahead with Options.ReadWorkers
+//the caller must hold consumeMu
+func (ch *fileWatcherChannel) consumeNamesLocked(names []string) {
+	ch.readAhead = ch.startReadAhead(names)
+	defer func() {
+		ch.readAhead.close()
+		ch.readAhead = nil
+	}()
+	for _, name := range names {
+		consumed := ch.tryConsume(path.Join(ch.path, name))
+		ch.readAhead.release(name)
+		if !consumed {
+			return
+		}
+	}
+}
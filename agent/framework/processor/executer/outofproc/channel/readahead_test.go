@@ -0,0 +1,134 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//the os file system with slow reads, tracking how many of them run at once
+type slowFileSystem struct {
+	osFileSystem
+	delay   func(name string) time.Duration
+	running int32
+	peak    int32
+}
+
+func (f *slowFileSystem) ReadFile(name string) ([]byte, error) {
+	running := atomic.AddInt32(&f.running, 1)
+	defer atomic.AddInt32(&f.running, -1)
+	for {
+		peak := atomic.LoadInt32(&f.peak)
+		if running <= peak || atomic.CompareAndSwapInt32(&f.peak, peak, running) {
+			break
+		}
+	}
+	time.Sleep(f.delay(name))
+	return f.osFileSystem.ReadFile(name)
+}
+
+func TestReadAheadDeliversInOrder(t *testing.T) {
+	//the later messages are read faster than the earlier ones
+	fs := &slowFileSystem{delay: func(name string) time.Duration {
+		counter, _ := parseSequenceCounter(name)
+		return time.Duration(40-counter) * time.Millisecond / 4
+	}}
+	ch := newTestChannel(t, ModeMaster, Options{FileSystem: fs, ReadWorkers: 4})
+	defer os.RemoveAll(ch.path)
+	var expected []string
+	for i := 0; i < 40; i++ {
+		dropMessage(t, ch.path, sequenceName(i), fmt.Sprintf("message %v", i))
+		expected = append(expected, fmt.Sprintf("message %v", i))
+	}
+	result := collectMessages(ch, len(expected))
+	ch.consumeAll()
+	assert.Equal(t, expected, <-result)
+	assert.Equal(t, 40, ch.recvCounter)
+	assert.True(t, fs.peak > 1, "the reads did not overlap")
+	//consume() reads a message itself if it gets there ahead of the workers
+	assert.True(t, fs.peak <= 4+1, "more reads than workers: %v", fs.peak)
+	assert.Nil(t, ch.readAhead)
+}
+
+//a deferred message stops the scan, the messages read ahead after it are left on disk
+func TestReadAheadStopsAtDeferredMessage(t *testing.T) {
+	fs := &slowFileSystem{delay: func(string) time.Duration { return time.Millisecond }}
+	var mu sync.Mutex
+	fail := true
+	ch := newTestChannel(t, ModeMaster, Options{FileSystem: fs, ReadWorkers: 3, BeforeDelete: func(id string, content string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if id == sequenceName(5) && fail {
+			return errors.New("sink unavailable")
+		}
+		return nil
+	}})
+	defer os.RemoveAll(ch.path)
+	for i := 0; i < 20; i++ {
+		dropMessage(t, ch.path, sequenceName(i), fmt.Sprintf("message %v", i))
+	}
+	result := collectMessages(ch, 5)
+	ch.consumeAll()
+	assert.Len(t, <-result, 5)
+	assert.Equal(t, 5, ch.recvCounter)
+	for i := 5; i < 20; i++ {
+		_, err := os.Stat(path.Join(ch.path, sequenceName(i)))
+		assert.NoError(t, err)
+	}
+
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	result = collectMessages(ch, 15)
+	ch.consumeAll()
+	assert.Len(t, <-result, 15)
+	assert.Equal(t, 20, ch.recvCounter)
+}
+
+//a backlog of messages read from a slow disk
+func benchmarkSlowReads(b *testing.B, workers int) {
+	fs := &slowFileSystem{delay: func(string) time.Duration { return 2 * time.Millisecond }}
+	const backlog = 32
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		ch := newTestChannel(b, ModeMaster, Options{FileSystem: fs, ReadWorkers: workers})
+		for j := 0; j < backlog; j++ {
+			dropMessage(b, ch.path, sequenceName(j), fmt.Sprintf("message %v", j))
+		}
+		result := collectMessages(ch, backlog)
+		b.StartTimer()
+		ch.consumeAll()
+		<-result
+		b.StopTimer()
+		os.RemoveAll(ch.path)
+		b.StartTimer()
+	}
+}
+
+func BenchmarkConsumeSlowReadsInline(b *testing.B) {
+	benchmarkSlowReads(b, 0)
+}
+
+func BenchmarkConsumeSlowReadsPool(b *testing.B) {
+	benchmarkSlowReads(b, 8)
+}
@@ -0,0 +1,65 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"path"
+)
+
+//the number of the consumed message names remembered, the oldest one is forgotten first
+const maxRecentConsumed = 256
+
+//recentSet is a bounded set of the names of the messages consumed last, a late event or a rescan coming across one of them
+//is ignored even where the receiving counter is ambiguous, e.g. a leftover of a restarted peer or a message behind it
+type recentSet struct {
+	names map[string]bool
+	//ring of the names in the order they were added, next is the slot to overwrite
+	order []string
+	next  int
+}
+
+func newRecentSet(size int) *recentSet {
+	return &recentSet{names: make(map[string]bool, size), order: make([]string, 0, size)}
+}
+
+func (s *recentSet) add(name string) {
+	if s.names[name] {
+		return
+	}
+	if len(s.order) < cap(s.order) {
+		s.order = append(s.order, name)
+	} else {
+		delete(s.names, s.order[s.next])
+		s.order[s.next] = name
+		s.next = (s.next + 1) % len(s.order)
+	}
+	s.names[name] = true
+}
+
+func (s *recentSet) contains(name string) bool {
+	return s.names[name]
+}
+
+//remember the message as consumed, the caller must hold consumeMu
+func (ch *fileWatcherChannel) markConsumed(filepath string) {
+	if ch.recentConsumed == nil {
+		ch.recentConsumed = newRecentSet(maxRecentConsumed)
+	}
+	ch.recentConsumed.add(path.Base(filepath))
+}
+
+//whether the message was consumed lately, the caller must hold consumeMu
+func (ch *fileWatcherChannel) consumedLately(filepath string) bool {
+	return ch.recentConsumed != nil && ch.recentConsumed.contains(path.Base(filepath))
+}
@@ -0,0 +1,65 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecentSetEvictsOldest(t *testing.T) {
+	s := newRecentSet(3)
+	for _, name := range []string{"a", "b", "c", "b", "d", "e"} {
+		s.add(name)
+	}
+	assert.False(t, s.contains("a"))
+	assert.False(t, s.contains("b"))
+	for _, name := range []string{"c", "d", "e"} {
+		assert.True(t, s.contains(name))
+	}
+	assert.Len(t, s.names, 3)
+}
+
+//the peer's files show up again after they were consumed, e.g. a retried send, along with late events of them
+func TestLateDuplicateEventsIgnored(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(ch.path)
+	for i := 0; i < 3; i++ {
+		dropMessage(t, ch.path, sequenceName(i), fmt.Sprintf("m%v", i))
+		ch.onCreate(path.Join(ch.path, sequenceName(i)))
+		assert.Equal(t, fmt.Sprintf("m%v", i), <-ch.onMessageChan)
+	}
+	for i := 0; i < 3; i++ {
+		dropMessage(t, ch.path, sequenceName(i), fmt.Sprintf("m%v", i))
+	}
+	for i := 2; i >= 0; i-- {
+		ch.onCreate(path.Join(ch.path, sequenceName(i)))
+	}
+	assert.Empty(t, ch.onMessageChan)
+	assert.Equal(t, 3, ch.recvCounter)
+
+	//a rescan drops the duplicates, the next message is delivered as usual
+	dropMessage(t, ch.path, sequenceName(3), "m3")
+	ch.consumeAll()
+	assert.Equal(t, "m3", <-ch.onMessageChan)
+	assert.Empty(t, ch.onMessageChan)
+	for i := 0; i < 4; i++ {
+		_, err := os.Stat(path.Join(ch.path, sequenceName(i)))
+		assert.True(t, os.IsNotExist(err))
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"os"
+)
+
+//Refresh picks up a repair of the channel directory made out-of-band, e.g. the grant of a RunAs user applied after the channel
+//was created: it verifies the directory is still the one pinned at construction, re-applies Options.DirMode if set, and
+//replaces the file watcher, whose watch may have been set up under the old state; the messages dropped meanwhile are consumed
+//by the new watcher, the receiving counter is left as is
+func (ch *fileWatcherChannel) Refresh() error {
+	log := ch.logger
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.closed {
+		return ErrChannelClosed
+	}
+	if err := ch.checkPinned(); err != nil {
+		log.Errorf("refusing to refresh channel %v: %v", ch.path, err)
+		return err
+	}
+	if err := ch.applyDirMode(); err != nil {
+		log.Errorf("failed to re-apply the permissions of channel %v: %v", ch.path, err)
+		return err
+	}
+	log.Infof("refreshing channel %v", ch.path)
+	watcher, err := newWatcher(log, ch.path, ch.options.WatchBackend)
+	if err != nil {
+		return err
+	}
+	ch.replaceWatcherLocked(watcher)
+	ch.startWatch(watcher)
+	return nil
+}
+
+//set the channel directory and its tmp directory to Options.DirMode, if set
+func (ch *fileWatcherChannel) applyDirMode() error {
+	if ch.options.DirMode == 0 {
+		return nil
+	}
+	for _, dir := range []string{ch.path, ch.tmpPath} {
+		if err := os.Chmod(dir, ch.options.DirMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
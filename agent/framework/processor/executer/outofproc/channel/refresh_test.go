@@ -0,0 +1,81 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefreshAfterPermissionRepair(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "refresh")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "channel")
+	ch, err := NewFileWatcherChannelWithOptions(log.NewMockLog(), ModeMaster, name, Options{DirMode: 0770})
+	assert.NoError(t, err)
+	defer ch.Destroy()
+	for _, d := range []string{ch.path, ch.tmpPath} {
+		info, err := os.Stat(d)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0770), info.Mode().Perm())
+	}
+
+	//the permissions are changed out-of-band, then the channel is refreshed
+	assert.NoError(t, os.Chmod(ch.tmpPath, 0700))
+	assert.NoError(t, os.Chmod(ch.path, 0700))
+	ch.mu.RLock()
+	previous := ch.watcher
+	ch.mu.RUnlock()
+	assert.NoError(t, ch.Refresh())
+	for _, d := range []string{ch.path, ch.tmpPath} {
+		info, err := os.Stat(d)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0770), info.Mode().Perm())
+	}
+	ch.mu.RLock()
+	assert.True(t, previous != ch.watcher)
+	ch.mu.RUnlock()
+
+	//the new watcher delivers the messages of the peer
+	worker, err := NewFileWatcherChannel(log.NewMockLog(), ModeWorker, name)
+	assert.NoError(t, err)
+	defer worker.Close()
+	assert.NoError(t, worker.Send("after refresh"))
+	msg, err := ch.WaitForMessage(5 * time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "after refresh", msg)
+
+	ch.Close()
+	assert.Equal(t, ErrChannelClosed, ch.Refresh())
+}
+
+func TestRefreshReplacedDirectory(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(ch.path)
+	pinned, err := os.Lstat(ch.path)
+	assert.NoError(t, err)
+	ch.pinned = pinned
+	//the directory is swapped for another one
+	assert.NoError(t, os.Rename(ch.path, ch.path+"-moved"))
+	defer os.RemoveAll(ch.path + "-moved")
+	assert.NoError(t, os.MkdirAll(ch.path, defaultFileCreateMode))
+	assert.Equal(t, ErrPathEscaped, ch.Refresh())
+}
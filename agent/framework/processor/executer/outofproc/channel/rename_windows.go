@@ -0,0 +1,43 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// +build windows
+
+package channel
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//the destination name can be briefly locked by the peer or an anti-virus scanner
+const defaultRenameRetries = 3
+
+//check whether both paths are on the same volume, i.e. a rename between them is atomic
+func sameFilesystem(a, b string) (bool, error) {
+	for _, p := range []string{a, b} {
+		if _, err := os.Stat(p); err != nil {
+			return false, err
+		}
+	}
+	absA, err := filepath.Abs(a)
+	if err != nil {
+		return false, err
+	}
+	absB, err := filepath.Abs(b)
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(filepath.VolumeName(absA), filepath.VolumeName(absB)), nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	//number of messages renamed in place by the probe and the size of each, large enough to take several writes
+	renameProbeCount = 100
+	renameProbeSize  = 256 << 10
+	//the probe gives up beyond this, the rename is then assumed not atomic
+	renameProbeTimeout = 10 * time.Second
+)
+
+//RootReport describes how the file system of a channel root behaves, see InspectRoot()
+type RootReport struct {
+	//whether a reader never observed a message file before its content was complete
+	AtomicRename bool
+}
+
+//the outcome of the rename probe of each root, probed once per process
+var (
+	renameProbesMu sync.Mutex
+	renameProbes   = make(map[string]bool)
+)
+
+//InspectRoot validates the root, see ValidateRoot(), and probes its file system once per process.
+//The rename probe drops messages the way Send() does while a concurrent reader reads them as soon as they show up, the
+//rename is deemed atomic if no read ever returns a partial message. It is a heuristic: a race may not show up within the
+//sample, the reader runs in this process and may not see what the peer sees e.g. through the client cache of a network
+//file system, and it costs a few megabytes of writes; a file system known to be atomic needs no probe at all
+func InspectRoot(root string) (RootReport, error) {
+	if err := ValidateRoot(root); err != nil {
+		return RootReport{}, err
+	}
+	key, err := filepath.Abs(root)
+	if err != nil {
+		return RootReport{}, err
+	}
+	renameProbesMu.Lock()
+	defer renameProbesMu.Unlock()
+	atomicRename, probed := renameProbes[key]
+	if !probed {
+		if atomicRename, err = probeRenameAtomicity(root); err != nil {
+			return RootReport{}, fmt.Errorf("channel root %v failed the rename probe: %v", root, err)
+		}
+		renameProbes[key] = atomicRename
+	}
+	return RootReport{AtomicRename: atomicRename}, nil
+}
+
+func probeRenameAtomicity(root string) (bool, error) {
+	dir, err := ioutil.TempDir(root, "probe")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(dir)
+	content := bytes.Repeat([]byte{'p'}, renameProbeSize)
+	deadline := time.Now().Add(renameProbeTimeout)
+	name := func(i int) string { return path.Join(dir, fmt.Sprintf("probe-%03d", i)) }
+
+	//the reader reads each message as soon as it shows up, in the order they are dropped
+	partial := make(chan bool, 1)
+	stop := make(chan bool)
+	go func() {
+		for i := 0; i < renameProbeCount; i++ {
+			for {
+				read, err := ioutil.ReadFile(name(i))
+				if err == nil {
+					if len(read) != renameProbeSize {
+						partial <- true
+						return
+					}
+					break
+				}
+				if !os.IsNotExist(err) || time.Now().After(deadline) {
+					partial <- true
+					return
+				}
+				select {
+				case <-stop:
+					partial <- true
+					return
+				default:
+				}
+			}
+		}
+		partial <- false
+	}()
+	tmp := path.Join(dir, "tmp")
+	for i := 0; i < renameProbeCount; i++ {
+		if err = ioutil.WriteFile(tmp, content, defaultFileWriteMode); err != nil {
+			break
+		}
+		if err = os.Rename(tmp, name(i)); err != nil {
+			break
+		}
+	}
+	if err != nil {
+		close(stop)
+		<-partial
+		return false, err
+	}
+	return !<-partial, nil
+}
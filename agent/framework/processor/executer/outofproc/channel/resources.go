@@ -0,0 +1,140 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//ErrResourceLimitReached is returned when creating a channel while the channels of this process hold more go-routines or file
+//descriptors than allowed by SetResourceLimits()
+var ErrResourceLimitReached = errors.New("channel resource limit reached")
+
+//how often a new channel checks whether the usage dropped below the limits, injected by the tests
+var resourcePollInterval = 50 * time.Millisecond
+
+//ResourceUsage is the go-routines and file descriptors held by a channel, or summed across the channels of this process
+type ResourceUsage struct {
+	Channels int
+	//the go-routines started by the channel, the event reader of each of its file watchers included
+	Goroutines int
+	//the descriptors of its file watchers and of its streamed messages not closed yet
+	FDs int
+}
+
+//ResourceLimits caps the resources held by the channels of this process, complementing the cap of SetMaxWatches()
+type ResourceLimits struct {
+	//MaxGoroutines and MaxFDs are the usage past which no channel is created, 0 disables the cap
+	MaxGoroutines int
+	MaxFDs        int
+	//Wait is how long a new channel waits for the usage to drop below the limits, 0 rejects it right away
+	Wait time.Duration
+}
+
+var (
+	limitsMu       sync.Mutex
+	resourceLimits ResourceLimits
+)
+
+//SetResourceLimits changes the limits checked when creating a channel, the channels already created are not affected
+//the limits are a guardrail rather than a strict bound, the channels created concurrently are checked against the same usage
+func SetResourceLimits(limits ResourceLimits) {
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+	resourceLimits = limits
+}
+
+//the descriptors a file watcher holds
+type descriptorHolder interface {
+	descriptors() int
+}
+
+//ResourceUsage returns the go-routines and file descriptors held by the channel, for diagnostics
+func (ch *fileWatcherChannel) ResourceUsage() ResourceUsage {
+	ch.streamsMu.Lock()
+	streams := len(ch.streams) + len(ch.sendStreams)
+	ch.streamsMu.Unlock()
+	watchers := atomic.LoadInt32(&ch.watchers)
+	return ResourceUsage{
+		Channels:   1,
+		Goroutines: int(atomic.LoadInt32(&ch.routines) + watchers),
+		FDs:        int(atomic.LoadInt32(&ch.watcherFDs)) + streams,
+	}
+}
+
+//TotalResourceUsage returns the resources held by the channels created and not closed yet by this process
+func TotalResourceUsage() ResourceUsage {
+	activeMu.Lock()
+	channels := make([]*fileWatcherChannel, 0, len(active))
+	for ch := range active {
+		channels = append(channels, ch)
+	}
+	activeMu.Unlock()
+	var total ResourceUsage
+	for _, ch := range channels {
+		usage := ch.ResourceUsage()
+		total.Channels += usage.Channels
+		total.Goroutines += usage.Goroutines
+		total.FDs += usage.FDs
+	}
+	return total
+}
+
+//wait until the usage is below the limits, ErrResourceLimitReached if it's not within ResourceLimits.Wait
+func checkResourceLimits() error {
+	limitsMu.Lock()
+	limits := resourceLimits
+	limitsMu.Unlock()
+	if limits.MaxGoroutines <= 0 && limits.MaxFDs <= 0 {
+		return nil
+	}
+	deadline := time.Now().Add(limits.Wait)
+	for {
+		usage := TotalResourceUsage()
+		if (limits.MaxGoroutines <= 0 || usage.Goroutines < limits.MaxGoroutines) && (limits.MaxFDs <= 0 || usage.FDs < limits.MaxFDs) {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return ErrResourceLimitReached
+		}
+		time.Sleep(resourcePollInterval)
+	}
+}
+
+//run fn on a go-routine accounted for in ResourceUsage()
+func (ch *fileWatcherChannel) spawn(fn func()) {
+	atomic.AddInt32(&ch.routines, 1)
+	go func() {
+		defer atomic.AddInt32(&ch.routines, -1)
+		fn()
+	}()
+}
+
+//account for a file watcher of the channel until releaseWatcher()
+func (ch *fileWatcherChannel) holdWatcher(watcher eventSource) {
+	atomic.AddInt32(&ch.watchers, 1)
+	if holder, ok := watcher.(descriptorHolder); ok {
+		atomic.AddInt32(&ch.watcherFDs, int32(holder.descriptors()))
+	}
+}
+
+func (ch *fileWatcherChannel) releaseWatcher(watcher eventSource) {
+	atomic.AddInt32(&ch.watchers, -1)
+	if holder, ok := watcher.(descriptorHolder); ok {
+		atomic.AddInt32(&ch.watcherFDs, -int32(holder.descriptors()))
+	}
+}
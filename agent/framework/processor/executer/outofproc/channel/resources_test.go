@@ -0,0 +1,77 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourceUsage(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "resources")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	before := TotalResourceUsage()
+	ch, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, path.Join(dir, "channel"))
+	assert.NoError(t, err)
+	usage := ch.ResourceUsage()
+	assert.Equal(t, 1, usage.Channels)
+	//the watch go-routine and the event reader of the watcher at least
+	assert.True(t, usage.Goroutines >= 2, "goroutines %v", usage.Goroutines)
+	assert.True(t, usage.FDs >= 1, "fds %v", usage.FDs)
+	total := TotalResourceUsage()
+	assert.Equal(t, before.Channels+1, total.Channels)
+	assert.Equal(t, before.FDs+usage.FDs, total.FDs)
+
+	ch.Destroy()
+	//the teardown of the watcher completes asynchronously
+	deadline := time.Now().Add(5 * time.Second)
+	for ch.ResourceUsage() != (ResourceUsage{Channels: 1}) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, ResourceUsage{Channels: 1}, ch.ResourceUsage())
+	assert.Equal(t, before.Channels, TotalResourceUsage().Channels)
+}
+
+func TestResourceLimitRejectsChannel(t *testing.T) {
+	defer SetResourceLimits(ResourceLimits{})
+	defer func(interval time.Duration) { resourcePollInterval = interval }(resourcePollInterval)
+	resourcePollInterval = 10 * time.Millisecond
+	dir, err := ioutil.TempDir(".", "resources")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	first, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, path.Join(dir, "first"))
+	assert.NoError(t, err)
+
+	//no room left for another channel
+	SetResourceLimits(ResourceLimits{MaxFDs: TotalResourceUsage().FDs})
+	_, err = NewFileWatcherChannel(log.NewMockLog(), ModeMaster, path.Join(dir, "second"))
+	assert.Equal(t, ErrResourceLimitReached, err)
+	SetResourceLimits(ResourceLimits{MaxGoroutines: TotalResourceUsage().Goroutines})
+	_, err = NewFileWatcherChannel(log.NewMockLog(), ModeMaster, path.Join(dir, "second"))
+	assert.Equal(t, ErrResourceLimitReached, err)
+
+	//a waiting channel is created once another one is closed
+	SetResourceLimits(ResourceLimits{MaxFDs: TotalResourceUsage().FDs, Wait: 5 * time.Second})
+	time.AfterFunc(100*time.Millisecond, first.Destroy)
+	second, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, path.Join(dir, "second"))
+	assert.NoError(t, err)
+	second.Destroy()
+}
@@ -0,0 +1,95 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"time"
+)
+
+type PeerRestartPolicy string
+
+const (
+	//the sequence ids are taken as is, a restarted peer counting from 0 again is only consumed by the directory polls
+	//and the leftovers of the previous peer may be held back by the ConsumeWindow for good
+	PeerRestartIgnore PeerRestartPolicy = "ignore"
+	//a newer start time with a lower counter is a restarted peer: the receiving counter restarts along with it, the leftovers of
+	//the previous peer are delivered first
+	PeerRestartResume PeerRestartPolicy = "resume"
+)
+
+//the length of the start time stamped by IDSchemeCounter, the stamps of IDSchemeTimestamp change with every message and never
+//denote a restart
+var startTimeStampLen = len("20060102150405")
+
+//whether the stamp a is older than the stamp b, a longer stamp is a later one since they are zero padded numbers
+func stampBefore(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+//track the start time of the peer, return whether the message is a leftover of a previous peer, which is delivered without
+//moving the receiving counter of the current one back; the caller must hold consumeMu
+func (ch *fileWatcherChannel) checkPeerRestart(id SequenceID) bool {
+	if ch.options.PeerRestart != PeerRestartResume || len(id.Stamp) != startTimeStampLen {
+		return false
+	}
+	switch {
+	case ch.recvStamp == "" || id.Stamp == ch.recvStamp:
+	case stampBefore(ch.recvStamp, id.Stamp):
+		if id.Counter < ch.recvCounter {
+			ch.logger.Infof("peer restarted at %v, receiving counter restarts from %v to %v", id.Stamp, ch.recvCounter, id.Counter)
+			ch.recvCounter = id.Counter
+		}
+		ch.windowMisses = 0
+		ch.gapSince = time.Time{}
+	default:
+		ch.logger.Debugf("message %v is a leftover of the peer started at %v", id, id.Stamp)
+		return true
+	}
+	ch.recvStamp = id.Stamp
+	return false
+}
+
+//whether the window scan leaves the message on disk for being too far ahead of the next expected one, restarted is whether
+//a message of a restarted peer is pending; the caller must hold consumeMu
+func (ch *fileWatcherChannel) beyondWindow(id SequenceID, restarted bool) bool {
+	if ch.options.PeerRestart != PeerRestartResume || ch.recvStamp == "" || len(id.Stamp) != startTimeStampLen {
+		return id.Counter >= ch.recvCounter+ch.options.ConsumeWindow
+	}
+	switch {
+	case id.Stamp == ch.recvStamp:
+		//the gap in front of the stragglers never fills once the peer is gone
+		return !restarted && id.Counter >= ch.recvCounter+ch.options.ConsumeWindow
+	case stampBefore(ch.recvStamp, id.Stamp):
+		//a restarted peer counts from 0 again
+		return id.Counter >= ch.options.ConsumeWindow
+	default:
+		return false
+	}
+}
+
+//whether any of the readable names is a message of a peer started after the current one, the caller must hold consumeMu
+func (ch *fileWatcherChannel) peerRestarted(names []string) bool {
+	if ch.options.PeerRestart != PeerRestartResume || ch.recvStamp == "" {
+		return false
+	}
+	for _, name := range names {
+		if id, err := ParseSequenceID(name); err == nil && len(id.Stamp) == startTimeStampLen && stampBefore(ch.recvStamp, id.Stamp) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//the name of a message of the peer started at the given time
+func restartedName(startTime string, counter int) string {
+	return fmt.Sprintf("worker-%v-%06d", startTime, counter)
+}
+
+const restartTime = "20170101000500"
+
+//consume the first messages of the peer, then leave a straggler behind a gap and restart the peer with a reset counter
+func simulatePeerRestart(t *testing.T, policy PeerRestartPolicy) *fileWatcherChannel {
+	ch := newTestChannel(t, ModeMaster, Options{ConsumeWindow: 2, GapGracePeriod: -1, PeerRestart: policy})
+	for i := 0; i < 3; i++ {
+		dropMessage(t, ch.path, sequenceName(i), fmt.Sprintf("old%v", i))
+	}
+	ch.consumeAll()
+	assert.Equal(t, []string{"old0", "old1", "old2"}, <-collectMessages(ch, 3))
+	dropMessage(t, ch.path, sequenceName(5), "old5")
+	dropMessage(t, ch.path, restartedName(restartTime, 0), "new0")
+	dropMessage(t, ch.path, restartedName(restartTime, 1), "new1")
+	ch.onCreate(path.Join(ch.path, restartedName(restartTime, 0)))
+	return ch
+}
+
+func TestPeerRestartResume(t *testing.T) {
+	ch := simulatePeerRestart(t, PeerRestartResume)
+	defer os.RemoveAll(ch.path)
+	//the straggler of the previous peer is no longer held back by the gap, and is delivered first
+	assert.Equal(t, []string{"old5", "new0", "new1"}, <-collectMessages(ch, 3))
+	assert.Equal(t, 2, ch.recvCounter)
+	assert.Equal(t, restartTime, ch.recvStamp)
+
+	//a late leftover of the previous peer does not move the receiving counter back
+	dropMessage(t, ch.path, sequenceName(7), "old7")
+	dropMessage(t, ch.path, restartedName(restartTime, 2), "new2")
+	ch.consumeAll()
+	assert.Equal(t, []string{"old7", "new2"}, <-collectMessages(ch, 2))
+	assert.Equal(t, 3, ch.recvCounter)
+	files, err := ch.fs().ReadDir(ch.path)
+	assert.NoError(t, err)
+	//only the tmp directory is left
+	assert.Len(t, files, 1)
+}
+
+func TestPeerRestartIgnore(t *testing.T) {
+	ch := simulatePeerRestart(t, "")
+	defer os.RemoveAll(ch.path)
+	//the straggler waits for a gap that never fills
+	assert.Equal(t, []string{"new0", "new1"}, <-collectMessages(ch, 2))
+	_, err := os.Stat(path.Join(ch.path, sequenceName(5)))
+	assert.NoError(t, err)
+}
+
+func TestSortSequenceNames(t *testing.T) {
+	names := []string{
+		"worker-20170101000500-000",
+		"worker-20170101000000-1000",
+		"worker-20170101000000-999",
+		"malformed",
+	}
+	(&fileWatcherChannel{}).sortSequenceNames(names)
+	assert.Equal(t, []string{
+		"malformed",
+		"worker-20170101000000-999",
+		"worker-20170101000000-1000",
+		"worker-20170101000500-000",
+	}, names)
+}
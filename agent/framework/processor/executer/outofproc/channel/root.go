@@ -0,0 +1,41 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+//ValidateRoot checks the given channel root is an existing directory the agent can write to and others cannot tamper with,
+//call it at startup to fail early on a misconfigured root
+func ValidateRoot(root string) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		return fmt.Errorf("channel root %v is not accessible: %v", root, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("channel root %v is not a directory", root)
+	}
+	if err = checkRootPermission(info); err != nil {
+		return fmt.Errorf("channel root %v: %v", root, err)
+	}
+	//the mode bits do not tell about read-only mounts or acls, probe it
+	probe, err := ioutil.TempDir(root, "probe")
+	if err != nil {
+		return fmt.Errorf("channel root %v is not writable: %v", root, err)
+	}
+	return os.Remove(probe)
+}
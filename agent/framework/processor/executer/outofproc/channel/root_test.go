@@ -0,0 +1,109 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateRoot(t *testing.T) {
+	root, err := ioutil.TempDir(".", "root")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+	assert.NoError(t, ValidateRoot(root))
+	//the probe does not stay behind
+	list, err := ioutil.ReadDir(root)
+	assert.NoError(t, err)
+	assert.Empty(t, list)
+
+	assert.Error(t, ValidateRoot(path.Join(root, "missing")))
+	file := path.Join(root, "file")
+	assert.NoError(t, ioutil.WriteFile(file, []byte{}, defaultFileCreateMode))
+	assert.Error(t, ValidateRoot(file))
+}
+
+func TestCreateFileChannelUnderRoot(t *testing.T) {
+	root, err := ioutil.TempDir(".", "root")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	master, err, found := CreateFileChannelUnderRoot(log.NewMockLog(), root, ModeMaster, "document")
+	assert.NoError(t, err)
+	assert.False(t, found)
+	_, err = os.Stat(path.Join(root, "document", "tmp"))
+	assert.NoError(t, err)
+	worker, err, found := CreateFileChannelUnderRoot(log.NewMockLog(), root, ModeWorker, "document")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	defer worker.Close()
+
+	assert.NoError(t, master.Send("hello"))
+	msg, err := worker.WaitForMessage(5 * time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", msg)
+	master.Destroy()
+	_, err = os.Stat(path.Join(root, "document"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCreateFileChannelUnderMissingRoot(t *testing.T) {
+	root, err := ioutil.TempDir(".", "root")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	_, err, found := CreateFileChannelUnderRoot(log.NewMockLog(), path.Join(root, "missing"), ModeMaster, "document")
+	assert.Error(t, err)
+	assert.False(t, found)
+	_, err = os.Stat(path.Join(root, "missing"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+//the temp directory of the build hosts is a local file system, where the rename is atomic
+func TestInspectRootAtomicRename(t *testing.T) {
+	root, err := ioutil.TempDir(".", "root")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+	report, err := InspectRoot(root)
+	assert.NoError(t, err)
+	assert.True(t, report.AtomicRename)
+	//the probe does not stay behind and runs once
+	list, err := ioutil.ReadDir(root)
+	assert.NoError(t, err)
+	assert.Empty(t, list)
+	key, err := filepath.Abs(root)
+	assert.NoError(t, err)
+	renameProbesMu.Lock()
+	renameProbes[key] = false
+	renameProbesMu.Unlock()
+	report, err = InspectRoot(root)
+	assert.NoError(t, err)
+	assert.False(t, report.AtomicRename)
+
+	//the channels under a root without atomic rename lock their messages
+	ch, err, _ := CreateFileChannelUnderRoot(log.NewMockLog(), root, ModeMaster, "document")
+	assert.NoError(t, err)
+	assert.True(t, ch.(*fileWatcherChannel).options.CooperativeLock)
+	ch.Destroy()
+
+	_, err = InspectRoot(path.Join(root, "missing"))
+	assert.Error(t, err)
+}
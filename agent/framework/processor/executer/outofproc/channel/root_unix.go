@@ -0,0 +1,42 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// +build darwin freebsd linux netbsd openbsd
+
+package channel
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+//PATH_MAX, the longest path accepted by the system calls
+var maxPathLength = func() int {
+	if runtime.GOOS == "linux" {
+		return 4096
+	}
+	return 1024
+}()
+
+//the channel root must be owned by the agent user and not writable by group or others, otherwise another user could plant or swap channels
+func checkRootPermission(info os.FileInfo) error {
+	if perm := info.Mode().Perm(); perm&0022 != 0 {
+		return fmt.Errorf("permission %v is writable by group or others", perm)
+	}
+	if owner := info.Sys().(*syscall.Stat_t).Uid; int(owner) != os.Geteuid() {
+		return fmt.Errorf("owned by uid %v instead of %v", owner, os.Geteuid())
+	}
+	return nil
+}
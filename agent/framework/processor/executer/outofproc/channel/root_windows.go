@@ -0,0 +1,29 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// +build windows
+
+package channel
+
+import (
+	"os"
+)
+
+//MAX_PATH, the long paths are opt-in on windows
+var maxPathLength = 260
+
+//the mode bits do not reflect the acl on windows, access is controlled by the acl inherited from the root
+//TODO check the acl of the root
+func checkRootPermission(info os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//SequenceID is the name of a message file, {mode}-{stamp}-{counter}
+//the counter is zero padded to 3 digits, a newer writer padding it wider and past 32 bits is read as well so that both ids
+//are ordered by value during a rolling upgrade, see SendOrder()
+type SequenceID struct {
+	Mode Mode
+	//the channel start time with IDSchemeCounter, the zero padded unix nano timestamp with IDSchemeTimestamp
+	Stamp string
+	//the message counter with IDSchemeCounter, the tiebreak among the ids sharing the timestamp with IDSchemeTimestamp
+	Counter int
+}
+
+func (id SequenceID) String() string {
+	return fmt.Sprintf("%v-%s-%03d", id.Mode, id.Stamp, id.Counter)
+}
+
+//ParseSequenceID parses the name of a message file, a path is accepted as well
+//On windows, path.Base() does not work
+func ParseSequenceID(name string) (SequenceID, error) {
+	_, name = path.Split(name)
+	parts := strings.Split(name, "-")
+	if len(parts) < 3 {
+		return SequenceID{}, fmt.Errorf("malformed sequence id %v: expected {mode}-{stamp}-{counter}", name)
+	}
+	stamp := parts[len(parts)-2]
+	if _, err := strconv.ParseUint(stamp, 10, 64); err != nil {
+		return SequenceID{}, fmt.Errorf("malformed sequence id %v: %v", name, err)
+	}
+	counter, err := strconv.ParseInt(parts[len(parts)-1], 10, strconv.IntSize)
+	if err != nil {
+		return SequenceID{}, fmt.Errorf("malformed sequence id %v: %v", name, err)
+	}
+	return SequenceID{
+		Mode:    Mode(strings.Join(parts[:len(parts)-2], "-")),
+		Stamp:   stamp,
+		Counter: int(counter),
+	}, nil
+}
+
+//SequenceLess reports whether the message a was sent before the message b, see Options.SequenceOrder
+type SequenceLess func(a, b SequenceID) bool
+
+//SendOrder orders the ids by start time then counter, it's the default order
+//it compares the decoded fields rather than the names, which do not sort lexically once a counter grows past its padding
+//or ids of another format are mixed in
+func SendOrder(a, b SequenceID) bool {
+	if a.Mode != b.Mode {
+		return a.Mode < b.Mode
+	}
+	if a.Stamp != b.Stamp {
+		return stampBefore(a.Stamp, b.Stamp)
+	}
+	return a.Counter < b.Counter
+}
+
+func (ch *fileWatcherChannel) sequenceLess() SequenceLess {
+	if ch.options.SequenceOrder != nil {
+		return ch.options.SequenceOrder
+	}
+	return SendOrder
+}
+
+//sort the message names by the sequence order of the channel, each name is decoded once
+//a malformed name is ordered lexically against the others, it's dead-lettered once consumed
+func (ch *fileWatcherChannel) sortSequenceNames(names []string) {
+	less := ch.sequenceLess()
+	type decoded struct {
+		name string
+		id   SequenceID
+		err  error
+	}
+	ids := make([]decoded, len(names))
+	for i, name := range names {
+		id, err := ParseSequenceID(name)
+		ids[i] = decoded{name: name, id: id, err: err}
+	}
+	sort.SliceStable(ids, func(i, j int) bool {
+		if ids[i].err != nil || ids[j].err != nil {
+			return ids[i].name < ids[j].name
+		}
+		return less(ids[i].id, ids[j].id)
+	})
+	for i := range ids {
+		names[i] = ids[i].name
+	}
+}
+
+//NextSequenceID returns the id the next Send() would use without consuming it
+//with IDSchemeTimestamp the id is stamped with the current time, the actual id is stamped at the time of the send
+func (ch *fileWatcherChannel) NextSequenceID() string {
+	ch.sendMu.Lock()
+	defer ch.sendMu.Unlock()
+	id, _ := ch.peekSequenceID(ch.clock.stamp())
+	return id.String()
+}
+
+//compute the sequence id of the next message at the given time, along with the timestamp it's based on
+func (ch *fileWatcherChannel) peekSequenceID(now int64) (SequenceID, int64) {
+	if ch.options.IDScheme != IDSchemeTimestamp {
+		return SequenceID{Mode: ch.mode, Stamp: ch.startTime, Counter: ch.counter}, 0
+	}
+	//never step back even if the wall clock does, a reopened channel continues after the leftover files of the previous one
+	stamp, tiebreak := now, 0
+	if stamp <= ch.lastStamp {
+		stamp = ch.lastStamp
+		tiebreak = ch.tiebreak + 1
+	}
+	return SequenceID{Mode: ch.mode, Stamp: fmt.Sprintf("%019d", stamp), Counter: tiebreak}, stamp
+}
+
+//generate the sequence id of the next message based on the configured scheme, the caller must hold sendMu
+//the counter itself is advanced once the message is sent
+func (ch *fileWatcherChannel) nextSequenceID() string {
+	id, stamp := ch.peekSequenceID(ch.clock.stamp())
+	if ch.options.IDScheme == IDSchemeTimestamp {
+		ch.lastStamp = stamp
+		ch.tiebreak = id.Counter
+	}
+	return id.String()
+}
@@ -0,0 +1,154 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSequenceID(t *testing.T) {
+	id, err := ParseSequenceID("channel/worker-20170101000000-012")
+	assert.NoError(t, err)
+	assert.Equal(t, SequenceID{Mode: ModeWorker, Stamp: "20170101000000", Counter: 12}, id)
+	assert.Equal(t, "worker-20170101000000-012", id.String())
+
+	stamped := fmt.Sprintf("master-%019d-003", int64(1500000000000000000))
+	id, err = ParseSequenceID(stamped)
+	assert.NoError(t, err)
+	assert.Equal(t, SequenceID{Mode: ModeMaster, Stamp: "1500000000000000000", Counter: 3}, id)
+	assert.Equal(t, stamped, id.String())
+
+	for _, name := range []string{"worker", "worker-001", "worker-2017x-001", "worker-20170101000000-1x"} {
+		_, err = ParseSequenceID(name)
+		assert.Error(t, err, name)
+	}
+}
+
+//a peer of a newer version pads the counter wider, its ids are ordered by value against the ones padded to 3 digits
+func TestMixedSequenceIDFormats(t *testing.T) {
+	id, err := ParseSequenceID("worker-20170101000000-00000000002147483648")
+	if strconv.IntSize == 64 {
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1<<31), int64(id.Counter))
+	}
+
+	names := []string{
+		"worker-20170101000000-0000001001",
+		"worker-20170101000000-1000",
+		"worker-20170101000000-999",
+		"worker-20170101000000-0000000998",
+	}
+	(&fileWatcherChannel{}).sortSequenceNames(names)
+	assert.Equal(t, []string{
+		"worker-20170101000000-0000000998",
+		"worker-20170101000000-999",
+		"worker-20170101000000-1000",
+		"worker-20170101000000-0000001001",
+	}, names)
+
+	for _, window := range []int{0, 8} {
+		ch := newTestChannel(t, ModeMaster, Options{ConsumeWindow: window})
+		ch.recvCounter = 998
+		for _, name := range names {
+			dropMessage(t, ch.path, name, name)
+		}
+		ch.onCreate(path.Join(ch.path, names[len(names)-1]))
+		for _, name := range names {
+			assert.Equal(t, name, <-ch.onMessageChan)
+		}
+		assert.Equal(t, 1002, ch.recvCounter)
+		os.RemoveAll(ch.path)
+	}
+}
+
+//the names sorting lexically in another order than their decoded ids
+func TestSendOrder(t *testing.T) {
+	names := []string{
+		//the timestamp scheme stamps are longer than the start time of the counter scheme
+		fmt.Sprintf("worker-%019d-000", int64(1500000000000000000)),
+		"worker-20170101000000-10",
+		"worker-20170101000000-9",
+		"worker-20170101000000-000000000011",
+		"worker-20161231235959-500",
+	}
+	(&fileWatcherChannel{}).sortSequenceNames(names)
+	assert.Equal(t, []string{
+		"worker-20161231235959-500",
+		"worker-20170101000000-9",
+		"worker-20170101000000-10",
+		"worker-20170101000000-000000000011",
+		fmt.Sprintf("worker-%019d-000", int64(1500000000000000000)),
+	}, names)
+
+	a, _ := ParseSequenceID("worker-20170101000000-9")
+	b, _ := ParseSequenceID("worker-20170101000000-10")
+	assert.True(t, SendOrder(a, b))
+	assert.False(t, SendOrder(b, a))
+	assert.False(t, SendOrder(a, a))
+}
+
+//the consumption follows a custom sequence order rather than the names
+func TestCustomSequenceOrder(t *testing.T) {
+	newestFirst := func(a, b SequenceID) bool {
+		return SendOrder(b, a)
+	}
+	ch := newTestChannel(t, ModeMaster, Options{SequenceOrder: newestFirst})
+	defer os.RemoveAll(ch.path)
+	for i := 0; i < 3; i++ {
+		dropMessage(t, ch.path, sequenceName(i), fmt.Sprintf("m%v", i))
+	}
+	ch.consumeAll()
+	for i := 2; i >= 0; i-- {
+		assert.Equal(t, fmt.Sprintf("m%v", i), <-ch.onMessageChan)
+	}
+}
+
+func TestNextSequenceID(t *testing.T) {
+	for _, scheme := range []IDScheme{IDSchemeCounter, IDSchemeTimestamp} {
+		ch := newTestChannel(t, ModeMaster, Options{IDScheme: scheme})
+		//peeking does not consume the id
+		before := ch.NextSequenceID()
+		ch.NextSequenceID()
+		assert.Equal(t, 0, ch.counter)
+		assert.Equal(t, int64(0), ch.lastStamp)
+		assert.NoError(t, ch.Send("m0"))
+		files, err := ioutil.ReadDir(ch.path)
+		assert.NoError(t, err)
+		var sent string
+		for _, file := range files {
+			if !file.IsDir() {
+				sent = file.Name()
+			}
+		}
+		if scheme == IDSchemeCounter {
+			assert.Equal(t, before, sent)
+		} else {
+			//the actual id is stamped at the time of the send
+			assert.True(t, before <= sent, "%v > %v", before, sent)
+		}
+		//the next message sorts after the sent one
+		time.Sleep(time.Millisecond)
+		next := ch.NextSequenceID()
+		assert.True(t, sent < next, "%v >= %v", sent, next)
+		os.RemoveAll(ch.path)
+	}
+}
@@ -0,0 +1,186 @@
+package channel
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//default message size bucket upper bounds in bytes, from 1KB to 10MB
+var DefaultSizeBuckets = []int{
+	1 << 10,
+	4 << 10,
+	16 << 10,
+	64 << 10,
+	256 << 10,
+	1 << 20,
+	4 << 20,
+	10 << 20,
+}
+
+//Stats is a point-in-time snapshot of the channel metrics
+type Stats struct {
+	//Options.Tag of the channel
+	Tag string
+	//payload sizes passed to Send()
+	SentSizes SizeHistogram
+	//payload sizes delivered by consume()
+	ReceivedSizes SizeHistogram
+	//latency between the peer's Send() and the local consume(), only recorded for peers with TrackLatency enabled
+	SendLatency LatencyStats
+	//age of the oldest message of the peer not consumed yet, 0 if there is none
+	BacklogAge time.Duration
+	//number of the backward steps of the wall clock seen by the channel, each one skewing SendLatency and the expiry
+	ClockSteps uint64
+	//the store the channel directory is on, BackingTmpfs once placed under Options.TmpfsRoot
+	Backing BackingStore
+	//the cost of the directory polls, see consumeAll()
+	Scans ScanStats	//whether the consumer of the payloads is behind and the number of times it fell behind, see Options.Throttle
+	Throttled bool
+	Throttles uint64
+}
+
+//SizeHistogram counts messages by payload size, Counts[i] is the number of messages of size <= Bounds[i]
+//and larger than the previous bound, the last element of Counts counts messages larger than all bounds
+type SizeHistogram struct {
+	Bounds []int
+	Counts []uint64
+}
+
+//sizeHistogram is safe to record concurrently, recording is a binary search plus an atomic increment
+type sizeHistogram struct {
+	bounds []int
+	counts []uint64
+}
+
+func newSizeHistogram(bounds []int) *sizeHistogram {
+	if len(bounds) == 0 {
+		bounds = DefaultSizeBuckets
+	}
+	sorted := append([]int(nil), bounds...)
+	sort.Ints(sorted)
+	return &sizeHistogram{
+		bounds: sorted,
+		counts: make([]uint64, len(sorted)+1),
+	}
+}
+
+func (h *sizeHistogram) record(size int) {
+	atomic.AddUint64(&h.counts[sort.SearchInts(h.bounds, size)], 1)
+}
+
+//total number of the recorded messages
+func (h *sizeHistogram) total() uint64 {
+	var total uint64
+	for i := range h.counts {
+		total += atomic.LoadUint64(&h.counts[i])
+	}
+	return total
+}
+
+func (h *sizeHistogram) snapshot() SizeHistogram {
+	counts := make([]uint64, len(h.counts))
+	for i := range h.counts {
+		counts[i] = atomic.LoadUint64(&h.counts[i])
+	}
+	return SizeHistogram{
+		Bounds: append([]int(nil), h.bounds...),
+		Counts: counts,
+	}
+}
+
+//number of the most recent latency samples kept to compute the percentiles
+const latencyWindowSize = 1000
+
+//LatencyStats summarizes the send-to-consume latency of the most recent messages
+//the latency is measured against the peer's clock, both ends share the host clock but a clock adjustment in between skews the samples
+type LatencyStats struct {
+	Count uint64
+	P50   time.Duration
+	P95   time.Duration
+}
+
+//latencyWindow is a ring buffer of the most recent latency samples
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	count   uint64
+}
+
+func newLatencyWindow() *latencyWindow {
+	return &latencyWindow{
+		samples: make([]time.Duration, 0, latencyWindowSize),
+	}
+}
+
+func (w *latencyWindow) record(latency time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.samples) < latencyWindowSize {
+		w.samples = append(w.samples, latency)
+	} else {
+		w.samples[w.next] = latency
+	}
+	w.next = (w.next + 1) % latencyWindowSize
+	w.count++
+}
+
+func (w *latencyWindow) snapshot() LatencyStats {
+	w.mu.Lock()
+	sorted := append([]time.Duration(nil), w.samples...)
+	count := w.count
+	w.mu.Unlock()
+	if len(sorted) == 0 {
+		return LatencyStats{}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return LatencyStats{
+		Count: count,
+		P50:   sorted[len(sorted)*50/100],
+		P95:   sorted[len(sorted)*95/100],
+	}
+}
+
+//a directory poll taking longer is logged, e.g. the channel directory is flooded or the file system stalls
+const slowScanThreshold = time.Second
+
+//ScanStats summarizes the directory polls, the duration includes consuming the files found by the poll
+type ScanStats struct {
+	Count        uint64
+	LastDuration time.Duration
+	MaxDuration  time.Duration
+	//the total of the durations, divided by Count it's the mean
+	TotalDuration time.Duration
+	//the number of entries of the directory listed by the last poll and by the largest one
+	LastScanned int
+	MaxScanned  int
+}
+
+//scanStats records the directory polls, the polls are serialized by consumeMu but read by Stats() at any time
+type scanStats struct {
+	mu    sync.Mutex
+	stats ScanStats
+}
+
+func (s *scanStats) record(duration time.Duration, scanned int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Count++
+	s.stats.LastDuration = duration
+	s.stats.TotalDuration += duration
+	if duration > s.stats.MaxDuration {
+		s.stats.MaxDuration = duration
+	}
+	s.stats.LastScanned = scanned
+	if scanned > s.stats.MaxScanned {
+		s.stats.MaxScanned = scanned
+	}
+}
+
+func (s *scanStats) snapshot() ScanStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
@@ -0,0 +1,47 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanStatsRecorded(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(ch.path)
+	assert.Equal(t, ScanStats{}, ch.Stats().Scans)
+	for i := 0; i < 5; i++ {
+		dropMessage(t, ch.path, sequenceName(i), "m")
+	}
+	ch.consumeAll()
+	scans := ch.Stats().Scans
+	assert.Equal(t, uint64(1), scans.Count)
+	//the messages along with the tmp directory
+	assert.Equal(t, 6, scans.LastScanned)
+	assert.Equal(t, 6, scans.MaxScanned)
+	assert.True(t, scans.LastDuration > 0)
+	assert.Equal(t, scans.LastDuration, scans.TotalDuration)
+	assert.Len(t, ch.onMessageChan, 5)
+
+	ch.consumeAll()
+	scans = ch.Stats().Scans
+	assert.Equal(t, uint64(2), scans.Count)
+	assert.Equal(t, 1, scans.LastScanned)
+	assert.Equal(t, 6, scans.MaxScanned)
+	assert.True(t, scans.MaxDuration >= scans.LastDuration)
+	assert.True(t, scans.TotalDuration >= scans.MaxDuration)
+}
@@ -0,0 +1,161 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+//the channels created and not closed yet, listed by DumpStatus()
+var (
+	activeMu sync.Mutex
+	active   = make(map[*fileWatcherChannel]bool)
+)
+
+//size of the buffer the goroutine stacks are dumped into, the dump is truncated beyond it
+const stackDumpSize = 1 << 20
+
+func register(ch *fileWatcherChannel) {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	active[ch] = true
+}
+
+func unregister(ch *fileWatcherChannel) {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	delete(active, ch)
+}
+
+//StatusLine returns a one-line summary of the channel for diagnosing a hung document, e.g.
+//mode=master path=/var/lib/amazon/ssm/i-123/channels/doc sent=4 received=3 pending=1 oldest=1200ms disk=2048 watcher=ok peer=seen closed=false
+//sent and received count the payloads, pending is the number of messages of the peer waiting on disk, disk is DiskUsage(),
+//peer is seen once the peer came up, see PeerSeen()
+//the line ends with tag=<Options.Tag> if the channel is tagged
+func (ch *fileWatcherChannel) StatusLine() string {
+	ch.mu.RLock()
+	dir := ch.path
+	closed := ch.closed
+	ch.mu.RUnlock()
+	count, age := ch.pending()
+	//a missing directory occupies nothing
+	disk, _ := diskUsage(dir)
+
+	buf := make([]byte, 0, 128+len(dir))
+	buf = append(buf, "mode="...)
+	buf = append(buf, ch.mode...)
+	buf = append(buf, " path="...)
+	buf = append(buf, dir...)
+	buf = append(buf, " sent="...)
+	buf = strconv.AppendUint(buf, ch.sentSizes.total(), 10)
+	buf = append(buf, " received="...)
+	buf = strconv.AppendUint(buf, ch.recvSizes.total(), 10)
+	buf = append(buf, " pending="...)
+	buf = strconv.AppendInt(buf, int64(count), 10)
+	buf = append(buf, " oldest="...)
+	buf = strconv.AppendInt(buf, int64(age/time.Millisecond), 10)
+	buf = append(buf, "ms disk="...)
+	buf = strconv.AppendInt(buf, disk, 10)
+	buf = append(buf, " watcher="...)
+	if atomic.LoadInt32(&ch.watching) > 0 {
+		buf = append(buf, "ok"...)
+	} else {
+		buf = append(buf, "down"...)
+	}
+	buf = append(buf, " peer="...)
+	if ch.PeerSeen() {
+		buf = append(buf, "seen"...)
+	} else {
+		buf = append(buf, "unseen"...)
+	}
+	buf = append(buf, " closed="...)
+	buf = strconv.AppendBool(buf, closed)
+	if tag := ch.options.Tag; tag != "" {
+		buf = append(buf, " tag="...)
+		buf = append(buf, tag...)
+	}
+	return string(buf)
+}
+
+//Tag returns Options.Tag of the channel, it cannot be changed after construction
+func (ch *fileWatcherChannel) Tag() string {
+	return ch.options.Tag
+}
+
+//FindByTag returns the channels created and not closed yet by this process with the given Options.Tag
+func FindByTag(tag string) []Channel {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	var channels []Channel
+	for ch := range active {
+		if ch.options.Tag == tag {
+			channels = append(channels, ch)
+		}
+	}
+	return channels
+}
+
+//DumpStatus returns the status line of every channel created and not closed yet by this process
+func DumpStatus() []string {
+	activeMu.Lock()
+	channels := make([]*fileWatcherChannel, 0, len(active))
+	for ch := range active {
+		channels = append(channels, ch)
+	}
+	activeMu.Unlock()
+	lines := make([]string, 0, len(channels))
+	for _, ch := range channels {
+		lines = append(lines, ch.StatusLine())
+	}
+	return lines
+}
+
+//DumpStatusOnSignal logs the status of the active channels followed by the goroutine stacks every time one of the signals
+//is received, like the default SIGQUIT handler of the go runtime but without exiting; call the returned func to stop
+func DumpStatusOnSignal(log log.T, signals ...os.Signal) (stop func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, signals...)
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-c:
+				lines := DumpStatus()
+				log.Infof("received %v, dumping %v active channels", sig, len(lines))
+				for _, line := range lines {
+					log.Info(line)
+				}
+				stacks := make([]byte, stackDumpSize)
+				log.Infof("goroutine dump:\n%s", stacks[:runtime.Stack(stacks, true)])
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(c)
+			close(done)
+		})
+	}
+}
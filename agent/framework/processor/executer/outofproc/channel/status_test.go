@@ -0,0 +1,131 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/cihub/seelog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusLine(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{})
+	defer os.RemoveAll(ch.path)
+	assert.Equal(t, "mode=master path="+ch.path+" sent=0 received=0 pending=0 oldest=0ms disk=0 watcher=down peer=unseen closed=false", ch.StatusLine())
+
+	assert.NoError(t, ch.Send("out"))
+	dropMessage(t, ch.path, "worker-20170101000000-000", "in")
+	dropMessage(t, ch.path, "worker-20170101000000-001", "stuck")
+	ch.consume(path.Join(ch.path, "worker-20170101000000-000"))
+	<-ch.onMessageChan
+	assert.Contains(t, ch.StatusLine(), " sent=1 received=1 pending=1 ")
+	assert.True(t, ch.PeerSeen())
+	assert.Contains(t, ch.StatusLine(), " peer=seen ")
+	ch.closed = true
+	assert.True(t, strings.HasSuffix(ch.StatusLine(), " closed=true"))
+}
+
+func TestDumpStatus(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "status")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "channel")
+	ch, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, name)
+	assert.NoError(t, err)
+	//the watch go-routine starts asynchronously
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(ch.StatusLine(), "watcher=ok") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	//the status reports the real directory the channel resolved its path to, the disk holds the ownership token only
+	assert.Contains(t, DumpStatus(), fmt.Sprintf("mode=master path=%v sent=0 received=0 pending=0 oldest=0ms disk=%v watcher=ok peer=unseen closed=false", ch.path, len(ch.ownerToken)))
+
+	ch.Destroy()
+	for _, line := range DumpStatus() {
+		assert.NotContains(t, line, " path="+ch.path+" ")
+	}
+}
+
+//the log is written by the channel go-routines while the test reads it
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestTaggedChannel(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "status")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	var out lockedBuffer
+	seelogger, err := seelog.LoggerFromWriterWithMinLevelAndFormat(&out, seelog.TraceLvl, "%Msg%n")
+	assert.NoError(t, err)
+	logger := &log.Wrapper{Format: &log.ContextFormatFilter{}, M: &sync.Mutex{}, Delegate: &log.DelegateLogger{BaseLoggerInstance: seelogger}}
+
+	ch, err := NewFileWatcherChannelWithOptions(logger, ModeMaster, path.Join(dir, "channel"), Options{Tag: "doc-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "doc-1", ch.Tag())
+	assert.Equal(t, "doc-1", ch.Stats().Tag)
+	assert.True(t, strings.HasSuffix(ch.StatusLine(), " closed=false tag=doc-1"))
+	assert.Equal(t, []Channel{ch}, FindByTag("doc-1"))
+	assert.Empty(t, FindByTag("doc-2"))
+
+	ch.Destroy()
+	assert.Empty(t, FindByTag("doc-1"))
+	//the close is logged asynchronously, wait for the last line
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(out.String(), " closed") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	seelogger.Flush()
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.NotEmpty(t, lines)
+	for _, line := range lines {
+		assert.True(t, strings.HasPrefix(line, "[tag=doc-1] "), line)
+	}
+}
+
+func BenchmarkStatusLine(b *testing.B) {
+	ch := newTestChannel(b, ModeMaster, Options{})
+	defer os.RemoveAll(ch.path)
+	for _, name := range []string{"worker-20170101000000-000", "worker-20170101000000-001", "master-20170101000000-000"} {
+		assert.NoError(b, ioutil.WriteFile(path.Join(ch.path, name), []byte("m"), defaultFileCreateMode))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ch.StatusLine()
+	}
+}
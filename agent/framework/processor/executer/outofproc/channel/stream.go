@@ -0,0 +1,251 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//prefix of a streamed message moved out of the channel directory, it does not match the sequence id of either side
+	streamFilePrefix = "received-"
+	//prefix of a message being written in the tmp directory, before it gets its sequence id
+	sendingFilePrefix = "sending-"
+	//a writer of SendStream() left open for longer is aborted
+	defaultSendStreamTimeout = 10 * time.Minute
+)
+
+//streamReader reads a message straight from its file, the file is removed once the reader is closed
+type streamReader struct {
+	*os.File
+	ch        *fileWatcherChannel
+	closeOnce sync.Once
+}
+
+func (r *streamReader) Close() (err error) {
+	r.closeOnce.Do(func() {
+		err = r.File.Close()
+		removeFile(r.Name())
+		r.ch.streamsMu.Lock()
+		defer r.ch.streamsMu.Unlock()
+		delete(r.ch.streams, r)
+	})
+	return
+}
+
+//GetStream receives the messages of at least Options.StreamThreshold bytes, the smaller ones keep flowing to GetMessage()
+//the consumer must close every reader, the readers left open are closed by Destroy()
+func (ch *fileWatcherChannel) GetStream() <-chan io.ReadCloser {
+	return ch.streamChan
+}
+
+//deliver the message as a stream if it's large enough and not wrapped in an envelope, return false to read it as a string
+func (ch *fileWatcherChannel) tryStream(filepath string, counter int) bool {
+	log := ch.logger
+	info, err := os.Stat(filepath)
+	if err != nil || info.Size() < ch.options.StreamThreshold {
+		return false
+	}
+	if enveloped, err := isEnveloped(filepath); err != nil || enveloped {
+		return false
+	}
+	//move the file out of the channel directory, so that it's not consumed again while the reader is open
+	streamPath := path.Join(ch.tmpPath, streamFilePrefix+path.Base(filepath))
+	if err = rename(filepath, streamPath); err != nil {
+		log.Debugf("failed to move message %v for streaming, reading it as a string: %v", filepath, err)
+		return false
+	}
+	f, err := os.Open(streamPath)
+	if err != nil {
+		log.Errorf("failed to open streamed message %v, dropping it: %v", streamPath, err)
+		removeFile(streamPath)
+		ch.recvCounter = counter + 1
+		return true
+	}
+	reader := &streamReader{File: f, ch: ch}
+	ch.streamsMu.Lock()
+	if ch.streams == nil {
+		ch.streams = make(map[*streamReader]bool)
+	}
+	ch.streams[reader] = true
+	ch.streamsMu.Unlock()
+	ch.recvCounter = counter + 1
+	ch.recvSizes.record(int(info.Size()))
+	//TODO handle buffered channel queue overflow
+	ch.streamChan <- reader
+	return true
+}
+
+//check whether the file starts with either envelope or is compacted, which needs to be decoded as a whole
+func isEnveloped(filepath string) (bool, error) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	//encodeEnvelope() marshals the version first
+	const jsonPrefix = `{"ipcVersion"`
+	buf := make([]byte, len(jsonPrefix))
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	head := string(buf[:n])
+	return isBinaryEnvelope(head) || isCompacted(head) || strings.HasPrefix(head, jsonPrefix), nil
+}
+
+//close the readers the consumer never closed and abort the writers never closed, removing their files
+func (ch *fileWatcherChannel) closeStreams() {
+	ch.streamsMu.Lock()
+	var readers []*streamReader
+	for reader := range ch.streams {
+		readers = append(readers, reader)
+	}
+	var writers []*streamWriter
+	for writer := range ch.sendStreams {
+		writers = append(writers, writer)
+	}
+	ch.streamsMu.Unlock()
+	for _, reader := range readers {
+		ch.logger.Infof("closing stream %v left open by the consumer", reader.Name())
+		reader.Close()
+	}
+	for _, writer := range writers {
+		ch.logger.Infof("aborting stream %v left open by the sender", writer.name)
+		writer.abort()
+	}
+}
+
+//streamWriter writes a message to its tmp file, the file is moved into the channel directory once the writer is closed
+type streamWriter struct {
+	file  io.WriteCloser
+	name  string
+	ch    *fileWatcherChannel
+	timer *time.Timer
+	//guards done and aborted, the writes are serialized with the abort so that the file is not removed underneath them
+	mu      sync.Mutex
+	size    int
+	done    bool
+	aborted bool
+}
+
+//SendStream returns a writer producing a single message out of the bytes written to it, for the payloads too large to be
+//built into a string; the message is sent raw, so that the peer receives it through GetStream() if it's large enough.
+//Nothing is sent until the writer is closed, the message is then ordered among the messages sent so far.
+//A writer not closed within Options.SendStreamTimeout or still open at Destroy() is aborted, discarding the message
+func (ch *fileWatcherChannel) SendStream() (io.WriteCloser, error) {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+	if ch.closed || ch.quiescing {
+		return nil, ErrChannelClosed
+	}
+	name := path.Join(ch.tmpPath, ch.tmpFileName())
+	f, err := ch.fs().Create(name)
+	if err != nil {
+		ch.logger.Errorf("failed to create stream file: %v", err)
+		return nil, notWritableOr(err)
+	}
+	w := &streamWriter{file: f, name: name, ch: ch}
+	timeout := ch.options.SendStreamTimeout
+	if timeout <= 0 {
+		timeout = defaultSendStreamTimeout
+	}
+	w.timer = time.AfterFunc(timeout, func() {
+		ch.logger.Errorf("stream %v was not closed within %v, aborting it", name, timeout)
+		w.abort()
+	})
+	ch.streamsMu.Lock()
+	if ch.sendStreams == nil {
+		ch.sendStreams = make(map[*streamWriter]bool)
+	}
+	ch.sendStreams[w] = true
+	ch.streamsMu.Unlock()
+	return w, nil
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.aborted {
+		return 0, ErrStreamAborted
+	}
+	if w.done {
+		return 0, os.ErrClosed
+	}
+	n, err := w.file.Write(p)
+	w.size += n
+	return n, err
+}
+
+//Close sends the message, it's discarded if it fails to be sent
+func (w *streamWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.aborted {
+		return ErrStreamAborted
+	}
+	if w.done {
+		return nil
+	}
+	w.done = true
+	w.release()
+	if err := w.file.Close(); err != nil {
+		w.ch.fs().Remove(w.name)
+		return notWritableOr(err)
+	}
+	if err := w.ch.sendFile(w.name, w.size); err != nil {
+		w.ch.fs().Remove(w.name)
+		return err
+	}
+	return nil
+}
+
+//discard the message written so far
+func (w *streamWriter) abort() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done || w.aborted {
+		return
+	}
+	w.aborted = true
+	w.release()
+	w.file.Close()
+	w.ch.fs().Remove(w.name)
+}
+
+func (w *streamWriter) release() {
+	w.timer.Stop()
+	w.ch.streamsMu.Lock()
+	defer w.ch.streamsMu.Unlock()
+	delete(w.ch.sendStreams, w)
+}
+
+//move a complete message file into the channel directory under the next sequence id
+func (ch *fileWatcherChannel) sendFile(tmpPath string, size int) error {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+	if ch.closed || ch.quiescing {
+		return ErrChannelClosed
+	}
+	if err := ch.commitLocked(tmpPath); err != nil {
+		return err
+	}
+	ch.sentSizes.record(size)
+	return nil
+}
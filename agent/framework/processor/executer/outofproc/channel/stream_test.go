@@ -0,0 +1,175 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamLargeMessages(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "stream")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "channel")
+	master, err := NewFileWatcherChannelWithOptions(log.NewMockLog(), ModeMaster, name, Options{StreamThreshold: 1024})
+	assert.NoError(t, err)
+	worker, err := NewFileWatcherChannel(log.NewMockLog(), ModeWorker, name)
+	assert.NoError(t, err)
+	large := strings.Repeat("o", 4096)
+	assert.NoError(t, worker.Send("small"))
+	assert.NoError(t, worker.Send(large))
+
+	msg, err := master.WaitForMessage(5 * time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "small", msg)
+	var reader *streamReader
+	select {
+	case stream := <-master.GetStream():
+		reader = stream.(*streamReader)
+	case <-time.After(5 * time.Second):
+		t.Fatal("large message is not streamed")
+	}
+	//the file is kept until the reader is closed, and it's never delivered twice
+	master.consumeAll()
+	_, err = master.WaitForMessage(100 * time.Millisecond)
+	assert.Equal(t, ErrMessageTimeout, err)
+	content, err := ioutil.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, large, string(content))
+	_, err = os.Stat(reader.Name())
+	assert.NoError(t, err)
+	assert.NoError(t, reader.Close())
+	_, err = os.Stat(reader.Name())
+	assert.True(t, os.IsNotExist(err))
+	worker.Close()
+	master.Destroy()
+}
+
+func TestStreamLeftOpenClosedOnDestroy(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "stream")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "channel")
+	worker, err := NewFileWatcherChannelWithOptions(log.NewMockLog(), ModeWorker, name, Options{StreamThreshold: 1})
+	assert.NoError(t, err)
+	dropMessage(t, name, "master-20170101000000-000", "never closed")
+	var reader *streamReader
+	select {
+	case stream := <-worker.GetStream():
+		reader = stream.(*streamReader)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message is not streamed")
+	}
+	worker.Destroy()
+	_, err = os.Stat(reader.Name())
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStreamSkipsEnvelopes(t *testing.T) {
+	ch := newTestChannel(t, ModeWorker, Options{TrackLatency: true})
+	defer os.RemoveAll(ch.path)
+	assert.NoError(t, ch.Send("enveloped"))
+	enveloped, err := isEnveloped(path.Join(ch.path, "worker-20170101000000-000"))
+	assert.NoError(t, err)
+	assert.True(t, enveloped)
+	dropMessage(t, ch.path, "worker-20170101000000-001", "raw")
+	enveloped, err = isEnveloped(path.Join(ch.path, "worker-20170101000000-001"))
+	assert.NoError(t, err)
+	assert.False(t, enveloped)
+}
+
+func TestSendStreamRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "stream")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "channel")
+	master, err := NewFileWatcherChannelWithOptions(log.NewMockLog(), ModeMaster, name, Options{StreamThreshold: 1024})
+	assert.NoError(t, err)
+	worker, err := NewFileWatcherChannel(log.NewMockLog(), ModeWorker, name)
+	assert.NoError(t, err)
+
+	writer, err := worker.SendStream()
+	assert.NoError(t, err)
+	//the messages sent while streaming are ordered before the stream
+	assert.NoError(t, worker.Send("before"))
+	for i := 0; i < 4; i++ {
+		_, err = writer.Write([]byte(strings.Repeat("o", 1024)))
+		assert.NoError(t, err)
+	}
+	msg, err := master.WaitForMessage(5 * time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "before", msg)
+	assert.Empty(t, master.GetStream())
+	assert.NoError(t, writer.Close())
+	_, err = writer.Write([]byte("late"))
+	assert.Error(t, err)
+
+	select {
+	case reader := <-master.GetStream():
+		content, err := ioutil.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, strings.Repeat("o", 4096), string(content))
+		assert.NoError(t, reader.Close())
+	case <-time.After(5 * time.Second):
+		t.Fatal("streamed message is not received")
+	}
+	files, err := ioutil.ReadDir(worker.tmpPath)
+	assert.NoError(t, err)
+	//only the ownership token of the master is left
+	assert.Len(t, files, 1)
+	assert.Equal(t, ownerFileName, files[0].Name())
+	worker.Close()
+	master.Destroy()
+}
+
+//a writer never closed is aborted and its file removed, nothing is sent
+func TestSendStreamNotClosedIsAborted(t *testing.T) {
+	ch := newTestChannel(t, ModeWorker, Options{SendStreamTimeout: 50 * time.Millisecond})
+	defer os.RemoveAll(ch.path)
+	writer, err := ch.SendStream()
+	assert.NoError(t, err)
+	_, err = writer.Write([]byte("partial"))
+	assert.NoError(t, err)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err = writer.Write([]byte("more")); err != nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, ErrStreamAborted, err)
+	assert.Equal(t, ErrStreamAborted, writer.Close())
+	files, err := ioutil.ReadDir(ch.tmpPath)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+	assert.Equal(t, 0, ch.counter)
+	ch.streamsMu.Lock()
+	assert.Empty(t, ch.sendStreams)
+	ch.streamsMu.Unlock()
+
+	//the writers still open are aborted at Destroy()
+	writer, err = ch.SendStream()
+	assert.NoError(t, err)
+	ch.closeStreams()
+	assert.Equal(t, ErrStreamAborted, writer.Close())
+}
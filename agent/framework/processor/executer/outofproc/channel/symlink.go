@@ -0,0 +1,65 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+//resolve the symlinks of a channel directory once, the channel then works on the real path only
+//so that swapping a link afterwards cannot redirect the watcher or the removal at Destroy()
+func resolveChannelPath(name string) (string, os.FileInfo, error) {
+	//make it absolute first, the working directory may be reached through a link as well
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", nil, err
+	}
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return "", nil, err
+	}
+	info, err := os.Lstat(real)
+	if err != nil {
+		return "", nil, err
+	}
+	if !info.IsDir() {
+		return "", nil, fmt.Errorf("channel path %v is not a directory", real)
+	}
+	return real, info, nil
+}
+
+//verify the channel path is still the directory pinned at construction,
+//it fails if the path or any of its parents has been replaced, e.g. by a link to another directory
+func (ch *fileWatcherChannel) checkPinned() error {
+	if ch.pinned == nil {
+		return nil
+	}
+	info, err := os.Lstat(ch.path)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 || !os.SameFile(info, ch.pinned) {
+		return ErrPathEscaped
+	}
+	real, err := filepath.EvalSymlinks(ch.path)
+	if err != nil {
+		return err
+	}
+	if real != ch.path {
+		return ErrPathEscaped
+	}
+	return nil
+}
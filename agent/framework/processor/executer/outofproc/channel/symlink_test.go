@@ -0,0 +1,81 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+//a channel opened through a link works on the real directory and Destroy() cleans up both
+func TestChannelThroughSymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "symlink")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	assert.NoError(t, err)
+	real := path.Join(dir, "real")
+	link := path.Join(dir, "link")
+	assert.NoError(t, os.MkdirAll(real, defaultFileCreateMode))
+	if err = os.Symlink(real, link); err != nil {
+		t.Skipf("cannot create links: %v", err)
+	}
+
+	ch, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, link)
+	assert.NoError(t, err)
+	assert.Equal(t, real, ch.path)
+	assert.NoError(t, ch.Send("m0"))
+	files, err := ioutil.ReadDir(real)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	ch.Destroy()
+	_, err = os.Lstat(real)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Lstat(link)
+	assert.True(t, os.IsNotExist(err))
+}
+
+//the channel directory swapped for a link to another directory after construction is not removed at Destroy()
+func TestDestroyRefusesSwappedDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "symlink")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	assert.NoError(t, err)
+	name := path.Join(dir, "channel")
+	victim := path.Join(dir, "victim")
+	assert.NoError(t, os.MkdirAll(victim, defaultFileCreateMode))
+	assert.NoError(t, ioutil.WriteFile(path.Join(victim, "precious"), []byte("data"), defaultFileWriteMode))
+
+	ch, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, name)
+	assert.NoError(t, err)
+	assert.NoError(t, ch.checkPinned())
+	assert.NoError(t, os.Rename(name, path.Join(dir, "aside")))
+	if err = os.Symlink(victim, name); err != nil {
+		ch.Close()
+		t.Skipf("cannot create links: %v", err)
+	}
+	assert.Equal(t, ErrPathEscaped, ch.checkPinned())
+
+	ch.Destroy()
+	_, err = os.Stat(path.Join(victim, "precious"))
+	assert.NoError(t, err)
+}
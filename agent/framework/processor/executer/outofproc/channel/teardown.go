@@ -0,0 +1,92 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+//TeardownState is the step the teardown of a channel reached, the steps are taken in order and each one is complete before
+//the next one starts, so that the directory is never removed under a watch go-routine still consuming it
+type TeardownState int32
+
+const (
+	//the channel is open
+	TeardownOpen TeardownState = iota
+	//Close() was called, the messages left over are consumed and the watcher torn down
+	TeardownDraining
+	//the watch go-routines exited, or the watcher teardown did not complete within Options.CloseTimeout
+	TeardownWatcherStopped
+	//Destroy() completed, the directory is removed if this end owns it
+	TeardownRemoved
+)
+
+//the number of passes removing the channel directory, a message the peer renames in during a pass fails to remove the
+//directory, whereas the next pass finds the tmp directory of the peer removed already
+const removeAttempts = 3
+
+//the interval the teardown polls the watch go-routines at until they exit
+const watchStopPollInterval = 5 * time.Millisecond
+
+func (s TeardownState) String() string {
+	switch s {
+	case TeardownOpen:
+		return "open"
+	case TeardownDraining:
+		return "draining"
+	case TeardownWatcherStopped:
+		return "watcherstopped"
+	case TeardownRemoved:
+		return "removed"
+	}
+	return "unknown"
+}
+
+//TeardownState returns the step the teardown of the channel reached, e.g. to diagnose a Destroy() that takes long
+func (ch *fileWatcherChannel) TeardownState() TeardownState {
+	return TeardownState(atomic.LoadInt32(&ch.teardown))
+}
+
+//move the teardown forward to the given step, it never goes back
+func (ch *fileWatcherChannel) advanceTeardown(state TeardownState) {
+	for {
+		current := atomic.LoadInt32(&ch.teardown)
+		if current >= int32(state) || atomic.CompareAndSwapInt32(&ch.teardown, current, int32(state)) {
+			return
+		}
+	}
+}
+
+//wait for the watch go-routines to exit once their watchers are closed, give up at the deadline; it must not be called on a
+//watch go-routine
+func (ch *fileWatcherChannel) waitWatchStopped(deadline time.Time) bool {
+	for atomic.LoadInt32(&ch.watching) > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(watchStopPollInterval)
+	}
+	return true
+}
+
+func removeChannelDir(dir string) (err error) {
+	for attempt := 0; attempt < removeAttempts; attempt++ {
+		if err = os.RemoveAll(dir); err == nil {
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTeardownStates(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "teardown")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "channel")
+	ch, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, name)
+	assert.NoError(t, err)
+	assert.Equal(t, TeardownOpen, ch.TeardownState())
+
+	ch.Close()
+	assert.True(t, ch.TeardownState() >= TeardownDraining)
+	ch.Destroy()
+	assert.Equal(t, TeardownRemoved, ch.TeardownState())
+	assert.Equal(t, int32(0), atomic.LoadInt32(&ch.watching))
+	_, err = os.Stat(name)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, "removed", ch.TeardownState().String())
+}
+
+//run with -race, the sends, the consumption of the peer's messages and the teardown race each other
+func TestTeardownStress(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "teardown")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for round := 0; round < 20; round++ {
+		name := path.Join(dir, fmt.Sprintf("channel%v", round))
+		master, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, name)
+		assert.NoError(t, err)
+		worker, err := NewFileWatcherChannel(log.NewMockLog(), ModeWorker, name)
+		assert.NoError(t, err)
+		go func() {
+			for range master.GetMessageShared() {
+			}
+		}()
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					worker.Send("to master")
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					master.Send("to worker")
+				}
+			}()
+		}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			time.Sleep(time.Millisecond)
+			master.Close()
+		}()
+		go func() {
+			defer wg.Done()
+			time.Sleep(2 * time.Millisecond)
+			master.Destroy()
+		}()
+		wg.Wait()
+		master.Destroy()
+		worker.Close()
+		assert.Equal(t, TeardownRemoved, master.TeardownState())
+		assert.Equal(t, int32(0), atomic.LoadInt32(&master.watching))
+		_, err = os.Stat(name)
+		assert.True(t, os.IsNotExist(err))
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+//the bounds of the interval the directory is polled at while the consumer is behind, see Options.Throttle; injected by the tests
+var (
+	throttleMinInterval = 50 * time.Millisecond
+	throttleMaxInterval = 2 * time.Second
+)
+
+//check whether the consumer of the payloads fell behind, i.e. the go channel they are delivered to is 3/4 full, until it
+//drains to 1/4; while it's behind the messages are left on disk and the directory is polled at an interval doubled on
+//every poll finding the consumer still behind. The caller must hold consumeMu
+func (ch *fileWatcherChannel) throttleLocked() bool {
+	if !ch.options.Throttle || ch.isClosed() {
+		return false
+	}
+	depth, size := len(ch.onMessageChan), cap(ch.onMessageChan)
+	if ch.options.DeliverMetadata {
+		depth, size = len(ch.messageChan), cap(ch.messageChan)
+	}
+	throttled := atomic.LoadInt32(&ch.throttled) == 1
+	switch {
+	case !throttled && size > 0 && depth >= size*3/4:
+		atomic.StoreInt32(&ch.throttled, 1)
+		atomic.AddUint32(&ch.throttleCount, 1)
+		ch.throttleInterval = throttleMinInterval
+		ch.logger.Infof("consumer of channel %v is behind with %v messages buffered, leaving the rest on disk and polling every %v",
+			ch.path, depth, ch.throttleInterval)
+	case throttled && depth <= size/4:
+		atomic.StoreInt32(&ch.throttled, 0)
+		ch.logger.Infof("consumer of channel %v caught up, resuming the delivery", ch.path)
+		return false
+	case throttled:
+		if ch.throttleInterval *= 2; ch.throttleInterval > throttleMaxInterval {
+			ch.throttleInterval = throttleMaxInterval
+		}
+	default:
+		return false
+	}
+	ch.retryLater(ch.throttleInterval)
+	return true
+}
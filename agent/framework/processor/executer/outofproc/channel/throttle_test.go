@@ -0,0 +1,61 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThrottleSlowConsumer(t *testing.T) {
+	defer func(min, max time.Duration) { throttleMinInterval, throttleMaxInterval = min, max }(throttleMinInterval, throttleMaxInterval)
+	throttleMinInterval, throttleMaxInterval = time.Millisecond, 10*time.Millisecond
+	ch := newTestChannel(t, ModeMaster, Options{Throttle: true})
+	defer os.RemoveAll(ch.path)
+	ch.onMessageChan = make(chan string, 8)
+	const count = 50
+	for i := 0; i < count; i++ {
+		dropMessage(t, ch.path, sequenceName(i), fmt.Sprintf("m%v", i))
+	}
+
+	//the poll returns instead of blocking on the full go channel, the rest is left on disk
+	ch.consumeAll()
+	assert.Len(t, ch.onMessageChan, 6)
+	stats := ch.Stats()
+	assert.True(t, stats.Throttled)
+	assert.Equal(t, uint64(1), stats.Throttles)
+	pending, _ := ch.pending()
+	assert.Equal(t, count-6, pending)
+
+	//the consumer is slower than the polls, no message is lost nor reordered
+	for i := 0; i < count; i++ {
+		select {
+		case msg := <-ch.onMessageChan:
+			assert.Equal(t, fmt.Sprintf("m%v", i), msg)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message %v not delivered", i)
+		}
+		assert.True(t, len(ch.onMessageChan) <= 6)
+		time.Sleep(2 * time.Millisecond)
+	}
+	//caught up
+	assert.False(t, ch.Stats().Throttled)
+	assert.True(t, ch.Stats().Throttles >= 1)
+	pending, _ = ch.pending()
+	assert.Equal(t, 0, pending)
+}
@@ -0,0 +1,89 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+type BackingStore string
+
+const (
+	BackingDisk  BackingStore = "disk"
+	BackingTmpfs BackingStore = "tmpfs"
+)
+
+//the space a tmpfs must have left for a new channel to be placed on it, if Options.TmpfsMinFree is 0
+const defaultTmpfsMinFree = 16 << 20
+
+//the free bytes of the tmpfs mounted at the given directory, an error if it's not a tmpfs; injected by the tests
+var statTmpfs = tmpfsFree
+
+//create the directory of a new channel under Options.TmpfsRoot and link name to it, so that the peer opening name follows
+//the link; name is left to be created on disk if no tmpfs is mounted there or it has less than Options.TmpfsMinFree left
+//an existing channel keeps its backing store, i.e. the choice of the end that created it
+func placeOnTmpfs(logger log.T, name string, options Options) {
+	if options.TmpfsRoot == "" {
+		return
+	}
+	if _, err := os.Lstat(name); err == nil {
+		return
+	}
+	minFree := options.TmpfsMinFree
+	if minFree <= 0 {
+		minFree = defaultTmpfsMinFree
+	}
+	free, err := statTmpfs(options.TmpfsRoot)
+	if err == nil && free < minFree {
+		err = fmt.Errorf("only %v bytes left", free)
+	}
+	if err == nil {
+		err = linkTmpfsDir(name, path.Join(options.TmpfsRoot, path.Base(name)))
+	}
+	if err != nil {
+		logger.Infof("tmpfs %v is not usable, placing channel %v on disk: %v", options.TmpfsRoot, name, err)
+		return
+	}
+	logger.Infof("placing channel %v on tmpfs %v", name, options.TmpfsRoot)
+}
+
+func linkTmpfsDir(name string, tmpfsDir string) error {
+	target, err := filepath.Abs(tmpfsDir)
+	if err != nil {
+		return err
+	}
+	if err = createIfNotExist(target); err != nil {
+		return err
+	}
+	if err = os.MkdirAll(path.Dir(name), defaultFileCreateMode); err == nil {
+		err = os.Symlink(target, name)
+	}
+	if err != nil {
+		os.RemoveAll(target)
+	}
+	return err
+}
+
+//the backing store of the resolved channel directory
+func backingStore(dir string) BackingStore {
+	if _, err := statTmpfs(dir); err == nil {
+		return BackingTmpfs
+	}
+	return BackingDisk
+}
@@ -0,0 +1,37 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// +build linux
+
+package channel
+
+import (
+	"errors"
+	"syscall"
+)
+
+//TMPFS_MAGIC of statfs(2)
+const tmpfsMagic = 0x01021994
+
+var errNotTmpfs = errors.New("not a tmpfs mount")
+
+func tmpfsFree(dir string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	if int64(stat.Type) != tmpfsMagic {
+		return 0, errNotTmpfs
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
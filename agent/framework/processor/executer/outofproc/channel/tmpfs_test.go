@@ -0,0 +1,92 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTmpfsFallbackToDisk(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "tmpfs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(saved func(string) (int64, error)) { statTmpfs = saved }(statTmpfs)
+	statTmpfs = func(string) (int64, error) { return 0, errors.New("not a tmpfs mount") }
+
+	name := path.Join(dir, "channel")
+	ch, err := NewFileWatcherChannelWithOptions(log.NewMockLog(), ModeMaster, name, Options{TmpfsRoot: path.Join(dir, "missing")})
+	assert.NoError(t, err)
+	defer ch.Destroy()
+	assert.Equal(t, BackingDisk, ch.Stats().Backing)
+	info, err := os.Lstat(name)
+	assert.NoError(t, err)
+	assert.True(t, info.IsDir())
+	_, err = os.Stat(path.Join(dir, "missing"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestTmpfsFullFallsBackToDisk(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "tmpfs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(saved func(string) (int64, error)) { statTmpfs = saved }(statTmpfs)
+	statTmpfs = func(string) (int64, error) { return 1 << 10, nil }
+
+	name := path.Join(dir, "channel")
+	ch, err := NewFileWatcherChannelWithOptions(log.NewMockLog(), ModeMaster, name, Options{TmpfsRoot: dir})
+	assert.NoError(t, err)
+	defer ch.Destroy()
+	info, err := os.Lstat(name)
+	assert.NoError(t, err)
+	assert.True(t, info.IsDir())
+}
+
+func TestTmpfsPeerFollowsLink(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "tmpfs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ramdisk := path.Join(dir, "ramdisk")
+	assert.NoError(t, os.Mkdir(ramdisk, defaultFileCreateMode))
+	defer func(saved func(string) (int64, error)) { statTmpfs = saved }(statTmpfs)
+	statTmpfs = func(string) (int64, error) { return 1 << 30, nil }
+
+	name := path.Join(dir, "disk", "channel")
+	master, err := NewFileWatcherChannelWithOptions(log.NewMockLog(), ModeMaster, name, Options{TmpfsRoot: ramdisk})
+	assert.NoError(t, err)
+	assert.Equal(t, BackingTmpfs, master.Stats().Backing)
+	info, err := os.Lstat(name)
+	assert.NoError(t, err)
+	assert.True(t, info.Mode()&os.ModeSymlink != 0)
+	worker, err := NewFileWatcherChannel(log.NewMockLog(), ModeWorker, name)
+	assert.NoError(t, err)
+	assert.NoError(t, worker.Send("over tmpfs"))
+	msg, err := master.WaitForMessage(5 * time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "over tmpfs", msg)
+
+	worker.Close()
+	master.Destroy()
+	_, err = os.Lstat(name)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(path.Join(ramdisk, "channel"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,161 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+type Transform string
+
+const (
+	//the payload is gzipped, see Options.CompressThreshold
+	TransformCompress Transform = "gzip"
+	//the payload is sealed with AES-GCM under Options.EncryptionKey
+	TransformEncrypt Transform = "aesgcm"
+)
+
+var (
+	errMissingKey     = errors.New("no encryption key configured")
+	errSealedTooShort = errors.New("sealed payload is shorter than its nonce")
+)
+
+//SendWithTransforms sends a payload compressed and/or encrypted, the peer applies the inverse transforms before delivering it
+//each transform must be agreed by Handshake(), ErrTransformNotPermitted otherwise; the control messages are never transformed
+func (ch *fileWatcherChannel) SendWithTransforms(rawJson string, transforms ...Transform) error {
+	env := envelope{Payload: rawJson}
+	for _, transform := range transforms {
+		switch transform {
+		case TransformCompress:
+			env.Compressed = true
+		case TransformEncrypt:
+			env.Sealed = true
+		default:
+			ch.logger.Errorf("unknown transform %q", transform)
+			return ErrTransformNotPermitted
+		}
+	}
+	return ch.send(env)
+}
+
+//apply the transforms flagged in the envelope to its payload, compressing the payloads of at least Options.CompressThreshold
+//bytes as well; the caller must hold the read lock
+func (ch *fileWatcherChannel) transform(env envelope) (envelope, error) {
+	if env.Control != nil {
+		env.Compressed, env.Sealed = false, false
+		return env, nil
+	}
+	compress := hasCapability(ch.agreed, Capability(TransformCompress))
+	if ch.options.CompressThreshold > 0 && len(env.Payload) >= ch.options.CompressThreshold && compress {
+		env.Compressed = true
+	}
+	if !env.Compressed && !env.Sealed {
+		return env, nil
+	}
+	if (env.Compressed && !compress) || (env.Sealed && !hasCapability(ch.agreed, Capability(TransformEncrypt))) {
+		ch.logger.Errorf("transforms compressed=%v sealed=%v are not agreed with the peer: %v", env.Compressed, env.Sealed, ch.agreed)
+		return env, ErrTransformNotPermitted
+	}
+	data := []byte(env.Payload)
+	var err error
+	if env.Compressed {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err = w.Write(data); err == nil {
+			err = w.Close()
+		}
+		if err != nil {
+			return env, err
+		}
+		data = buf.Bytes()
+	}
+	if env.Sealed {
+		if data, err = ch.seal(data); err != nil {
+			return env, err
+		}
+	}
+	env.Payload = base64.StdEncoding.EncodeToString(data)
+	return env, nil
+}
+
+//apply the inverse of the transforms flagged in a received envelope, in the reverse order
+func (ch *fileWatcherChannel) untransform(env envelope) (envelope, error) {
+	if !env.Compressed && !env.Sealed {
+		return env, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(env.Payload)
+	if err != nil {
+		return env, err
+	}
+	if env.Sealed {
+		if data, err = ch.open(data); err != nil {
+			return env, err
+		}
+	}
+	if env.Compressed {
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return env, err
+		}
+		if data, err = ioutil.ReadAll(r); err != nil {
+			return env, err
+		}
+	}
+	env.Payload = string(data)
+	env.Compressed, env.Sealed = false, false
+	return env, nil
+}
+
+func (ch *fileWatcherChannel) newAEAD() (cipher.AEAD, error) {
+	if len(ch.options.EncryptionKey) == 0 {
+		return nil, errMissingKey
+	}
+	block, err := aes.NewCipher(ch.options.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//encrypt the data under a random nonce, which is prepended to it
+func (ch *fileWatcherChannel) seal(data []byte) ([]byte, error) {
+	aead, err := ch.newAEAD()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+func (ch *fileWatcherChannel) open(data []byte) ([]byte, error) {
+	aead, err := ch.newAEAD()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errSealedTooShort
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+}
@@ -0,0 +1,105 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testEncryptionKey = []byte("0123456789abcdef")
+
+//large payloads, sealed payloads and small control messages mixed in one stream, each transformed as flagged
+func TestMixedTransforms(t *testing.T) {
+	options := Options{CompressThreshold: 1024, EncryptionKey: testEncryptionKey}
+	pair := newChannelPair(t, harnessVariant{master: options, worker: options})
+	defer pair.cleanup()
+	master, worker := pair.master, pair.worker
+	offered := append(SupportedCapabilities, CapabilityEncryption)
+	agreed := make(chan []Capability)
+	go func() {
+		agreed <- worker.Handshake(offered, 5*time.Second)
+	}()
+	assert.Contains(t, master.Handshake(offered, 5*time.Second), CapabilityEncryption)
+	assert.Contains(t, <-agreed, CapabilityCompression)
+
+	large := strings.Repeat("{\"output\":\"large\"}", 100)
+	assert.NoError(t, master.Send(large))
+	assert.NoError(t, master.Send("small"))
+	assert.NoError(t, master.SendControl(ControlMessage{Type: ControlCancel}))
+	assert.NoError(t, master.SendWithTransforms("secret", TransformEncrypt))
+	assert.NoError(t, master.SendWithTransforms(large, TransformCompress, TransformEncrypt))
+	for _, expected := range []string{large, "small", "secret", large} {
+		msg, err := worker.WaitForMessage(5 * time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, msg)
+	}
+	assert.Equal(t, ControlMessage{Type: ControlCancel}, <-worker.ControlMessages())
+}
+
+func TestTransformEncoding(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{CompressThreshold: 16, EncryptionKey: testEncryptionKey})
+	defer os.RemoveAll(ch.path)
+	large := strings.Repeat("a", 4096)
+	//nothing is transformed until the peer agrees
+	content, err := ch.encode(envelope{Payload: large})
+	assert.NoError(t, err)
+	assert.Equal(t, large, content)
+	_, err = ch.encode(envelope{Payload: "small", Sealed: true})
+	assert.Equal(t, ErrTransformNotPermitted, err)
+
+	ch.agreed = []Capability{CapabilityCompression, CapabilityEncryption}
+	content, err = ch.encode(envelope{Payload: "small"})
+	assert.NoError(t, err)
+	assert.Equal(t, "small", content)
+	content, err = ch.encode(envelope{Payload: large})
+	assert.NoError(t, err)
+	env, err := decodeEnvelope(content)
+	assert.NoError(t, err)
+	assert.True(t, env.Compressed)
+	assert.False(t, env.Sealed)
+	assert.True(t, len(content) < len(large)/4, "compressed to %v bytes", len(content))
+	restored, err := ch.untransform(env)
+	assert.NoError(t, err)
+	assert.Equal(t, large, restored.Payload)
+	//the control messages are never transformed
+	content, err = ch.encode(envelope{Control: &ControlMessage{Type: ControlCancel, Content: large}})
+	assert.NoError(t, err)
+	env, err = decodeEnvelope(content)
+	assert.NoError(t, err)
+	assert.False(t, env.Compressed)
+	assert.Equal(t, large, env.Control.Content)
+}
+
+//a message sealed under another key is dead-lettered, so that it can be replayed once the key is fixed
+func TestSealedUnderAnotherKey(t *testing.T) {
+	sender := newTestChannel(t, ModeWorker, Options{EncryptionKey: []byte("fedcba9876543210")})
+	defer os.RemoveAll(sender.path)
+	sender.agreed = []Capability{CapabilityEncryption}
+	content, err := sender.encode(envelope{Payload: "secret", Sealed: true})
+	assert.NoError(t, err)
+	assert.NotContains(t, content, "secret")
+
+	ch := newTestChannel(t, ModeMaster, Options{EncryptionKey: testEncryptionKey})
+	defer os.RemoveAll(ch.path)
+	dropMessage(t, ch.path, sequenceName(0), content)
+	dropMessage(t, ch.path, sequenceName(1), "next")
+	ch.consumeAll()
+	assert.Equal(t, "next", <-ch.onMessageChan)
+	assert.Equal(t, []string{sequenceName(0)}, ch.DeadLetters())
+}
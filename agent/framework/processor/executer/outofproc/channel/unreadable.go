@@ -0,0 +1,69 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"fmt"
+	"path"
+)
+
+type UnreadablePolicy string
+
+const (
+	//the message is left on disk and read again by the next poll, holding back the messages after it until it's readable
+	UnreadableRetry UnreadablePolicy = "retry"
+	//the message is moved aside as a dead letter and the messages after it are consumed, see ReplayDeadLetter()
+	UnreadableDeadLetter UnreadablePolicy = "deadletter"
+	//the message is moved aside under the tmp directory for inspection and the messages after it are consumed, it's never replayed
+	UnreadableQuarantine UnreadablePolicy = "quarantine"
+)
+
+const (
+	//prefix of a message moved out of the channel directory by UnreadableQuarantine
+	quarantinePrefix = "quarantine-"
+
+	defaultUnreadableAttempts = 3
+)
+
+//record a consume round failing to read the message, and move it aside once it failed Options.UnreadableAttempts rounds,
+//so that a message that can never be read does not wedge the ones after it; the caller must hold consumeMu
+func (ch *fileWatcherChannel) unreadable(filepath string, counter int, err error) {
+	policy := ch.options.OnUnreadable
+	if policy == "" || policy == UnreadableRetry {
+		return
+	}
+	attempts := ch.options.UnreadableAttempts
+	if attempts <= 0 {
+		attempts = defaultUnreadableAttempts
+	}
+	name := path.Base(filepath)
+	if ch.readFailures == nil {
+		ch.readFailures = make(map[string]int)
+	}
+	ch.readFailures[name]++
+	if ch.readFailures[name] < attempts {
+		return
+	}
+	delete(ch.readFailures, name)
+	reason := fmt.Errorf("failed to read in %v attempts: %v", attempts, err)
+	if policy == UnreadableQuarantine {
+		ch.moveAside(filepath, quarantinePrefix, reason)
+	} else {
+		ch.deadLetter(filepath, reason)
+	}
+	//the messages after it may have been consumed meanwhile by the directory polls
+	if counter >= ch.recvCounter {
+		ch.recvCounter = counter + 1
+	}
+}
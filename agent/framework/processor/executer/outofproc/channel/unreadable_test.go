@@ -0,0 +1,68 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//a message that always fails to read is moved aside once it failed the given number of consume rounds
+func TestConsumeUnreadable(t *testing.T) {
+	for _, policy := range []UnreadablePolicy{UnreadableDeadLetter, UnreadableQuarantine} {
+		ch, fs := newFakeChannel(t, ModeMaster)
+		ch.options.OnUnreadable = policy
+		ch.options.UnreadableAttempts = 2
+		broken := path.Join(ch.path, "worker-20170101000000-000")
+		fs.files[broken] = []byte("m0")
+		fs.files[path.Join(ch.path, "worker-20170101000000-001")] = []byte("m1")
+		for round := 0; round < 2; round++ {
+			_, err := fs.ReadFile(broken)
+			assert.NoError(t, err, "moved aside after %v rounds", round)
+			for i := 0; i < consumeAttemptCount; i++ {
+				fs.failNext("ReadFile", syscall.EIO)
+			}
+			ch.consumeAll()
+		}
+		//the poll does not stop at the broken message, the receiving counter moves past both of them
+		assert.Equal(t, "m1", <-ch.onMessageChan)
+		assert.Empty(t, ch.onMessageChan)
+		assert.Equal(t, 2, ch.recvCounter)
+		prefix := deadLetterPrefix
+		if policy == UnreadableQuarantine {
+			prefix = quarantinePrefix
+		}
+		assert.Equal(t, []string{path.Join(ch.tmpPath, prefix+path.Base(broken))}, fs.names())
+		assert.Empty(t, ch.readFailures)
+	}
+}
+
+//by default the message is retried for good, a transient failure does not count against a later read
+func TestConsumeUnreadableRetry(t *testing.T) {
+	ch, fs := newFakeChannel(t, ModeMaster)
+	fs.files[path.Join(ch.path, "worker-20170101000000-000")] = []byte("m0")
+	for round := 0; round < defaultUnreadableAttempts+1; round++ {
+		for i := 0; i < consumeAttemptCount; i++ {
+			fs.failNext("ReadFile", syscall.EIO)
+		}
+		ch.consumeAll()
+	}
+	assert.Empty(t, ch.onMessageChan)
+	assert.Len(t, fs.names(), 1)
+	ch.consumeAll()
+	assert.Equal(t, "m0", <-ch.onMessageChan)
+}
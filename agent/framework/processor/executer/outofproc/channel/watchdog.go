@@ -0,0 +1,55 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+//the number of times the watch go-routine of a channel is restarted after dying, so that a watcher failing for good does not
+//restart in a loop; injected by the tests
+var maxWatchRestarts = 3
+
+//superviseWatch is deferred by the watch go-routine of the given watcher: if it exits while the channel is still open and the
+//watcher was not replaced meanwhile, e.g. its events go channel was closed by a failure, nothing would deliver the messages
+//anymore, so a new watcher is put in place and the new watch go-routine polls the messages dropped meanwhile
+func (ch *fileWatcherChannel) superviseWatch(watcher eventSource) {
+	log := ch.logger
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.closed || ch.watcher != watcher {
+		return
+	}
+	if ch.watchRestarts >= maxWatchRestarts {
+		log.Errorf("watcher of channel %v died after %v restarts, no more messages are delivered", ch.path, ch.watchRestarts)
+		return
+	}
+	ch.watchRestarts++
+	log.Errorf("watcher of channel %v died, restarting it (%v/%v)", ch.path, ch.watchRestarts, maxWatchRestarts)
+	restarted, err := newWatcher(log, ch.path, ch.options.WatchBackend)
+	if err != nil {
+		log.Errorf("failed to restart the watcher of channel %v: %v", ch.path, err)
+		return
+	}
+	ch.replaceWatcherLocked(restarted)
+	ch.startWatch(restarted)
+}
+
+//put the given watcher in place of the current one, which is closed off the caller's go-routine; the caller must hold mu
+//the watch go-routine of the replaced watcher exits once it's closed
+func (ch *fileWatcherChannel) replaceWatcherLocked(watcher eventSource) {
+	oldWatcher, dir := ch.watcher, ch.path
+	ch.watcher = watcher
+	ch.holdWatcher(watcher)
+	ch.spawn(func() {
+		closeWatcher(oldWatcher, dir)
+		ch.releaseWatcher(oldWatcher)
+	})
+}
@@ -0,0 +1,93 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+//kill the watcher of the channel the way a failure does, by closing its events go channel, and wait for its watch go-routine
+//to exit; return the watcher in place afterwards
+func killWatcher(t *testing.T, ch *fileWatcherChannel) eventSource {
+	ch.mu.RLock()
+	watcher := ch.watcher
+	ch.mu.RUnlock()
+	assert.NoError(t, watcher.Close())
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ch.mu.RLock()
+		current := ch.watcher
+		ch.mu.RUnlock()
+		if current != watcher || atomic.LoadInt32(&ch.watching) == 0 {
+			return current
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("watch go-routine did not exit")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchRestartedAfterDying(t *testing.T) {
+	defer func(original int) { maxWatchRestarts = original }(maxWatchRestarts)
+	maxWatchRestarts = 1
+	pair := newChannelPair(t, harnessVariant{})
+	defer pair.cleanup()
+	master, worker := pair.master, pair.worker
+
+	//the message sent while the watcher is down is polled by the restarted one
+	assert.NoError(t, worker.Send("m0"))
+	killWatcher(t, master)
+	assert.NoError(t, worker.Send("m1"))
+	for _, expected := range []string{"m0", "m1"} {
+		msg, err := master.WaitForMessage(5 * time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, msg)
+	}
+	master.mu.RLock()
+	assert.Equal(t, 1, master.watchRestarts)
+	master.mu.RUnlock()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&master.watching))
+
+	//the restarts are bounded
+	watcher := killWatcher(t, master)
+	time.Sleep(100 * time.Millisecond)
+	master.mu.RLock()
+	assert.Equal(t, watcher, master.watcher)
+	master.mu.RUnlock()
+	assert.Equal(t, int32(0), atomic.LoadInt32(&master.watching))
+}
+
+//a watcher replaced on purpose or closed along with the channel is not restarted
+func TestWatchNotRestartedOnClose(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "watchdog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ch, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, path.Join(dir, "channel"))
+	assert.NoError(t, err)
+	assert.NoError(t, ch.Reset())
+	ch.Destroy()
+	time.Sleep(100 * time.Millisecond)
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+	assert.Equal(t, 0, ch.watchRestarts)
+}
@@ -0,0 +1,173 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/fsnotify/fsnotify"
+)
+
+type WatchBackend string
+
+const (
+	//fanotify where the kernel supports it, fsnotify otherwise
+	WatchBackendAuto WatchBackend = "auto"
+	//inotify on linux, kqueue on BSD and darwin, ReadDirectoryChangesW on windows
+	WatchBackendFsnotify WatchBackend = "fsnotify"
+	//a fanotify group reporting the directory entry events (linux 5.9+ on 64-bit, CAP_SYS_ADMIN before 5.13)
+	//it does not use any of the inotify watches and instances, which are exhausted first by a large number of channels
+	//falls back to fsnotify if not available
+	WatchBackendFanotify WatchBackend = "fanotify"
+)
+
+var errFanotifyUnsupported = errors.New("fanotify is not supported on this platform")
+
+//ErrNoFileEvents is returned when the file system of the channel directory does not report the files created in it,
+//e.g. some network mounts and overlay file systems; the channel would never deliver a message there
+var ErrNoFileEvents = errors.New("file system does not report file events for the channel directory")
+
+//how long a new watcher has to report the creation of a probe file, injected by the tests
+var watchProbeTimeout = 2 * time.Second
+
+//distinguishes the probe files of the channels opened concurrently by this process
+var watchProbeCounter int64
+
+//eventSource delivers the events of the files created under a single watched directory
+type eventSource interface {
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+	Remove(name string) error
+	Close() error
+}
+
+//injected by the tests to simulate the kernels without fanotify and the file systems without events
+var newFanotifySource = openFanotify
+var newFsnotifySource = openFsnotify
+
+//the backends tried in order for the given selection
+func watchBackends(backend WatchBackend) []WatchBackend {
+	if backend == WatchBackendFsnotify {
+		return []WatchBackend{WatchBackendFsnotify}
+	}
+	return []WatchBackend{WatchBackendFanotify, WatchBackendFsnotify}
+}
+
+//create a file watcher monitoring the given directory with the first available backend, WatchBackendAuto if empty
+func newWatcher(logger log.T, name string, backend WatchBackend) (eventSource, error) {
+	var err error
+	for _, candidate := range watchBackends(backend) {
+		var source eventSource
+		if candidate == WatchBackendFanotify {
+			source, err = newFanotifySource(name)
+		} else {
+			source, err = newFsnotifySource(name)
+		}
+		if err == nil {
+			if err = probeEvents(source, name); err != nil {
+				source.Close()
+			}
+		}
+		if err == nil {
+			logger.Debugf("watching %v with %v", name, candidate)
+			return source, nil
+		}
+		if candidate == WatchBackendFanotify {
+			logger.Debugf("fanotify not available, falling back: %v", err)
+			continue
+		}
+		if err == ErrNoFileEvents {
+			logger.Errorf("no file event is reported for %v, e.g. it's on a network mount, move the channel root to a local file system", name)
+		}
+		logger.Errorf("filewatcher listener encountered error when start watcher: %v", err)
+	}
+	return nil, err
+}
+
+//fsnotifySource adapts fsnotify.Watcher, whose go channels are fields
+type fsnotifySource struct {
+	watcher *fsnotify.Watcher
+}
+
+//create a file the watcher must report in time, the events of the peer's messages arriving meanwhile are dropped, they are
+//picked up by the directory scan the watch go-routine starts with
+func probeEvents(source eventSource, dir string) error {
+	//the name never matches a message, so that it's ignored by any other watcher of the directory
+	name := fmt.Sprintf("watchprobe.%v.%v", os.Getpid(), atomic.AddInt64(&watchProbeCounter, 1))
+	probe := path.Join(dir, name)
+	if err := ioutil.WriteFile(probe, nil, defaultFileWriteMode); err != nil {
+		return err
+	}
+	defer os.Remove(probe)
+	timeout := time.After(watchProbeTimeout)
+	for {
+		select {
+		case event, ok := <-source.Events():
+			if !ok {
+				return ErrNoFileEvents
+			}
+			if path.Base(event.Name) == name {
+				return nil
+			}
+		case <-source.Errors():
+		case <-timeout:
+			return ErrNoFileEvents
+		}
+	}
+}
+
+func openFsnotify(name string) (eventSource, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err = watcher.Add(name); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	return &fsnotifySource{watcher: watcher}, nil
+}
+
+func (s *fsnotifySource) Events() <-chan fsnotify.Event {
+	return s.watcher.Events
+}
+
+func (s *fsnotifySource) Errors() <-chan error {
+	return s.watcher.Errors
+}
+
+func (s *fsnotifySource) Remove(name string) error {
+	return s.watcher.Remove(name)
+}
+
+func (s *fsnotifySource) Close() error {
+	return s.watcher.Close()
+}
+
+//on linux fsnotify holds the inotify instance along with an epoll instance and the pipe waking it up, elsewhere a single handle
+//counts, although kqueue holds one more per file of the directory
+func (s *fsnotifySource) descriptors() int {
+	if runtime.GOOS == "linux" {
+		return 4
+	}
+	return 1
+}
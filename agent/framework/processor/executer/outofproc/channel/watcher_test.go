@@ -0,0 +1,122 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchBackends(t *testing.T) {
+	assert.Equal(t, []WatchBackend{WatchBackendFanotify, WatchBackendFsnotify}, watchBackends(""))
+	assert.Equal(t, []WatchBackend{WatchBackendFanotify, WatchBackendFsnotify}, watchBackends(WatchBackendAuto))
+	assert.Equal(t, []WatchBackend{WatchBackendFanotify, WatchBackendFsnotify}, watchBackends(WatchBackendFanotify))
+	assert.Equal(t, []WatchBackend{WatchBackendFsnotify}, watchBackends(WatchBackendFsnotify))
+}
+
+func TestNewWatcherFallback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watcher")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(original func(string) (eventSource, error)) { newFanotifySource = original }(newFanotifySource)
+	attempts := 0
+	newFanotifySource = func(name string) (eventSource, error) {
+		attempts++
+		return nil, errors.New("function not implemented")
+	}
+
+	for _, backend := range []WatchBackend{WatchBackendAuto, WatchBackendFanotify} {
+		watcher, err := newWatcher(log.NewMockLog(), dir, backend)
+		assert.NoError(t, err)
+		assert.IsType(t, &fsnotifySource{}, watcher)
+		watcher.Close()
+	}
+	assert.Equal(t, 2, attempts)
+
+	//the override skips fanotify altogether
+	watcher, err := newWatcher(log.NewMockLog(), dir, WatchBackendFsnotify)
+	assert.NoError(t, err)
+	assert.IsType(t, &fsnotifySource{}, watcher)
+	watcher.Close()
+	assert.Equal(t, 2, attempts)
+
+	//no backend is able to watch a missing directory
+	_, err = newWatcher(log.NewMockLog(), dir+"-missing", WatchBackendAuto)
+	assert.Error(t, err)
+}
+
+//an event source of a file system not reporting any event
+type silentSource struct {
+	events chan fsnotify.Event
+	errors chan error
+}
+
+func newSilentSource(name string) (eventSource, error) {
+	return &silentSource{events: make(chan fsnotify.Event), errors: make(chan error)}, nil
+}
+
+func (s *silentSource) Events() <-chan fsnotify.Event {
+	return s.events
+}
+
+func (s *silentSource) Errors() <-chan error {
+	return s.errors
+}
+
+func (s *silentSource) Remove(name string) error {
+	return nil
+}
+
+func (s *silentSource) Close() error {
+	return nil
+}
+
+func TestNewWatcherWithoutEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watcher")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(fanotify, fsnotify func(string) (eventSource, error), timeout time.Duration) {
+		newFanotifySource, newFsnotifySource, watchProbeTimeout = fanotify, fsnotify, timeout
+	}(newFanotifySource, newFsnotifySource, watchProbeTimeout)
+	watchProbeTimeout = 50 * time.Millisecond
+
+	//fanotify silently fails to report the events, fsnotify is used instead
+	newFanotifySource = newSilentSource
+	watcher, err := newWatcher(log.NewMockLog(), dir, WatchBackendAuto)
+	assert.NoError(t, err)
+	assert.IsType(t, &fsnotifySource{}, watcher)
+	watcher.Close()
+
+	//neither backend reports them, the channel is not created instead of never delivering a message
+	newFsnotifySource = newSilentSource
+	_, err = newWatcher(log.NewMockLog(), dir, WatchBackendAuto)
+	assert.Equal(t, ErrNoFileEvents, err)
+	name := path.Join(dir, "channel")
+	_, err = NewFileWatcherChannel(log.NewMockLog(), ModeMaster, name)
+	assert.Equal(t, ErrNoFileEvents, err)
+	_, err = os.Stat(name)
+	assert.True(t, os.IsNotExist(err))
+	//the probe files are removed
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
@@ -0,0 +1,104 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	//every file watcher is an inotify instance or a fanotify group on linux, fs.inotify.max_user_instances and
+	//fs.fanotify.max_user_groups both default to 128 per user
+	//leave room for the rest of the agent and the other processes of the same user
+	defaultMaxWatches = 64
+	//how long a new channel waits for a watch to be released once the limit is reached
+	defaultWatchTimeout = 10 * time.Second
+)
+
+//ErrWatchLimitReached is returned when no file watch is released within the timeout once the global limit is reached
+var ErrWatchLimitReached = errors.New("file watch limit reached")
+
+//watchLimiter caps the file watches held by the channels of this process
+type watchLimiter struct {
+	mu    sync.Mutex
+	count int
+	limit int
+	//closed and replaced on every release, to wake up all the waiters
+	released chan bool
+}
+
+var watches = &watchLimiter{
+	limit:    defaultMaxWatches,
+	released: make(chan bool),
+}
+
+//SetMaxWatches changes the global limit of file watches, the watches already held are not affected
+func SetMaxWatches(limit int) {
+	watches.mu.Lock()
+	defer watches.mu.Unlock()
+	watches.limit = limit
+	watches.broadcast()
+}
+
+//WatchCount returns the number of file watches currently held by the channels, for diagnostics
+func WatchCount() int {
+	watches.mu.Lock()
+	defer watches.mu.Unlock()
+	return watches.count
+}
+
+//number of watch go-routines running, see ActiveWatchRoutines()
+var activeWatchRoutines int32
+
+//ActiveWatchRoutines returns the number of watch go-routines running, for diagnostics
+//a count staying above the number of open channels reveals channels that never fully closed
+func ActiveWatchRoutines() int {
+	return int(atomic.LoadInt32(&activeWatchRoutines))
+}
+
+//acquire a watch, block until one is released if the limit is reached
+func (l *watchLimiter) acquire(timeout time.Duration) error {
+	deadline := time.After(timeout)
+	for {
+		l.mu.Lock()
+		if l.count < l.limit {
+			l.count++
+			l.mu.Unlock()
+			return nil
+		}
+		released := l.released
+		l.mu.Unlock()
+		select {
+		case <-released:
+		case <-deadline:
+			return ErrWatchLimitReached
+		}
+	}
+}
+
+func (l *watchLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.count--
+	l.broadcast()
+}
+
+//must be called with the lock held
+func (l *watchLimiter) broadcast() {
+	close(l.released)
+	l.released = make(chan bool)
+}
@@ -0,0 +1,92 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchLimit(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "watchlimit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	//a private limiter, so that the channels released by the other tests do not interfere
+	defer func(original *watchLimiter) { watches = original }(watches)
+	watches = &watchLimiter{limit: 1, released: make(chan bool)}
+	base := WatchCount()
+
+	first, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, path.Join(dir, "first"))
+	assert.NoError(t, err)
+	assert.Equal(t, base+1, WatchCount())
+	_, err = NewFileWatcherChannelWithOptions(log.NewMockLog(), ModeMaster, path.Join(dir, "second"), Options{WatchTimeout: 50 * time.Millisecond})
+	assert.Equal(t, ErrWatchLimitReached, err)
+	_, err = os.Stat(path.Join(dir, "second"))
+	assert.True(t, os.IsNotExist(err))
+
+	//a blocked channel proceeds once a watch is released
+	created := make(chan error)
+	go func() {
+		second, err := NewFileWatcherChannelWithOptions(log.NewMockLog(), ModeMaster, path.Join(dir, "second"), Options{WatchTimeout: 5 * time.Second})
+		if err == nil {
+			second.Destroy()
+		}
+		created <- err
+	}()
+	first.Destroy()
+	assert.NoError(t, <-created)
+	//the watch is released by the asynchronous teardown of Close()
+	for deadline := time.Now().Add(5 * time.Second); WatchCount() != base && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, base, WatchCount())
+}
+
+func TestWatchRoutinesDoNotLeak(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "watchleak")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	var channels []*fileWatcherChannel
+	for i := 0; i < 20; i++ {
+		ch, err := NewFileWatcherChannel(log.NewMockLog(), ModeMaster, path.Join(dir, fmt.Sprintf("channel%v", i)))
+		assert.NoError(t, err)
+		channels = append(channels, ch)
+	}
+	//the go-routines start asynchronously as well
+	deadline := time.Now().Add(5 * time.Second)
+	for ActiveWatchRoutines() < len(channels) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, len(channels), ActiveWatchRoutines())
+	for i, ch := range channels {
+		//exercise the replaced watchers as well
+		if i%2 == 0 {
+			assert.NoError(t, ch.Reset())
+		}
+		ch.Destroy()
+	}
+	//the go-routines exit once their watcher is closed, asynchronously
+	deadline = time.Now().Add(5 * time.Second)
+	for ActiveWatchRoutines() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 0, ActiveWatchRoutines())
+}
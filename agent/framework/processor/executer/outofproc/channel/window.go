@@ -0,0 +1,142 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"os"
+	"time"
+)
+
+const (
+	//number of consecutive window scans consuming nothing before falling back to a full scan
+	maxWindowMisses = 3
+	//the default of Options.GapGracePeriod
+	defaultGapGracePeriod = 5 * time.Second
+)
+
+//consumeWindowLocked is consumeAllLocked restricted to the messages within Options.ConsumeWindow of the next expected one,
+//the stragglers further ahead are left on disk until the gap fills; the caller must hold consumeMu
+//if the window repeatedly fails to fill, e.g. the missing message is lost, it falls back to a full scan
+func (ch *fileWatcherChannel) consumeWindowLocked() {
+	if ch.options.ConsumeWindow <= 0 || ch.windowMisses >= maxWindowMisses {
+		ch.windowMisses = 0
+		ch.windowSkipped = false
+		ch.gapSince = time.Time{}
+		ch.consumeAllLocked()
+		return
+	}
+	for {
+		start := ch.recvCounter
+		names, skipped := ch.readWindow()
+		if ch.options.Order != nil && len(names) > 1 {
+			names = ch.options.Order(names, ch.isControlFile)
+		}
+		ch.consumeNamesLocked(names)
+		ch.windowSkipped = skipped
+		if !skipped {
+			ch.windowMisses = 0
+			ch.gapSince = time.Time{}
+			return
+		}
+		//the window moved forward, the stragglers it reached are consumed by the next round
+		if ch.recvCounter != start {
+			ch.windowMisses = 0
+			continue
+		}
+		ch.windowMisses++
+		ch.logger.Debugf("message %v not arrived yet, leaving the messages after it on disk", ch.recvCounter)
+		ch.watchGapLocked()
+		return
+	}
+}
+
+//watchGapLocked arms the repair of the gap in front of the stragglers, the miss count only falls back to a full scan
+//when more messages arrive, so a gap followed by silence would stall the delivery otherwise; the caller must hold consumeMu
+func (ch *fileWatcherChannel) watchGapLocked() {
+	grace := ch.options.GapGracePeriod
+	if grace == 0 {
+		grace = defaultGapGracePeriod
+	}
+	if grace < 0 || (!ch.gapSince.IsZero() && ch.gapCounter == ch.recvCounter) {
+		return
+	}
+	ch.gapCounter = ch.recvCounter
+	ch.gapSince = time.Now()
+	time.AfterFunc(grace, func() {
+		defer ch.recoverPanic()
+		ch.consumeMu.Lock()
+		if ch.isClosed() {
+			ch.consumeMu.Unlock()
+			return
+		}
+		skipped := ch.repairGapLocked(grace)
+		ch.consumeMu.Unlock()
+		//reported outside of the consumption, the callback may well close the channel
+		if len(skipped) > 0 && ch.options.OnGap != nil {
+			ch.options.OnGap(skipped)
+		}
+	})
+}
+
+//skip the missing message if it's still missing after the grace period while the later ones are pending
+//return the ids skipped, they carry the stamp of the first pending message as the missing files are gone
+func (ch *fileWatcherChannel) repairGapLocked(grace time.Duration) []SequenceID {
+	if ch.gapSince.IsZero() || ch.gapCounter != ch.recvCounter || time.Since(ch.gapSince) < grace {
+		return nil
+	}
+	ch.gapSince = time.Time{}
+	next, found := ch.lowestPending()
+	if !found || next.Counter <= ch.recvCounter {
+		return nil
+	}
+	ch.logger.Errorf("message %v is missing for over %v while later messages are pending, skipping to message %v",
+		ch.recvCounter, grace, next.Counter)
+	var skipped []SequenceID
+	for counter := ch.recvCounter; counter < next.Counter; counter++ {
+		skipped = append(skipped, SequenceID{Mode: next.Mode, Stamp: next.Stamp, Counter: counter})
+	}
+	ch.recvCounter = next.Counter
+	ch.windowMisses = 0
+	ch.consumeWindowLocked()
+	return skipped
+}
+
+//list the readable messages within the window in sequence order, the names are listed without a stat of each file
+//return whether any message was skipped for being too far ahead
+func (ch *fileWatcherChannel) readWindow() (names []string, skipped bool) {
+	dir, err := os.Open(ch.path)
+	if err != nil {
+		ch.logger.Errorf("failed to open channel directory %v: %v", ch.path, err)
+		return nil, false
+	}
+	all, _ := dir.Readdirnames(-1)
+	dir.Close()
+	var readable []string
+	for _, name := range all {
+		if ch.isReadable(name) {
+			readable = append(readable, name)
+		}
+	}
+	ch.sortSequenceNames(readable)
+	restarted := ch.peerRestarted(readable)
+	for _, name := range readable {
+		//a malformed sequence id is left to consume(), which dead-letters it
+		if id, err := ParseSequenceID(name); err == nil && ch.beyondWindow(id, restarted) {
+			skipped = true
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, skipped
+}
@@ -0,0 +1,185 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package channel
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sequenceName(counter int) string {
+	return fmt.Sprintf("worker-20170101000000-%06d", counter)
+}
+
+func TestConsumeWindowWaitsForGap(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{ConsumeWindow: 1})
+	defer os.RemoveAll(ch.path)
+	dropMessage(t, ch.path, sequenceName(2), "m2")
+	ch.onCreate(path.Join(ch.path, sequenceName(2)))
+	//the straggler stays on disk until the messages in front of it arrive
+	assert.Empty(t, ch.onMessageChan)
+	_, err := os.Stat(path.Join(ch.path, sequenceName(2)))
+	assert.NoError(t, err)
+
+	dropMessage(t, ch.path, sequenceName(1), "m1")
+	dropMessage(t, ch.path, sequenceName(0), "m0")
+	ch.onCreate(path.Join(ch.path, sequenceName(0)))
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, fmt.Sprintf("m%v", i), <-ch.onMessageChan)
+	}
+	assert.Equal(t, 3, ch.recvCounter)
+	assert.False(t, ch.windowSkipped)
+	assert.Equal(t, 0, ch.windowMisses)
+}
+
+func TestConsumeWindowFallsBackToFullScan(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{ConsumeWindow: 1})
+	defer os.RemoveAll(ch.path)
+	straggler := path.Join(ch.path, sequenceName(5))
+	dropMessage(t, ch.path, sequenceName(5), "m5")
+	//the gap never fills
+	for i := 0; i < maxWindowMisses; i++ {
+		ch.onCreate(straggler)
+		assert.Empty(t, ch.onMessageChan)
+	}
+	ch.onCreate(straggler)
+	assert.Equal(t, "m5", <-ch.onMessageChan)
+	assert.Equal(t, 6, ch.recvCounter)
+	assert.Equal(t, 0, ch.windowMisses)
+}
+
+//a message deleted out-of-band is skipped once the gap persists for the grace period, even if no more messages arrive
+func TestConsumeWindowSkipsDeletedMessage(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{ConsumeWindow: 1})
+	defer os.RemoveAll(ch.path)
+	ch.options.GapGracePeriod = 100 * time.Millisecond
+	for i := 0; i < 2; i++ {
+		dropMessage(t, ch.path, sequenceName(i), fmt.Sprintf("m%v", i))
+		ch.onCreate(path.Join(ch.path, sequenceName(i)))
+		assert.Equal(t, fmt.Sprintf("m%v", i), <-ch.onMessageChan)
+	}
+	dropMessage(t, ch.path, sequenceName(2), "m2")
+	assert.NoError(t, os.Remove(path.Join(ch.path, sequenceName(2))))
+	for i := 3; i < 5; i++ {
+		dropMessage(t, ch.path, sequenceName(i), fmt.Sprintf("m%v", i))
+		ch.onCreate(path.Join(ch.path, sequenceName(i)))
+	}
+	assert.Empty(t, ch.onMessageChan)
+
+	for i := 3; i < 5; i++ {
+		select {
+		case msg := <-ch.onMessageChan:
+			assert.Equal(t, fmt.Sprintf("m%v", i), msg)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message %v is not delivered after the gap", i)
+		}
+	}
+	ch.consumeMu.Lock()
+	defer ch.consumeMu.Unlock()
+	assert.Equal(t, 5, ch.recvCounter)
+	assert.True(t, ch.gapSince.IsZero())
+}
+
+func TestConsumeWindowReportsGap(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{ConsumeWindow: 1})
+	defer os.RemoveAll(ch.path)
+	ch.options.GapGracePeriod = 100 * time.Millisecond
+	gaps := make(chan []SequenceID, 1)
+	ch.options.OnGap = func(skipped []SequenceID) {
+		gaps <- skipped
+	}
+	dropMessage(t, ch.path, sequenceName(0), "m0")
+	ch.onCreate(path.Join(ch.path, sequenceName(0)))
+	assert.Equal(t, "m0", <-ch.onMessageChan)
+	//messages 1 and 2 never show up
+	dropMessage(t, ch.path, sequenceName(3), "m3")
+	ch.onCreate(path.Join(ch.path, sequenceName(3)))
+
+	select {
+	case skipped := <-gaps:
+		assert.Equal(t, []SequenceID{
+			{Mode: ModeWorker, Stamp: "20170101000000", Counter: 1},
+			{Mode: ModeWorker, Stamp: "20170101000000", Counter: 2},
+		}, skipped)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the gap is not reported")
+	}
+	assert.Equal(t, "m3", <-ch.onMessageChan)
+	assert.Empty(t, gaps)
+}
+
+//a message arriving late within the grace period is delivered in order
+func TestConsumeWindowGapFilledWithinGracePeriod(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{ConsumeWindow: 1})
+	defer os.RemoveAll(ch.path)
+	ch.options.GapGracePeriod = 100 * time.Millisecond
+	dropMessage(t, ch.path, sequenceName(1), "m1")
+	ch.onCreate(path.Join(ch.path, sequenceName(1)))
+	dropMessage(t, ch.path, sequenceName(0), "m0")
+	ch.onCreate(path.Join(ch.path, sequenceName(0)))
+	assert.Equal(t, "m0", <-ch.onMessageChan)
+	assert.Equal(t, "m1", <-ch.onMessageChan)
+
+	time.Sleep(3 * ch.options.GapGracePeriod)
+	ch.consumeMu.Lock()
+	defer ch.consumeMu.Unlock()
+	assert.Equal(t, 2, ch.recvCounter)
+	assert.True(t, ch.gapSince.IsZero())
+}
+
+func TestConsumeWindowDisabled(t *testing.T) {
+	ch := newTestChannel(t, ModeMaster, Options{ConsumeWindow: 0})
+	defer os.RemoveAll(ch.path)
+	dropMessage(t, ch.path, sequenceName(5), "m5")
+	ch.onCreate(path.Join(ch.path, sequenceName(5)))
+	assert.Equal(t, "m5", <-ch.onMessageChan)
+}
+
+//the peer's messages show up in reverse order, each one triggering a directory poll
+func benchmarkConsumeReordered(b *testing.B, window int) {
+	const batch = 64
+	ch := newTestChannel(b, ModeMaster, Options{ConsumeWindow: window})
+	defer os.RemoveAll(ch.path)
+	ch.onMessageChan = make(chan string, batch)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		base := ch.recvCounter
+		for j := batch - 1; j >= 0; j-- {
+			b.StopTimer()
+			name := sequenceName(base + j)
+			dropMessage(b, ch.path, name, "m")
+			b.StartTimer()
+			ch.onCreate(path.Join(ch.path, name))
+		}
+		for j := 0; j < batch; j++ {
+			<-ch.onMessageChan
+		}
+		//the full scan consumes in arrival order, the counter is the last consumed one
+		ch.recvCounter = base + batch
+	}
+}
+
+func BenchmarkConsumeReorderedFullScan(b *testing.B) {
+	benchmarkConsumeReordered(b, 0)
+}
+
+func BenchmarkConsumeReorderedWindow(b *testing.B) {
+	benchmarkConsumeReordered(b, 8)
+}
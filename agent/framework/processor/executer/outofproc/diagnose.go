@@ -0,0 +1,68 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package outofproc
+
+import (
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer/outofproc/channel"
+	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer/outofproc/proc"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+//WorkerOutcome classifies how far the document worker got, to triage a failed document
+type WorkerOutcome string
+
+const (
+	//the worker process was never launched, e.g. the spawn failed and the document fell back to run in-process
+	WorkerNeverSpawned WorkerOutcome = "never-spawned"
+	//the process was launched but never wrote to the channel, it failed to start or hung during its initialization
+	WorkerNoHandshake WorkerOutcome = "spawned-but-no-handshake"
+	//the worker came up and exited or crashed since
+	WorkerDied WorkerOutcome = "handshake-then-died"
+	//the worker came up and is running
+	WorkerHealthy WorkerOutcome = "healthy"
+)
+
+//peerObserver is the part of the file channel telling whether the worker end came up
+type peerObserver interface {
+	PeerSeen() bool
+}
+
+//ClassifyWorker combines the launch record of the worker, whether the process is still alive and whether the worker ever
+//wrote to the channel; a channel not able to tell about its peer, e.g. a mock, is assumed to have heard of it
+//a worker launched by this agent is also required to be its child, unless ps is ruled out by proc.LivenessPidFile
+func ClassifyWorker(log log.T, livenessStrategy proc.LivenessStrategy, documentID string, procInfo contracts.OSProcInfo, launched bool, ipc channel.Channel) WorkerOutcome {
+	//pid 0 is never assigned to a launched worker, see processFinder
+	if procInfo.Pid == 0 {
+		return WorkerNeverSpawned
+	}
+	seen := true
+	if observer, ok := ipc.(peerObserver); ok {
+		seen = observer.PeerSeen()
+	}
+	var alive bool
+	if launched && livenessStrategy != proc.LivenessPidFile {
+		alive = childFinder(log, procInfo)
+	} else {
+		alive = processFinder(log, livenessStrategy, documentID, procInfo)
+	}
+	switch {
+	case !seen:
+		return WorkerNoHandshake
+	case alive:
+		return WorkerHealthy
+	default:
+		return WorkerDied
+	}
+}
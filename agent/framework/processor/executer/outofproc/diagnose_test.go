@@ -0,0 +1,80 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package outofproc
+
+import (
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer/outofproc/channel"
+	channelmock "github.com/aws/amazon-ssm-agent/agent/framework/processor/executer/outofproc/channel/mock"
+	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer/outofproc/proc"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+//a channel reporting whether its peer came up
+type observedChannel struct {
+	*channelmock.MockedChannel
+	seen bool
+}
+
+func (c observedChannel) PeerSeen() bool {
+	return c.seen
+}
+
+func TestClassifyWorker(t *testing.T) {
+	defer func(finder func(log.T, proc.LivenessStrategy, string, contracts.OSProcInfo) bool) { processFinder = finder }(processFinder)
+	launched := contracts.OSProcInfo{Pid: testPid, StartTime: testStartDateTime}
+	testCases := []struct {
+		name     string
+		procInfo contracts.OSProcInfo
+		alive    bool
+		ipc      channel.Channel
+		outcome  WorkerOutcome
+	}{
+		{"spawn failed", contracts.OSProcInfo{}, false, observedChannel{new(channelmock.MockedChannel), false}, WorkerNeverSpawned},
+		{"crashed at startup", launched, false, observedChannel{new(channelmock.MockedChannel), false}, WorkerNoHandshake},
+		{"hung at startup", launched, true, observedChannel{new(channelmock.MockedChannel), false}, WorkerNoHandshake},
+		{"crashed while running", launched, false, observedChannel{new(channelmock.MockedChannel), true}, WorkerDied},
+		{"running", launched, true, observedChannel{new(channelmock.MockedChannel), true}, WorkerHealthy},
+		{"channel not observing the peer", launched, true, new(channelmock.MockedChannel), WorkerHealthy},
+	}
+	for _, testCase := range testCases {
+		alive := testCase.alive
+		processFinder = func(log log.T, livenessStrategy proc.LivenessStrategy, documentID string, procInfo contracts.OSProcInfo) bool {
+			assert.Equal(t, testDocumentID, documentID)
+			return alive
+		}
+		assert.Equal(t, testCase.outcome, ClassifyWorker(logger, proc.LivenessPs, testDocumentID, testCase.procInfo, false, testCase.ipc), testCase.name)
+	}
+}
+
+//a worker launched by this agent must still be its child, a reused pid of the same start time is not taken for it
+func TestClassifyLaunchedWorker(t *testing.T) {
+	defer func(finder func(log.T, proc.LivenessStrategy, string, contracts.OSProcInfo) bool) { processFinder = finder }(processFinder)
+	defer func(finder func(log.T, contracts.OSProcInfo) bool) { childFinder = finder }(childFinder)
+	launched := contracts.OSProcInfo{Pid: testPid, StartTime: testStartDateTime}
+	ipc := observedChannel{new(channelmock.MockedChannel), true}
+	processFinder = func(log log.T, livenessStrategy proc.LivenessStrategy, documentID string, procInfo contracts.OSProcInfo) bool {
+		return true
+	}
+	childFinder = func(log log.T, procInfo contracts.OSProcInfo) bool {
+		assert.Equal(t, launched, procInfo)
+		return false
+	}
+	assert.Equal(t, WorkerDied, ClassifyWorker(logger, proc.LivenessPs, testDocumentID, launched, true, ipc))
+	//ps is not available to tell the parent, the pid file is looked up instead
+	assert.Equal(t, WorkerHealthy, ClassifyWorker(logger, proc.LivenessPidFile, testDocumentID, launched, true, ipc))
+}
@@ -56,7 +56,7 @@ func setup(t *testing.T) *TestCase {
 		t.Fatalf("process already exists: %v", fakeProcess)
 	}
 	fakeProcess = NewFakeProcess(t)
-	processCreator = func(name string, argv []string) (proc.OSProcess, error) {
+	processCreator = func(name string, argv []string, options proc.SpawnOptions) (proc.OSProcess, error) {
 		//fakeProcess is imposed as singleton here
 		if fakeProcess.live {
 			t.Fatalf("start process repeatedly, already exists: %v", fakeProcess)
@@ -68,7 +68,8 @@ func setup(t *testing.T) *TestCase {
 		go fakeProcess.fakeWorker(fakeProcess.t, docID)
 		return fakeProcess, nil
 	}
-	processFinder = func(log log.T, procinfo contracts.OSProcInfo) bool {
+	processFinder = func(log log.T, livenessStrategy proc.LivenessStrategy, documentID string, procinfo contracts.OSProcInfo) bool {
+		assert.Equal(t, testDocumentID, documentID)
 		assert.Equal(t, testPid, procinfo.Pid)
 		return fakeProcess != nil && fakeProcess.live
 	}
@@ -344,7 +345,7 @@ func (p *FakeProcess) fakeWorker(t *testing.T, handle string) {
 	ipc := channelmock.NewFakeChannel(logger, channel.ModeWorker, handle)
 	pipeline := messaging.NewWorkerBackend(ctx, pluginRunner)
 	stopTimer := make(chan bool)
-	if err := messaging.Messaging(log, ipc, pipeline, stopTimer); err != nil {
+	if _, err := messaging.Messaging(log, ipc, pipeline, stopTimer); err != nil {
 		t.Fatalf("worker process messaging encountered error: %v", err)
 	}
 	log.Info("document worker process exited")
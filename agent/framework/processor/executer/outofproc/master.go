@@ -1,6 +1,8 @@
 package outofproc
 
 import (
+	"os"
+	"path"
 	"time"
 
 	"fmt"
@@ -32,30 +34,118 @@ type OutOfProcExecuter struct {
 	docState   *contracts.DocumentState
 	ctx        context.T
 	cancelFlag task.CancelFlag
+	//livenessStrategy determines how a detached worker is looked up, the zero value looks it up through ps
+	livenessStrategy proc.LivenessStrategy
+	//launched is set once the worker is launched by this executer rather than reattached
+	launched bool
 }
 
 var channelCreator = func(log log.T, mode channel.Mode, documentID string) (channel.Channel, error, bool) {
 	return channel.CreateFileChannel(log, mode, documentID)
 }
 
-var processFinder = func(log log.T, procinfo contracts.OSProcInfo) bool {
+var processFinder = func(log log.T, livenessStrategy proc.LivenessStrategy, documentID string, procinfo contracts.OSProcInfo) bool {
 	//If ProcInfo is not initailized
 	//pid 0 is reserved for kernel on both linux and windows, so the assumption is safe here
 	if procinfo.Pid == 0 {
 		return false
 	}
+	var pidFile string
+	if livenessStrategy == proc.LivenessPidFile {
+		channelPath, err := channel.ChannelPath(documentID)
+		if err != nil {
+			log.Errorf("failed to locate the pid file: %v", err)
+			return false
+		}
+		pidFile = path.Join(channelPath, proc.DefaultPidFileName)
+	}
+	return proc.IsProcessAlive(log, livenessStrategy, procinfo.Pid, procinfo.StartTime, pidFile)
+}
+
+//childFinder looks up a worker launched by this agent, it must still be a child of the agent, which hardens the lookup
+//against a reused pid beyond the start time; a reattached worker is reparented, look it up with processFinder instead
+var childFinder = func(log log.T, procinfo contracts.OSProcInfo) bool {
+	return proc.IsChildProcessExists(log, procinfo.Pid, procinfo.StartTime, os.Getpid())
+}
+
+//record the master identity in the channel directory, the worker shuts itself down once the recorded master is gone
+var masterRegistrar = func(log log.T, livenessStrategy proc.LivenessStrategy, documentID string) {
+	channelPath, err := channel.ChannelPath(documentID)
+	if err != nil {
+		log.Errorf("failed to record the master identity, the worker keeps running once the master is gone: %v", err)
+		return
+	}
+	pidFile := path.Join(channelPath, proc.DefaultMasterPidFileName)
+	if err = proc.WriteMasterPidFile(pidFile, livenessStrategy); err != nil {
+		//the record of a previous master would have the worker shut down while this master is alive
+		os.Remove(pidFile)
+		log.Errorf("failed to record the master identity, the worker keeps running once the master is gone: %v", err)
+	}
+}
+
+//the scheduling priority of the document worker for each value of the agent configuration
+var workerPriorities = map[string]proc.Priority{
+	"BelowNormal": proc.PriorityBelowNormal,
+	"Idle":        proc.PriorityIdle,
+}
+
+//derive the resource limits and the priority of the document worker from the agent configuration, a session worker is
+//never restricted, nor is a document worker by default
+func workerSpawnOptions(log log.T, config appconfig.SsmCfg, workerName string) proc.SpawnOptions {
+	if workerName != appconfig.DefaultDocumentWorker {
+		return proc.SpawnOptions{}
+	}
+	priority, ok := workerPriorities[config.DocumentWorkerPriority]
+	if !ok && config.DocumentWorkerPriority != "" {
+		log.Errorf("unknown document worker priority %q, the worker inherits the priority of the agent", config.DocumentWorkerPriority)
+	}
+	return proc.SpawnOptions{
+		MemoryLimit: uint64(config.DocumentWorkerMemoryLimitMB) * 1024 * 1024,
+		CPULimit:    time.Duration(config.DocumentWorkerCPULimitSeconds) * time.Second,
+		Priority:    priority,
+	}
+}
+
+//how long a launched worker has to write its pid file, it's stuck in early init otherwise and killed
+var defaultReadyTimeout = proc.DefaultReadyTimeout
 
-	return proc.IsProcessExists(log, procinfo.Pid, procinfo.StartTime)
+//workerReadiness returns a channel closed once the launched worker is ready, i.e. it opened its end of the channel and
+//recorded itself in the pid file; closing stop ends the lookup
+var workerReadiness = func(log log.T, documentID string, pid int, stop <-chan bool) <-chan bool {
+	channelPath, err := channel.ChannelPath(documentID)
+	if err != nil {
+		//without the channel directory the readiness cannot be told, do not kill the worker for it
+		log.Errorf("failed to locate the pid file, the readiness of the worker is not checked: %v", err)
+		ready := make(chan bool)
+		close(ready)
+		return ready
+	}
+	return proc.PidFileReady(path.Join(channelPath, proc.DefaultPidFileName), pid, stop)
+}
+
+//how often the resources consumed by a launched worker are sampled
+var usageSampleInterval = 30 * time.Second
+
+//usageSampler samples the memory and cpu time of the worker, its start time guards against sampling a reused pid
+var usageSampler = func(pid int, startTime time.Time) (proc.ResourceUsage, error) {
+	return proc.Usage(pid, proc.StartTime{Time: startTime}.Format())
 }
 
-var processCreator = func(name string, argv []string) (proc.OSProcess, error) {
-	return proc.StartProcess(name, argv)
+var processCreator = func(name string, argv []string, options proc.SpawnOptions) (proc.OSProcess, error) {
+	return proc.StartProcessWithOptions(name, argv, options)
 }
 
 func NewOutOfProcExecuter(ctx context.T) *OutOfProcExecuter {
+	return NewOutOfProcExecuterWithLiveness(ctx, proc.LivenessPs)
+}
+
+//NewOutOfProcExecuterWithLiveness creates an executer looking up the detached worker with the given strategy, use
+//proc.LivenessPidFile where ps is forbidden
+func NewOutOfProcExecuterWithLiveness(ctx context.T, livenessStrategy proc.LivenessStrategy) *OutOfProcExecuter {
 	return &OutOfProcExecuter{
-		BasicExecuter: *basicexecuter.NewBasicExecuter(ctx),
-		ctx:           ctx.With("[OutOfProcExecuter]"),
+		BasicExecuter:    *basicexecuter.NewBasicExecuter(ctx),
+		ctx:              ctx.With("[OutOfProcExecuter]"),
+		livenessStrategy: livenessStrategy,
 	}
 }
 
@@ -109,18 +199,25 @@ func (e *OutOfProcExecuter) messaging(log log.T, ipc channel.Channel, resChan ch
 	//handoff reply functionalities to data backend.
 	backend := messaging.NewExecuterBackend(resChan, e.docState, cancelFlag)
 	//handoff the data backend to messaging worker
-	if err := messaging.Messaging(log, ipc, backend, stopTimer); err != nil {
+	finished, err := messaging.Messaging(log, ipc, backend, stopTimer)
+	if err != nil {
 		//the messaging worker encountered error, either ipc run into error or data backend throws error
 		log.Errorf("messaging worker encountered error: %v", err)
+		documentInfo := e.docState.DocumentInformation
+		outcome := ClassifyWorker(log, e.livenessStrategy, documentInfo.DocumentID, documentInfo.ProcInfo, e.launched, ipc)
+		log.Infof("document worker outcome: %v", outcome)
 		if e.docState.DocumentInformation.DocumentStatus == contracts.ResultStatusInProgress ||
 			e.docState.DocumentInformation.DocumentStatus == "" ||
 			e.docState.DocumentInformation.DocumentStatus == contracts.ResultStatusNotStarted {
 			e.docState.DocumentInformation.DocumentStatus = contracts.ResultStatusFailed
 			log.Info("document failed half way, sending fail message...")
-			resChan <- e.generateUnexpectedFailResult(fmt.Sprintf("document process failed unexpectedly: %s , check [ssm-document-worker] log for crash reason", err))
+			resChan <- e.generateUnexpectedFailResult(fmt.Sprintf("document process failed unexpectedly: %s , worker: %s, check [ssm-document-worker] log for crash reason", err, outcome))
 		}
 		//destroy the channel
 		ipc.Destroy()
+	} else if finished {
+		//the document is complete, nothing is left to reattach to
+		ipc.Destroy()
 	}
 }
 
@@ -152,13 +249,16 @@ func (e *OutOfProcExecuter) initialize(stopTimer chan bool) (ipc channel.Channel
 		log.Errorf("failed to create ipc channel: %v", err)
 		return
 	}
+	//a reattaching master overwrites the record of the previous one
+	masterRegistrar(log, e.livenessStrategy, documentID)
 	if found {
 		log.Info("discovered old channel object, trying to find detached process...")
 		var stopTime time.Duration
 		procInfo := e.docState.DocumentInformation.ProcInfo
-		if processFinder(log, procInfo) {
+		if processFinder(log, e.livenessStrategy, documentID, procInfo) {
 			log.Infof("found orphan process: %v, start time: %v", procInfo.Pid, procInfo.StartTime)
 			stopTime = defaultOrphanProcessTimeout
+			go e.cancelOnRequest(ipc, proc.ReattachProcess(procInfo.Pid, procInfo.StartTime))
 		} else {
 			log.Infof("process: %v not found, treat as exited", procInfo.Pid)
 			stopTime = defaultZombieProcessTimeout
@@ -173,7 +273,8 @@ func (e *OutOfProcExecuter) initialize(stopTimer chan bool) (ipc channel.Channel
 			workerName = appconfig.DefaultDocumentWorker
 		}
 		var process proc.OSProcess
-		if process, err = processCreator(workerName, proc.FormArgv(documentID)); err != nil {
+		options := workerSpawnOptions(log, e.ctx.AppConfig().Ssm, workerName)
+		if process, err = processCreator(workerName, proc.FormArgv(documentID), options); err != nil {
 			log.Errorf("start process: %v error: %v", workerName, err)
 			//make sure close the channel
 			ipc.Destroy()
@@ -185,15 +286,56 @@ func (e *OutOfProcExecuter) initialize(stopTimer chan bool) (ipc channel.Channel
 			Pid:       process.Pid(),
 			StartTime: process.StartTime(),
 		}
+		e.launched = true
 		//TODO add command timeout as well, in case process get stuck
-		go e.WaitForProcess(stopTimer, process)
+		exited := make(chan bool)
+		ready := workerReadiness(log, documentID, process.Pid(), exited)
+		go e.WaitForProcess(stopTimer, process, exited)
+		go e.waitForReady(process, exited, ready, defaultReadyTimeout)
+		go e.monitorUsage(process, exited, usageSampleInterval)
+		go e.cancelOnRequest(ipc, process)
 
 	}
 
 	return
 }
 
-func (e *OutOfProcExecuter) WaitForProcess(stopTimer chan bool, process proc.OSProcess) {
+//waitForReady kills a launched worker that does not become ready before the deadline, the worker exiting in the meantime
+//is left to WaitForProcess
+func (e *OutOfProcExecuter) waitForReady(process proc.OSProcess, exited <-chan bool, ready <-chan bool, deadline time.Duration) {
+	log := e.ctx.Log()
+	if err := proc.WaitForReady(log, process, exited, ready, deadline); err != nil {
+		log.Errorf("process: %v failed to start: %v", process.Pid(), err)
+	}
+}
+
+//monitorUsage samples the resources consumed by the launched worker until it exits, and reports its peak memory and
+//total cpu time then; a failed sample is skipped, e.g. the worker is exiting
+func (e *OutOfProcExecuter) monitorUsage(process proc.OSProcess, exited <-chan bool, interval time.Duration) (usage proc.ResourceUsage) {
+	log := e.ctx.Log()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-exited:
+			log.Infof("process: %v used %v of cpu time, peak resident memory: %v bytes", process.Pid(), usage.CPUTime, usage.RSS)
+			return
+		case <-ticker.C:
+			sample, err := usageSampler(process.Pid(), process.StartTime())
+			if err != nil {
+				log.Debugf("failed to sample the usage of process: %v, error message: %v", process.Pid(), err)
+				continue
+			}
+			if sample.RSS > usage.RSS {
+				usage.RSS = sample.RSS
+			}
+			usage.CPUTime = sample.CPUTime
+		}
+	}
+}
+
+//WaitForProcess waits for the launched worker to exit, exited is closed once it does
+func (e *OutOfProcExecuter) WaitForProcess(stopTimer chan bool, process proc.OSProcess, exited chan bool) {
 	log := e.ctx.Log()
 	//TODO revisit this feature, it has done sides of killing the document worker too fast -- the worker might busy doing s3 upload
 	//waitReturned := false
@@ -212,6 +354,7 @@ func (e *OutOfProcExecuter) WaitForProcess(stopTimer chan bool, process proc.OSP
 	} else {
 		log.Debugf("process: %v exited successfully, trying to stop messaging worker", process.Pid())
 	}
+	close(exited)
 	//waitReturned = true
 	timeout(stopTimer, defaultZombieProcessTimeout, e.cancelFlag)
 }
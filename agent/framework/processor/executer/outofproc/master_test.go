@@ -14,10 +14,12 @@ import (
 	"errors"
 
 	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer/outofproc/messaging"
 	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer/outofproc/proc"
 	"github.com/aws/amazon-ssm-agent/agent/log"
 	"github.com/aws/amazon-ssm-agent/agent/task"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 type TestCase struct {
@@ -42,7 +44,16 @@ var testPid = 100
 
 var logger = log.NewMockLog()
 
+//the launched worker is ready right away
+func readyWorker(log log.T, documentID string, pid int, stop <-chan bool) <-chan bool {
+	ready := make(chan bool)
+	close(ready)
+	return ready
+}
+
 func CreateTestCase() *TestCase {
+	masterRegistrar = func(log log.T, livenessStrategy proc.LivenessStrategy, documentID string) {}
+	workerReadiness = readyWorker
 	contextMock := context.NewMockDefaultWithContext([]string{"MASTER"})
 	docStore := new(executermocks.MockDocumentStore)
 	processMock := new(procmock.MockedOSProcess)
@@ -107,7 +118,7 @@ func TestInitializeNewProcess(t *testing.T) {
 		assert.Equal(t, testDocumentID, documentID)
 		return channelMock, nil, false
 	}
-	processCreator = func(name string, argv []string) (proc.OSProcess, error) {
+	processCreator = func(name string, argv []string, options proc.SpawnOptions) (proc.OSProcess, error) {
 		assert.Equal(t, name, appconfig.DefaultDocumentWorker)
 		assert.Equal(t, argv, []string{testDocumentID})
 		return testCase.processMock, nil
@@ -131,6 +142,7 @@ func TestInitializeNewProcess(t *testing.T) {
 	channelMock.AssertExpectations(t)
 	//assert pid is saved
 	assert.Equal(t, testPid, exe.docState.DocumentInformation.ProcInfo.Pid)
+	assert.True(t, exe.launched)
 }
 
 func TestInitializeNewProcessForSession(t *testing.T) {
@@ -141,7 +153,7 @@ func TestInitializeNewProcessForSession(t *testing.T) {
 		assert.Equal(t, testDocumentID, documentID)
 		return channelMock, nil, false
 	}
-	processCreator = func(name string, argv []string) (proc.OSProcess, error) {
+	processCreator = func(name string, argv []string, options proc.SpawnOptions) (proc.OSProcess, error) {
 		assert.Equal(t, name, appconfig.DefaultSessionWorker)
 		assert.Equal(t, argv, []string{testDocumentID})
 		return testCase.processMock, nil
@@ -168,6 +180,7 @@ func TestInitializeNewProcessForSession(t *testing.T) {
 }
 
 func createTestCaseForStartSession() *TestCase {
+	workerReadiness = readyWorker
 	contextMock := context.NewMockDefaultWithContext([]string{"MASTER"})
 	docStore := new(executermocks.MockDocumentStore)
 	processMock := new(procmock.MockedOSProcess)
@@ -212,7 +225,7 @@ func TestCreateProcessFailed(t *testing.T) {
 		return channelMock, nil, false
 	}
 	var err = errors.New("failed to create process")
-	processCreator = func(name string, argv []string) (proc.OSProcess, error) {
+	processCreator = func(name string, argv []string, options proc.SpawnOptions) (proc.OSProcess, error) {
 		assert.Equal(t, name, appconfig.DefaultDocumentWorker)
 		assert.Equal(t, argv, []string{testDocumentID})
 		return nil, err
@@ -237,7 +250,7 @@ func TestInitializeProcessUnexpectedExited(t *testing.T) {
 		assert.Equal(t, testDocumentID, documentID)
 		return channelMock, nil, false
 	}
-	processCreator = func(name string, argv []string) (proc.OSProcess, error) {
+	processCreator = func(name string, argv []string, options proc.SpawnOptions) (proc.OSProcess, error) {
 		assert.Equal(t, name, appconfig.DefaultDocumentWorker)
 		assert.Equal(t, argv, []string{testDocumentID})
 		return testCase.processMock, nil
@@ -265,6 +278,73 @@ func TestInitializeProcessUnexpectedExited(t *testing.T) {
 	testCase.processMock.AssertExpectations(t)
 }
 
+//the worker launches but never becomes ready, it's killed once the readiness deadline elapses
+func TestInitializeWorkerNeverReady(t *testing.T) {
+	defer func(original time.Duration) { defaultReadyTimeout = original }(defaultReadyTimeout)
+	defaultReadyTimeout = 100 * time.Millisecond
+	testCase := CreateTestCase()
+	workerReadiness = func(log log.T, documentID string, pid int, stop <-chan bool) <-chan bool {
+		assert.Equal(t, testDocumentID, documentID)
+		assert.Equal(t, testPid, pid)
+		return make(chan bool)
+	}
+	channelMock := new(channelmock.MockedChannel)
+	channelCreator = func(log log.T, mode channel.Mode, documentID string) (channel.Channel, error, bool) {
+		return channelMock, nil, false
+	}
+	processCreator = func(name string, argv []string, options proc.SpawnOptions) (proc.OSProcess, error) {
+		return testCase.processMock, nil
+	}
+	exe := &OutOfProcExecuter{
+		ctx:        testCase.context,
+		docState:   &testCase.docState,
+		cancelFlag: task.NewChanneledCancelFlag(),
+	}
+	killed := make(chan bool)
+	testCase.processMock.On("Pid").Return(testPid)
+	testCase.processMock.On("StartTime").Return(testStartDateTime)
+	testCase.processMock.On("Wait").Run(func(mock.Arguments) {
+		<-killed
+	}).Return(errors.New("process received SIGKILL"))
+	testCase.processMock.On("Kill").Run(func(mock.Arguments) {
+		close(killed)
+	}).Return(nil)
+	stopTimer := make(chan bool)
+	_, err := exe.initialize(stopTimer)
+	assert.NoError(t, err)
+	//the killed worker is waited for, then messaging is stopped
+	<-stopTimer
+	testCase.processMock.AssertExpectations(t)
+}
+
+//the worker crashes before it becomes ready, it's reported right away and not killed
+func TestInitializeWorkerExitedBeforeReady(t *testing.T) {
+	testCase := CreateTestCase()
+	workerReadiness = func(log log.T, documentID string, pid int, stop <-chan bool) <-chan bool {
+		return make(chan bool)
+	}
+	channelMock := new(channelmock.MockedChannel)
+	channelCreator = func(log log.T, mode channel.Mode, documentID string) (channel.Channel, error, bool) {
+		return channelMock, nil, false
+	}
+	processCreator = func(name string, argv []string, options proc.SpawnOptions) (proc.OSProcess, error) {
+		return testCase.processMock, nil
+	}
+	exe := &OutOfProcExecuter{
+		ctx:        testCase.context,
+		docState:   &testCase.docState,
+		cancelFlag: task.NewChanneledCancelFlag(),
+	}
+	testCase.processMock.On("Pid").Return(testPid)
+	testCase.processMock.On("StartTime").Return(testStartDateTime)
+	testCase.processMock.On("Wait").Return(errors.New("process exited with status 1"))
+	stopTimer := make(chan bool)
+	_, err := exe.initialize(stopTimer)
+	assert.NoError(t, err)
+	<-stopTimer
+	testCase.processMock.AssertNotCalled(t, "Kill")
+}
+
 //TODO revisit this feature
 //func TestTerminateWaitWhenJobComplete(t *testing.T) {
 //	testCase := CreateTestCase()
@@ -300,21 +380,25 @@ func TestInitializeConnectOldOrphan(t *testing.T) {
 	}
 	//make sure not create new process
 	isCreateCalled := false
-	processCreator = func(name string, argv []string) (proc.OSProcess, error) {
+	processCreator = func(name string, argv []string, options proc.SpawnOptions) (proc.OSProcess, error) {
 		isCreateCalled = true
 		return testCase.processMock, nil
 	}
 	//make sure the finder is called
 	isFinderCalled := false
-	processFinder = func(log log.T, procinfo contracts.OSProcInfo) bool {
+	processFinder = func(log log.T, livenessStrategy proc.LivenessStrategy, documentID string, procinfo contracts.OSProcInfo) bool {
+		assert.Equal(t, testDocumentID, documentID)
+		//the strategy chosen for the executer
+		assert.Equal(t, proc.LivenessPidFile, livenessStrategy)
 		isFinderCalled = true
 		return true
 	}
 	cancel := task.NewChanneledCancelFlag()
 	exe := &OutOfProcExecuter{
-		ctx:        testCase.context,
-		docState:   &testCase.docState,
-		cancelFlag: cancel,
+		ctx:              testCase.context,
+		docState:         &testCase.docState,
+		cancelFlag:       cancel,
+		livenessStrategy: proc.LivenessPidFile,
 	}
 	stopTimer := make(chan bool)
 	_, err := exe.initialize(stopTimer)
@@ -323,9 +407,95 @@ func TestInitializeConnectOldOrphan(t *testing.T) {
 	assert.NoError(t, err)
 	assert.False(t, isCreateCalled)
 	assert.True(t, isFinderCalled)
+	//the reattached worker is reparented, it's no child of this agent
+	assert.False(t, exe.launched)
 	channelMock.AssertExpectations(t)
 }
 
+func TestWorkerSpawnOptions(t *testing.T) {
+	config := appconfig.SsmCfg{
+		DocumentWorkerMemoryLimitMB:   100,
+		DocumentWorkerCPULimitSeconds: 60,
+		DocumentWorkerPriority:        "BelowNormal",
+	}
+	assert.Equal(t, proc.SpawnOptions{
+		MemoryLimit: 100 * 1024 * 1024,
+		CPULimit:    time.Minute,
+		Priority:    proc.PriorityBelowNormal,
+	}, workerSpawnOptions(logger, config, appconfig.DefaultDocumentWorker))
+	//a session worker is never restricted
+	assert.Equal(t, proc.SpawnOptions{}, workerSpawnOptions(logger, config, appconfig.DefaultSessionWorker))
+	//unrestricted by default, an unknown priority is inherited
+	assert.Equal(t, proc.SpawnOptions{}, workerSpawnOptions(logger, appconfig.SsmCfg{DocumentWorkerPriority: "High"}, appconfig.DefaultDocumentWorker))
+}
+
+//a channel acknowledging every control message at once
+type controlledChannel struct {
+	*channelmock.MockedChannel
+	sent chan channel.ControlMessage
+}
+
+func (c controlledChannel) SendControlWithAck(msg channel.ControlMessage, deadline time.Duration) (<-chan channel.AckResult, error) {
+	c.sent <- msg
+	result := make(chan channel.AckResult, 1)
+	result <- channel.AckResult{Acked: true}
+	return result, nil
+}
+
+//the cancel of a document is escalated to the worker reattached after an agent restart too
+func TestInitializeReattachedCancel(t *testing.T) {
+	testCase := CreateTestCase()
+	ipc := controlledChannel{new(channelmock.MockedChannel), make(chan channel.ControlMessage, 1)}
+	channelCreator = func(log log.T, mode channel.Mode, documentID string) (channel.Channel, error, bool) {
+		return ipc, nil, true
+	}
+	processFinder = func(log log.T, livenessStrategy proc.LivenessStrategy, documentID string, procinfo contracts.OSProcInfo) bool {
+		return true
+	}
+	cancel := task.NewChanneledCancelFlag()
+	exe := &OutOfProcExecuter{
+		ctx:        testCase.context,
+		docState:   &testCase.docState,
+		cancelFlag: cancel,
+	}
+	_, err := exe.initialize(make(chan bool))
+	assert.NoError(t, err)
+	cancel.Set(task.Canceled)
+	select {
+	case msg := <-ipc.sent:
+		assert.Equal(t, channel.ControlCancel, msg.Type)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the cancel is not sent to the reattached worker")
+	}
+}
+
+//messaging leaves the channel to the executer, which destroys it once the document is complete
+func TestMessagingDestroysCompletedChannel(t *testing.T) {
+	testCase := CreateTestCase()
+	recvChan := make(chan string)
+	channelMock := new(channelmock.MockedChannel)
+	channelMock.On("GetMessage").Return(recvChan)
+	channelMock.On("Send", mock.Anything).Return(nil)
+	channelMock.On("Destroy").Return(nil)
+	cancel := task.NewChanneledCancelFlag()
+	defer cancel.Set(task.Completed)
+	exe := &OutOfProcExecuter{
+		ctx:        testCase.context,
+		docState:   &testCase.docState,
+		cancelFlag: cancel,
+	}
+	complete, err := messaging.CreateDatagram(messaging.MessageTypeComplete, contracts.DocumentResult{Status: contracts.ResultStatusSuccess})
+	assert.NoError(t, err)
+	go func() {
+		recvChan <- complete
+	}()
+	resChan := make(chan contracts.DocumentResult, 1)
+	exe.messaging(logger, channelMock, resChan, cancel, make(chan bool))
+	assert.Equal(t, contracts.ResultStatusSuccess, (<-resChan).Status)
+	//the plugin config may or may not be sent before the terminate
+	channelMock.AssertCalled(t, "Destroy")
+}
+
 //TODO add Run() unittest
 
 //this is needed, since after marshal-unmarshalling thru the data channel, the pointer value changed
@@ -335,3 +505,37 @@ func assertValueEqual(t *testing.T, a map[string]*contracts.PluginResult, b map[
 		assert.Equal(t, *val, *b[key])
 	}
 }
+
+func TestMonitorUsageReportsPeak(t *testing.T) {
+	defer func(sampler func(int, time.Time) (proc.ResourceUsage, error)) { usageSampler = sampler }(usageSampler)
+	testCase := CreateTestCase()
+	testCase.processMock.On("Pid").Return(testPid)
+	testCase.processMock.On("StartTime").Return(testStartDateTime)
+	samples := []proc.ResourceUsage{
+		{RSS: 200, CPUTime: time.Second},
+		{RSS: 300, CPUTime: 2 * time.Second},
+		{RSS: 100, CPUTime: 3 * time.Second},
+	}
+	exited := make(chan bool)
+	count := 0
+	usageSampler = func(pid int, startTime time.Time) (proc.ResourceUsage, error) {
+		assert.Equal(t, testPid, pid)
+		assert.Equal(t, testStartDateTime, startTime)
+		count++
+		if count > len(samples) {
+			//the worker is exiting, it cannot be sampled anymore
+			if count == len(samples)+1 {
+				close(exited)
+			}
+			return proc.ResourceUsage{}, errors.New("process not found")
+		}
+		return samples[count-1], nil
+	}
+	exe := &OutOfProcExecuter{
+		ctx:        testCase.context,
+		docState:   &testCase.docState,
+		cancelFlag: task.NewChanneledCancelFlag(),
+	}
+	usage := exe.monitorUsage(testCase.processMock, exited, time.Millisecond)
+	assert.Equal(t, proc.ResourceUsage{RSS: 300, CPUTime: 3 * time.Second}, usage)
+}
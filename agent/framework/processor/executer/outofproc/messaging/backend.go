@@ -210,3 +210,8 @@ func (p *WorkerBackend) Stop() <-chan int {
 func (p *WorkerBackend) Close() {
 	p.input = nil
 }
+
+//the worker sends the result of the document last
+func (p *WorkerBackend) endsStream() bool {
+	return true
+}
@@ -73,9 +73,21 @@ func ParseDatagram(datagram string) (MessageType, string) {
 	return message.Type, message.Content
 }
 
+//the channels able to tell the peer that no datagram follows, see channel.SendEndOfStream()
+type streamEnder interface {
+	SendEndOfStream() error
+	StreamEnded() bool
+}
+
+//the backends whose last outbound datagram concludes the exchange, i.e. the worker's once it sent the document result
+type streamEnding interface {
+	endsStream() bool
+}
+
 // Messaging implements the duplex transmission between master and worker, it send datagram it received to data backend,
-// TODO ipc should not be destroyed within this worker, destroying ipc object should be done in its caller: Executer
-func Messaging(log log.T, ipc channel.Channel, backend MessagingBackend, stopTimer chan bool) (err error) {
+// the ipc is owned by the caller, finished tells it the exchange is concluded, by a terminate or the peer's end of stream,
+// so that the caller decides whether to destroy the ipc or keep it, e.g. for a reattach
+func Messaging(log log.T, ipc channel.Channel, backend MessagingBackend, stopTimer chan bool) (finished bool, err error) {
 
 	defer func() {
 		if msg := recover(); msg != nil {
@@ -85,6 +97,8 @@ func Messaging(log log.T, ipc channel.Channel, backend MessagingBackend, stopTim
 	log.Info("inter process communication started")
 	requestedStop := false
 	inboundClosed := false
+	//the channel delivers to a single consumer, obtain its go channel once
+	messages := ipc.GetMessage()
 	//TODO add timer, if IPC is unresponsive to Close(), force return
 	for {
 		select {
@@ -106,19 +120,21 @@ func Messaging(log log.T, ipc channel.Channel, backend MessagingBackend, stopTim
 				requestedStop = true
 				//TODO add timer, and if inbound has not closed within a given period, force return
 				if inboundClosed {
+					endStream(log, ipc, backend)
 					ipc.Close()
 				}
 				break
 			} else if signal == stopTypeTerminate {
-				//hard stop, remove the channel and force return
-				log.Info("requested terminate messaging worker, destroying the channel")
-				ipc.Destroy()
+				//hard stop, force return
+				log.Info("requested terminate messaging worker")
+				finished = true
 				return
 			}
 		case datagram, more := <-backend.Accept():
 			if !more {
 				inboundClosed = true
 				if requestedStop {
+					endStream(log, ipc, backend)
 					ipc.Close()
 				}
 				// Set channel to nil by calling Close function. Receive on closed channel is non blocking
@@ -133,8 +149,14 @@ func Messaging(log log.T, ipc channel.Channel, backend MessagingBackend, stopTim
 				log.Errorf("failed to send message to ipc channel: %v", err)
 				return
 			}
-		case datagram, more := <-ipc.GetMessage():
+		case datagram, more := <-messages:
 			if !more {
+				//the peer is done once the datagrams before its end of stream are processed, same as a terminate
+				if ender, ok := ipc.(streamEnder); ok && ender.StreamEnded() {
+					log.Info("peer ended the stream, stop messaging worker")
+					finished = true
+					return
+				}
 				//safe close
 				log.Info("ipc channel closed, stop messaging worker")
 				return
@@ -148,3 +170,16 @@ func Messaging(log log.T, ipc channel.Channel, backend MessagingBackend, stopTim
 		}
 	}
 }
+
+//tell the peer that the exchange is over once the last outbound datagram is sent, so that its messaging stops on the end
+//of stream rather than on its reading of the datagrams
+func endStream(log log.T, ipc channel.Channel, backend MessagingBackend) {
+	if ending, ok := backend.(streamEnding); !ok || !ending.endsStream() {
+		return
+	}
+	if ender, ok := ipc.(streamEnder); ok {
+		if err := ender.SendEndOfStream(); err != nil {
+			log.Errorf("failed to send the end of stream: %v", err)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer/outofproc/channel/mock"
 	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -18,7 +19,6 @@ func TestMessagingTerminate(t *testing.T) {
 	stopChan := make(chan int)
 	channelMock := new(channelmock.MockedChannel)
 	channelMock.On("GetMessage").Return(recvChan)
-	channelMock.On("Destroy").Return(nil)
 	channelMock.On("Send", testInputDatagram).Return(nil)
 	backendMock := new(BackendMock)
 	backendMock.On("Accept").Return(sendChan)
@@ -34,7 +34,12 @@ func TestMessagingTerminate(t *testing.T) {
 		stopChan <- stopTypeTerminate
 	}()
 	stopTimer := make(chan bool)
-	Messaging(logger, channelMock, backendMock, stopTimer)
+	finished, err := Messaging(logger, channelMock, backendMock, stopTimer)
+	assert.NoError(t, err)
+	assert.True(t, finished)
+	//the caller owns the channel
+	channelMock.AssertNotCalled(t, "Destroy")
+	channelMock.AssertNotCalled(t, "Close")
 	channelMock.AssertExpectations(t)
 	backendMock.AssertExpectations(t)
 }
@@ -67,11 +72,103 @@ func TestMessagingShutdown(t *testing.T) {
 		close(sendChan)
 	}()
 	stopTimer := make(chan bool)
-	Messaging(logger, channelMock, backendMock, stopTimer)
+	finished, err := Messaging(logger, channelMock, backendMock, stopTimer)
+	assert.NoError(t, err)
+	assert.False(t, finished)
 	channelMock.AssertExpectations(t)
 	backendMock.AssertExpectations(t)
 }
 
+//the master stops once the worker ended the stream, without waiting for the backend to terminate
+func TestMessagingPeerEndedStream(t *testing.T) {
+	testOutputDatagram := "testoutput"
+	recvChan := make(chan string)
+	channelMock := &endingChannel{MockedChannel: new(channelmock.MockedChannel)}
+	channelMock.On("GetMessage").Return(recvChan)
+	backendMock := new(BackendMock)
+	backendMock.On("Accept").Return(make(chan string))
+	backendMock.On("Process", testOutputDatagram).Return(nil)
+	backendMock.On("Stop").Return(make(chan int))
+	go func() {
+		recvChan <- testOutputDatagram
+		//the go channel is closed by the end of stream
+		channelMock.ended = true
+		close(recvChan)
+	}()
+	finished, err := Messaging(logger, channelMock, backendMock, make(chan bool))
+	assert.NoError(t, err)
+	assert.True(t, finished)
+	//the master may still need the channel, it's up to the caller to destroy it
+	channelMock.AssertNotCalled(t, "Destroy")
+	channelMock.AssertExpectations(t)
+	backendMock.AssertExpectations(t)
+}
+
+//the worker ends the stream once its last datagram is sent, before closing the channel
+func TestMessagingWorkerEndsStream(t *testing.T) {
+	testInputDatagram := "testinput"
+	recvChan := make(chan string)
+	sendChan := make(chan string)
+	stopChan := make(chan int)
+	channelMock := &endingChannel{MockedChannel: new(channelmock.MockedChannel)}
+	channelMock.On("GetMessage").Return(recvChan)
+	channelMock.On("Send", testInputDatagram).Return(nil)
+	channelMock.On("Close").Run(func(mock.Arguments) {
+		assert.Equal(t, 1, channelMock.endsSent)
+		close(recvChan)
+	}).Return(nil)
+	backend := &endingBackend{input: sendChan, stop: stopChan}
+	go func() {
+		sendChan <- testInputDatagram
+		close(sendChan)
+		stopChan <- stopTypeShutdown
+	}()
+	Messaging(logger, channelMock, backend, make(chan bool))
+	channelMock.AssertExpectations(t)
+	assert.Equal(t, 1, channelMock.endsSent)
+}
+
+type endingChannel struct {
+	*channelmock.MockedChannel
+	ended    bool
+	endsSent int
+}
+
+func (c *endingChannel) SendEndOfStream() error {
+	c.endsSent++
+	return nil
+}
+
+func (c *endingChannel) StreamEnded() bool {
+	return c.ended
+}
+
+//a backend ending the stream like the worker's, its input is set to nil once closed
+type endingBackend struct {
+	input chan string
+	stop  chan int
+}
+
+func (b *endingBackend) Accept() <-chan string {
+	return b.input
+}
+
+func (b *endingBackend) Stop() <-chan int {
+	return b.stop
+}
+
+func (b *endingBackend) Process(datagram string) error {
+	return nil
+}
+
+func (b *endingBackend) Close() {
+	b.input = nil
+}
+
+func (b *endingBackend) endsStream() bool {
+	return true
+}
+
 type BackendMock struct {
 	mock.Mock
 }
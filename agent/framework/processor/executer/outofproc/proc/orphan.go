@@ -0,0 +1,105 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package process wraps up the os.Process interface and also provides os-specific process lookup functions
+package proc
+
+import (
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+const (
+	DefaultOrphanCheckInterval = 30 * time.Second
+	//the master pid file is rewritten when a restarted agent reattaches to the channel, wait this long for that to happen
+	DefaultOrphanGracePeriod = 5 * time.Minute
+)
+
+//masterState is the outcome of a single lookup of the master
+type masterState int
+
+const (
+	//the master cannot be looked up, e.g. its pid file is missing or ps is not allowed, it's never taken for gone
+	masterUnknown masterState = iota
+	masterAlive
+	masterGone
+)
+
+//MonitorMaster checks the master recorded in the given pid file at every interval, the returned channel is closed once the
+//master has been confirmed gone for the grace period; a restarted master rewriting the pid file in the meantime resets the
+//countdown, a master that cannot be looked up neither resets nor completes it
+//closing stop ends the monitor without signalling
+func MonitorMaster(log log.T, pidFile string, interval time.Duration, grace time.Duration, stop chan bool) <-chan bool {
+	orphaned := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var goneSince time.Time
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				switch lookupMaster(log, pidFile) {
+				case masterAlive:
+					goneSince = time.Time{}
+					continue
+				case masterUnknown:
+					continue
+				}
+				if goneSince.IsZero() {
+					log.Infof("master recorded in %v is gone, shutting down in %v unless it comes back", pidFile, grace)
+					goneSince = now
+				}
+				if now.Sub(goneSince) >= grace {
+					log.Infof("master recorded in %v did not come back, the worker is orphaned", pidFile)
+					close(orphaned)
+					return
+				}
+			}
+		}
+	}()
+	return orphaned
+}
+
+//look up the master with the liveness strategy it recorded, under LivenessPidFile ps is not allowed and only the null
+//signal tells whether the pid exists
+func lookupMaster(log log.T, pidFile string) masterState {
+	master, err := ReadPidFile(pidFile)
+	if err != nil {
+		log.Errorf("failed to read master pid file %v, cannot tell whether the master is alive: %v", pidFile, err)
+		return masterUnknown
+	}
+	if master.Liveness == LivenessPidFile {
+		exists, err := pidExists(master.Pid)
+		if err != nil {
+			log.Errorf("encountered error when checking master pid %v: %v", master.Pid, err)
+			return masterUnknown
+		}
+		if !exists {
+			return masterGone
+		}
+		return masterAlive
+	}
+	startTime, found, err := lookupStartTime(master.Pid)
+	if err != nil {
+		log.Errorf("encountered error when finding master process %v: %v", master.Pid, err)
+		return masterUnknown
+	}
+	//the pid of a dead master can be reused by an unrelated process, only the recorded start time tells them apart
+	if !found || !startTime.Equal(master.StartTime) {
+		return masterGone
+	}
+	return masterAlive
+}
@@ -0,0 +1,138 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package process wraps up the os.Process interface and also provides os-specific process lookup functions
+package proc
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+//a pid beyond the default pid_max, it never exists
+const nonexistentPid = 4194305
+
+func writeMasterPidFile(t *testing.T, filepath string, pid int, startTime time.Time) {
+	content, err := jsonutil.Marshal(PidFile{Pid: pid, StartTime: startTime.UTC()})
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(filepath, []byte(content), defaultPidFileMode))
+}
+
+func TestMonitorMasterOrphaned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orphan")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	pidFile := path.Join(dir, DefaultMasterPidFileName)
+	writeMasterPidFile(t, pidFile, os.Getpid(), SelfStartTime())
+	stop := make(chan bool)
+	defer close(stop)
+	orphaned := MonitorMaster(log.NewMockLog(), pidFile, 10*time.Millisecond, 50*time.Millisecond, stop)
+	select {
+	case <-orphaned:
+		t.Fatal("worker is orphaned while the master is alive")
+	case <-time.After(200 * time.Millisecond):
+	}
+	//master dies
+	writeMasterPidFile(t, pidFile, nonexistentPid, time.Now())
+	select {
+	case <-orphaned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker is not orphaned after the master is gone")
+	}
+}
+
+func TestMonitorMasterRestartedWithinGracePeriod(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orphan")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	pidFile := path.Join(dir, DefaultMasterPidFileName)
+	writeMasterPidFile(t, pidFile, nonexistentPid, time.Now())
+	stop := make(chan bool)
+	defer close(stop)
+	orphaned := MonitorMaster(log.NewMockLog(), pidFile, 10*time.Millisecond, 500*time.Millisecond, stop)
+	time.Sleep(100 * time.Millisecond)
+	//the restarted master reattaches to the channel and records itself
+	writeMasterPidFile(t, pidFile, os.Getpid(), SelfStartTime())
+	select {
+	case <-orphaned:
+		t.Fatal("worker is orphaned although the master came back")
+	case <-time.After(time.Second):
+	}
+}
+
+//the pid of the dead master is reused by another process, the worker is orphaned all the same
+func TestMonitorMasterPidReused(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orphan")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	pidFile := path.Join(dir, DefaultMasterPidFileName)
+	writeMasterPidFile(t, pidFile, os.Getpid(), SelfStartTime().Add(-time.Hour))
+	stop := make(chan bool)
+	defer close(stop)
+	orphaned := MonitorMaster(log.NewMockLog(), pidFile, 10*time.Millisecond, 50*time.Millisecond, stop)
+	select {
+	case <-orphaned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker is not orphaned after the master pid is reused")
+	}
+}
+
+//the master pid file is not written, the worker cannot tell whether the master is alive and keeps running
+func TestMonitorMasterPidFileMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orphan")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	stop := make(chan bool)
+	defer close(stop)
+	orphaned := MonitorMaster(log.NewMockLog(), path.Join(dir, DefaultMasterPidFileName), 10*time.Millisecond, 50*time.Millisecond, stop)
+	select {
+	case <-orphaned:
+		t.Fatal("worker is orphaned although the master cannot be looked up")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+//under LivenessPidFile the master is looked up with the null signal instead of ps, its pid is enough
+func TestMonitorMasterPidFileLiveness(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orphan")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	pidFile := path.Join(dir, DefaultMasterPidFileName)
+	//ps would tell the start time apart
+	content, err := jsonutil.Marshal(PidFile{Pid: os.Getpid(), StartTime: SelfStartTime().Add(-time.Hour), Liveness: LivenessPidFile})
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(pidFile, []byte(content), defaultPidFileMode))
+	stop := make(chan bool)
+	defer close(stop)
+	orphaned := MonitorMaster(log.NewMockLog(), pidFile, 10*time.Millisecond, 50*time.Millisecond, stop)
+	select {
+	case <-orphaned:
+		t.Fatal("worker is orphaned while the master is alive")
+	case <-time.After(200 * time.Millisecond):
+	}
+	content, err = jsonutil.Marshal(PidFile{Pid: nonexistentPid, Liveness: LivenessPidFile})
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(pidFile, []byte(content), defaultPidFileMode))
+	select {
+	case <-orphaned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker is not orphaned after the master is gone")
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// +build darwin freebsd linux netbsd openbsd
+
+// Package process wraps up the os.Process interface and also provides os-specific process lookup functions
+package proc
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+//ps is not allowed on a hardened host, the worker cannot tell whether the master is alive and keeps running
+func TestMonitorMasterPsFails(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orphan")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	startTime := SelfStartTime()
+	//ps is taken off the PATH rather than replaced, the monitor may still be looking up the master once the test returns
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir)
+	for _, recorded := range []bool{false, true} {
+		pidFile := path.Join(dir, DefaultMasterPidFileName)
+		if recorded {
+			writeMasterPidFile(t, pidFile, os.Getpid(), startTime)
+		}
+		stop := make(chan bool)
+		orphaned := MonitorMaster(log.NewMockLog(), pidFile, 10*time.Millisecond, 50*time.Millisecond, stop)
+		select {
+		case <-orphaned:
+			t.Fatalf("worker is orphaned although the master cannot be looked up, pid file recorded: %v", recorded)
+		case <-time.After(300 * time.Millisecond):
+		}
+		close(stop)
+	}
+}
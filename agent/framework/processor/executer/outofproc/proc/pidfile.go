@@ -0,0 +1,151 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package process wraps up the os.Process interface and also provides os-specific process lookup functions
+package proc
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+type LivenessStrategy string
+
+const (
+	//look up the process table through ps (or the OS process api on windows)
+	LivenessPs LivenessStrategy = "ps"
+	//check the heartbeat of the pid file written by the worker, for environments where ps is not allowed
+	LivenessPidFile LivenessStrategy = "pidfile"
+)
+
+const (
+	DefaultPidFileName = "worker.pid"
+	//the master records its identity in the channel directory, so that the worker can tell when it's orphaned
+	DefaultMasterPidFileName = "master.pid"
+	//the worker touches the pid file at this interval
+	DefaultHeartbeatInterval = 5 * time.Second
+	//the pid file is considered stale if it's not touched within this duration
+	DefaultHeartbeatStaleTimeout = 30 * time.Second
+	defaultPidFileMode           = 0600
+)
+
+//PidFile is the content of the pid file written by the worker
+type PidFile struct {
+	Pid       int       `json:"pid"`
+	StartTime time.Time `json:"startTime"`
+	//Liveness is only recorded by the master, the worker looks up the master the same way the master looks up the worker
+	Liveness LivenessStrategy `json:"liveness,omitempty"`
+}
+
+//WritePidFile writes the pid and start time of the current process to the given path
+func WritePidFile(filepath string) error {
+	return writePidFile(filepath, PidFile{
+		Pid:       os.Getpid(),
+		StartTime: SelfStartTime().UTC(),
+	})
+}
+
+//WriteMasterPidFile writes the pid and start time of the current process along with the liveness strategy it looks up
+//its workers with
+func WriteMasterPidFile(filepath string, strategy LivenessStrategy) error {
+	return writePidFile(filepath, PidFile{
+		Pid:       os.Getpid(),
+		StartTime: SelfStartTime().UTC(),
+		Liveness:  strategy,
+	})
+}
+
+func writePidFile(filepath string, pidFile PidFile) error {
+	content, err := jsonutil.Marshal(pidFile)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath, []byte(content), defaultPidFileMode)
+}
+
+//ReadPidFile parses the pid file at the given path
+func ReadPidFile(filepath string) (pidFile PidFile, err error) {
+	content, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return
+	}
+	err = jsonutil.Unmarshal(string(content), &pidFile)
+	return
+}
+
+//StartPidFileHeartbeat writes the pid file and keeps touching it until the returned channel is closed
+func StartPidFileHeartbeat(log log.T, filepath string, interval time.Duration) (chan bool, error) {
+	if err := WritePidFile(filepath); err != nil {
+		return nil, err
+	}
+	stop := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				if err := os.Chtimes(filepath, now, now); err != nil {
+					log.Errorf("failed to touch pid file %v: %v", filepath, err)
+				}
+			}
+		}
+	}()
+	return stop, nil
+}
+
+//IsPidFileAlive checks the given process owns a pid file refreshed within the staleTimeout, and the pid still exists
+//the start time recorded by the process tells it apart from an earlier process with the same pid
+func IsPidFileAlive(log log.T, filepath string, pid int, createTime time.Time, staleTimeout time.Duration) bool {
+	info, err := os.Stat(filepath)
+	if err != nil {
+		log.Debugf("failed to stat pid file %v: %v", filepath, err)
+		return false
+	}
+	if time.Since(info.ModTime()) > staleTimeout {
+		log.Infof("pid file %v is stale, last heartbeat: %v", filepath, info.ModTime())
+		return false
+	}
+	pidFile, err := ReadPidFile(filepath)
+	if err != nil {
+		log.Errorf("failed to read pid file %v: %v", filepath, err)
+		return false
+	}
+	if pidFile.Pid != pid {
+		log.Infof("pid file %v belongs to process %v, expected %v", filepath, pidFile.Pid, pid)
+		return false
+	}
+	if !(StartTime{Time: pidFile.StartTime}).Equal(createTime) {
+		log.Infof("pid file %v belongs to process %v started at %v, expected %v", filepath, pid, pidFile.StartTime, createTime)
+		return false
+	}
+	exists, err := pidExists(pid)
+	if err != nil {
+		log.Errorf("encountered error when checking pid %v: %v", pid, err)
+	}
+	return exists
+}
+
+//IsProcessAlive looks up the process with the given strategy, pidFile is only used by LivenessPidFile
+func IsProcessAlive(log log.T, strategy LivenessStrategy, pid int, createTime time.Time, pidFile string) bool {
+	if strategy == LivenessPidFile {
+		return IsPidFileAlive(log, pidFile, pid, createTime, DefaultHeartbeatStaleTimeout)
+	}
+	return IsProcessExists(log, pid, createTime)
+}
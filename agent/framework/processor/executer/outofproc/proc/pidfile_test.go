@@ -0,0 +1,83 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package process wraps up the os.Process interface and also provides os-specific process lookup functions
+package proc
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPidFileAlive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pidfile")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	pidFile := path.Join(dir, DefaultPidFileName)
+	stop, err := StartPidFileHeartbeat(log.NewMockLog(), pidFile, 10*time.Millisecond)
+	assert.NoError(t, err)
+	defer close(stop)
+	startTime := SelfStartTime()
+	assert.True(t, IsPidFileAlive(log.NewMockLog(), pidFile, os.Getpid(), startTime, time.Second))
+	//pid file belongs to a different process
+	assert.False(t, IsPidFileAlive(log.NewMockLog(), pidFile, os.Getpid()+1, startTime, time.Second))
+	//pid file belongs to a process started at another time with the same pid
+	assert.False(t, IsPidFileAlive(log.NewMockLog(), pidFile, os.Getpid(), startTime.Add(-time.Hour), time.Second))
+}
+
+func TestPidFileStale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pidfile")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	pidFile := path.Join(dir, DefaultPidFileName)
+	assert.NoError(t, WritePidFile(pidFile))
+	lastHeartbeat := time.Now().Add(-time.Minute)
+	assert.NoError(t, os.Chtimes(pidFile, lastHeartbeat, lastHeartbeat))
+	assert.False(t, IsPidFileAlive(log.NewMockLog(), pidFile, os.Getpid(), SelfStartTime(), DefaultHeartbeatStaleTimeout))
+	//heartbeat resumes
+	assert.NoError(t, os.Chtimes(pidFile, time.Now(), time.Now()))
+	assert.True(t, IsPidFileAlive(log.NewMockLog(), pidFile, os.Getpid(), SelfStartTime(), DefaultHeartbeatStaleTimeout))
+}
+
+func TestPidFileMissing(t *testing.T) {
+	assert.False(t, IsPidFileAlive(log.NewMockLog(), path.Join(os.TempDir(), "nonexist", DefaultPidFileName), os.Getpid(), SelfStartTime(), time.Second))
+}
+
+func TestPidFileReady(t *testing.T) {
+	defer func(original time.Duration) { readyCheckInterval = original }(readyCheckInterval)
+	readyCheckInterval = 10 * time.Millisecond
+	dir, err := ioutil.TempDir("", "pidfile")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	pidFile := path.Join(dir, DefaultPidFileName)
+	stop := make(chan bool)
+	defer close(stop)
+	ready := PidFileReady(pidFile, os.Getpid(), stop)
+	select {
+	case <-ready:
+		t.Fatal("worker is ready before it writes its pid file")
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.NoError(t, WritePidFile(pidFile))
+	select {
+	case <-ready:
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker is not ready after it writes its pid file")
+	}
+}
@@ -15,6 +15,8 @@
 package proc
 
 import (
+	"io"
+	"os"
 	"time"
 
 	"errors"
@@ -25,6 +27,33 @@ import (
 	"github.com/aws/amazon-ssm-agent/agent/log"
 )
 
+//maximum difference allowed between the recorded start time and the one reported by the OS
+//the reported time can be truncated to seconds and derived from the boot time, so it can drift from the recorded start time
+const startTimeTolerance = 3 * time.Second
+
+//ErrSelfSignal is returned when a signal or a kill targets the agent itself, its parent or a process group, e.g. through a
+//corrupted worker record or a reused pid
+var ErrSelfSignal = errors.New("refusing to signal the agent process, its parent or a process group")
+
+//checkSignalTarget is the backstop of every signal and kill, the agent must never take itself down
+//a pid <= 0 addresses a process group, or every process, on unix
+func checkSignalTarget(pid int) error {
+	if pid <= 0 || pid == os.Getpid() || pid == os.Getppid() {
+		return ErrSelfSignal
+	}
+	return nil
+}
+
+//StartTime is the creation time of a process as reported by the OS, its textual representation is platform specific
+type StartTime struct {
+	Time time.Time
+}
+
+//Equal returns whether the recorded start time matches the OS reported one within the tolerance
+func (s StartTime) Equal(recorded time.Time) bool {
+	return recorded.Before(s.Time.Add(startTimeTolerance)) && recorded.After(s.Time.Add(-startTimeTolerance))
+}
+
 //OSProcess is an abstracted interface of os.Process
 type OSProcess interface {
 	//generic ssm visible fields
@@ -55,6 +84,9 @@ func (p *WorkerProcess) StartTime() time.Time {
 
 //TODO use the kill functions provided in executes package
 func (p *WorkerProcess) Kill() error {
+	if err := checkSignalTarget(p.Pid()); err != nil {
+		return err
+	}
 	return p.Cmd.Process.Kill()
 }
 
@@ -62,20 +94,118 @@ func (p *WorkerProcess) Wait() error {
 	return p.Cmd.Wait()
 }
 
+//the liveness of a reattached worker is polled at this interval while waiting for it
+var reattachedPollInterval = time.Second
+
+//ReattachedProcess is a worker launched by a previous agent, it's not a child of this agent and is only known by its identity
+type ReattachedProcess struct {
+	pid       int
+	startTime time.Time
+}
+
+//ReattachProcess returns the worker of the given identity, launched by a previous agent
+func ReattachProcess(pid int, startTime time.Time) *ReattachedProcess {
+	return &ReattachedProcess{pid, startTime}
+}
+
+func (p *ReattachedProcess) Pid() int {
+	return p.pid
+}
+
+func (p *ReattachedProcess) StartTime() time.Time {
+	return p.startTime
+}
+
+//Kill verifies the start time first, the pid may have been reused since the worker exited
+func (p *ReattachedProcess) Kill() error {
+	return Signal(p.pid, p.startTime, os.Kill)
+}
+
+//Wait polls the process table until the worker is gone, its exit status is not available to this agent
+func (p *ReattachedProcess) Wait() error {
+	for {
+		if found, err := find_process(p.pid, p.startTime); err == nil && !found {
+			return nil
+		}
+		time.Sleep(reattachedPollInterval)
+	}
+}
+
+//Priority is the scheduling priority of a worker process relative to the agent
+type Priority int
+
+const (
+	//PriorityInherit runs the worker at the priority of the agent, it's the default
+	PriorityInherit Priority = iota
+	//PriorityBelowNormal is nice 10 on unix and BELOW_NORMAL_PRIORITY_CLASS on windows, e.g. for best-effort documents
+	PriorityBelowNormal
+	//PriorityIdle is nice 19 on unix and IDLE_PRIORITY_CLASS on windows, the worker only runs when the host is otherwise idle
+	PriorityIdle
+)
+
+//SpawnOptions defines the resource limits applied to the worker process at spawn, zero value means unlimited
+type SpawnOptions struct {
+	//MemoryLimit in bytes, enforced by RLIMIT_AS on unix and the process memory limit of a Job Object on windows
+	MemoryLimit uint64
+	//CPULimit is the total cpu time of the process, enforced by RLIMIT_CPU on unix and the Job Object on windows
+	CPULimit time.Duration
+	//Detached launches the worker in a new session (setsid) on unix and as a DETACHED_PROCESS on windows,
+	//so that it survives the agent restart and can be reattached afterwards
+	Detached bool
+	//Priority lowers the scheduling priority of the worker so that it does not starve the agent or the host
+	Priority Priority
+	//Stdout and Stderr receive the output of the worker, e.g. the channel.NewOutputBridge() forwarding it to the master,
+	//discarded if nil; do not combine with Detached, the worker is killed by SIGPIPE once the agent is gone
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
 //start a child process, with the resources attached to its parent
 func StartProcess(name string, argv []string) (OSProcess, error) {
+	return StartProcessWithOptions(name, argv, SpawnOptions{})
+}
+
+//StartProcessWithOptions starts a child process with the given resource limits
+func StartProcessWithOptions(name string, argv []string, options SpawnOptions) (OSProcess, error) {
 	//TODO connect stdin and stdout to avoid seelog error
-	cmd := exec.Command(name, argv...)
-	prepareProcess(cmd)
+	cmd := newCommand(name, argv, options)
+	cmd.Stdout = options.Stdout
+	cmd.Stderr = options.Stderr
+	prepareProcess(cmd, options)
 	err := cmd.Start()
 	p := WorkerProcess{
 		cmd,
 		time.Now().UTC(),
 	}
+	if err == nil && options.Detached {
+		//a restarted agent matches the worker against the OS reported start time, so record that one when available
+		if startTime, found, lookupErr := lookupStartTime(cmd.Process.Pid); lookupErr == nil && found {
+			p.startTime = startTime.Time.UTC()
+		}
+	}
+	if err == nil {
+		if err = applyLimits(cmd, options); err != nil {
+			//do not leave an unrestricted worker running
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+	}
 
 	return &p, err
 }
 
+//the time the current process was initialized, close to its start time
+var initTime = time.Now()
+
+//SelfStartTime returns the start time of the current process as reported by the OS, so that it matches the start time
+//looked up by other processes; falls back to the time the process was initialized where the OS cannot be queried
+func SelfStartTime() time.Time {
+	if startTime, found, err := lookupStartTime(os.Getpid()); err == nil && found && !startTime.Time.IsZero() {
+		return startTime.Time
+	}
+	return initTime
+}
+
 //os.FindProcess() doesn't work on Linux: https://groups.google.com/forum/#!topic/golang-nuts/hqrp0UHBK9k
 //what we can only do is check whether it exists
 func IsProcessExists(log log.T, pid int, createTime time.Time) bool {
@@ -86,6 +216,55 @@ func IsProcessExists(log log.T, pid int, createTime time.Time) bool {
 	return found
 }
 
+//given the pid and its start time, return whether the process is still alive
+func find_process(pid int, startTime time.Time) (bool, error) {
+	actual, found, err := lookupStartTime(pid)
+	if err != nil || !found {
+		return false, err
+	}
+	//a process started at another time reuses the pid of the recorded one, which is gone
+	return actual.Equal(startTime), nil
+}
+
+//ProcessIdentity identifies a worker process, the start time guards against pid reuse
+type ProcessIdentity struct {
+	Pid       int
+	StartTime time.Time
+}
+
+//LivenessResult is the liveness of a single process looked up by FindProcesses
+type LivenessResult struct {
+	Alive bool
+	Err   error
+}
+
+//FindProcesses looks up the liveness of many processes at once, matching all of them against a single process table snapshot
+//this is much cheaper than calling IsProcessExists for each worker, which forks a ps every time on unix
+func FindProcesses(ids []ProcessIdentity) map[int]LivenessResult {
+	pids := make([]int, 0, len(ids))
+	for _, id := range ids {
+		pids = append(pids, id.Pid)
+	}
+	startTimes, err := lookupStartTimes(pids)
+	results := make(map[int]LivenessResult, len(ids))
+	for _, id := range ids {
+		startTime, found := startTimes[id.Pid]
+		//consistent with find_process, a reused pid is not alive
+		results[id.Pid] = LivenessResult{Alive: found && startTime.Equal(id.StartTime), Err: err}
+	}
+	return results
+}
+
+//IsChildProcessExists additionally requires the process to be a child of the given parent pid, hardening the lookup against pid reuse
+//do not use it for reattached workers, their parent is gone and they are reparented
+func IsChildProcessExists(log log.T, pid int, createTime time.Time, ppid int) bool {
+	found, err := find_child_process(pid, createTime, ppid)
+	if err != nil {
+		log.Errorf("encountered error when finding child process: %v", err)
+	}
+	return found
+}
+
 //TODO figure out why sometimes argv does not contain program name
 func ParseArgv(argv []string) (string, string, error) {
 	if len(argv) == 1 {
@@ -17,6 +17,8 @@
 package proc
 
 import (
+	"fmt"
+	"os"
 	"os/exec"
 	"strconv"
 	"strings"
@@ -28,42 +30,193 @@ import (
 //verified on RHEL, Amazon Linux, Ubuntu, Centos, FreeBSD and Darwin
 //TODO optimize this, do not print all processes; what we need is the process belongs to a specific user and no tty attached
 var ps = func() ([]byte, error) {
-	return exec.Command("ps", "-e", "-o", "pid,lstart").CombinedOutput()
+	return psCommand("pid,lstart").CombinedOutput()
 }
 
-func prepareProcess(command *exec.Cmd) {
+//same as ps, with the parent pid column, used to verify the process is a child of the agent
+var psWithParent = func() ([]byte, error) {
+	return psCommand("pid,ppid,lstart").CombinedOutput()
+}
+
+//the lstart column follows LC_TIME, e.g. the day and month names are translated and reordered in some locales,
+//the C locale prints it in the format of ParseStartTime() on every platform
+func psCommand(columns string) *exec.Cmd {
+	cmd := exec.Command("ps", "-e", "-o", columns)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	return cmd
+}
+
+//split a row of the ps output into its leading numeric columns and the lstart column, which spans several tokens,
+//e.g. "  2600  2598 Fri Aug  4 11:39:23 2017"; return false for the header or a malformed row
+func parsePsRow(row string, numeric int) ([]int, string, bool) {
+	parts := strings.Fields(row)
+	if len(parts) <= numeric {
+		return nil, "", false
+	}
+	values := make([]int, numeric)
+	for i := 0; i < numeric; i++ {
+		value, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return nil, "", false
+		}
+		values[i] = value
+	}
+	return values, strings.Join(parts[numeric:], " "), true
+}
+
+//the nice value of each priority, the worker inherits the one of the agent by default
+var niceValues = map[Priority]int{
+	PriorityBelowNormal: 10,
+	PriorityIdle:        19,
+}
+
+//rlimits cannot be set between fork and exec in go, so the command is wrapped in a shell applying ulimit before exec
+//the shell execs into the actual process, so the pid stays the same
+func newCommand(name string, argv []string, options SpawnOptions) *exec.Cmd {
+	var limits []string
+	if options.MemoryLimit > 0 {
+		//ulimit -v is in kilobytes
+		limits = append(limits, fmt.Sprintf("ulimit -v %d", options.MemoryLimit/1024))
+	}
+	if options.CPULimit > 0 {
+		seconds := int64((options.CPULimit + time.Second - 1) / time.Second)
+		limits = append(limits, fmt.Sprintf("ulimit -t %d", seconds))
+	}
+	//nice sets the priority before the worker starts any thread, setpriority on the running worker would only cover
+	//the main thread on linux
+	exe := `exec "$0" "$@"`
+	if nice, ok := niceValues[options.Priority]; ok {
+		exe = fmt.Sprintf(`exec nice -n %d "$0" "$@"`, nice)
+	}
+	if len(limits) == 0 && options.Priority == PriorityInherit {
+		return exec.Command(name, argv...)
+	}
+	script := strings.Join(append(limits, exe), " && ")
+	return exec.Command("/bin/sh", append([]string{"-c", script, name}, argv...)...)
+}
+
+//limits are applied by the wrapping shell on unix
+func applyLimits(command *exec.Cmd, options SpawnOptions) error {
+	return nil
+}
+
+func prepareProcess(command *exec.Cmd, options SpawnOptions) {
+	if options.Detached {
+		// start a new session, the process is no longer tied to the agent's controlling terminal nor process group
+		command.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+		return
+	}
 	// set pgid to new pid, so that the process can survive when upstart/systemd kill the original process group
 	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 }
 
-//given the pid and the unix process startTime format string, return whether the process is still alive
-func find_process(pid int, startTime time.Time) (bool, error) {
+//Signal sends the given signal to the process, after verifying the pid is not reused by checking its startTime
+func Signal(pid int, startTime time.Time, sig os.Signal) error {
+	sysSig, ok := sig.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("unsupported signal: %v", sig)
+	}
+	if err := checkSignalTarget(pid); err != nil {
+		return err
+	}
+	actual, found, err := lookupStartTime(pid)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("process %v not found", pid)
+	}
+	if !actual.Equal(startTime) {
+		return fmt.Errorf("process %v start time %v does not match %v, pid may have been reused", pid, actual.Format(), startTime)
+	}
+	return syscall.Kill(pid, sysSig)
+}
+
+//ParseStartTime parses the lstart column of ps, which is printed in local time, e.g. "Fri Aug  4 11:39:23 2017"
+func ParseStartTime(raw string) (StartTime, error) {
+	parsedTime, err := time.ParseInLocation(time.ANSIC, strings.Join(strings.Fields(raw), " "), time.Local)
+	if err != nil {
+		return StartTime{}, err
+	}
+	return StartTime{Time: parsedTime}, nil
+}
+
+//Format prints the start time in the lstart format of ps
+func (s StartTime) Format() string {
+	return s.Time.In(time.Local).Format(time.ANSIC)
+}
+
+//look up the start time of the given pid in the process table
+func lookupStartTime(pid int) (StartTime, bool, error) {
+	startTimes, err := lookupStartTimes([]int{pid})
+	startTime, found := startTimes[pid]
+	return startTime, found, err
+}
+
+//look up the start times of all the given pids from a single process table snapshot
+//a found pid with an unparsable start time is mapped to a zero StartTime, which matches no recorded start time
+func lookupStartTimes(pids []int) (map[int]StartTime, error) {
+	startTimes := make(map[int]StartTime)
+	//the pids that do not exist at all are ruled out with the null signal, ps is only forked to verify the ones that do
+	requested := make(map[int]bool, len(pids))
+	for _, pid := range pids {
+		if exists, err := pidExists(pid); err == nil && !exists {
+			continue
+		}
+		requested[pid] = true
+	}
+	if len(requested) == 0 {
+		return startTimes, nil
+	}
 	output, err := ps()
 	if err != nil {
-		return false, err
+		return startTimes, err
 	}
-	proc_list := strings.Split(string(output), "\n")
+	for _, row := range strings.Split(string(output), "\n") {
+		values, lstart, ok := parsePsRow(row, 1)
+		if !ok {
+			continue
+		}
+		if pid := values[0]; requested[pid] {
+			startTime, _ := ParseStartTime(lstart)
+			startTimes[pid] = startTime
+		}
+	}
+	return startTimes, nil
+}
 
-	for i := 1; i < len(proc_list); i++ {
-		parts := strings.Fields(proc_list[i])
-		if len(parts) < 2 {
+//given the pid and its expected parent pid, return whether the process is alive and still a child of the parent
+func find_child_process(pid int, startTime time.Time, ppid int) (bool, error) {
+	output, err := psWithParent()
+	if err != nil {
+		return false, err
+	}
+	for _, row := range strings.Split(string(output), "\n") {
+		values, lstart, ok := parsePsRow(row, 2)
+		if !ok || values[0] != pid {
 			continue
 		}
-		_pid, err := strconv.ParseInt(parts[0], 10, 64)
+		if values[1] != ppid {
+			return false, nil
+		}
+		actual, err := ParseStartTime(lstart)
 		if err != nil {
 			return false, err
 		}
-		if pid == int(_pid) {
-			return true, nil
-		}
+		//a reused pid can be a child of the agent too, e.g. another worker launched since
+		return actual.Equal(startTime), nil
 	}
 	return false, nil
 }
 
-//TODO add time comparison
-//compare the 2 UTC date time, whether the startTime is within one sec
-func compareTimes(startTime time.Time, timeRaw string) bool {
-	startTime = startTime.UTC()
-	parsedTime, _ := time.Parse(time.ANSIC, timeRaw)
-	return startTime.Before(parsedTime.Add(time.Second)) && startTime.After(parsedTime.Add(-time.Second))
+//check the existence of the pid with the null signal, without spawning ps
+//EPERM means the process exists but belongs to another user
+var pidExists = func(pid int) (bool, error) {
+	err := syscall.Kill(pid, 0)
+	if err == nil || err == syscall.EPERM {
+		return true, nil
+	} else if err == syscall.ESRCH {
+		return false, nil
+	}
+	return false, err
 }
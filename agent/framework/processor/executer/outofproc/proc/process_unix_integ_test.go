@@ -0,0 +1,209 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// +build integration
+// +build darwin freebsd linux netbsd openbsd
+
+// Package process wraps up the os.Process interface and also provides os-specific process lookup functions
+package proc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer/outofproc/channel"
+	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+//the agent launches a detached worker, restarts mid-execution, and the new agent reattaches to both the worker and the channel
+func TestDetachedWorkerSurvivesAgentRestart(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "detached")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	channelPath := path.Join(dir, "channel")
+	agentChannel, err := channel.NewFileWatcherChannel(log.NewMockLog(), channel.ModeMaster, channelPath)
+	assert.NoError(t, err)
+
+	//the fake worker replies through the channel directory after the agent has restarted
+	script := `sleep 2; printf reply > "$0/tmp/worker-20170101000000-000"; mv "$0/tmp/worker-20170101000000-000" "$0/"; sleep 30`
+	worker, err := StartProcessWithOptions("sh", []string{"-c", script, channelPath}, SpawnOptions{Detached: true})
+	assert.NoError(t, err)
+	pid, startTime := worker.Pid(), worker.StartTime()
+	defer syscall.Kill(pid, syscall.SIGKILL)
+	pgid, err := syscall.Getpgid(pid)
+	assert.NoError(t, err)
+	assert.Equal(t, pid, pgid)
+
+	//agent restarts, only the persisted identity is carried over
+	agentChannel.Close()
+	worker = nil
+
+	assert.True(t, IsProcessExists(log.NewMockLog(), pid, startTime))
+	assert.NoError(t, Signal(pid, startTime, syscall.Signal(0)))
+	newAgentChannel, err := channel.ReopenFileWatcherChannel(log.NewMockLog(), channel.ModeMaster, channelPath)
+	assert.NoError(t, err)
+	msg, err := newAgentChannel.WaitForMessage(10 * time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "reply", msg)
+	newAgentChannel.Destroy()
+
+	_, err = channel.ReopenFileWatcherChannel(log.NewMockLog(), channel.ModeMaster, channelPath)
+	assert.Error(t, err)
+}
+
+//the worker monitors the master recorded in the channel directory and learns it's orphaned once the master is killed
+func TestWorkerOrphanedWhenMasterKilled(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "orphan")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	master, err := StartProcess("sleep", []string{"30"})
+	assert.NoError(t, err)
+	pidFile := path.Join(dir, DefaultMasterPidFileName)
+	content, err := jsonutil.Marshal(PidFile{Pid: master.Pid(), StartTime: master.StartTime()})
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(pidFile, []byte(content), defaultPidFileMode))
+
+	stop := make(chan bool)
+	defer close(stop)
+	orphaned := MonitorMaster(log.NewMockLog(), pidFile, 100*time.Millisecond, 300*time.Millisecond, stop)
+	select {
+	case <-orphaned:
+		t.Fatal("worker is orphaned while the master is alive")
+	case <-time.After(time.Second):
+	}
+	assert.NoError(t, master.Kill())
+	master.Wait()
+	select {
+	case <-orphaned:
+	case <-time.After(10 * time.Second):
+		t.Fatal("worker is not orphaned after the master is killed")
+	}
+}
+
+//a worker stuck in early init never signals ready, it's killed once the readiness deadline elapses
+func TestWorkerNeverReadyIsKilled(t *testing.T) {
+	worker, err := StartProcess("sleep", []string{"30"})
+	assert.NoError(t, err)
+	exited := make(chan bool)
+	var waitErr error
+	go func() {
+		waitErr = worker.Wait()
+		close(exited)
+	}()
+
+	ready := make(chan bool)
+	start := time.Now()
+	err = WaitForReady(log.NewMockLog(), worker, exited, ready, 2*time.Second)
+	assert.Equal(t, ErrNotReady, err)
+	assert.True(t, time.Since(start) < 10*time.Second)
+	select {
+	case <-exited:
+		assert.Error(t, waitErr)
+	case <-time.After(10 * time.Second):
+		t.Fatal("worker is not killed after the readiness deadline")
+	}
+	assert.False(t, IsProcessExists(log.NewMockLog(), worker.Pid(), worker.StartTime()))
+}
+
+//a worker crashing in early init is reported as soon as it exits, although it's not reaped by the time it's checked
+func TestWorkerExitedBeforeReady(t *testing.T) {
+	worker, err := StartProcess("sh", []string{"-c", "exit 1"})
+	assert.NoError(t, err)
+	exited := make(chan bool)
+	go func() {
+		worker.Wait()
+		close(exited)
+	}()
+
+	ready := make(chan bool)
+	start := time.Now()
+	assert.Equal(t, ErrExitedBeforeReady, WaitForReady(log.NewMockLog(), worker, exited, ready, 30*time.Second))
+	assert.True(t, time.Since(start) < 10*time.Second)
+}
+
+//a worker that handshakes within the deadline is left running
+func TestWorkerReadyWithinDeadline(t *testing.T) {
+	worker, err := StartProcess("sleep", []string{"30"})
+	assert.NoError(t, err)
+	exited := make(chan bool)
+	go func() {
+		worker.Wait()
+		close(exited)
+	}()
+	defer func() {
+		worker.Kill()
+		<-exited
+	}()
+
+	ready := make(chan bool)
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		close(ready)
+	}()
+	assert.NoError(t, WaitForReady(log.NewMockLog(), worker, exited, ready, 10*time.Second))
+	assert.True(t, IsProcessExists(log.NewMockLog(), worker.Pid(), worker.StartTime()))
+}
+
+//the output of the worker is forwarded to the master over the channel, line by line in the printing order
+func TestWorkerOutputForwardedToMaster(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "output")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	channelPath := path.Join(dir, "channel")
+	master, err := channel.NewFileWatcherChannel(log.NewMockLog(), channel.ModeMaster, channelPath)
+	assert.NoError(t, err)
+	defer master.Destroy()
+	bridgeChannel, err := channel.NewFileWatcherChannel(log.NewMockLog(), channel.ModeWorker, channelPath)
+	assert.NoError(t, err)
+	defer bridgeChannel.Close()
+
+	const count = 50
+	stdout := channel.NewOutputBridge(bridgeChannel, channel.OutputStdout)
+	stderr := channel.NewOutputBridge(bridgeChannel, channel.OutputStderr)
+	script := fmt.Sprintf(`i=0; while [ $i -lt %d ]; do echo "line $i"; i=$((i+1)); done; printf done >&2`, count)
+	worker, err := StartProcessWithOptions("sh", []string{"-c", script}, SpawnOptions{Stdout: stdout, Stderr: stderr})
+	assert.NoError(t, err)
+	assert.NoError(t, worker.Wait())
+	assert.NoError(t, stdout.Close())
+	assert.NoError(t, stderr.Close())
+
+	var received []channel.OutputLine
+	for len(received) < count+1 {
+		msg, err := master.WaitForMessage(10 * time.Second)
+		if !assert.NoError(t, err) {
+			break
+		}
+		line, err := channel.ParseOutputLine(msg)
+		assert.NoError(t, err)
+		received = append(received, line)
+	}
+	var stdoutLines []string
+	for _, line := range received {
+		if line.Stream == channel.OutputStdout {
+			stdoutLines = append(stdoutLines, string(line.Line))
+		} else {
+			assert.Equal(t, channel.OutputLine{Stream: channel.OutputStderr, Line: []byte("done")}, line)
+		}
+	}
+	assert.Len(t, stdoutLines, count)
+	for i, line := range stdoutLines {
+		assert.Equal(t, fmt.Sprintf("line %v", i), line)
+	}
+}
@@ -21,7 +21,11 @@ import (
 
 	"time"
 
+	"os"
 	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
 
 	"github.com/aws/amazon-ssm-agent/agent/log"
 	"github.com/stretchr/testify/assert"
@@ -29,7 +33,6 @@ import (
 
 var logger = log.NewMockLog()
 
-//TODO add process start time
 func TestIsProcessExists(t *testing.T) {
 	cmd := exec.Command("sleep", "10")
 	err := cmd.Start()
@@ -38,6 +41,8 @@ func TestIsProcessExists(t *testing.T) {
 	pid := cmd.Process.Pid
 	logger.Infof("process pid: %v", pid)
 	assert.True(t, IsProcessExists(logger, pid, time.Now()))
+	//a process started at another time is not the recorded one
+	assert.False(t, IsProcessExists(logger, pid, time.Now().Add(-time.Hour)))
 }
 
 //Output format is verified to be identical on RHEL, CENTOS, UBUNTU, AL. However darwin has a different time format
@@ -53,12 +58,15 @@ func TestFindProcess(t *testing.T) {
 		"49382 Mon Aug  7 16:29:10 2017" + "\n" +
 		"16179 Fri Aug 18 15:26:15 2017" + "\n" +
 		"49394 Mon Aug  7 16:29:19 2017"
+	defer func(original func(int) (bool, error)) { pidExists = original }(pidExists)
+	//the pids of the captured output do not exist on this host
+	pidExists = func(int) (bool, error) { return true, nil }
+	defer func(original func() ([]byte, error)) { ps = original }(ps)
 	ps = func() ([]byte, error) {
 		return []byte(testInput), nil
 	}
 	testPidExist := 2598
-	//TODO add time to the overall result
-	testPidExistTime := time.Now()
+	testPidExistTime := time.Date(2017, 8, 4, 11, 39, 23, 0, time.Local)
 	testPidNonExist := 10000
 	exists, err := find_process(testPidExist, testPidExistTime)
 	assert.NoError(t, err)
@@ -66,10 +74,329 @@ func TestFindProcess(t *testing.T) {
 	exists, err = find_process(testPidNonExist, testPidExistTime)
 	assert.NoError(t, err)
 	assert.False(t, exists)
+	//the pid is reused by a process started at another time
+	exists, err = find_process(testPidExist, testPidExistTime.Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+//the lstart column spans several tokens and is padded differently across platforms, the header may span several tokens too
+func TestLookupStartTimesMultiTokenStart(t *testing.T) {
+	testInput := "  PID                  STARTED" + "\n" +
+		"    1 Fri Aug  4 11:39:20 2017" + "\n" +
+		" 2598 Fri Aug  4 11:39:23 2017" + "\n" +
+		"16198 Fri Aug 18 15:28:01 2017" + "\n" +
+		"54770   Mon  Aug  7 17:39:34   2017" + "\n" +
+		"  PID                  STARTED" + "\n" +
+		"garbled row" + "\n" +
+		"  777" + "\n"
+	defer func(original func(int) (bool, error)) { pidExists = original }(pidExists)
+	//the pids of the captured output do not exist on this host
+	pidExists = func(int) (bool, error) { return true, nil }
+	defer func(original func() ([]byte, error)) { ps = original }(ps)
+	ps = func() ([]byte, error) {
+		return []byte(testInput), nil
+	}
+	startTimes, err := lookupStartTimes([]int{2598, 16198, 54770, 777})
+	assert.NoError(t, err)
+	assert.Len(t, startTimes, 3)
+	assert.True(t, startTimes[2598].Time.Equal(time.Date(2017, 8, 4, 11, 39, 23, 0, time.Local)))
+	assert.True(t, startTimes[16198].Time.Equal(time.Date(2017, 8, 18, 15, 28, 1, 0, time.Local)))
+	assert.True(t, startTimes[54770].Time.Equal(time.Date(2017, 8, 7, 17, 39, 34, 0, time.Local)))
+	//a row without a start time is not a process
+	_, found := startTimes[777]
+	assert.False(t, found)
+
+	values, lstart, ok := parsePsRow("  2600  2598 Fri Aug  4 11:39:23 2017", 2)
+	assert.True(t, ok)
+	assert.Equal(t, []int{2600, 2598}, values)
+	assert.Equal(t, "Fri Aug 4 11:39:23 2017", lstart)
+	_, _, ok = parsePsRow("  PID  PPID                  STARTED", 2)
+	assert.False(t, ok)
+}
+
+//the start time is printed in the C locale whatever the locale of the agent
+func TestPsCommandLocale(t *testing.T) {
+	cmd := psCommand("pid,lstart")
+	assert.Equal(t, []string{"ps", "-e", "-o", "pid,lstart"}, cmd.Args)
+	assert.Equal(t, "LC_ALL=C", cmd.Env[len(cmd.Env)-1])
+	startTime, found, err := lookupStartTime(os.Getpid())
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.False(t, startTime.Time.IsZero())
+}
+
+//lstart output captured on Amazon Linux, Ubuntu and Darwin
+func TestParseStartTime(t *testing.T) {
+	testCases := []struct {
+		raw      string
+		expected time.Time
+	}{
+		{"Fri Aug  4 11:39:23 2017", time.Date(2017, 8, 4, 11, 39, 23, 0, time.Local)},
+		{"Mon Aug 14 16:29:09 2017", time.Date(2017, 8, 14, 16, 29, 9, 0, time.Local)},
+		{"Thu Jan  1 00:00:00 2015", time.Date(2015, 1, 1, 0, 0, 0, 0, time.Local)},
+	}
+	for _, testCase := range testCases {
+		startTime, err := ParseStartTime(testCase.raw)
+		assert.NoError(t, err)
+		assert.True(t, startTime.Time.Equal(testCase.expected))
+		assert.True(t, startTime.Equal(testCase.expected.Add(10*time.Millisecond)))
+		assert.False(t, startTime.Equal(testCase.expected.Add(time.Minute)))
+		reparsed, err := ParseStartTime(startTime.Format())
+		assert.NoError(t, err)
+		assert.Equal(t, startTime, reparsed)
+	}
+	_, err := ParseStartTime("Aug 4 2017")
+	assert.Error(t, err)
+}
+
+func TestSignal(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "trap 'exit 7' USR1; while true; do sleep 0.1; done")
+	assert.NoError(t, cmd.Start())
+	startTime := time.Now()
+	pid := cmd.Process.Pid
+	//mismatched start time indicates a reused pid, must not be signaled
+	assert.Error(t, Signal(pid, startTime.Add(-time.Hour), syscall.SIGUSR1))
+	if !assert.NoError(t, Signal(pid, startTime, syscall.SIGUSR1)) {
+		cmd.Process.Kill()
+	}
+	err := cmd.Wait()
+	exitErr, ok := err.(*exec.ExitError)
+	assert.True(t, ok, "child should exit through the signal trap")
+	assert.Equal(t, 7, exitErr.Sys().(syscall.WaitStatus).ExitStatus())
+}
+
+//a worker launched by a previous agent is killed and waited through its identity only
+func TestReattachedProcess(t *testing.T) {
+	cmd := exec.Command("sleep", "30")
+	assert.NoError(t, cmd.Start())
+	startTime := time.Now()
+	//the exit is reaped by the previous agent, i.e. the test, so that the worker leaves the process table
+	go cmd.Wait()
+	defer func(original time.Duration) { reattachedPollInterval = original }(reattachedPollInterval)
+	reattachedPollInterval = 10 * time.Millisecond
+
+	//the pid is reused by another process
+	assert.Error(t, ReattachProcess(cmd.Process.Pid, startTime.Add(-time.Hour)).Kill())
+	worker := ReattachProcess(cmd.Process.Pid, startTime)
+	assert.Equal(t, cmd.Process.Pid, worker.Pid())
+	assert.NoError(t, worker.Kill())
+	done := make(chan error)
+	go func() {
+		done <- worker.Wait()
+	}()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("wait did not return after the worker is killed")
+	}
+}
+
+func TestSignalSelfRejected(t *testing.T) {
+	for _, pid := range []int{os.Getpid(), os.Getppid(), 0, -1} {
+		assert.Equal(t, ErrSelfSignal, Signal(pid, time.Now(), syscall.SIGKILL))
+	}
+	//a corrupted worker record pointing at the agent is not killed either
+	worker := &WorkerProcess{Cmd: &exec.Cmd{Process: &os.Process{Pid: os.Getpid()}}}
+	assert.Equal(t, ErrSelfSignal, worker.Kill())
+}
+
+func TestStartProcessWithLimitsInherited(t *testing.T) {
+	process, err := StartProcessWithOptions("sh", []string{"-c", `test "$(ulimit -v)" = 102400 && test "$(ulimit -t)" = 5`}, SpawnOptions{
+		MemoryLimit: 100 * 1024 * 1024,
+		CPULimit:    5 * time.Second,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, process.Wait())
+}
+
+func TestStartProcessExceedsMemoryLimit(t *testing.T) {
+	//the child tries to hold 100MB in memory under a 50MB address space cap
+	script := `a=$(head -c 100000000 /dev/zero | tr '\0' a); echo ${#a}`
+	process, err := StartProcessWithOptions("sh", []string{"-c", script}, SpawnOptions{MemoryLimit: 50 * 1024 * 1024})
+	assert.NoError(t, err)
+	assert.Error(t, process.Wait())
+	//same child succeeds without the limit
+	process, err = StartProcessWithOptions("sh", []string{"-c", script}, SpawnOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, process.Wait())
+}
+
+func TestStartProcessExceedsCPULimit(t *testing.T) {
+	process, err := StartProcessWithOptions("sh", []string{"-c", "while :; do :; done"}, SpawnOptions{CPULimit: time.Second})
+	assert.NoError(t, err)
+	done := make(chan error)
+	go func() {
+		done <- process.Wait()
+	}()
+	select {
+	case err = <-done:
+		assert.Error(t, err)
+	case <-time.After(10 * time.Second):
+		process.Kill()
+		t.Fatal("child was not killed by the cpu limit")
+	}
+}
+
+func TestFindProcesses(t *testing.T) {
+	testInput := " PID STARTED" + "\n" +
+		"2598 Fri Aug  4 11:39:23 2017" + "\n" +
+		"16198 Fri Aug 18 15:28:01 2017" + "\n" +
+		"54770 Mon Aug  7 17:39:34 2017"
+	defer func(original func(int) (bool, error)) { pidExists = original }(pidExists)
+	//the pids of the captured output do not exist on this host
+	pidExists = func(int) (bool, error) { return true, nil }
+	defer func(original func() ([]byte, error)) { ps = original }(ps)
+	psCalls := 0
+	ps = func() ([]byte, error) {
+		psCalls++
+		return []byte(testInput), nil
+	}
+	results := FindProcesses([]ProcessIdentity{
+		{Pid: 2598, StartTime: time.Date(2017, 8, 4, 11, 39, 23, 0, time.Local)},
+		{Pid: 54770, StartTime: time.Date(2017, 8, 7, 17, 39, 34, 0, time.Local)},
+		//the pid is reused by a process started at another time
+		{Pid: 16198, StartTime: time.Date(2017, 8, 4, 11, 39, 23, 0, time.Local)},
+		{Pid: 10000, StartTime: time.Now()},
+	})
+	assert.Equal(t, 1, psCalls)
+	assert.Len(t, results, 4)
+	assert.True(t, results[2598].Alive)
+	assert.True(t, results[54770].Alive)
+	assert.False(t, results[16198].Alive)
+	assert.NoError(t, results[16198].Err)
+	assert.False(t, results[10000].Alive)
+	assert.NoError(t, results[10000].Err)
+}
+
+//a pid that does not exist is ruled out without forking ps, an existing one is still verified against the process table
+func TestLookupStartTimeNullSignal(t *testing.T) {
+	startTime := selfStartTime(t)
+	defer func(original func() ([]byte, error)) { ps = original }(ps)
+	psCalls := 0
+	ps = func(original func() ([]byte, error)) func() ([]byte, error) {
+		return func() ([]byte, error) {
+			psCalls++
+			return original()
+		}
+	}(ps)
+	exists, err := find_process(nonexistentPid, time.Now())
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	results := FindProcesses([]ProcessIdentity{{Pid: nonexistentPid, StartTime: time.Now()}, {Pid: nonexistentPid + 1, StartTime: time.Now()}})
+	assert.False(t, results[nonexistentPid].Alive)
+	assert.False(t, results[nonexistentPid+1].Alive)
+	assert.Equal(t, 0, psCalls)
+
+	exists, err = find_process(os.Getpid(), startTime)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, 1, psCalls)
+	//an existing pid missing from the process table, e.g. exited in between, is not found
+	defer func(original func(int) (bool, error)) { pidExists = original }(pidExists)
+	pidExists = func(int) (bool, error) { return true, nil }
+	exists, err = find_process(nonexistentPid, time.Now())
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	assert.Equal(t, 2, psCalls)
+}
+
+//the start time of the test process as reported by the OS
+func selfStartTime(t testing.TB) time.Time {
+	startTime, found, err := lookupStartTime(os.Getpid())
+	assert.NoError(t, err)
+	assert.True(t, found)
+	return startTime.Time
+}
+
+func workerIdentities(b *testing.B, count int) []ProcessIdentity {
+	ids := []ProcessIdentity{{Pid: os.Getpid(), StartTime: selfStartTime(b)}}
+	for i := 1; i < count; i++ {
+		ids = append(ids, ProcessIdentity{Pid: 1000000 + i, StartTime: time.Now()})
+	}
+	return ids
 }
 
-func TestCompareTime(t *testing.T) {
-	testInput := "Fri Aug  4 11:39:23 2017"
-	testTime := time.Date(2017, 8, 4, 11, 39, 23, 10000, time.UTC)
-	assert.True(t, compareTimes(testTime, testInput))
+func BenchmarkFindProcessesBatched(b *testing.B) {
+	ids := workerIdentities(b, 50)
+	for i := 0; i < b.N; i++ {
+		FindProcesses(ids)
+	}
+}
+
+func BenchmarkFindProcessesPerPid(b *testing.B) {
+	ids := workerIdentities(b, 50)
+	for i := 0; i < b.N; i++ {
+		for _, id := range ids {
+			find_process(id.Pid, id.StartTime)
+		}
+	}
+}
+
+func TestFindChildProcess(t *testing.T) {
+	testInput := "  PID  PPID                  STARTED" + "\n" +
+		"    1     0 Fri Aug  4 11:39:20 2017" + "\n" +
+		" 2598     1 Fri Aug  4 11:39:23 2017" + "\n" +
+		" 2600  2598 Fri Aug  4 11:39:23 2017" + "\n" +
+		"16198  2598 Fri Aug 18 15:28:01 2017" + "\n" +
+		"54770     1 Mon Aug  7 17:39:34 2017"
+	defer func(original func() ([]byte, error)) { psWithParent = original }(psWithParent)
+	psWithParent = func() ([]byte, error) {
+		return []byte(testInput), nil
+	}
+	testCases := []struct {
+		pid       int
+		startTime time.Time
+		ppid      int
+		expected  bool
+	}{
+		{2600, time.Date(2017, 8, 4, 11, 39, 23, 0, time.Local), 2598, true},
+		{16198, time.Date(2017, 8, 18, 15, 28, 1, 0, time.Local), 2598, true},
+		//the pid is reused by another child started at another time
+		{16198, time.Date(2017, 8, 4, 11, 39, 23, 0, time.Local), 2598, false},
+		//reparented to init after its parent died
+		{54770, time.Date(2017, 8, 7, 17, 39, 34, 0, time.Local), 2598, false},
+		{10000, time.Now(), 2598, false},
+	}
+	for _, testCase := range testCases {
+		found, err := find_child_process(testCase.pid, testCase.startTime, testCase.ppid)
+		assert.NoError(t, err)
+		assert.Equal(t, testCase.expected, found, "pid %v", testCase.pid)
+	}
+}
+
+func TestIsChildProcessExists(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	assert.NoError(t, cmd.Start())
+	defer cmd.Process.Kill()
+	assert.True(t, IsChildProcessExists(logger, cmd.Process.Pid, time.Now(), os.Getpid()))
+	assert.False(t, IsChildProcessExists(logger, cmd.Process.Pid, time.Now(), os.Getppid()))
+	assert.False(t, IsChildProcessExists(logger, cmd.Process.Pid, time.Now().Add(-time.Hour), os.Getpid()))
+}
+
+func TestStartProcessWithPriority(t *testing.T) {
+	//the nice value is relative to the one of the agent, capped at 19
+	output, err := exec.Command("nice").Output()
+	assert.NoError(t, err)
+	base, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	assert.NoError(t, err)
+	expected := func(increment int) string {
+		if base+increment > 19 {
+			return "19"
+		}
+		return strconv.Itoa(base + increment)
+	}
+	for priority, nice := range map[Priority]string{PriorityInherit: expected(0), PriorityBelowNormal: expected(10), PriorityIdle: expected(19)} {
+		process, err := StartProcessWithOptions("sh", []string{"-c", `test "$(nice)" = "$0"`, nice}, SpawnOptions{Priority: priority})
+		assert.NoError(t, err)
+		assert.NoError(t, process.Wait(), "priority %v", priority)
+	}
+	//the priority applies along with the limits
+	process, err := StartProcessWithOptions("sh", []string{"-c", `test "$(nice)" = "$0" && test "$(ulimit -t)" = 5`, expected(10)}, SpawnOptions{
+		CPULimit: 5 * time.Second,
+		Priority: PriorityBelowNormal,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, process.Wait())
 }
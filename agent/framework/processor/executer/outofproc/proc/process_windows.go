@@ -19,43 +19,234 @@ package proc
 import (
 	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"strconv"
+	"sync"
 	"syscall"
 	"time"
+	"unsafe"
+)
+
+const (
+	jobObjectExtendedLimitInformation = 9
+	jobObjectLimitProcessTime         = 0x2
+	jobObjectLimitProcessMemory       = 0x100
+	processSetQuotaAccess             = 0x100
+	processTerminateAccess            = 0x1
+	detachedProcess                   = 0x8
+	belowNormalPriorityClass          = 0x4000
+	idlePriorityClass                 = 0x40
+	maxConcurrentLookups              = 8
+	errorInvalidParameter             = syscall.Errno(87)
 )
 
 var (
-	//https://msdn.microsoft.com/en-us/library/windows/desktop/ms724290(v=vs.85).aspx
-	windowsBaseTime = time.Date(1601, 1, 1, 0, 0, 0, 0, time.UTC)
+	kernel32                 = syscall.NewLazyDLL("kernel32.dll")
+	createJobObjectW         = kernel32.NewProc("CreateJobObjectW")
+	assignProcessToJobObject = kernel32.NewProc("AssignProcessToJobObject")
+	setInformationJobObject  = kernel32.NewProc("SetInformationJobObject")
 )
 
-func prepareProcess(command *exec.Cmd) {
-	// nothing to do on windows
+//https://msdn.microsoft.com/en-us/library/windows/desktop/ms684156(v=vs.85).aspx
+type jobObjectExtendedLimit struct {
+	PerProcessUserTimeLimit uint64
+	PerJobUserTimeLimit     uint64
+	LimitFlags              uint32
+	MinimumWorkingSetSize   uintptr
+	MaximumWorkingSetSize   uintptr
+	ActiveProcessLimit      uint32
+	Affinity                uintptr
+	PriorityClass           uint32
+	SchedulingClass         uint32
+	IoCounters              [6]uint64
+	ProcessMemoryLimit      uintptr
+	JobMemoryLimit          uintptr
+	PeakProcessMemoryUsed   uintptr
+	PeakJobMemoryUsed       uintptr
+}
+
+func newCommand(name string, argv []string, options SpawnOptions) *exec.Cmd {
+	return exec.Command(name, argv...)
+}
+
+//create a dedicated Job Object carrying the limits and assign the started process to it
+//the job handle is intentionally not closed, it has to live as long as the process
+func applyLimits(command *exec.Cmd, options SpawnOptions) error {
+	if options.MemoryLimit == 0 && options.CPULimit == 0 {
+		return nil
+	}
+	job, _, e1 := createJobObjectW.Call(0, 0)
+	if job == 0 {
+		return fmt.Errorf("create job object error: %v", e1)
+	}
+	var info jobObjectExtendedLimit
+	if options.MemoryLimit > 0 {
+		info.LimitFlags |= jobObjectLimitProcessMemory
+		info.ProcessMemoryLimit = uintptr(options.MemoryLimit)
+	}
+	if options.CPULimit > 0 {
+		info.LimitFlags |= jobObjectLimitProcessTime
+		//user time limit is in 100-nanosecond ticks
+		info.PerProcessUserTimeLimit = uint64(options.CPULimit / 100)
+	}
+	if r1, _, e1 := setInformationJobObject.Call(job, jobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info)); r1 == 0 {
+		syscall.CloseHandle(syscall.Handle(job))
+		return fmt.Errorf("set job object limits error: %v", e1)
+	}
+	handle, err := syscall.OpenProcess(processSetQuotaAccess|processTerminateAccess, false, uint32(command.Process.Pid))
+	if err != nil {
+		syscall.CloseHandle(syscall.Handle(job))
+		return fmt.Errorf("open process error: %v", err)
+	}
+	defer syscall.CloseHandle(handle)
+	if r1, _, e1 := assignProcessToJobObject.Call(job, uintptr(handle)); r1 == 0 {
+		syscall.CloseHandle(syscall.Handle(job))
+		return fmt.Errorf("assign process to job object error: %v", e1)
+	}
+	return nil
+}
+
+//the priority class of each priority, the worker inherits the one of the agent by default
+var priorityClasses = map[Priority]uint32{
+	PriorityBelowNormal: belowNormalPriorityClass,
+	PriorityIdle:        idlePriorityClass,
+}
+
+func prepareProcess(command *exec.Cmd, options SpawnOptions) {
+	var flags uint32
+	if options.Detached {
+		//the worker has no console and is not part of the agent's console process group
+		//TODO the agent Job Object needs JOB_OBJECT_LIMIT_BREAKAWAY_OK for the worker to survive the agent stop
+		flags |= detachedProcess | syscall.CREATE_NEW_PROCESS_GROUP
+	}
+	//the priority class is set at creation, before the worker runs
+	flags |= priorityClasses[options.Priority]
+	if flags != 0 {
+		command.SysProcAttr = &syscall.SysProcAttr{CreationFlags: flags}
+	}
+}
+
+//ParseStartTime parses the creation time reported by GetProcessTimes, printed as the decimal FILETIME,
+//i.e. the number of 100-nanosecond intervals since January 1, 1601 UTC, e.g. "131463203635000000"
+//https://msdn.microsoft.com/en-us/library/windows/desktop/ms724284(v=vs.85).aspx
+func ParseStartTime(raw string) (StartTime, error) {
+	ticks, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return StartTime{}, fmt.Errorf("invalid FILETIME: %v", raw)
+	}
+	filetime := syscall.Filetime{LowDateTime: uint32(ticks), HighDateTime: uint32(ticks >> 32)}
+	return StartTime{Time: time.Unix(0, filetime.Nanoseconds())}, nil
+}
+
+//Format prints the start time as the decimal FILETIME
+func (s StartTime) Format() string {
+	filetime := syscall.NsecToFiletime(s.Time.UnixNano())
+	return strconv.FormatUint(uint64(filetime.HighDateTime)<<32|uint64(filetime.LowDateTime), 10)
 }
 
-//given the pid and the high order filetime, look up the process
-func find_process(pid int, startTime time.Time) (bool, error) {
+//given the pid, look up the process creation time
+func lookupStartTime(pid int) (StartTime, bool, error) {
 	const da = syscall.STANDARD_RIGHTS_READ |
 		syscall.PROCESS_QUERY_INFORMATION | syscall.SYNCHRONIZE
 	handle, err := syscall.OpenProcess(da, false, uint32(pid))
-	defer syscall.CloseHandle(handle)
-	if err != nil {
-		return false, fmt.Errorf("open process error: ", err)
+	if err == errorInvalidParameter {
+		//no such pid, see pidExists
+		return StartTime{}, false, nil
+	} else if err != nil {
+		return StartTime{}, false, fmt.Errorf("open process error: %v", err)
 	}
-	//process exists, check whether the creation time matches
+	defer syscall.CloseHandle(handle)
 	var u syscall.Rusage
 	err = syscall.GetProcessTimes(syscall.Handle(handle), &u.CreationTime, &u.ExitTime, &u.KernelTime, &u.UserTime)
 
 	if err != nil {
-		return false, errors.New("unable to get process time")
+		return StartTime{}, false, errors.New("unable to get process time")
+	}
+	//Filetime.Nanoseconds() is already relative to the unix epoch
+	return StartTime{Time: time.Unix(0, u.CreationTime.Nanoseconds())}, true, nil
+}
+
+//look up the start times of the given pids, with a bounded number of concurrent lookups
+//pids that cannot be opened are considered not found
+func lookupStartTimes(pids []int) (map[int]StartTime, error) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	startTimes := make(map[int]StartTime)
+	semaphore := make(chan bool, maxConcurrentLookups)
+	for _, pid := range pids {
+		wg.Add(1)
+		semaphore <- true
+		go func(pid int) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			if startTime, found, err := lookupStartTime(pid); err == nil && found {
+				mu.Lock()
+				startTimes[pid] = startTime
+				mu.Unlock()
+			}
+		}(pid)
+	}
+	wg.Wait()
+	return startTimes, nil
+}
+
+//given the pid and its expected parent pid, return whether the process is alive and still a child of the parent
+func find_child_process(pid int, startTime time.Time, ppid int) (bool, error) {
+	snapshot, err := syscall.CreateToolhelp32Snapshot(syscall.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return false, fmt.Errorf("create process snapshot error: %v", err)
+	}
+	defer syscall.CloseHandle(snapshot)
+	var entry syscall.ProcessEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+	for err = syscall.Process32First(snapshot, &entry); err == nil; err = syscall.Process32Next(snapshot, &entry) {
+		if int(entry.ProcessID) == pid {
+			if int(entry.ParentProcessID) != ppid {
+				return false, nil
+			}
+			//the snapshot does not carry the creation time, a reused pid can be a child of the agent too
+			return find_process(pid, startTime)
+		}
+	}
+	return false, nil
+}
+
+//Signal on windows only supports os.Kill, other signals are not deliverable to a process
+func Signal(pid int, startTime time.Time, sig os.Signal) error {
+	if sig != os.Kill {
+		return fmt.Errorf("signal %v is not supported on windows", sig)
+	}
+	if err := checkSignalTarget(pid); err != nil {
+		return err
+	}
+	found, err := find_process(pid, startTime)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("process %v not found", pid)
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
 	}
-	//TODO add start time comparison
-	return true, nil
+	return process.Kill()
 }
 
-//TODO add date comparison
-//compare the filetime and Date time, whether they are within 1sec range
-func compare(ftime syscall.Filetime, startTime time.Time) bool {
-	parsedTime := windowsBaseTime.Add(time.Duration(ftime.Nanoseconds()))
-	return startTime.Before(parsedTime.Add(time.Second)) && startTime.After(parsedTime.Add(-time.Second))
+//check the existence of the pid by opening a handle of the process
+//ERROR_ACCESS_DENIED means the process exists but belongs to another user, only ERROR_INVALID_PARAMETER means no such pid
+func pidExists(pid int) (bool, error) {
+	handle, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err == nil {
+		syscall.CloseHandle(handle)
+		return true, nil
+	} else if err == syscall.ERROR_ACCESS_DENIED {
+		return true, nil
+	} else if err == errorInvalidParameter {
+		return false, nil
+	}
+	return false, err
 }
@@ -17,16 +17,16 @@
 package proc
 
 import (
+	"os"
 	"os/exec"
 	"testing"
 
 	"time"
 
 	"github.com/aws/amazon-ssm-agent/agent/log"
-	"src/github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/assert"
 )
 
-//TODO add process start time
 func TestIsProcessExists(t *testing.T) {
 	cmd := exec.Command("cmd", "timeout", "100")
 	err := cmd.Start()
@@ -34,4 +34,64 @@ func TestIsProcessExists(t *testing.T) {
 	pid := cmd.Process.Pid
 	//do not call wait in case process is recycled
 	assert.True(t, IsProcessExists(log.NewMockLog(), pid, time.Now()))
+	//a process started at another time is not the recorded one
+	assert.False(t, IsProcessExists(log.NewMockLog(), pid, time.Now().Add(-time.Hour)))
+}
+
+//CreationTime output of GetProcessTimes, printed as the decimal FILETIME
+func TestParseStartTime(t *testing.T) {
+	testCases := []struct {
+		raw      string
+		expected time.Time
+	}{
+		{"131463203635000000", time.Date(2017, 8, 4, 11, 39, 23, 500000000, time.UTC)},
+		{"131472269491234560", time.Date(2017, 8, 14, 23, 29, 9, 123456000, time.UTC)},
+		{"130645404000000000", time.Date(2014, 12, 31, 23, 0, 0, 0, time.UTC)},
+	}
+	for _, testCase := range testCases {
+		startTime, err := ParseStartTime(testCase.raw)
+		assert.NoError(t, err)
+		assert.True(t, startTime.Time.Equal(testCase.expected))
+		assert.True(t, startTime.Equal(testCase.expected.Add(10*time.Millisecond)))
+		assert.False(t, startTime.Equal(testCase.expected.Add(time.Minute)))
+		assert.Equal(t, testCase.raw, startTime.Format())
+	}
+	_, err := ParseStartTime("20170804113923.500000+000")
+	assert.Error(t, err)
+}
+
+//the start time printed from the lookup output parses back to the same creation time
+func TestParseStartTimeLookup(t *testing.T) {
+	startTime, found, err := lookupStartTime(os.Getpid())
+	assert.NoError(t, err)
+	assert.True(t, found)
+	reparsed, err := ParseStartTime(startTime.Format())
+	assert.NoError(t, err)
+	assert.True(t, startTime.Time.Equal(reparsed.Time))
+	found, err = find_process(os.Getpid(), reparsed.Time)
+	assert.NoError(t, err)
+	assert.True(t, found)
+}
+
+func TestIsChildProcessExists(t *testing.T) {
+	cmd := exec.Command("cmd", "timeout", "100")
+	assert.NoError(t, cmd.Start())
+	defer cmd.Process.Kill()
+	assert.True(t, IsChildProcessExists(log.NewMockLog(), cmd.Process.Pid, time.Now(), os.Getpid()))
+	assert.False(t, IsChildProcessExists(log.NewMockLog(), cmd.Process.Pid, time.Now(), os.Getppid()))
+	//the pid is reused by another child started at another time
+	assert.False(t, IsChildProcessExists(log.NewMockLog(), cmd.Process.Pid, time.Now().Add(-time.Hour), os.Getpid()))
+}
+
+func TestPidExists(t *testing.T) {
+	exists, err := pidExists(os.Getpid())
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	//the System process denies the access to the processes of the users, it exists all the same
+	exists, err = pidExists(4)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = pidExists(nonexistentPid)
+	assert.NoError(t, err)
+	assert.False(t, exists)
 }
@@ -0,0 +1,83 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package process wraps up the os.Process interface and also provides os-specific process lookup functions
+package proc
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+//a launched worker is expected to become ready within this duration, it's stuck in early init otherwise
+const DefaultReadyTimeout = 60 * time.Second
+
+var (
+	ErrNotReady          = errors.New("worker did not become ready before the deadline")
+	ErrExitedBeforeReady = errors.New("worker exited before becoming ready")
+)
+
+//the pid file of a launching worker is checked at this interval
+var readyCheckInterval = time.Second
+
+//WaitForReady blocks until ready is signalled or closed, or the worker exits, or the deadline elapses
+//a worker still alive at the deadline is killed, so that the document does not hang on a worker that never handshakes
+//exited must be closed once Wait() of the launched process returns, the process table is not consulted since an exited
+//child stays in it as a zombie until it's reaped
+func WaitForReady(log log.T, process OSProcess, exited <-chan bool, ready <-chan bool, deadline time.Duration) error {
+	timer := time.NewTimer(deadline)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return nil
+	case <-exited:
+		log.Errorf("process: %v exited before becoming ready", process.Pid())
+		return ErrExitedBeforeReady
+	case <-timer.C:
+	}
+	select {
+	case <-exited:
+		log.Errorf("process: %v exited before becoming ready", process.Pid())
+		return ErrExitedBeforeReady
+	default:
+	}
+	log.Errorf("process: %v did not become ready within %v, killing it", process.Pid(), deadline)
+	if err := process.Kill(); err != nil {
+		log.Errorf("failed to kill process: %v, error: %v", process.Pid(), err)
+	}
+	return ErrNotReady
+}
+
+//PidFileReady returns a channel closed once the pid file at the given path records the given pid, the worker writes it
+//right after opening its end of the channel; closing stop ends the polling without signalling
+func PidFileReady(filepath string, pid int, stop <-chan bool) <-chan bool {
+	ready := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(readyCheckInterval)
+		defer ticker.Stop()
+		for {
+			if pidFile, err := ReadPidFile(filepath); err == nil && pidFile.Pid == pid {
+				close(ready)
+				return
+			}
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return ready
+}
@@ -0,0 +1,47 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package proc
+
+import (
+	"fmt"
+	"time"
+)
+
+//ResourceUsage is a sample of the resources consumed by a process
+type ResourceUsage struct {
+	//resident set size in bytes
+	RSS int64
+	//user and system cpu time consumed since the process started
+	CPUTime time.Duration
+}
+
+//Usage samples the current memory and cumulative cpu time of the process, startTime is the start time of the process as
+//printed by StartTime.Format(), it's verified before sampling so that a reused pid is never sampled instead
+func Usage(pid int, startTime string) (ResourceUsage, error) {
+	expected, err := ParseStartTime(startTime)
+	if err != nil {
+		return ResourceUsage{}, err
+	}
+	actual, found, err := lookupStartTime(pid)
+	if err != nil {
+		return ResourceUsage{}, err
+	}
+	if !found {
+		return ResourceUsage{}, fmt.Errorf("process %v not found", pid)
+	}
+	if !actual.Equal(expected.Time) {
+		return ResourceUsage{}, fmt.Errorf("process %v start time %v does not match %v, pid may have been reused", pid, actual.Format(), startTime)
+	}
+	return sampleUsage(pid)
+}
@@ -0,0 +1,86 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// +build darwin freebsd netbsd openbsd
+
+package proc
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//print the resident set size in kilobytes and the cumulative cpu time of a single process, without the header
+var psUsage = func(pid int) ([]byte, error) {
+	return exec.Command("ps", "-o", "rss=,time=", "-p", strconv.Itoa(pid)).CombinedOutput()
+}
+
+//there is no proc file system to read from, ask ps
+func sampleUsage(pid int) (ResourceUsage, error) {
+	output, err := psUsage(pid)
+	if err != nil {
+		return ResourceUsage{}, err
+	}
+	return parsePsUsage(string(output))
+}
+
+//parse a "<rss> <time>" line of ps
+func parsePsUsage(output string) (ResourceUsage, error) {
+	fields := strings.Fields(output)
+	if len(fields) != 2 {
+		return ResourceUsage{}, fmt.Errorf("unexpected ps output: %v", output)
+	}
+	rss, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return ResourceUsage{}, err
+	}
+	cpuTime, err := parsePsTime(fields[1])
+	if err != nil {
+		return ResourceUsage{}, err
+	}
+	return ResourceUsage{RSS: rss * 1024, CPUTime: cpuTime}, nil
+}
+
+//the time column is [[dd-]hh:]mm:ss[.cc], e.g. "0:01.52" on darwin or "1-02:03:04" on freebsd
+func parsePsTime(raw string) (time.Duration, error) {
+	var days int64
+	if i := strings.Index(raw, "-"); i >= 0 {
+		value, err := strconv.ParseInt(raw[:i], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid cpu time: %v", raw)
+		}
+		days, raw = value, raw[i+1:]
+	}
+	parts := strings.Split(raw, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid cpu time: %v", raw)
+	}
+	seconds, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cpu time: %v", raw)
+	}
+	total := time.Duration(seconds * float64(time.Second))
+	unit := time.Minute
+	for i := len(parts) - 2; i >= 0; i-- {
+		value, err := strconv.ParseInt(parts[i], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid cpu time: %v", raw)
+		}
+		total += time.Duration(value) * unit
+		unit *= 60
+	}
+	return total + time.Duration(days)*24*time.Hour, nil
+}
@@ -0,0 +1,39 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// +build darwin freebsd netbsd openbsd
+
+package proc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePsUsage(t *testing.T) {
+	usage, err := parsePsUsage("  2048   0:01.52\n")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2048*1024), usage.RSS)
+	assert.Equal(t, 1520*time.Millisecond, usage.CPUTime)
+
+	cpuTime, err := parsePsTime("1-02:03:04")
+	assert.NoError(t, err)
+	assert.Equal(t, 26*time.Hour+3*time.Minute+4*time.Second, cpuTime)
+
+	for _, output := range []string{"", "2048", "x 0:01", "2048 1.52", "2048 a:01"} {
+		_, err = parsePsUsage(output)
+		assert.Error(t, err, output)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// +build linux
+
+package proc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//the clock ticks per second of the cpu times in /proc, USER_HZ is 100 on every architecture the agent supports
+const clockTicks = 100
+
+//root of the proc file system, injected by the tests
+var procRoot = "/proc"
+
+//read the cpu times from /proc/<pid>/stat and the resident pages from /proc/<pid>/statm
+func sampleUsage(pid int) (ResourceUsage, error) {
+	dir := path.Join(procRoot, strconv.Itoa(pid))
+	stat, err := ioutil.ReadFile(path.Join(dir, "stat"))
+	if err != nil {
+		return ResourceUsage{}, err
+	}
+	cpuTime, err := parseProcStat(string(stat))
+	if err != nil {
+		return ResourceUsage{}, fmt.Errorf("invalid stat of process %v: %v", pid, err)
+	}
+	statm, err := ioutil.ReadFile(path.Join(dir, "statm"))
+	if err != nil {
+		return ResourceUsage{}, err
+	}
+	pages, err := parseProcStatm(string(statm))
+	if err != nil {
+		return ResourceUsage{}, fmt.Errorf("invalid statm of process %v: %v", pid, err)
+	}
+	return ResourceUsage{RSS: pages * int64(os.Getpagesize()), CPUTime: cpuTime}, nil
+}
+
+//the command name in the second field may contain spaces and parentheses, the fields are counted after its closing one
+//utime and stime are the 14th and 15th fields, see proc(5)
+func parseProcStat(stat string) (time.Duration, error) {
+	end := strings.LastIndex(stat, ")")
+	if end < 0 {
+		return 0, fmt.Errorf("missing command name: %v", stat)
+	}
+	fields := strings.Fields(stat[end+1:])
+	//the fields after the command name start with the 3rd one
+	const utime, stime = 14 - 3, 15 - 3
+	if len(fields) <= stime {
+		return 0, fmt.Errorf("too few fields: %v", stat)
+	}
+	var ticks int64
+	for _, field := range []string{fields[utime], fields[stime]} {
+		value, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		ticks += value
+	}
+	return time.Duration(ticks) * time.Second / clockTicks, nil
+}
+
+//the resident pages are the second field of statm
+func parseProcStatm(statm string) (int64, error) {
+	fields := strings.Fields(statm)
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("too few fields: %v", statm)
+	}
+	return strconv.ParseInt(fields[1], 10, 64)
+}
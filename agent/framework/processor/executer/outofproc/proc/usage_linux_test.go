@@ -0,0 +1,71 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// +build linux
+
+package proc
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampleUsageFromProc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proc")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(root string) { procRoot = root }(procRoot)
+	procRoot = dir
+	assert.NoError(t, os.MkdirAll(path.Join(dir, "4242"), 0700))
+	//the command name contains both a space and a closing parenthesis
+	stat := "4242 (ssm worker) x) S 1 4242 4242 0 -1 4194560 2279 0 0 0 250 75 0 0 20 0 8 0 1234567 1000000 2048 18446744073709551615\n"
+	assert.NoError(t, ioutil.WriteFile(path.Join(dir, "4242", "stat"), []byte(stat), 0600))
+	assert.NoError(t, ioutil.WriteFile(path.Join(dir, "4242", "statm"), []byte("250000 2048 512 100 0 4000 0\n"), 0600))
+
+	usage, err := sampleUsage(4242)
+	assert.NoError(t, err)
+	assert.Equal(t, 3250*time.Millisecond, usage.CPUTime)
+	assert.Equal(t, int64(2048*os.Getpagesize()), usage.RSS)
+
+	_, err = sampleUsage(4343)
+	assert.Error(t, err)
+}
+
+func TestParseProcStatMalformed(t *testing.T) {
+	for _, stat := range []string{"", "4242 (worker", "4242 (worker) S 1 2 3", "4242 (worker) S 1 4242 4242 0 -1 0 0 0 0 0 x 75"} {
+		_, err := parseProcStat(stat)
+		assert.Error(t, err, stat)
+	}
+	_, err := parseProcStatm("250000")
+	assert.Error(t, err)
+}
+
+func TestUsageOfCurrentProcess(t *testing.T) {
+	startTime, found, err := lookupStartTime(os.Getpid())
+	assert.NoError(t, err)
+	assert.True(t, found)
+	usage, err := Usage(os.Getpid(), startTime.Format())
+	assert.NoError(t, err)
+	assert.True(t, usage.RSS > 0)
+
+	//a pid reused by another process is not sampled
+	_, err = Usage(os.Getpid(), StartTime{Time: startTime.Time.Add(-time.Hour)}.Format())
+	assert.Error(t, err)
+	_, err = Usage(os.Getpid(), "not a start time")
+	assert.Error(t, err)
+}
@@ -0,0 +1,67 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// +build windows
+
+package proc
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var getProcessMemoryInfo = kernel32.NewProc("K32GetProcessMemoryInfo")
+
+//https://msdn.microsoft.com/en-us/library/windows/desktop/ms684877(v=vs.85).aspx
+type processMemoryCounters struct {
+	Size                       uint32
+	PageFaultCount             uint32
+	PeakWorkingSetSize         uintptr
+	WorkingSetSize             uintptr
+	QuotaPeakPagedPoolUsage    uintptr
+	QuotaPagedPoolUsage        uintptr
+	QuotaPeakNonPagedPoolUsage uintptr
+	QuotaNonPagedPoolUsage     uintptr
+	PagefileUsage              uintptr
+	PeakPagefileUsage          uintptr
+}
+
+//read the kernel and user times and the working set through the process handle
+func sampleUsage(pid int) (ResourceUsage, error) {
+	const da = syscall.PROCESS_QUERY_INFORMATION | 0x10 //PROCESS_VM_READ
+	handle, err := syscall.OpenProcess(da, false, uint32(pid))
+	if err != nil {
+		return ResourceUsage{}, fmt.Errorf("open process error: %v", err)
+	}
+	defer syscall.CloseHandle(handle)
+	var u syscall.Rusage
+	if err = syscall.GetProcessTimes(handle, &u.CreationTime, &u.ExitTime, &u.KernelTime, &u.UserTime); err != nil {
+		return ResourceUsage{}, fmt.Errorf("unable to get process time: %v", err)
+	}
+	var counters processMemoryCounters
+	counters.Size = uint32(unsafe.Sizeof(counters))
+	if ret, _, err := getProcessMemoryInfo.Call(uintptr(handle), uintptr(unsafe.Pointer(&counters)), uintptr(counters.Size)); ret == 0 {
+		return ResourceUsage{}, fmt.Errorf("unable to get process memory: %v", err)
+	}
+	return ResourceUsage{
+		RSS:     int64(counters.WorkingSetSize),
+		CPUTime: filetimeDuration(u.KernelTime) + filetimeDuration(u.UserTime),
+	}, nil
+}
+
+//the kernel and user times are durations in 100ns units, not points in time
+func filetimeDuration(ft syscall.Filetime) time.Duration {
+	return time.Duration(int64(ft.HighDateTime)<<32|int64(ft.LowDateTime)) * 100
+}
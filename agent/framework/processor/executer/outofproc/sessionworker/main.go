@@ -16,6 +16,8 @@ package main
 
 import (
 	"os"
+	"path"
+	"time"
 
 	"github.com/aws/amazon-ssm-agent/agent/appconfig"
 	"github.com/aws/amazon-ssm-agent/agent/context"
@@ -25,12 +27,15 @@ import (
 	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer/outofproc/proc"
 	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer/plugin"
 	"github.com/aws/amazon-ssm-agent/agent/framework/runpluginutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
 	"github.com/aws/amazon-ssm-agent/agent/log/ssmlog"
 	"github.com/aws/amazon-ssm-agent/agent/task"
 )
 
 const (
 	defaultSessionWorkerContextName = "[ssm-session-worker]"
+	//how long an orphaned session worker waits for the cancelled session to flush its final status
+	defaultOrphanShutdownTimeout = 30 * time.Second
 )
 
 var sessionPluginRunner = func(
@@ -101,16 +106,69 @@ func createFileChannelAndExecutePlugin(context context.T, channelName string) {
 		return
 	}
 
+	var orphaned <-chan bool
+	//keep the pid file fresh until the process exits, so that master can look up this process without ps
+	if channelPath, err := channel.ChannelPath(channelName); err == nil {
+		if _, err = proc.StartPidFileHeartbeat(log, path.Join(channelPath, proc.DefaultPidFileName), proc.DefaultHeartbeatInterval); err != nil {
+			log.Errorf("failed to write pid file: %v", err)
+		}
+		orphaned = proc.MonitorMaster(log, path.Join(channelPath, proc.DefaultMasterPidFileName), proc.DefaultOrphanCheckInterval, proc.DefaultOrphanGracePeriod, nil)
+	}
+
 	//initialize SessionPluginRegistry
 	runpluginutil.SSMSessionPluginRegistry = plugin.RegisteredSessionWorkerPlugins()
 
 	//TODO add command timeout
-	stopTimer := make(chan bool)
+	stopTimer := make(chan bool, 1)
 	pipeline := messaging.NewWorkerBackend(context, sessionPluginRunner)
+	if orphaned != nil {
+		go shutdownOnOrphan(log, orphaned, pipeline, stopTimer)
+	}
+	if controlled, ok := ipc.(controlReceiver); ok {
+		go cancelOnControl(log, controlled, pipeline)
+	}
 	//TODO wait for sigterm or send fail message to the channel?
-	if err = messaging.Messaging(log, ipc, pipeline, stopTimer); err != nil {
+	if _, err = messaging.Messaging(log, ipc, pipeline, stopTimer); err != nil {
 		log.Errorf("messaging worker encountered error: %v", err)
 		//If ipc messaging broke, there's nothing session worker process can do, exit immediately
 		return
 	}
 }
+
+// shutdownOnOrphan cancels the session once the master is gone, so that the final status is flushed to the channel, then stops messaging
+func shutdownOnOrphan(logger log.T, orphaned <-chan bool, pipeline messaging.MessagingBackend, stopTimer chan bool) {
+	<-orphaned
+	logger.Info("master is gone, cancelling the session and shutting down")
+	cancel, _ := messaging.CreateDatagram(messaging.MessageTypeCancel, "cancel")
+	if err := pipeline.Process(cancel); err != nil {
+		logger.Errorf("failed to cancel the session: %v", err)
+	}
+	//the session may not have started or may not respond to the cancel, do not wait forever
+	time.Sleep(defaultOrphanShutdownTimeout)
+	stopTimer <- true
+}
+
+// controlReceiver is the part of the file channel delivering the control messages and acknowledging the handled ones
+type controlReceiver interface {
+	ControlMessages() <-chan channel.ControlMessage
+	Acknowledge(msg channel.ControlMessage) error
+}
+
+// cancelOnControl cancels the session on a cancel control message and acknowledges it once the pipeline took it,
+// the master kills a session worker that does not acknowledge the cancel
+func cancelOnControl(logger log.T, controls controlReceiver, pipeline messaging.MessagingBackend) {
+	for msg := range controls.ControlMessages() {
+		if msg.Type != channel.ControlCancel {
+			continue
+		}
+		logger.Info("received cancel control message, cancelling the session")
+		cancel, _ := messaging.CreateDatagram(messaging.MessageTypeCancel, "cancel")
+		if err := pipeline.Process(cancel); err != nil {
+			logger.Errorf("failed to cancel the session: %v", err)
+			continue
+		}
+		if err := controls.Acknowledge(msg); err != nil {
+			logger.Errorf("failed to acknowledge the cancel: %v", err)
+		}
+	}
+}
@@ -18,6 +18,9 @@ import (
 	"testing"
 
 	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer/outofproc/channel"
+	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer/outofproc/messaging"
+	"github.com/aws/amazon-ssm-agent/agent/log"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
@@ -45,6 +48,48 @@ func (suite *SessionWorkerTestSuite) TestSessionWorkerInitialize() {
 		[]string{defaultSessionWorkerContextName, "[" + channelName + "]"})
 }
 
+// cancelRecorder records the order in which the cancel is processed and acknowledged
+type cancelRecorder struct {
+	controls chan channel.ControlMessage
+	calls    []string
+}
+
+func (r *cancelRecorder) ControlMessages() <-chan channel.ControlMessage {
+	return r.controls
+}
+
+func (r *cancelRecorder) Acknowledge(msg channel.ControlMessage) error {
+	r.calls = append(r.calls, "ack "+msg.AckID)
+	return nil
+}
+
+func (r *cancelRecorder) Accept() <-chan string {
+	return nil
+}
+
+func (r *cancelRecorder) Stop() <-chan int {
+	return nil
+}
+
+func (r *cancelRecorder) Process(datagram string) error {
+	t, _ := messaging.ParseDatagram(datagram)
+	r.calls = append(r.calls, "process "+string(t))
+	return nil
+}
+
+func (r *cancelRecorder) Close() {
+}
+
+// Testing the session worker acknowledges the cancel once the pipeline took it, so that the master does not kill it.
+func (suite *SessionWorkerTestSuite) TestCancelOnControlAcksAfterProcess() {
+	recorder := &cancelRecorder{controls: make(chan channel.ControlMessage, 2)}
+	recorder.controls <- channel.ControlMessage{Type: "other"}
+	recorder.controls <- channel.ControlMessage{Type: channel.ControlCancel, AckID: "cancel-1"}
+	close(recorder.controls)
+	cancelOnControl(log.NewMockLog(), recorder, recorder)
+	assert.Equal(suite.T(), []string{"process " + messaging.MessageTypeCancel, "ack cancel-1"}, recorder.calls)
+}
+
 //Execute the test suite
 func TestSessionTestSuite(t *testing.T) {
 	suite.Run(t, new(SessionWorkerTestSuite))
@@ -2,6 +2,8 @@ package main
 
 import (
 	"os"
+	"path"
+	"syscall"
 	"time"
 
 	"github.com/aws/amazon-ssm-agent/agent/appconfig"
@@ -21,6 +23,8 @@ import (
 const (
 	defaultCommandTimeoutMax = 172800 * time.Second
 	defaultWorkerContextName = "[ssm-document-worker]"
+	//how long an orphaned worker waits for the cancelled document to flush its final status
+	defaultOrphanShutdownTimeout = 30 * time.Second
 )
 
 var pluginRunner = func(
@@ -53,6 +57,44 @@ func initialize(args []string) (context.T, string, error) {
 	return context.Default(logger, config).With(defaultWorkerContextName).With("[" + channelName + "]"), channelName, err
 }
 
+//once the master is gone, cancel the document so that the final status is flushed to the channel, then stop messaging
+func shutdownOnOrphan(log log.T, orphaned <-chan bool, pipeline messaging.MessagingBackend, stopTimer chan bool) {
+	<-orphaned
+	log.Info("master is gone, cancelling the document and shutting down")
+	cancel, _ := messaging.CreateDatagram(messaging.MessageTypeCancel, "cancel")
+	if err := pipeline.Process(cancel); err != nil {
+		log.Errorf("failed to cancel the document: %v", err)
+	}
+	//the document may not have started or may not respond to the cancel, do not wait forever
+	time.Sleep(defaultOrphanShutdownTimeout)
+	stopTimer <- true
+}
+
+//controlReceiver is the part of the file channel delivering the control messages and acknowledging the handled ones
+type controlReceiver interface {
+	ControlMessages() <-chan channel.ControlMessage
+	Acknowledge(msg channel.ControlMessage) error
+}
+
+//cancel the document the same way the cancel datagram does, and acknowledge the cancel control message once the pipeline
+//took it, the master kills a worker stuck before that
+func cancelOnControl(log log.T, controls controlReceiver, pipeline messaging.MessagingBackend) {
+	for msg := range controls.ControlMessages() {
+		if msg.Type != channel.ControlCancel {
+			continue
+		}
+		log.Info("received cancel control message, cancelling the document")
+		cancel, _ := messaging.CreateDatagram(messaging.MessageTypeCancel, "cancel")
+		if err := pipeline.Process(cancel); err != nil {
+			log.Errorf("failed to cancel the document: %v", err)
+			continue
+		}
+		if err := controls.Acknowledge(msg); err != nil {
+			log.Errorf("failed to acknowledge the cancel: %v", err)
+		}
+	}
+}
+
 func main() {
 	var err error
 	var logger log.T
@@ -72,14 +114,30 @@ func main() {
 		logger.Close()
 		return
 	}
+	//dump the channel status to diagnose a hung document, the go runtime dump would kill the worker instead
+	defer channel.DumpStatusOnSignal(logger, syscall.SIGQUIT)()
+	var orphaned <-chan bool
+	//keep the pid file fresh until the process exits, so that master can look up this process without ps
+	if channelPath, err := channel.ChannelPath(channelName); err == nil {
+		if _, err = proc.StartPidFileHeartbeat(logger, path.Join(channelPath, proc.DefaultPidFileName), proc.DefaultHeartbeatInterval); err != nil {
+			logger.Errorf("failed to write pid file: %v", err)
+		}
+		orphaned = proc.MonitorMaster(logger, path.Join(channelPath, proc.DefaultMasterPidFileName), proc.DefaultOrphanCheckInterval, proc.DefaultOrphanGracePeriod, nil)
+	}
 	//initialize PluginRegistry
 	runpluginutil.SSMPluginRegistry = plugin.RegisteredWorkerPlugins(ctx)
 
 	//TODO add command timeout
-	stopTimer := make(chan bool)
+	stopTimer := make(chan bool, 1)
 	pipeline := messaging.NewWorkerBackend(ctx, pluginRunner)
+	if orphaned != nil {
+		go shutdownOnOrphan(logger, orphaned, pipeline, stopTimer)
+	}
+	if controlled, ok := ipc.(controlReceiver); ok {
+		go cancelOnControl(logger, controlled, pipeline)
+	}
 	//TODO wait for sigterm or send fail message to the channel?
-	if err = messaging.Messaging(ctx.Log(), ipc, pipeline, stopTimer); err != nil {
+	if _, err = messaging.Messaging(ctx.Log(), ipc, pipeline, stopTimer); err != nil {
 		logger.Errorf("messaging worker encountered error: %v", err)
 		//If ipc messaging broke, there's nothing worker process can do, exit immediately
 		logger.Close()
@@ -1,9 +1,16 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path"
 	"testing"
+	"time"
 
+	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer/outofproc/channel"
+	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer/outofproc/messaging"
 	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer/outofproc/proc"
+	"github.com/aws/amazon-ssm-agent/agent/log"
 	"github.com/aws/amazon-ssm-agent/agent/platform"
 	"github.com/stretchr/testify/assert"
 )
@@ -25,3 +32,65 @@ func TestWorkerInitializeLightWeight(t *testing.T) {
 	assert.Equal(t, "instanceID", instanceID)
 	assert.Equal(t, ctxLight.CurrentContext(), []string{defaultWorkerContextName, "[" + name + "]"})
 }
+
+//records the order in which the cancel is processed and acknowledged
+type cancelRecorder struct {
+	controls chan channel.ControlMessage
+	calls    []string
+}
+
+func (r *cancelRecorder) ControlMessages() <-chan channel.ControlMessage {
+	return r.controls
+}
+
+func (r *cancelRecorder) Acknowledge(msg channel.ControlMessage) error {
+	r.calls = append(r.calls, "ack "+msg.AckID)
+	return nil
+}
+
+func (r *cancelRecorder) Accept() <-chan string {
+	return nil
+}
+
+func (r *cancelRecorder) Stop() <-chan int {
+	return nil
+}
+
+func (r *cancelRecorder) Process(datagram string) error {
+	t, _ := messaging.ParseDatagram(datagram)
+	r.calls = append(r.calls, "process "+string(t))
+	return nil
+}
+
+func (r *cancelRecorder) Close() {
+}
+
+//the cancel is acknowledged once the pipeline took it, not on its delivery
+func TestCancelOnControlAcksAfterProcess(t *testing.T) {
+	recorder := &cancelRecorder{controls: make(chan channel.ControlMessage, 2)}
+	recorder.controls <- channel.ControlMessage{Type: "other"}
+	recorder.controls <- channel.ControlMessage{Type: channel.ControlCancel, AckID: "cancel-1"}
+	close(recorder.controls)
+	cancelOnControl(log.NewMockLog(), recorder, recorder)
+	assert.Equal(t, []string{"process " + messaging.MessageTypeCancel, "ack cancel-1"}, recorder.calls)
+}
+
+//the master pid file is missing, the worker cannot tell whether the master is alive and does not cancel the document
+func TestShutdownOnOrphanMasterUnknown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	stop := make(chan bool)
+	defer close(stop)
+	orphaned := proc.MonitorMaster(log.NewMockLog(), path.Join(dir, proc.DefaultMasterPidFileName), 10*time.Millisecond, 50*time.Millisecond, stop)
+	recorder := &cancelRecorder{}
+	stopTimer := make(chan bool, 1)
+	go shutdownOnOrphan(log.NewMockLog(), orphaned, recorder, stopTimer)
+	select {
+	case <-orphaned:
+		t.Fatal("the document is cancelled although the master cannot be looked up")
+	case <-stopTimer:
+		t.Fatal("messaging is stopped although the master cannot be looked up")
+	case <-time.After(300 * time.Millisecond):
+	}
+}